@@ -13,15 +13,33 @@ type Visitor interface {
 	VisitStatement(node Statement) interface{}
 	VisitExpression(node Expression) interface{}
 	VisitVariableDeclaration(node *VariableDeclaration) interface{}
+	VisitMultiVariableDeclaration(node *MultiVariableDeclaration) interface{}
+	VisitDestructuringDeclaration(node *DestructuringDeclaration) interface{}
 	VisitAssignment(node *Assignment) interface{}
 	VisitIfStatement(node *IfStatement) interface{}
 	VisitLoopStatement(node *LoopStatement) interface{}
+	VisitSwitchStatement(node *SwitchStatement) interface{}
+	VisitForEachStatement(node *ForEachStatement) interface{}
+	VisitRepeatStatement(node *RepeatStatement) interface{}
+	VisitTryStatement(node *TryStatement) interface{}
+	VisitBlockStatement(node *BlockStatement) interface{}
 	VisitFunctionDeclaration(node *FunctionDeclaration) interface{}
+	VisitReturnStatement(node *ReturnStatement) interface{}
 	VisitFunctionCall(node *FunctionCall) interface{}
+	VisitFunctionLiteral(node *FunctionLiteral) interface{}
 	VisitPrintStatement(node *PrintStatement) interface{}
+	VisitAssertStatement(node *AssertStatement) interface{}
+	VisitRaiseStatement(node *RaiseStatement) interface{}
+	VisitEnumDeclaration(node *EnumDeclaration) interface{}
+	VisitIncludeStatement(node *IncludeStatement) interface{}
+	VisitImportStatement(node *ImportStatement) interface{}
+	VisitExpressionStatement(node *ExpressionStatement) interface{}
 	VisitBinaryExpression(node *BinaryExpression) interface{}
 	VisitUnaryExpression(node *UnaryExpression) interface{}
 	VisitLiteral(node *Literal) interface{}
+	VisitListLiteral(node *ListLiteral) interface{}
+	VisitIndexExpression(node *IndexExpression) interface{}
+	VisitSliceExpression(node *SliceExpression) interface{}
 	VisitIdentifier(node *Identifier) interface{}
 }
 
@@ -34,20 +52,35 @@ func (p *Program) Accept(visitor Visitor) interface{} {
 	return visitor.VisitProgram(p)
 }
 
+// Position marks where a node begins in the source code
+type Position struct {
+	Line   int
+	Column int
+}
+
+// Pos returns the source position of the node
+func (p Position) Pos() Position {
+	return p
+}
+
 // Statement represents any statement in the language
 type Statement interface {
 	Node
+	Pos() Position
 	IsStatement()
 }
 
 // Expression represents any expression in the language
 type Expression interface {
 	Node
+	Pos() Position
 	IsExpression()
 }
 
-// VariableDeclaration represents a variable declaration
+// VariableDeclaration represents a variable declaration. Type is nil for a
+// 'let' declaration, whose type is taken from its initial value.
 type VariableDeclaration struct {
+	Position
 	Type  types.Type
 	Name  string
 	Value Expression
@@ -59,12 +92,46 @@ func (v *VariableDeclaration) Accept(visitor Visitor) interface{} {
 
 func (v *VariableDeclaration) IsStatement() {}
 
-// Assignment represents a variable assignment
-type Assignment struct {
-	Name  string
+// MultiVariableDeclaration declares several variables of one type in a
+// single statement, such as number a = 1, b = 2. Each declaration carries the
+// shared type and runs in order.
+type MultiVariableDeclaration struct {
+	Position
+	Declarations []*VariableDeclaration
+}
+
+func (m *MultiVariableDeclaration) Accept(visitor Visitor) interface{} {
+	return visitor.VisitMultiVariableDeclaration(m)
+}
+
+func (m *MultiVariableDeclaration) IsStatement() {}
+
+// DestructuringDeclaration declares several variables from the elements of
+// one list, such as the values a function returns with 'return a, b', as in
+// let q, r = divmod(10, 3). Type is shared by every name, and nil for 'let'.
+type DestructuringDeclaration struct {
+	Position
+	Type  types.Type
+	Names []string
 	Value Expression
 }
 
+func (d *DestructuringDeclaration) Accept(visitor Visitor) interface{} {
+	return visitor.VisitDestructuringDeclaration(d)
+}
+
+func (d *DestructuringDeclaration) IsStatement() {}
+
+// Assignment represents a variable assignment. The statements x++ and x--
+// are parsed as assignments of x + 1 and x - 1, with Shorthand recording
+// which form was written; it is empty for an ordinary assignment.
+type Assignment struct {
+	Position
+	Name      string
+	Value     Expression
+	Shorthand string
+}
+
 func (a *Assignment) Accept(visitor Visitor) interface{} {
 	return visitor.VisitAssignment(a)
 }
@@ -73,6 +140,7 @@ func (a *Assignment) IsStatement() {}
 
 // IfStatement represents an if-else statement
 type IfStatement struct {
+	Position
 	Condition Expression
 	ThenBody  []Statement
 	ElseBody  []Statement
@@ -84,11 +152,14 @@ func (i *IfStatement) Accept(visitor Visitor) interface{} {
 
 func (i *IfStatement) IsStatement() {}
 
-// LoopStatement represents a loop
+// LoopStatement represents a counting loop. Step is nil when the loop has no
+// 'step' clause, in which case it counts by one towards To.
 type LoopStatement struct {
+	Position
 	Variable string
 	From     Expression
 	To       Expression
+	Step     Expression
 	Body     []Statement
 }
 
@@ -98,17 +169,101 @@ func (l *LoopStatement) Accept(visitor Visitor) interface{} {
 
 func (l *LoopStatement) IsStatement() {}
 
+// SwitchStatement runs the body of the first case whose value equals the
+// subject, or the default body when none does. Cases never fall through.
+// Default is nil when the switch has no default branch.
+type SwitchStatement struct {
+	Position
+	Subject Expression
+	Cases   []SwitchCase
+	Default []Statement
+}
+
+// SwitchCase is a single 'case' branch of a switch statement
+type SwitchCase struct {
+	Value Expression
+	Body  []Statement
+}
+
+func (s *SwitchStatement) Accept(visitor Visitor) interface{} {
+	return visitor.VisitSwitchStatement(s)
+}
+
+func (s *SwitchStatement) IsStatement() {}
+
+// ForEachStatement runs its body once for every element of a list, binding
+// the element to Variable
+type ForEachStatement struct {
+	Position
+	Variable string
+	Iterable Expression
+	Body     []Statement
+}
+
+func (f *ForEachStatement) Accept(visitor Visitor) interface{} {
+	return visitor.VisitForEachStatement(f)
+}
+
+func (f *ForEachStatement) IsStatement() {}
+
+// RepeatStatement runs Body, then repeats it for as long as Condition is
+// false. The body always runs at least once, and the condition is evaluated
+// in the body's scope, so it can use the variables the body declares.
+type RepeatStatement struct {
+	Position
+	Body      []Statement
+	Condition Expression
+}
+
+func (r *RepeatStatement) Accept(visitor Visitor) interface{} {
+	return visitor.VisitRepeatStatement(r)
+}
+
+func (r *RepeatStatement) IsStatement() {}
+
+// TryStatement runs Body and, if a runtime error stops it, runs CatchBody
+// with the error's message in Variable instead of failing the program
+type TryStatement struct {
+	Position
+	Body      []Statement
+	Variable  string
+	CatchBody []Statement
+}
+
+func (t *TryStatement) Accept(visitor Visitor) interface{} {
+	return visitor.VisitTryStatement(t)
+}
+
+func (t *TryStatement) IsStatement() {}
+
+// BlockStatement runs Body, written between 'begin' and 'end', in a scope of
+// its own
+type BlockStatement struct {
+	Position
+	Body []Statement
+}
+
+func (b *BlockStatement) Accept(visitor Visitor) interface{} {
+	return visitor.VisitBlockStatement(b)
+}
+
+func (b *BlockStatement) IsStatement() {}
+
 // FunctionDeclaration represents a function definition
 type FunctionDeclaration struct {
+	Position
 	Name       string
 	Parameters []Parameter
 	ReturnType types.Type
 	Body       []Statement
 }
 
+// Parameter is a declared function parameter. A parameter with a Default
+// may be left out of a call, in which case the default is evaluated instead.
 type Parameter struct {
-	Name string
-	Type types.Type
+	Name    string
+	Type    types.Type
+	Default Expression
 }
 
 func (f *FunctionDeclaration) Accept(visitor Visitor) interface{} {
@@ -117,10 +272,42 @@ func (f *FunctionDeclaration) Accept(visitor Visitor) interface{} {
 
 func (f *FunctionDeclaration) IsStatement() {}
 
-// FunctionCall represents a function call
+// RequiredParameters counts the parameters without a default, which always
+// come first
+func (f *FunctionDeclaration) RequiredParameters() int {
+	for i, param := range f.Parameters {
+		if param.Default != nil {
+			return i
+		}
+	}
+	return len(f.Parameters)
+}
+
+// ReturnStatement ends the function it appears in. A nil Value returns void.
+// Several values, as in return a, b, are returned as a list: Value is then a
+// ListLiteral of them and Multiple is set.
+type ReturnStatement struct {
+	Position
+	Value    Expression
+	Multiple bool
+}
+
+func (r *ReturnStatement) Accept(visitor Visitor) interface{} {
+	return visitor.VisitReturnStatement(r)
+}
+
+func (r *ReturnStatement) IsStatement() {}
+
+// FunctionCall represents a function call. A call by name sets Name; calling
+// the result of any other expression, such as f(1)(2), sets Callee instead.
 type FunctionCall struct {
+	Position
 	Name      string
+	Callee    Expression
 	Arguments []Expression
+	// Method marks a call written as a method on its first argument, as in
+	// s.upper(), which means the same as upper(s)
+	Method bool
 }
 
 func (f *FunctionCall) Accept(visitor Visitor) interface{} {
@@ -129,9 +316,31 @@ func (f *FunctionCall) Accept(visitor Visitor) interface{} {
 
 func (f *FunctionCall) IsExpression() {}
 
-// PrintStatement represents a print statement
+// AnonymousFunction is the name given to functions written as expressions,
+// used wherever a message or a printed value needs one
+const AnonymousFunction = "anonymous"
+
+// FunctionLiteral is a function written as an expression, such as
+// function(number x) return x * x end. Function holds its parameters and
+// body under the name AnonymousFunction; it is not declared anywhere, only
+// evaluated to a function value.
+type FunctionLiteral struct {
+	Position
+	Function *FunctionDeclaration
+}
+
+func (f *FunctionLiteral) Accept(visitor Visitor) interface{} {
+	return visitor.VisitFunctionLiteral(f)
+}
+
+func (f *FunctionLiteral) IsExpression() {}
+
+// PrintStatement represents a print statement. Write is set for a 'write'
+// statement, which leaves out the newline that 'print' ends its output with.
 type PrintStatement struct {
+	Position
 	Value Expression
+	Write bool
 }
 
 func (p *PrintStatement) Accept(visitor Visitor) interface{} {
@@ -140,8 +349,101 @@ func (p *PrintStatement) Accept(visitor Visitor) interface{} {
 
 func (p *PrintStatement) IsStatement() {}
 
+// AssertStatement fails the program when its condition is false, with
+// Message as the reason if there is one. A nil Message reports the
+// condition itself.
+type AssertStatement struct {
+	Position
+	Condition Expression
+	Message   Expression
+}
+
+func (a *AssertStatement) Accept(visitor Visitor) interface{} {
+	return visitor.VisitAssertStatement(a)
+}
+
+func (a *AssertStatement) IsStatement() {}
+
+// RaiseStatement fails with Value, a text, as the error message. The error
+// can be caught like any other runtime error.
+type RaiseStatement struct {
+	Position
+	Value Expression
+}
+
+func (r *RaiseStatement) Accept(visitor Visitor) interface{} {
+	return visitor.VisitRaiseStatement(r)
+}
+
+func (r *RaiseStatement) IsStatement() {}
+
+// EnumDeclaration declares the members of an enum, as in
+// enum Color red green blue end. Each member becomes a constant named after
+// the enum and itself, such as Color.red, numbered from 0 in the order given.
+type EnumDeclaration struct {
+	Position
+	Name    string
+	Members []string
+}
+
+func (e *EnumDeclaration) Accept(visitor Visitor) interface{} {
+	return visitor.VisitEnumDeclaration(e)
+}
+
+func (e *EnumDeclaration) IsStatement() {}
+
+// MemberName is the name an enum member is declared under and read by, as
+// in Color.red, and the qualified name of a declaration in an imported
+// module, as in m.add
+func MemberName(enum, member string) string {
+	return enum + "." + member
+}
+
+// IncludeStatement runs the program in another file, as in
+// include "utils.sl", declaring its variables and functions in the including
+// scope. Path is resolved relative to the directory of the including file.
+type IncludeStatement struct {
+	Position
+	Path string
+}
+
+func (i *IncludeStatement) Accept(visitor Visitor) interface{} {
+	return visitor.VisitIncludeStatement(i)
+}
+
+func (i *IncludeStatement) IsStatement() {}
+
+// ImportStatement runs another file as a module of its own, as in
+// import "math.sl" as m, whose declarations the program reaches through
+// Alias as qualified names such as m.add. Path resolves as an include's does.
+type ImportStatement struct {
+	Position
+	Path  string
+	Alias string
+}
+
+func (i *ImportStatement) Accept(visitor Visitor) interface{} {
+	return visitor.VisitImportStatement(i)
+}
+
+func (i *ImportStatement) IsStatement() {}
+
+// ExpressionStatement represents an expression evaluated for its side
+// effects, such as a function call; its value is discarded
+type ExpressionStatement struct {
+	Position
+	Expression Expression
+}
+
+func (e *ExpressionStatement) Accept(visitor Visitor) interface{} {
+	return visitor.VisitExpressionStatement(e)
+}
+
+func (e *ExpressionStatement) IsStatement() {}
+
 // BinaryExpression represents a binary operation
 type BinaryExpression struct {
+	Position
 	Left     Expression
 	Operator string
 	Right    Expression
@@ -155,6 +457,7 @@ func (b *BinaryExpression) IsExpression() {}
 
 // UnaryExpression represents a unary operation
 type UnaryExpression struct {
+	Position
 	Operator string
 	Operand  Expression
 }
@@ -167,6 +470,7 @@ func (u *UnaryExpression) IsExpression() {}
 
 // Literal represents a literal value
 type Literal struct {
+	Position
 	Value interface{}
 	Type  types.Type
 }
@@ -177,8 +481,51 @@ func (l *Literal) Accept(visitor Visitor) interface{} {
 
 func (l *Literal) IsExpression() {}
 
+// ListLiteral represents a bracketed list of expressions such as [1, 2, 3]
+type ListLiteral struct {
+	Position
+	Elements []Expression
+}
+
+func (l *ListLiteral) Accept(visitor Visitor) interface{} {
+	return visitor.VisitListLiteral(l)
+}
+
+func (l *ListLiteral) IsExpression() {}
+
+// IndexExpression represents picking one element of a text or list by its
+// position, such as s[0]. Its position is that of the '['.
+type IndexExpression struct {
+	Position
+	Target Expression
+	Index  Expression
+}
+
+func (i *IndexExpression) Accept(visitor Visitor) interface{} {
+	return visitor.VisitIndexExpression(i)
+}
+
+func (i *IndexExpression) IsExpression() {}
+
+// SliceExpression represents the run of a text or list between two
+// positions, such as s[1:3]. Start and End are nil when left out, meaning
+// the beginning and the end.
+type SliceExpression struct {
+	Position
+	Target Expression
+	Start  Expression
+	End    Expression
+}
+
+func (s *SliceExpression) Accept(visitor Visitor) interface{} {
+	return visitor.VisitSliceExpression(s)
+}
+
+func (s *SliceExpression) IsExpression() {}
+
 // Identifier represents a variable reference
 type Identifier struct {
+	Position
 	Name string
 }
 
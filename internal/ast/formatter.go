@@ -0,0 +1,458 @@
+package ast
+
+import (
+	"fmt"
+	"simplelang/internal/types"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Formatter renders a program back into canonical SimpleLang source: one
+// statement per line, single spaces around binary operators, 4-space block
+// indentation and parentheses only where precedence requires them.
+// Formatting already-formatted source yields the same text.
+type Formatter struct {
+	builder strings.Builder
+	indent  int
+}
+
+// NewFormatter creates a new formatter
+func NewFormatter() *Formatter {
+	return &Formatter{}
+}
+
+// Format renders the program as source code
+func (f *Formatter) Format(program *Program) string {
+	f.builder.Reset()
+	f.indent = 0
+	program.Accept(f)
+	return f.builder.String()
+}
+
+// FormatExpression renders a single expression as source code
+func (f *Formatter) FormatExpression(expr Expression) string {
+	return f.expr(expr)
+}
+
+func (f *Formatter) line(format string, args ...interface{}) {
+	f.builder.WriteString(strings.Repeat("    ", f.indent))
+	fmt.Fprintf(&f.builder, format, args...)
+	f.builder.WriteString("\n")
+}
+
+func (f *Formatter) block(body []Statement) {
+	f.indent++
+	for _, stmt := range body {
+		stmt.Accept(f)
+	}
+	f.indent--
+}
+
+func (f *Formatter) expr(expr Expression) string {
+	return expr.Accept(f).(string)
+}
+
+// operand renders a child of a binary or unary expression, adding parentheses
+// when the child binds more loosely than its parent. Binary operators are left
+// associative, so a right-hand child of equal precedence is parenthesized too.
+// '^' is the exception: it is right associative, and its exponent may be a
+// prefix expression as in 2 ^ -1.
+func (f *Formatter) operand(expr Expression, parent int, right bool) string {
+	text := f.expr(expr)
+	child := precedence(expr)
+
+	if parent == powerPrecedence {
+		if right && child >= unaryPrecedence {
+			return text
+		}
+		right = !right
+	}
+	if child < parent || (right && child == parent) {
+		return "(" + text + ")"
+	}
+	return text
+}
+
+// precedence returns how tightly an expression binds; anything that is not
+// an operator binds tightest of all
+func precedence(expr Expression) int {
+	switch e := expr.(type) {
+	case *BinaryExpression:
+		return binaryPrecedence(e.Operator)
+	case *UnaryExpression:
+		return unaryPrecedence
+	case *Literal:
+		// A negative number, as left by constant folding, reads as a negation
+		if _, isText := e.Type.(types.TextType); !isText && strings.HasPrefix(fmt.Sprint(e.Value), "-") {
+			return unaryPrecedence
+		}
+		return atomPrecedence
+	default:
+		return atomPrecedence
+	}
+}
+
+// binaryPrecedence mirrors the parser's precedence levels, loosest first
+func binaryPrecedence(operator string) int {
+	switch operator {
+	case "or":
+		return 1
+	case "and":
+		return 2
+	case "==", "!=":
+		return 3
+	case "<", "<=", ">", ">=":
+		return 4
+	case "|":
+		return 5
+	case "xor":
+		return 6
+	case "&":
+		return 7
+	case "<<", ">>":
+		return 8
+	case "+", "-":
+		return 9
+	case "*", "/", "//", "%":
+		return 10
+	case "^":
+		return powerPrecedence
+	default:
+		return 0
+	}
+}
+
+const (
+	// unaryPrecedence binds tighter than every binary operator except '^'
+	unaryPrecedence = 11
+	powerPrecedence = 12
+	atomPrecedence  = 13
+)
+
+// declarationKeyword is the word that starts a declaration: its type, or
+// 'let' when the type is inferred
+func declarationKeyword(t types.Type) string {
+	if t == nil {
+		return "let"
+	}
+	return t.String()
+}
+
+// quoteText renders a text value as a literal using the lexer's escapes
+func quoteText(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\r", `\r`)
+	return `"` + escapeUnprintable(replacer.Replace(value)) + `"`
+}
+
+// quoteChar renders a character as a literal using the lexer's escapes
+func quoteChar(value rune) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\t", `\t`, "\r", `\r`)
+	return `'` + escapeUnprintable(replacer.Replace(string(value))) + `'`
+}
+
+// escapeUnprintable writes the characters of text that would not show up
+// in source, such as control characters, as \u escapes. Bytes that are not
+// valid UTF-8 are kept as they are.
+func escapeUnprintable(text string) string {
+	var b strings.Builder
+	for len(text) > 0 {
+		r, size := utf8.DecodeRuneInString(text)
+		switch {
+		case r == utf8.RuneError && size == 1, unicode.IsPrint(r):
+			b.WriteString(text[:size])
+		case r <= 0xffff:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			fmt.Fprintf(&b, `\u{%x}`, r)
+		}
+		text = text[size:]
+	}
+	return b.String()
+}
+
+func (f *Formatter) VisitProgram(node *Program) interface{} {
+	for i, stmt := range node.Statements {
+		// Separate top-level functions from their neighbours with a blank line
+		if i > 0 {
+			_, isFunction := stmt.(*FunctionDeclaration)
+			_, prevFunction := node.Statements[i-1].(*FunctionDeclaration)
+			if isFunction || prevFunction {
+				f.builder.WriteString("\n")
+			}
+		}
+		stmt.Accept(f)
+	}
+	return nil
+}
+
+func (f *Formatter) VisitStatement(node Statement) interface{} {
+	return node.Accept(f)
+}
+
+func (f *Formatter) VisitExpression(node Expression) interface{} {
+	return node.Accept(f)
+}
+
+func (f *Formatter) VisitVariableDeclaration(node *VariableDeclaration) interface{} {
+	f.line("%s %s = %s", declarationKeyword(node.Type), node.Name, f.expr(node.Value))
+	return nil
+}
+
+func (f *Formatter) VisitMultiVariableDeclaration(node *MultiVariableDeclaration) interface{} {
+	declarations := make([]string, len(node.Declarations))
+	for i, decl := range node.Declarations {
+		declarations[i] = fmt.Sprintf("%s = %s", decl.Name, f.expr(decl.Value))
+	}
+	f.line("%s %s", declarationKeyword(node.Declarations[0].Type), strings.Join(declarations, ", "))
+	return nil
+}
+
+func (f *Formatter) VisitDestructuringDeclaration(node *DestructuringDeclaration) interface{} {
+	f.line("%s %s = %s", declarationKeyword(node.Type), strings.Join(node.Names, ", "), f.expr(node.Value))
+	return nil
+}
+
+func (f *Formatter) VisitAssignment(node *Assignment) interface{} {
+	if node.Shorthand != "" {
+		f.line("%s%s", node.Name, node.Shorthand)
+		return nil
+	}
+	f.line("%s = %s", node.Name, f.expr(node.Value))
+	return nil
+}
+
+func (f *Formatter) VisitIfStatement(node *IfStatement) interface{} {
+	f.line("if %s then", f.expr(node.Condition))
+	f.block(node.ThenBody)
+	if len(node.ElseBody) > 0 {
+		f.line("else")
+		f.block(node.ElseBody)
+	}
+	f.line("end")
+	return nil
+}
+
+func (f *Formatter) VisitLoopStatement(node *LoopStatement) interface{} {
+	if node.Step != nil {
+		f.line("loop %s from %s to %s step %s", node.Variable, f.expr(node.From), f.expr(node.To), f.expr(node.Step))
+	} else {
+		f.line("loop %s from %s to %s", node.Variable, f.expr(node.From), f.expr(node.To))
+	}
+	f.block(node.Body)
+	f.line("end")
+	return nil
+}
+
+func (f *Formatter) VisitSwitchStatement(node *SwitchStatement) interface{} {
+	f.line("switch %s", f.expr(node.Subject))
+	for _, c := range node.Cases {
+		f.line("case %s", f.expr(c.Value))
+		f.block(c.Body)
+	}
+	if node.Default != nil {
+		f.line("default")
+		f.block(node.Default)
+	}
+	f.line("end")
+	return nil
+}
+
+func (f *Formatter) VisitForEachStatement(node *ForEachStatement) interface{} {
+	f.line("for %s in %s", node.Variable, f.expr(node.Iterable))
+	f.block(node.Body)
+	f.line("end")
+	return nil
+}
+
+func (f *Formatter) VisitRepeatStatement(node *RepeatStatement) interface{} {
+	f.line("repeat")
+	f.block(node.Body)
+	f.line("until %s", f.expr(node.Condition))
+	return nil
+}
+
+func (f *Formatter) VisitTryStatement(node *TryStatement) interface{} {
+	f.line("try")
+	f.block(node.Body)
+	f.line("catch %s", node.Variable)
+	f.block(node.CatchBody)
+	f.line("end")
+	return nil
+}
+
+func (f *Formatter) VisitBlockStatement(node *BlockStatement) interface{} {
+	f.line("begin")
+	f.block(node.Body)
+	f.line("end")
+	return nil
+}
+
+func (f *Formatter) VisitFunctionDeclaration(node *FunctionDeclaration) interface{} {
+	f.line("function %s(%s)", node.Name, f.parameters(node.Parameters))
+	f.block(node.Body)
+	f.line("end")
+	return nil
+}
+
+func (f *Formatter) parameters(parameters []Parameter) string {
+	params := make([]string, len(parameters))
+	for i, param := range parameters {
+		params[i] = fmt.Sprintf("%s %s", param.Type, param.Name)
+		if param.Default != nil {
+			params[i] += " = " + f.expr(param.Default)
+		}
+	}
+	return strings.Join(params, ", ")
+}
+
+func (f *Formatter) VisitReturnStatement(node *ReturnStatement) interface{} {
+	switch {
+	case node.Value == nil:
+		f.line("return")
+	case node.Multiple:
+		values := node.Value.(*ListLiteral).Elements
+		texts := make([]string, len(values))
+		for i, value := range values {
+			texts[i] = f.expr(value)
+		}
+		f.line("return %s", strings.Join(texts, ", "))
+	default:
+		f.line("return %s", f.expr(node.Value))
+	}
+	return nil
+}
+
+func (f *Formatter) VisitFunctionCall(node *FunctionCall) interface{} {
+	args := make([]string, len(node.Arguments))
+	for i, arg := range node.Arguments {
+		args[i] = f.expr(arg)
+	}
+	name := node.Name
+	if node.Callee != nil {
+		name = f.operand(node.Callee, atomPrecedence, false)
+	}
+	if node.Method {
+		receiver := f.operand(node.Arguments[0], atomPrecedence, false)
+		// The '.' would otherwise be read as a decimal point
+		if literal, ok := node.Arguments[0].(*Literal); ok {
+			switch literal.Type.(type) {
+			case types.IntType, types.NumberType:
+				receiver = "(" + receiver + ")"
+			}
+		}
+		return fmt.Sprintf("%s.%s(%s)", receiver, name, strings.Join(args[1:], ", "))
+	}
+	return fmt.Sprintf("%s(%s)", name, strings.Join(args, ", "))
+}
+
+// VisitFunctionLiteral renders an anonymous function across several lines,
+// its body indented one level deeper than the line it starts on
+func (f *Formatter) VisitFunctionLiteral(node *FunctionLiteral) interface{} {
+	body := &Formatter{indent: f.indent}
+	body.block(node.Function.Body)
+	return fmt.Sprintf("function(%s)\n%s%send", f.parameters(node.Function.Parameters),
+		body.builder.String(), strings.Repeat("    ", f.indent))
+}
+
+func (f *Formatter) VisitPrintStatement(node *PrintStatement) interface{} {
+	if node.Write {
+		f.line("write %s", f.expr(node.Value))
+		return nil
+	}
+	f.line("print %s", f.expr(node.Value))
+	return nil
+}
+
+func (f *Formatter) VisitAssertStatement(node *AssertStatement) interface{} {
+	if node.Message != nil {
+		f.line("assert %s, %s", f.expr(node.Condition), f.expr(node.Message))
+	} else {
+		f.line("assert %s", f.expr(node.Condition))
+	}
+	return nil
+}
+
+func (f *Formatter) VisitRaiseStatement(node *RaiseStatement) interface{} {
+	f.line("raise %s", f.expr(node.Value))
+	return nil
+}
+
+func (f *Formatter) VisitEnumDeclaration(node *EnumDeclaration) interface{} {
+	f.line("enum %s %s end", node.Name, strings.Join(node.Members, " "))
+	return nil
+}
+
+func (f *Formatter) VisitIncludeStatement(node *IncludeStatement) interface{} {
+	f.line("include %s", quoteText(node.Path))
+	return nil
+}
+
+func (f *Formatter) VisitImportStatement(node *ImportStatement) interface{} {
+	f.line("import %s as %s", quoteText(node.Path), node.Alias)
+	return nil
+}
+
+func (f *Formatter) VisitExpressionStatement(node *ExpressionStatement) interface{} {
+	f.line("%s", f.expr(node.Expression))
+	return nil
+}
+
+func (f *Formatter) VisitBinaryExpression(node *BinaryExpression) interface{} {
+	precedence := binaryPrecedence(node.Operator)
+	left := f.operand(node.Left, precedence, false)
+	right := f.operand(node.Right, precedence, true)
+	return fmt.Sprintf("%s %s %s", left, node.Operator, right)
+}
+
+func (f *Formatter) VisitUnaryExpression(node *UnaryExpression) interface{} {
+	// A word operator needs a space to stay separate from its operand
+	if node.Operator == "not" {
+		return "not " + f.operand(node.Operand, unaryPrecedence, false)
+	}
+	// Keep a nested minus apart so that - -x is not read back as --x
+	operand := f.operand(node.Operand, unaryPrecedence, false)
+	if node.Operator == "-" && strings.HasPrefix(operand, "-") {
+		return "- " + operand
+	}
+	return node.Operator + operand
+}
+
+func (f *Formatter) VisitLiteral(node *Literal) interface{} {
+	switch node.Type.(type) {
+	case types.TextType:
+		return quoteText(fmt.Sprint(node.Value))
+	case types.CharType:
+		if char, ok := node.Value.(rune); ok {
+			return quoteChar(char)
+		}
+	}
+	return fmt.Sprint(node.Value)
+}
+
+func (f *Formatter) VisitListLiteral(node *ListLiteral) interface{} {
+	elements := make([]string, len(node.Elements))
+	for i, element := range node.Elements {
+		elements[i] = f.expr(element)
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+func (f *Formatter) VisitIndexExpression(node *IndexExpression) interface{} {
+	return fmt.Sprintf("%s[%s]", f.operand(node.Target, atomPrecedence, false), f.expr(node.Index))
+}
+
+func (f *Formatter) VisitSliceExpression(node *SliceExpression) interface{} {
+	var start, end string
+	if node.Start != nil {
+		start = f.expr(node.Start)
+	}
+	if node.End != nil {
+		end = f.expr(node.End)
+	}
+	return fmt.Sprintf("%s[%s:%s]", f.operand(node.Target, atomPrecedence, false), start, end)
+}
+
+func (f *Formatter) VisitIdentifier(node *Identifier) interface{} {
+	return node.Name
+}
@@ -0,0 +1,330 @@
+package ast
+
+import (
+	"encoding/json"
+	"fmt"
+	"simplelang/internal/types"
+)
+
+// JSONEncoder serializes a syntax tree for tools outside the compiler. Each
+// node becomes an object whose "kind" names the node type, alongside its
+// position and fields, with child nodes nested as objects of their own.
+// Types are written by name, and a declaration whose type is inferred has a
+// null type.
+type JSONEncoder struct{}
+
+// object is the JSON form of a single node
+type object map[string]interface{}
+
+// NewJSONEncoder creates a new JSON encoder
+func NewJSONEncoder() *JSONEncoder {
+	return &JSONEncoder{}
+}
+
+// Encode returns node as indented JSON
+func (e *JSONEncoder) Encode(node Node) ([]byte, error) {
+	return json.MarshalIndent(node.Accept(e), "", "  ")
+}
+
+// node starts the object for a node at pos
+func node(kind string, pos Position) object {
+	return object{"kind": kind, "line": pos.Line, "column": pos.Column}
+}
+
+func (e *JSONEncoder) expr(expr Expression) interface{} {
+	if expr == nil {
+		return nil
+	}
+	return expr.Accept(e)
+}
+
+// block encodes a list of statements, empty rather than null when there are
+// none
+func (e *JSONEncoder) block(body []Statement) []interface{} {
+	statements := make([]interface{}, len(body))
+	for i, stmt := range body {
+		statements[i] = stmt.Accept(e)
+	}
+	return statements
+}
+
+func (e *JSONEncoder) parameters(parameters []Parameter) []interface{} {
+	params := make([]interface{}, len(parameters))
+	for i, param := range parameters {
+		p := object{"name": param.Name, "type": typeName(param.Type)}
+		if param.Default != nil {
+			p["default"] = e.expr(param.Default)
+		}
+		params[i] = p
+	}
+	return params
+}
+
+func typeName(t types.Type) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.String()
+}
+
+func (e *JSONEncoder) VisitProgram(node *Program) interface{} {
+	return object{"kind": "Program", "statements": e.block(node.Statements)}
+}
+
+func (e *JSONEncoder) VisitStatement(node Statement) interface{} {
+	return node.Accept(e)
+}
+
+func (e *JSONEncoder) VisitExpression(node Expression) interface{} {
+	return node.Accept(e)
+}
+
+func (e *JSONEncoder) VisitVariableDeclaration(n *VariableDeclaration) interface{} {
+	o := node("VariableDeclaration", n.Position)
+	o["type"] = typeName(n.Type)
+	o["name"] = n.Name
+	o["value"] = e.expr(n.Value)
+	return o
+}
+
+func (e *JSONEncoder) VisitMultiVariableDeclaration(n *MultiVariableDeclaration) interface{} {
+	o := node("MultiVariableDeclaration", n.Position)
+	declarations := make([]interface{}, len(n.Declarations))
+	for i, decl := range n.Declarations {
+		declarations[i] = decl.Accept(e)
+	}
+	o["declarations"] = declarations
+	return o
+}
+
+func (e *JSONEncoder) VisitDestructuringDeclaration(n *DestructuringDeclaration) interface{} {
+	o := node("DestructuringDeclaration", n.Position)
+	o["type"] = typeName(n.Type)
+	o["names"] = n.Names
+	o["value"] = e.expr(n.Value)
+	return o
+}
+
+func (e *JSONEncoder) VisitAssignment(n *Assignment) interface{} {
+	o := node("Assignment", n.Position)
+	o["name"] = n.Name
+	if n.Shorthand != "" {
+		o["shorthand"] = n.Shorthand
+	} else {
+		o["value"] = e.expr(n.Value)
+	}
+	return o
+}
+
+func (e *JSONEncoder) VisitIfStatement(n *IfStatement) interface{} {
+	o := node("IfStatement", n.Position)
+	o["condition"] = e.expr(n.Condition)
+	o["then"] = e.block(n.ThenBody)
+	o["else"] = e.block(n.ElseBody)
+	return o
+}
+
+func (e *JSONEncoder) VisitLoopStatement(n *LoopStatement) interface{} {
+	o := node("LoopStatement", n.Position)
+	o["variable"] = n.Variable
+	o["from"] = e.expr(n.From)
+	o["to"] = e.expr(n.To)
+	o["step"] = e.expr(n.Step)
+	o["body"] = e.block(n.Body)
+	return o
+}
+
+func (e *JSONEncoder) VisitSwitchStatement(n *SwitchStatement) interface{} {
+	o := node("SwitchStatement", n.Position)
+	o["subject"] = e.expr(n.Subject)
+	cases := make([]interface{}, len(n.Cases))
+	for i, c := range n.Cases {
+		cases[i] = object{"value": e.expr(c.Value), "body": e.block(c.Body)}
+	}
+	o["cases"] = cases
+	if n.Default != nil {
+		o["default"] = e.block(n.Default)
+	} else {
+		o["default"] = nil
+	}
+	return o
+}
+
+func (e *JSONEncoder) VisitForEachStatement(n *ForEachStatement) interface{} {
+	o := node("ForEachStatement", n.Position)
+	o["variable"] = n.Variable
+	o["iterable"] = e.expr(n.Iterable)
+	o["body"] = e.block(n.Body)
+	return o
+}
+
+func (e *JSONEncoder) VisitRepeatStatement(n *RepeatStatement) interface{} {
+	o := node("RepeatStatement", n.Position)
+	o["body"] = e.block(n.Body)
+	o["condition"] = e.expr(n.Condition)
+	return o
+}
+
+func (e *JSONEncoder) VisitTryStatement(n *TryStatement) interface{} {
+	o := node("TryStatement", n.Position)
+	o["body"] = e.block(n.Body)
+	o["variable"] = n.Variable
+	o["catch"] = e.block(n.CatchBody)
+	return o
+}
+
+func (e *JSONEncoder) VisitBlockStatement(n *BlockStatement) interface{} {
+	o := node("BlockStatement", n.Position)
+	o["body"] = e.block(n.Body)
+	return o
+}
+
+func (e *JSONEncoder) VisitFunctionDeclaration(n *FunctionDeclaration) interface{} {
+	o := node("FunctionDeclaration", n.Position)
+	o["name"] = n.Name
+	o["parameters"] = e.parameters(n.Parameters)
+	o["body"] = e.block(n.Body)
+	return o
+}
+
+func (e *JSONEncoder) VisitReturnStatement(n *ReturnStatement) interface{} {
+	o := node("ReturnStatement", n.Position)
+	o["value"] = e.expr(n.Value)
+	o["multiple"] = n.Multiple
+	return o
+}
+
+func (e *JSONEncoder) VisitFunctionCall(n *FunctionCall) interface{} {
+	o := node("FunctionCall", n.Position)
+	if n.Callee != nil {
+		o["callee"] = e.expr(n.Callee)
+	} else {
+		o["name"] = n.Name
+	}
+	if n.Method {
+		o["method"] = true
+	}
+	args := make([]interface{}, len(n.Arguments))
+	for i, arg := range n.Arguments {
+		args[i] = e.expr(arg)
+	}
+	o["arguments"] = args
+	return o
+}
+
+func (e *JSONEncoder) VisitFunctionLiteral(n *FunctionLiteral) interface{} {
+	o := node("FunctionLiteral", n.Position)
+	o["parameters"] = e.parameters(n.Function.Parameters)
+	o["body"] = e.block(n.Function.Body)
+	return o
+}
+
+func (e *JSONEncoder) VisitPrintStatement(n *PrintStatement) interface{} {
+	o := node("PrintStatement", n.Position)
+	o["value"] = e.expr(n.Value)
+	o["write"] = n.Write
+	return o
+}
+
+func (e *JSONEncoder) VisitAssertStatement(n *AssertStatement) interface{} {
+	o := node("AssertStatement", n.Position)
+	o["condition"] = e.expr(n.Condition)
+	o["message"] = e.expr(n.Message)
+	return o
+}
+
+func (e *JSONEncoder) VisitRaiseStatement(n *RaiseStatement) interface{} {
+	o := node("RaiseStatement", n.Position)
+	o["value"] = e.expr(n.Value)
+	return o
+}
+
+func (e *JSONEncoder) VisitEnumDeclaration(n *EnumDeclaration) interface{} {
+	o := node("EnumDeclaration", n.Position)
+	o["name"] = n.Name
+	o["members"] = append([]string{}, n.Members...)
+	return o
+}
+
+func (e *JSONEncoder) VisitIncludeStatement(n *IncludeStatement) interface{} {
+	o := node("IncludeStatement", n.Position)
+	o["path"] = n.Path
+	return o
+}
+
+func (e *JSONEncoder) VisitImportStatement(n *ImportStatement) interface{} {
+	o := node("ImportStatement", n.Position)
+	o["path"] = n.Path
+	o["alias"] = n.Alias
+	return o
+}
+
+func (e *JSONEncoder) VisitExpressionStatement(n *ExpressionStatement) interface{} {
+	o := node("ExpressionStatement", n.Position)
+	o["expression"] = e.expr(n.Expression)
+	return o
+}
+
+func (e *JSONEncoder) VisitBinaryExpression(n *BinaryExpression) interface{} {
+	o := node("BinaryExpression", n.Position)
+	o["operator"] = n.Operator
+	o["left"] = e.expr(n.Left)
+	o["right"] = e.expr(n.Right)
+	return o
+}
+
+func (e *JSONEncoder) VisitUnaryExpression(n *UnaryExpression) interface{} {
+	o := node("UnaryExpression", n.Position)
+	o["operator"] = n.Operator
+	o["operand"] = e.expr(n.Operand)
+	return o
+}
+
+// VisitLiteral writes numbers as JSON numbers with the digits of the source,
+// a character as a one-character string, and nil as null
+func (e *JSONEncoder) VisitLiteral(n *Literal) interface{} {
+	o := node("Literal", n.Position)
+	o["type"] = typeName(n.Type)
+	switch n.Type.(type) {
+	case types.IntType, types.NumberType:
+		o["value"] = json.Number(fmt.Sprint(n.Value))
+	case types.CharType:
+		o["value"] = fmt.Sprintf("%c", n.Value)
+	case types.NilType:
+		o["value"] = nil
+	default:
+		o["value"] = n.Value
+	}
+	return o
+}
+
+func (e *JSONEncoder) VisitListLiteral(n *ListLiteral) interface{} {
+	o := node("ListLiteral", n.Position)
+	elements := make([]interface{}, len(n.Elements))
+	for i, element := range n.Elements {
+		elements[i] = e.expr(element)
+	}
+	o["elements"] = elements
+	return o
+}
+
+func (e *JSONEncoder) VisitIndexExpression(n *IndexExpression) interface{} {
+	o := node("IndexExpression", n.Position)
+	o["target"] = e.expr(n.Target)
+	o["index"] = e.expr(n.Index)
+	return o
+}
+
+func (e *JSONEncoder) VisitSliceExpression(n *SliceExpression) interface{} {
+	o := node("SliceExpression", n.Position)
+	o["target"] = e.expr(n.Target)
+	o["start"] = e.expr(n.Start)
+	o["end"] = e.expr(n.End)
+	return o
+}
+
+func (e *JSONEncoder) VisitIdentifier(n *Identifier) interface{} {
+	o := node("Identifier", n.Position)
+	o["name"] = n.Name
+	return o
+}
@@ -0,0 +1,322 @@
+package ast
+
+import (
+	"fmt"
+	"simplelang/internal/types"
+	"strings"
+)
+
+// PrettyPrinter renders an AST as an indented tree with one labeled line per
+// node and each node's children indented beneath it
+type PrettyPrinter struct {
+	builder strings.Builder
+	indent  int
+}
+
+// NewPrettyPrinter creates a new pretty printer
+func NewPrettyPrinter() *PrettyPrinter {
+	return &PrettyPrinter{}
+}
+
+// Print renders the tree rooted at node
+func (p *PrettyPrinter) Print(node Node) string {
+	p.builder.Reset()
+	p.indent = 0
+	node.Accept(p)
+	return p.builder.String()
+}
+
+func (p *PrettyPrinter) line(format string, args ...interface{}) {
+	p.builder.WriteString(strings.Repeat("  ", p.indent))
+	fmt.Fprintf(&p.builder, format, args...)
+	p.builder.WriteString("\n")
+}
+
+func (p *PrettyPrinter) child(node Node) {
+	p.indent++
+	node.Accept(p)
+	p.indent--
+}
+
+// section prints a label followed by the statements of a block
+func (p *PrettyPrinter) section(label string, body []Statement) {
+	p.indent++
+	p.line("%s", label)
+	for _, stmt := range body {
+		p.child(stmt)
+	}
+	p.indent--
+}
+
+// labeled prints a label with a single expression beneath it
+func (p *PrettyPrinter) labeled(label string, expr Expression) {
+	p.indent++
+	p.line("%s", label)
+	p.child(expr)
+	p.indent--
+}
+
+func (p *PrettyPrinter) VisitProgram(node *Program) interface{} {
+	p.line("Program")
+	for _, stmt := range node.Statements {
+		p.child(stmt)
+	}
+	return nil
+}
+
+func (p *PrettyPrinter) VisitStatement(node Statement) interface{} {
+	return node.Accept(p)
+}
+
+func (p *PrettyPrinter) VisitExpression(node Expression) interface{} {
+	return node.Accept(p)
+}
+
+func (p *PrettyPrinter) VisitVariableDeclaration(node *VariableDeclaration) interface{} {
+	if node.Type == nil {
+		p.line("VariableDeclaration %s", node.Name)
+	} else {
+		p.line("VariableDeclaration %s: %s", node.Name, node.Type)
+	}
+	p.child(node.Value)
+	return nil
+}
+
+func (p *PrettyPrinter) VisitMultiVariableDeclaration(node *MultiVariableDeclaration) interface{} {
+	p.line("MultiVariableDeclaration")
+	for _, decl := range node.Declarations {
+		p.child(decl)
+	}
+	return nil
+}
+
+func (p *PrettyPrinter) VisitDestructuringDeclaration(node *DestructuringDeclaration) interface{} {
+	names := strings.Join(node.Names, ", ")
+	if node.Type == nil {
+		p.line("DestructuringDeclaration %s", names)
+	} else {
+		p.line("DestructuringDeclaration %s: %s", names, node.Type)
+	}
+	p.child(node.Value)
+	return nil
+}
+
+func (p *PrettyPrinter) VisitAssignment(node *Assignment) interface{} {
+	p.line("Assignment %s", node.Name)
+	p.child(node.Value)
+	return nil
+}
+
+func (p *PrettyPrinter) VisitIfStatement(node *IfStatement) interface{} {
+	p.line("IfStatement")
+	p.labeled("Condition", node.Condition)
+	p.section("Then", node.ThenBody)
+	if len(node.ElseBody) > 0 {
+		p.section("Else", node.ElseBody)
+	}
+	return nil
+}
+
+func (p *PrettyPrinter) VisitLoopStatement(node *LoopStatement) interface{} {
+	p.line("LoopStatement %s", node.Variable)
+	p.labeled("From", node.From)
+	p.labeled("To", node.To)
+	if node.Step != nil {
+		p.labeled("Step", node.Step)
+	}
+	p.section("Body", node.Body)
+	return nil
+}
+
+func (p *PrettyPrinter) VisitSwitchStatement(node *SwitchStatement) interface{} {
+	p.line("SwitchStatement")
+	p.labeled("Subject", node.Subject)
+	for _, c := range node.Cases {
+		p.indent++
+		p.line("Case")
+		p.child(c.Value)
+		p.section("Body", c.Body)
+		p.indent--
+	}
+	if node.Default != nil {
+		p.section("Default", node.Default)
+	}
+	return nil
+}
+
+func (p *PrettyPrinter) VisitForEachStatement(node *ForEachStatement) interface{} {
+	p.line("ForEachStatement %s", node.Variable)
+	p.labeled("Iterable", node.Iterable)
+	p.section("Body", node.Body)
+	return nil
+}
+
+func (p *PrettyPrinter) VisitRepeatStatement(node *RepeatStatement) interface{} {
+	p.line("RepeatStatement")
+	p.section("Body", node.Body)
+	p.labeled("Until", node.Condition)
+	return nil
+}
+
+func (p *PrettyPrinter) VisitTryStatement(node *TryStatement) interface{} {
+	p.line("TryStatement")
+	p.section("Body", node.Body)
+	p.section("Catch "+node.Variable, node.CatchBody)
+	return nil
+}
+
+func (p *PrettyPrinter) VisitBlockStatement(node *BlockStatement) interface{} {
+	p.line("BlockStatement")
+	p.section("Body", node.Body)
+	return nil
+}
+
+func (p *PrettyPrinter) VisitFunctionDeclaration(node *FunctionDeclaration) interface{} {
+	p.function("FunctionDeclaration "+node.Name, node)
+	return nil
+}
+
+// function prints a function's signature after label, then its parameter
+// defaults and body
+func (p *PrettyPrinter) function(label string, node *FunctionDeclaration) {
+	params := make([]string, len(node.Parameters))
+	for i, param := range node.Parameters {
+		params[i] = fmt.Sprintf("%s %s", param.Type, param.Name)
+	}
+	p.line("%s(%s)", label, strings.Join(params, ", "))
+	for _, param := range node.Parameters {
+		if param.Default != nil {
+			p.labeled("Default "+param.Name, param.Default)
+		}
+	}
+	p.section("Body", node.Body)
+}
+
+func (p *PrettyPrinter) VisitReturnStatement(node *ReturnStatement) interface{} {
+	p.line("ReturnStatement")
+	if node.Value != nil {
+		p.child(node.Value)
+	}
+	return nil
+}
+
+func (p *PrettyPrinter) VisitFunctionCall(node *FunctionCall) interface{} {
+	if node.Callee != nil {
+		p.line("FunctionCall")
+		p.labeled("Callee", node.Callee)
+	} else if node.Method {
+		p.line("FunctionCall %s (method)", node.Name)
+	} else {
+		p.line("FunctionCall %s", node.Name)
+	}
+	for _, arg := range node.Arguments {
+		p.child(arg)
+	}
+	return nil
+}
+
+func (p *PrettyPrinter) VisitFunctionLiteral(node *FunctionLiteral) interface{} {
+	p.function("FunctionLiteral", node.Function)
+	return nil
+}
+
+func (p *PrettyPrinter) VisitPrintStatement(node *PrintStatement) interface{} {
+	if node.Write {
+		p.line("PrintStatement (write)")
+	} else {
+		p.line("PrintStatement")
+	}
+	p.child(node.Value)
+	return nil
+}
+
+func (p *PrettyPrinter) VisitAssertStatement(node *AssertStatement) interface{} {
+	p.line("AssertStatement")
+	p.labeled("Condition", node.Condition)
+	if node.Message != nil {
+		p.labeled("Message", node.Message)
+	}
+	return nil
+}
+
+func (p *PrettyPrinter) VisitRaiseStatement(node *RaiseStatement) interface{} {
+	p.line("RaiseStatement")
+	p.child(node.Value)
+	return nil
+}
+
+func (p *PrettyPrinter) VisitEnumDeclaration(node *EnumDeclaration) interface{} {
+	p.line("EnumDeclaration %s(%s)", node.Name, strings.Join(node.Members, ", "))
+	return nil
+}
+
+func (p *PrettyPrinter) VisitIncludeStatement(node *IncludeStatement) interface{} {
+	p.line("IncludeStatement %q", node.Path)
+	return nil
+}
+
+func (p *PrettyPrinter) VisitImportStatement(node *ImportStatement) interface{} {
+	p.line("ImportStatement %q as %s", node.Path, node.Alias)
+	return nil
+}
+
+func (p *PrettyPrinter) VisitExpressionStatement(node *ExpressionStatement) interface{} {
+	p.line("ExpressionStatement")
+	p.child(node.Expression)
+	return nil
+}
+
+func (p *PrettyPrinter) VisitBinaryExpression(node *BinaryExpression) interface{} {
+	p.line("BinaryExpression %s", node.Operator)
+	p.child(node.Left)
+	p.child(node.Right)
+	return nil
+}
+
+func (p *PrettyPrinter) VisitUnaryExpression(node *UnaryExpression) interface{} {
+	p.line("UnaryExpression %s", node.Operator)
+	p.child(node.Operand)
+	return nil
+}
+
+func (p *PrettyPrinter) VisitLiteral(node *Literal) interface{} {
+	switch node.Type.(type) {
+	case types.TextType, types.CharType:
+		p.line("Literal %s %q", node.Type, node.Value)
+	default:
+		p.line("Literal %s %v", node.Type, node.Value)
+	}
+	return nil
+}
+
+func (p *PrettyPrinter) VisitListLiteral(node *ListLiteral) interface{} {
+	p.line("ListLiteral")
+	for _, element := range node.Elements {
+		p.child(element)
+	}
+	return nil
+}
+
+func (p *PrettyPrinter) VisitIndexExpression(node *IndexExpression) interface{} {
+	p.line("IndexExpression")
+	p.child(node.Target)
+	p.labeled("Index", node.Index)
+	return nil
+}
+
+func (p *PrettyPrinter) VisitSliceExpression(node *SliceExpression) interface{} {
+	p.line("SliceExpression")
+	p.child(node.Target)
+	if node.Start != nil {
+		p.labeled("Start", node.Start)
+	}
+	if node.End != nil {
+		p.labeled("End", node.End)
+	}
+	return nil
+}
+
+func (p *PrettyPrinter) VisitIdentifier(node *Identifier) interface{} {
+	p.line("Identifier %s", node.Name)
+	return nil
+}
@@ -0,0 +1,544 @@
+// Package cli implements the simplelang command. It reads a program, takes
+// it through the compiler's stages and reports the outcome, leaving the
+// executable itself to hand over its arguments and exit with the status.
+package cli
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"simplelang/internal/ast"
+	"simplelang/internal/diagnostic"
+	"simplelang/internal/interpreter"
+	"simplelang/internal/lexer"
+	"simplelang/internal/optimizer"
+	"simplelang/internal/parser"
+	"simplelang/internal/repl"
+	"simplelang/internal/sema"
+	"simplelang/internal/transpile"
+	"simplelang/internal/vm"
+	"strings"
+	"text/tabwriter"
+)
+
+// Version and Commit describe the build. Release builds set them with the
+// linker, as in
+//
+//	go build -ldflags "-X simplelang/internal/cli.Version=1.2.0 -X simplelang/internal/cli.Commit=abc123" ./cmd/compiler
+//
+// When Commit is left empty, the revision the Go toolchain stamped into the
+// binary is used if there is one.
+var (
+	Version = "dev"
+	Commit  = ""
+)
+
+// modes lists the flags that print a stage of the pipeline instead of
+// running the program; at most one may be given
+var modes = []string{"check", "tokens", "ast", "json", "fmt", "transpile-go", "transpile-js"}
+
+// Options choose what is done with a program
+type Options struct {
+	// Mode names the stage to print, such as "tokens" or "transpile-js",
+	// or is empty to run the program
+	Mode      string
+	Optimize  bool
+	VM        bool
+	SourceMap bool
+	// Profile tallies what each kind of statement and expression did while
+	// the program runs on the interpreter
+	Profile bool
+	// BaseDir is the directory include statements resolve their paths
+	// against, that of the source file when there is one
+	BaseDir string
+	// Color highlights errors for a terminal
+	Color bool
+}
+
+// arguments holds the parsed command line
+type arguments struct {
+	Options
+	version  bool
+	inline   string
+	filename string
+}
+
+// errUsage marks a command line that could not be understood
+var errUsage = errors.New("invalid arguments")
+
+// Run carries out the command line args, not counting the program name, and
+// returns the exit status. Without arguments it starts an interactive
+// session reading from stdin; a source file named "-" is read from stdin
+// instead. Everything is written to stdout apart from the profile that
+// --profile asks for, which goes to stderr.
+func Run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		if err := repl.Run(stdin, stdout); err != nil {
+			fmt.Fprintf(stdout, "Error reading input: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	parsed, err := parseArgs(args)
+	if err == flag.ErrHelp {
+		usage(stdout)
+		return 0
+	}
+	if err != nil {
+		usage(stdout)
+		return 1
+	}
+	parsed.Color = UseColor(stdout)
+
+	switch {
+	case parsed.version:
+		writeVersion(stdout)
+		return 0
+	case parsed.filename == "":
+		return RunSource(strings.NewReader(parsed.inline), "<inline>", parsed.Options, stdout, stderr)
+	case parsed.filename == "-":
+		return RunSource(stdin, "<stdin>", parsed.Options, stdout, stderr)
+	}
+
+	file, err := os.Open(parsed.filename)
+	if err != nil {
+		fmt.Fprintf(stdout, "Error reading file %s: %v\n", parsed.filename, err)
+		return 1
+	}
+	defer file.Close()
+	parsed.BaseDir = filepath.Dir(parsed.filename)
+	return RunSource(file, parsed.filename, parsed.Options, stdout, stderr)
+}
+
+// UseColor reports whether errors written to w are highlighted, which they
+// are when w is a terminal. Setting NO_COLOR to anything but the empty text
+// turns highlighting off.
+func UseColor(w io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	file, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// RunSource reads a program from r and takes it through the stages opts
+// asks for, returning the exit status. The program's errors are reported
+// against name, which is the file it came from or a stand-in like
+// "<stdin>". A profile of the run goes to stderr.
+func RunSource(r io.Reader, name string, opts Options, stdout, stderr io.Writer) int {
+	source, err := io.ReadAll(r)
+	if err != nil {
+		fmt.Fprintf(stdout, "Error reading %s: %v\n", name, err)
+		return 1
+	}
+	job := &job{opts: opts, name: name, out: stdout, errOut: stderr}
+	if !job.compile(string(source)) {
+		return 1
+	}
+	return 0
+}
+
+// parseArgs reads the flags and the source file, which may come in any order
+func parseArgs(args []string) (*arguments, error) {
+	fs := flag.NewFlagSet("simplelang", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	selected := make(map[string]*bool, len(modes))
+	for _, mode := range modes {
+		selected[mode] = fs.Bool(mode, false, "")
+	}
+	parsed := &arguments{}
+	fs.BoolVar(&parsed.Optimize, "optimize", false, "")
+	fs.BoolVar(&parsed.VM, "vm", false, "")
+	fs.BoolVar(&parsed.SourceMap, "source-map", false, "")
+	fs.BoolVar(&parsed.Profile, "profile", false, "")
+	fs.BoolVar(&parsed.version, "version", false, "")
+	fs.StringVar(&parsed.inline, "e", "", "")
+
+	// The flag package stops at the first argument that is not a flag, so
+	// pick the file out and carry on with whatever follows it
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+
+	if parsed.version {
+		if len(positional) > 0 || fs.NFlag() > 1 {
+			return nil, errUsage
+		}
+		return parsed, nil
+	}
+
+	for _, mode := range modes {
+		if *selected[mode] {
+			if parsed.Mode != "" {
+				return nil, errUsage
+			}
+			parsed.Mode = mode
+		}
+	}
+	// Only the interpreter keeps a profile, and only of a program it runs
+	if parsed.Profile && (parsed.Mode != "" || parsed.VM) {
+		return nil, errUsage
+	}
+
+	inline := false
+	fs.Visit(func(f *flag.Flag) {
+		inline = inline || f.Name == "e"
+	})
+	switch {
+	case inline && len(positional) == 0:
+		return parsed, nil
+	case inline || len(positional) != 1:
+		return nil, errUsage
+	}
+	parsed.filename = positional[0]
+	return parsed, nil
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: simplelang [--check | --tokens | --ast | --json | --fmt | --transpile-go | --transpile-js] [--optimize] [--vm | --profile] [--source-map] <source_file>")
+	fmt.Fprintln(w, "       simplelang [options] -e <code>")
+	fmt.Fprintln(w, "       simplelang --version")
+	fmt.Fprintln(w, "Example: simplelang examples/hello.sl")
+	fmt.Fprintln(w, "Give - as the source file to read the program from standard input.")
+	fmt.Fprintln(w, "Run without arguments to start an interactive session.")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Options:")
+	fmt.Fprintln(w, "  --check    Report syntax and semantic errors, or OK, without running")
+	fmt.Fprintln(w, "  --tokens   Print the token stream and exit without running")
+	fmt.Fprintln(w, "  --ast      Print the parse tree and exit without running")
+	fmt.Fprintln(w, "  --json     Print the parse tree as JSON and exit without running")
+	fmt.Fprintln(w, "  --fmt      Print the source in canonical format and exit without running")
+	fmt.Fprintln(w, "  --transpile-go")
+	fmt.Fprintln(w, "             Print the program translated to Go and exit without running")
+	fmt.Fprintln(w, "  --transpile-js")
+	fmt.Fprintln(w, "             Print the program translated to JavaScript and exit without running")
+	fmt.Fprintln(w, "  --optimize Fold constant expressions before printing or running")
+	fmt.Fprintln(w, "  --vm       Run on the bytecode virtual machine instead of the interpreter")
+	fmt.Fprintln(w, "  --profile  Count and time each kind of statement and expression as the")
+	fmt.Fprintln(w, "             program runs on the interpreter, and print a summary to stderr")
+	fmt.Fprintln(w, "  --source-map")
+	fmt.Fprintln(w, "             Point translated code back at the source lines it came from")
+	fmt.Fprintln(w, "  -e <code>  Run code given on the command line instead of a file")
+	fmt.Fprintln(w, "  --version  Print the version, the Go version and the commit it was built from")
+	fmt.Fprintln(w, "  --help     Print this message")
+}
+
+// writeVersion prints the version of the tool, the Go release that built it
+// and the commit it was built from
+func writeVersion(w io.Writer) {
+	fmt.Fprintf(w, "simplelang %s\n", Version)
+	fmt.Fprintf(w, "go: %s\n", runtime.Version())
+	fmt.Fprintf(w, "commit: %s\n", commit())
+}
+
+func commit() string {
+	if Commit != "" {
+		return Commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return "unknown"
+}
+
+// job is one program on its way through the pipeline
+type job struct {
+	opts Options
+	// name is the file the program came from, used when reporting errors
+	name string
+	// lines holds the program's source, to show the lines errors are on
+	lines []string
+	out   io.Writer
+	// errOut receives the profile, kept apart from the program's output
+	errOut io.Writer
+}
+
+// errorf prints a message about the program, naming the file it came from
+func (j *job) errorf(format string, args ...interface{}) {
+	fmt.Fprintf(j.out, "%s: %s\n", j.name, fmt.Sprintf(format, args...))
+}
+
+// diagnose prints a problem the compiler found in the program, in the form
+// "file:line:column: error: message" that editors and terminals recognise,
+// followed by the line it is on with a caret under the column. A problem in
+// an included file is printed without the line, which is not at hand.
+func (j *job) diagnose(d *diagnostic.Diagnostic) {
+	lines := j.lines
+	if d.File != "" {
+		lines = nil
+	}
+	fmt.Fprint(j.out, d.Render(j.name, lines, j.opts.Color))
+}
+
+// fail prints the error that stopped the program from compiling to bytecode
+// or running. One with a position is printed as a diagnostic, with the line
+// it happened on; any other is prefixed with stage, what failed.
+func (j *job) fail(stage string, err error) {
+	var compileErr *vm.Error
+	var runtimeErr *interpreter.RuntimeError
+	switch {
+	case errors.As(err, &compileErr) && compileErr.Line > 0:
+		j.diagnose(&diagnostic.Diagnostic{
+			Severity: diagnostic.Error,
+			Phase:    diagnostic.Compile,
+			Message:  compileErr.Message,
+			Line:     compileErr.Line,
+			Column:   compileErr.Column,
+		})
+	case errors.As(err, &runtimeErr) && runtimeErr.Line > 0:
+		j.diagnose(&diagnostic.Diagnostic{
+			Severity: diagnostic.Error,
+			Phase:    diagnostic.Runtime,
+			Message:  runtimeErr.Message,
+			Line:     runtimeErr.Line,
+			Column:   runtimeErr.Column,
+		})
+	default:
+		j.errorf("%s: %v", stage, err)
+	}
+}
+
+// compile takes source through the stages the options ask for and reports
+// whether it succeeded
+func (j *job) compile(source string) bool {
+	j.lines = strings.Split(source, "\n")
+	switch j.opts.Mode {
+	case "check":
+		program, ok := j.parse(source)
+		if !ok || !j.check(program) {
+			return false
+		}
+		fmt.Fprintln(j.out, "OK")
+		return true
+	case "tokens":
+		tokens, ok := j.tokenize(source)
+		if ok {
+			printTokens(tokens, j.out)
+		}
+		return ok
+	case "ast", "json", "fmt":
+		program, ok := j.parse(source)
+		if !ok {
+			return false
+		}
+		if j.opts.Optimize {
+			program = optimizer.Fold(program)
+		}
+		switch j.opts.Mode {
+		case "ast":
+			fmt.Fprint(j.out, ast.NewPrettyPrinter().Print(program))
+		case "json":
+			encoded, err := ast.NewJSONEncoder().Encode(program)
+			if err != nil {
+				fmt.Fprintf(j.out, "Error encoding JSON: %v\n", err)
+				return false
+			}
+			fmt.Fprintln(j.out, string(encoded))
+		default:
+			fmt.Fprint(j.out, ast.NewFormatter().Format(program))
+		}
+		return true
+	case "transpile-go", "transpile-js":
+		program, ok := j.parse(source)
+		if !ok || !j.check(program) {
+			return false
+		}
+		if j.opts.Optimize {
+			program = optimizer.Fold(program)
+		}
+		return j.transpile(program)
+	}
+	return j.execute(source)
+}
+
+// transpile prints program translated to the language the options ask for
+func (j *job) transpile(program *ast.Program) bool {
+	if j.opts.Mode == "transpile-go" {
+		transpiler := transpile.NewGoTranspiler()
+		transpiler.SetLineComments(j.opts.SourceMap)
+		return j.translate(transpiler.Transpile(program))
+	}
+
+	transpiler := transpile.NewJSTranspiler()
+	if !j.translate(transpiler.Transpile(program)) {
+		return false
+	}
+	if j.opts.SourceMap {
+		encoded, err := transpiler.SourceMap(j.name)
+		if err != nil {
+			fmt.Fprintf(j.out, "Error encoding source map: %v\n", err)
+			return false
+		}
+		fmt.Fprintf(j.out, "//# sourceMappingURL=data:application/json;charset=utf-8;base64,%s\n", base64.StdEncoding.EncodeToString(encoded))
+	}
+	return true
+}
+
+// execute runs source, narrating each stage of the pipeline as it goes
+func (j *job) execute(source string) bool {
+	fmt.Fprintf(j.out, "Compiling and running: %s\n", j.name)
+	fmt.Fprintln(j.out, "="+string(make([]byte, 50, 50))+"=")
+
+	// Step 1: Lexical Analysis (Tokenization)
+	fmt.Fprintln(j.out, "Step 1: Lexical Analysis...")
+	tokens, ok := j.tokenize(source)
+	if !ok {
+		return false
+	}
+	fmt.Fprintf(j.out, "✓ Generated %d tokens\n", len(tokens)-1) // -1 for EOF token
+
+	// Step 2: Parsing (Syntax Analysis)
+	fmt.Fprintln(j.out, "Step 2: Parsing...")
+	program, ok := j.parseTokens(tokens)
+	if !ok {
+		return false
+	}
+	fmt.Fprintf(j.out, "✓ Parsed %d statements\n", len(program.Statements))
+
+	// Step 2.5: Semantic Analysis
+	fmt.Fprintln(j.out, "Step 2.5: Semantic analysis...")
+	if !j.check(program) {
+		return false
+	}
+	fmt.Fprintln(j.out, "✓ No semantic errors")
+
+	if j.opts.Optimize {
+		fmt.Fprintln(j.out, "Optimizing...")
+		folder := optimizer.NewFolder()
+		program = folder.Fold(program)
+		fmt.Fprintf(j.out, "✓ Folded %d constant expression(s)\n", folder.Folded())
+	}
+
+	// Step 3: Execution, either compiled to bytecode or tree-walking
+	if j.opts.VM {
+		fmt.Fprintln(j.out, "Step 3: Execution (bytecode VM)...")
+		bytecode, err := vm.Compile(program)
+		if err != nil {
+			j.fail("Compile error", err)
+			return false
+		}
+		machine := vm.New(bytecode)
+		machine.SetOutput(j.out)
+		machine.SetBaseDir(j.opts.BaseDir)
+		if err := machine.Run(); err != nil {
+			j.fail("Runtime error", err)
+			return false
+		}
+	} else {
+		fmt.Fprintln(j.out, "Step 3: Execution...")
+		interp := interpreter.NewInterpreter()
+		interp.SetOutput(j.out)
+		interp.SetBaseDir(j.opts.BaseDir)
+		if j.opts.Profile {
+			interp.EnableProfiling()
+		}
+		err := interp.Interpret(program)
+		if j.opts.Profile {
+			interp.Profile().WriteSummary(j.errOut)
+		}
+		if err != nil {
+			j.fail("Runtime error", err)
+			return false
+		}
+	}
+	fmt.Fprintln(j.out, "✓ Program executed successfully!")
+	return true
+}
+
+// tokenize runs the lexer over source, printing any lexical error
+func (j *job) tokenize(source string) ([]lexer.Token, bool) {
+	tokens, err := lexer.NewLexer(source).Tokenize()
+	var d *diagnostic.Diagnostic
+	if errors.As(err, &d) {
+		j.diagnose(d)
+		return nil, false
+	}
+	if err != nil {
+		j.errorf("Lexical error: %v", err)
+		return nil, false
+	}
+	return tokens, true
+}
+
+// parse lexes and parses source, printing any errors
+func (j *job) parse(source string) (*ast.Program, bool) {
+	tokens, ok := j.tokenize(source)
+	if !ok {
+		return nil, false
+	}
+	return j.parseTokens(tokens)
+}
+
+// parseTokens builds the AST for tokens, printing every syntax error
+func (j *job) parseTokens(tokens []lexer.Token) (*ast.Program, bool) {
+	program, err := parser.NewParser(tokens).Parse()
+	if errs, ok := err.(parser.ErrorList); ok {
+		for _, e := range errs {
+			j.diagnose(e)
+		}
+		fmt.Fprintf(j.out, "Found %d parse error(s)\n", len(errs))
+		return nil, false
+	}
+	return program, true
+}
+
+// translate prints generated code, or the error that stopped the
+// transpiler from producing it
+func (j *job) translate(generated string, err error) bool {
+	if err != nil {
+		j.errorf("%v", err)
+		return false
+	}
+	fmt.Fprint(j.out, generated)
+	return true
+}
+
+// check runs semantic analysis over program, printing every error
+func (j *job) check(program *ast.Program) bool {
+	checker := sema.NewChecker()
+	checker.SetBaseDir(j.opts.BaseDir)
+	errs := checker.Check(program)
+	for _, err := range errs {
+		j.diagnose(err)
+	}
+	if len(errs) > 0 {
+		fmt.Fprintf(j.out, "Found %d semantic error(s)\n", len(errs))
+		return false
+	}
+	return true
+}
+
+// printTokens writes the token stream as an aligned table
+func printTokens(tokens []lexer.Token, out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tVALUE\tLINE\tCOLUMN")
+	for _, token := range tokens {
+		fmt.Fprintf(w, "%s\t%q\t%d\t%d\n", token.Type, token.Value, token.Line, token.Column)
+	}
+	w.Flush()
+}
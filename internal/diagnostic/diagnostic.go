@@ -0,0 +1,130 @@
+// Package diagnostic describes the problems the compiler finds in a program
+// before running it. The lexer, the parser and the semantic checker each
+// report them as a Diagnostic, so that the command line prints them all the
+// same way and tools such as editors can read where they are without
+// parsing messages. The command line prints the errors that stop a program
+// compiled to bytecode or running as diagnostics too.
+package diagnostic
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Severity is how serious a diagnostic is
+type Severity int
+
+const (
+	// Error stops the program from running
+	Error Severity = iota
+	// Warning points out something that runs but is likely a mistake
+	Warning
+)
+
+func (s Severity) String() string {
+	if s == Warning {
+		return "warning"
+	}
+	return "error"
+}
+
+// Phase names the stage of the compiler that found a problem
+type Phase string
+
+const (
+	Lexical  Phase = "lexical"
+	Parse    Phase = "parse"
+	Semantic Phase = "semantic"
+	Compile  Phase = "compile"
+	Runtime  Phase = "runtime"
+)
+
+// Diagnostic is a problem at a position in the source. Line and Column
+// count from 1, and Length is how many characters the problem spans on that
+// line, or 0 when only its start is known. File is empty for a problem in
+// the program itself and names the included or imported file one is in
+// otherwise, the position then being in that file.
+type Diagnostic struct {
+	Severity Severity
+	Phase    Phase
+	Message  string
+	Line     int
+	Column   int
+	Length   int
+	File     string
+}
+
+// Error describes the diagnostic in a sentence, such as "parse error at
+// line 2, column 5: expected 'end'", which is how the compiler's errors
+// have always read
+func (d *Diagnostic) Error() string {
+	return fmt.Sprintf("%s %s at line %d, column %d: %s", d.Phase, d.Severity, d.Line, d.Column, d.Message)
+}
+
+// Format renders the diagnostic the way compilers do, as
+// "file:line:column: error: message", for the source file named file
+func (d *Diagnostic) Format(file string) string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s", file, d.Line, d.Column, d.Severity, d.Message)
+}
+
+// ANSI escape codes that highlight parts of a rendered diagnostic
+const (
+	bold   = "\x1b[1m"
+	red    = "\x1b[1;31m"
+	purple = "\x1b[1;35m"
+	green  = "\x1b[1;32m"
+	reset  = "\x1b[0m"
+)
+
+// Render formats the diagnostic as Format does, followed by the line of
+// source it is on, taken from lines, with a caret under its column and
+// tildes under the rest of what it spans. Only the first line is given when
+// the source line is not among lines. With color, the severity and caret
+// are highlighted for a terminal.
+func (d *Diagnostic) Render(file string, lines []string, color bool) string {
+	var b strings.Builder
+	if color {
+		highlight := red
+		if d.Severity == Warning {
+			highlight = purple
+		}
+		fmt.Fprintf(&b, "%s%s:%d:%d: %s%s:%s %s%s%s\n", bold, file, d.Line, d.Column, highlight, d.Severity, reset, bold, d.Message, reset)
+	} else {
+		b.WriteString(d.Format(file) + "\n")
+	}
+	if d.Line < 1 || d.Line > len(lines) {
+		return b.String()
+	}
+
+	line := strings.TrimSuffix(lines[d.Line-1], "\r")
+	b.WriteString(line + "\n")
+
+	// Line the caret up with the column, keeping any tabs before it so that
+	// it lands in the same place however wide the terminal shows them
+	column := 1
+	for _, char := range line {
+		if column >= d.Column {
+			break
+		}
+		if char == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+		column++
+	}
+	length := d.Length
+	if rest := utf8.RuneCountInString(line) - column + 1; length > rest {
+		length = rest
+	}
+	marker := "^"
+	if length > 1 {
+		marker += strings.Repeat("~", length-1)
+	}
+	if color {
+		marker = green + marker + reset
+	}
+	b.WriteString(marker + "\n")
+	return b.String()
+}
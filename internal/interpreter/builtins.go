@@ -0,0 +1,737 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"simplelang/internal/ast"
+	"simplelang/internal/lexer"
+	"simplelang/internal/parser"
+	"simplelang/internal/types"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// builtinFunc implements a function provided by the interpreter itself rather
+// than declared in SimpleLang source. Arguments arrive already evaluated.
+type builtinFunc func(i *Interpreter, args []types.Value) (types.Value, error)
+
+// builtin is a registered built-in function and the number of arguments it
+// takes. A variadic built-in takes at least arity arguments, and one with
+// optional arguments up to that many more.
+type builtin struct {
+	arity    int
+	optional int
+	variadic bool
+	call     builtinFunc
+}
+
+// builtins holds every built-in function by name. A function declared in the
+// program with the same name takes precedence.
+var builtins = map[string]builtin{}
+
+func registerBuiltin(name string, arity int, call builtinFunc) {
+	builtins[name] = builtin{arity: arity, call: call}
+}
+
+func registerVariadicBuiltin(name string, minimum int, call builtinFunc) {
+	builtins[name] = builtin{arity: minimum, variadic: true, call: call}
+}
+
+func registerBuiltinWithOptional(name string, arity, optional int, call builtinFunc) {
+	builtins[name] = builtin{arity: arity, optional: optional, call: call}
+}
+
+func init() {
+	registerBuiltin("toNumber", 1, builtinToNumber)
+	registerBuiltin("toText", 1, builtinToText)
+	registerBuiltin("toBoolean", 1, builtinToBoolean)
+	registerVariadicBuiltin("format", 1, builtinFormat)
+	registerBuiltin("type", 1, builtinType)
+	registerBuiltin("upper", 1, builtinUpper)
+	registerBuiltin("lower", 1, builtinLower)
+	registerBuiltin("length", 1, builtinLength)
+	registerBuiltin("contains", 2, builtinContains)
+	registerBuiltin("indexOf", 2, builtinIndexOf)
+	registerBuiltin("replace", 3, builtinReplace)
+	registerBuiltinWithOptional("trim", 1, 1, builtinTrim("trim", strings.Trim, strings.TrimSpace))
+	registerBuiltinWithOptional("trimLeft", 1, 1, builtinTrim("trimLeft", strings.TrimLeft, trimLeftSpace))
+	registerBuiltinWithOptional("trimRight", 1, 1, builtinTrim("trimRight", strings.TrimRight, trimRightSpace))
+	registerBuiltinWithOptional("padLeft", 2, 1, builtinPad("padLeft", true))
+	registerBuiltinWithOptional("padRight", 2, 1, builtinPad("padRight", false))
+	registerBuiltin("concat", 2, builtinConcat)
+	registerBuiltin("join", 2, builtinJoin)
+	registerBuiltin("split", 2, builtinSplit)
+	registerBuiltin("splitLines", 1, builtinSplitLines)
+	registerBuiltin("map", 2, builtinMap)
+	registerBuiltin("filter", 2, builtinFilter)
+	registerBuiltin("reduce", 3, builtinReduce)
+	registerBuiltin("random", 0, builtinRandom)
+	registerBuiltin("randomInt", 2, builtinRandomInt)
+	registerBuiltin("now", 0, builtinNow)
+	registerBuiltin("sleep", 1, builtinSleep)
+	registerBuiltin("eval", 1, builtinEval)
+	registerBuiltin("dumpEnv", 0, builtinDumpEnv)
+}
+
+// checkArity fails if a call passes the built-in name the wrong number of
+// arguments
+func (b builtin) checkArity(name string, count int) error {
+	if b.variadic {
+		if count < b.arity {
+			return fmt.Errorf("function %s expects at least %d arguments, got %d", name, b.arity, count)
+		}
+		return nil
+	}
+	if b.optional > 0 && (count < b.arity || count > b.arity+b.optional) {
+		return fmt.Errorf("function %s expects %d to %d arguments, got %d", name, b.arity, b.arity+b.optional, count)
+	}
+	if b.optional == 0 && count != b.arity {
+		return fmt.Errorf("function %s expects %d arguments, got %d", name, b.arity, count)
+	}
+	return nil
+}
+
+// callBuiltin checks the argument count and invokes a built-in
+func (i *Interpreter) callBuiltin(name string, b builtin, args []types.Value) (types.Value, error) {
+	if err := b.checkArity(name, len(args)); err != nil {
+		return nil, err
+	}
+	return b.call(i, args)
+}
+
+// CallBuiltin calls the built-in function name with already evaluated
+// arguments, as if from the scope env, which is what dumpEnv shows. It fails
+// if there is no such built-in.
+func (i *Interpreter) CallBuiltin(env *Environment, name string, args []types.Value) (types.Value, error) {
+	b, ok := builtins[name]
+	if !ok {
+		return nil, fmt.Errorf("undefined function: %s", name)
+	}
+
+	previous := i.environment
+	i.environment = env
+	defer func() {
+		i.environment = previous
+	}()
+	return i.callBuiltin(name, b, args)
+}
+
+// HostFunction is a Go function that a program embedding the interpreter
+// makes callable from SimpleLang. It receives the evaluated arguments, however
+// many the call passed, and returns the call's result. A nil result counts as
+// returning nothing, and an error becomes a runtime error at the call.
+type HostFunction func(args []types.Value) (types.Value, error)
+
+// RegisterBuiltin makes fn callable as name from the programs this
+// interpreter runs. A function or variable the program declares with the
+// same name takes precedence, but fn takes precedence over a built-in.
+func (i *Interpreter) RegisterBuiltin(name string, fn HostFunction) {
+	i.hosts[name] = fn
+}
+
+// hostFunction finds the function registered as name, unless the program
+// has declared a function or variable that hides it
+func (i *Interpreter) hostFunction(name string) (HostFunction, bool) {
+	host, ok := i.hosts[name]
+	if !ok {
+		return nil, false
+	}
+	if _, declared := i.functions.Find(i.environment, name); declared {
+		return nil, false
+	}
+	if _, declared := i.environment.GetVariable(name); declared {
+		return nil, false
+	}
+	return host, true
+}
+
+func callHost(host HostFunction, args []types.Value) (types.Value, error) {
+	result, err := host(args)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil {
+		return types.VoidValue{}, nil
+	}
+	return result, nil
+}
+
+// builtinToNumber passes numbers through unchanged, parses text, ignoring
+// surrounding whitespace, and gives the number backing an enum member
+func builtinToNumber(i *Interpreter, args []types.Value) (types.Value, error) {
+	switch value := args[0].(type) {
+	case types.IntValue, types.NumberValue:
+		return value, nil
+	case types.EnumValue:
+		return types.IntValue{Value: value.Value}, nil
+	case types.TextValue:
+		num, err := strconv.ParseFloat(strings.TrimSpace(value.Value), 64)
+		if err != nil {
+			return nil, fmt.Errorf("toNumber: cannot convert %q to a number", value.Value)
+		}
+		return types.NumberValue{Value: num}, nil
+	default:
+		return nil, fmt.Errorf("toNumber: cannot convert %s to a number", value.Type().String())
+	}
+}
+
+// builtinToText renders any value the same way print does
+func builtinToText(i *Interpreter, args []types.Value) (types.Value, error) {
+	return types.TextValue{Value: args[0].String()}, nil
+}
+
+// builtinType names the type of any value, as declarations spell it
+func builtinType(i *Interpreter, args []types.Value) (types.Value, error) {
+	return types.TextValue{Value: args[0].Type().String()}, nil
+}
+
+// builtinToBoolean accepts booleans, the exact texts "true" and "false", and
+// numbers, where zero is false and anything else is true
+func builtinToBoolean(i *Interpreter, args []types.Value) (types.Value, error) {
+	switch value := args[0].(type) {
+	case types.BooleanValue:
+		return value, nil
+	case types.TextValue:
+		switch value.Value {
+		case "true":
+			return types.BooleanValue{Value: true}, nil
+		case "false":
+			return types.BooleanValue{Value: false}, nil
+		}
+		return nil, fmt.Errorf("toBoolean: cannot convert %q to a boolean", value.Value)
+	case types.IntValue, types.NumberValue:
+		return types.BooleanValue{Value: toFloat(value) != 0}, nil
+	default:
+		return nil, fmt.Errorf("toBoolean: cannot convert %s to a boolean", value.Type().String())
+	}
+}
+
+// builtinFormat substitutes its remaining arguments, shown as print would
+// show them, for the {} placeholders of the template in its first. {{ and }}
+// stand for literal braces, and a brace that is neither is an error.
+func builtinFormat(i *Interpreter, args []types.Value) (types.Value, error) {
+	template, ok := args[0].(types.TextValue)
+	if !ok {
+		return nil, fmt.Errorf("format: template must be text, got %s", args[0].Type().String())
+	}
+	values := args[1:]
+
+	var result strings.Builder
+	placeholders := 0
+	text := template.Value
+	for j := 0; j < len(text); j++ {
+		switch {
+		case strings.HasPrefix(text[j:], "{{"), strings.HasPrefix(text[j:], "}}"):
+			result.WriteByte(text[j])
+			j++
+		case strings.HasPrefix(text[j:], "{}"):
+			if placeholders < len(values) {
+				result.WriteString(values[placeholders].String())
+			}
+			placeholders++
+			j++
+		case text[j] == '{':
+			return nil, fmt.Errorf("format: '{' must start a {} placeholder or be doubled as {{")
+		case text[j] == '}':
+			return nil, fmt.Errorf("format: '}' must end a {} placeholder or be doubled as }}")
+		default:
+			result.WriteByte(text[j])
+		}
+	}
+
+	if placeholders != len(values) {
+		return nil, fmt.Errorf("format: template has %d placeholders but %d values were given", placeholders, len(values))
+	}
+	return types.TextValue{Value: result.String()}, nil
+}
+
+// builtinConcat returns a new list holding the elements of its first list
+// followed by those of its second, leaving both unchanged
+func builtinConcat(i *Interpreter, args []types.Value) (types.Value, error) {
+	var elements []types.Value
+	for n, arg := range args {
+		list, ok := arg.(types.ListValue)
+		if !ok {
+			return nil, fmt.Errorf("concat: argument %d must be a list, got %s", n+1, arg.Type().String())
+		}
+		elements = append(elements, list.Elements...)
+	}
+	if elements == nil {
+		elements = []types.Value{}
+	}
+	return types.ListValue{Elements: elements}, nil
+}
+
+// builtinUpper converts text to upper case
+func builtinUpper(i *Interpreter, args []types.Value) (types.Value, error) {
+	text, ok := args[0].(types.TextValue)
+	if !ok {
+		return nil, fmt.Errorf("upper: argument must be text, got %s", args[0].Type().String())
+	}
+	return types.TextValue{Value: strings.ToUpper(text.Value)}, nil
+}
+
+// builtinLower converts text to lower case
+func builtinLower(i *Interpreter, args []types.Value) (types.Value, error) {
+	text, ok := args[0].(types.TextValue)
+	if !ok {
+		return nil, fmt.Errorf("lower: argument must be text, got %s", args[0].Type().String())
+	}
+	return types.TextValue{Value: strings.ToLower(text.Value)}, nil
+}
+
+// builtinLength counts the characters of text, the same units that indexing
+// uses, or the elements of a list
+func builtinLength(i *Interpreter, args []types.Value) (types.Value, error) {
+	switch value := args[0].(type) {
+	case types.TextValue:
+		return types.IntValue{Value: int64(utf8.RuneCountInString(value.Value))}, nil
+	case types.ListValue:
+		return types.IntValue{Value: int64(len(value.Elements))}, nil
+	default:
+		return nil, fmt.Errorf("length: argument must be text or a list, got %s", args[0].Type().String())
+	}
+}
+
+// textArguments checks that every argument to the built-in name is text and
+// returns their values
+func textArguments(name string, args []types.Value) ([]string, error) {
+	texts := make([]string, len(args))
+	for n, arg := range args {
+		text, ok := arg.(types.TextValue)
+		if !ok {
+			return nil, fmt.Errorf("%s: argument %d must be text, got %s", name, n+1, arg.Type().String())
+		}
+		texts[n] = text.Value
+	}
+	return texts, nil
+}
+
+// builtinContains reports whether the needle occurs in the haystack. Empty
+// text occurs in every text.
+func builtinContains(i *Interpreter, args []types.Value) (types.Value, error) {
+	texts, err := textArguments("contains", args)
+	if err != nil {
+		return nil, err
+	}
+	return types.BooleanValue{Value: strings.Contains(texts[0], texts[1])}, nil
+}
+
+// builtinIndexOf finds the first occurrence of the needle in the haystack,
+// counting characters as indexing does, or gives -1 if there is none. Empty
+// text is found at 0.
+func builtinIndexOf(i *Interpreter, args []types.Value) (types.Value, error) {
+	texts, err := textArguments("indexOf", args)
+	if err != nil {
+		return nil, err
+	}
+	index := strings.Index(texts[0], texts[1])
+	if index < 0 {
+		return types.IntValue{Value: -1}, nil
+	}
+	return types.IntValue{Value: int64(utf8.RuneCountInString(texts[0][:index]))}, nil
+}
+
+// builtinReplace replaces every occurrence of old in the text with new.
+// Replacing empty text puts new before every character and at the end.
+func builtinReplace(i *Interpreter, args []types.Value) (types.Value, error) {
+	texts, err := textArguments("replace", args)
+	if err != nil {
+		return nil, err
+	}
+	return types.TextValue{Value: strings.ReplaceAll(texts[0], texts[1], texts[2])}, nil
+}
+
+// builtinTrim makes a trim built-in that removes whitespace from one or both
+// ends of text, or, given a second argument, any of the characters in it
+func builtinTrim(name string, trimCharacters func(s, cutset string) string, trimSpace func(s string) string) builtinFunc {
+	return func(i *Interpreter, args []types.Value) (types.Value, error) {
+		texts, err := textArguments(name, args)
+		if err != nil {
+			return nil, err
+		}
+		if len(texts) == 2 {
+			return types.TextValue{Value: trimCharacters(texts[0], texts[1])}, nil
+		}
+		return types.TextValue{Value: trimSpace(texts[0])}, nil
+	}
+}
+
+func trimLeftSpace(s string) string  { return strings.TrimLeftFunc(s, unicode.IsSpace) }
+func trimRightSpace(s string) string { return strings.TrimRightFunc(s, unicode.IsSpace) }
+
+// builtinPad makes a pad built-in that adds spaces, or copies of the single
+// character given as a third argument, to the start or the end of text until
+// it is width characters long. Text already that long is left as it is.
+func builtinPad(name string, left bool) builtinFunc {
+	return func(i *Interpreter, args []types.Value) (types.Value, error) {
+		text, ok := args[0].(types.TextValue)
+		if !ok {
+			return nil, fmt.Errorf("%s: argument 1 must be text, got %s", name, args[0].Type().String())
+		}
+		width, err := indexPosition(args[1])
+		if err != nil {
+			return nil, fmt.Errorf("%s: width must be a whole number, got %s", name, describeArgument(args[1]))
+		}
+		if width < 0 {
+			return nil, fmt.Errorf("%s: width cannot be negative, got %d", name, width)
+		}
+		pad := " "
+		if len(args) == 3 {
+			character, ok := args[2].(types.TextValue)
+			if !ok || utf8.RuneCountInString(character.Value) != 1 {
+				return nil, fmt.Errorf("%s: padding must be a single character, got %s", name, describeArgument(args[2]))
+			}
+			pad = character.Value
+		}
+
+		missing := width - int64(utf8.RuneCountInString(text.Value))
+		if missing <= 0 {
+			return text, nil
+		}
+		if missing > maxRepeatedText/int64(len(pad)) {
+			return nil, fmt.Errorf("%s: padded text would be longer than %d bytes", name, maxRepeatedText)
+		}
+		padding := strings.Repeat(pad, int(missing))
+		if left {
+			return types.TextValue{Value: padding + text.Value}, nil
+		}
+		return types.TextValue{Value: text.Value + padding}, nil
+	}
+}
+
+// describeArgument shows a rejected argument in an error: text quoted, so
+// that empty text is visible, numbers as they print, and anything else by
+// its type
+func describeArgument(value types.Value) string {
+	switch v := value.(type) {
+	case types.TextValue:
+		return strconv.Quote(v.Value)
+	case types.IntValue, types.NumberValue:
+		return v.String()
+	default:
+		return value.Type().String()
+	}
+}
+
+// builtinJoin places the separator between the texts of a list, so that an
+// empty list joins to empty text
+func builtinJoin(i *Interpreter, args []types.Value) (types.Value, error) {
+	list, ok := args[0].(types.ListValue)
+	if !ok {
+		return nil, fmt.Errorf("join: first argument must be a list, got %s", args[0].Type().String())
+	}
+	separator, ok := args[1].(types.TextValue)
+	if !ok {
+		return nil, fmt.Errorf("join: separator must be text, got %s", args[1].Type().String())
+	}
+
+	parts := make([]string, len(list.Elements))
+	for n, element := range list.Elements {
+		text, ok := element.(types.TextValue)
+		if !ok {
+			return nil, fmt.Errorf("join: element %d is %s, expected text", n, element.Type().String())
+		}
+		parts[n] = text.Value
+	}
+	return types.TextValue{Value: strings.Join(parts, separator.Value)}, nil
+}
+
+// textList makes a list of texts
+func textList(parts []string) types.ListValue {
+	elements := make([]types.Value, len(parts))
+	for n, part := range parts {
+		elements[n] = types.TextValue{Value: part}
+	}
+	return types.ListValue{Elements: elements}
+}
+
+// builtinSplit cuts text at every occurrence of the separator, undoing join:
+// join(split(s, sep), sep) is always s. So empty text gives a list of one
+// empty text, except with an empty separator, which splits text into its
+// characters and so gives an empty list.
+func builtinSplit(i *Interpreter, args []types.Value) (types.Value, error) {
+	texts, err := textArguments("split", args)
+	if err != nil {
+		return nil, err
+	}
+	return textList(strings.Split(texts[0], texts[1])), nil
+}
+
+// builtinSplitLines splits text into its lines, without their line endings.
+// A line may end in "\n" or "\r\n", and a final line ending does not start
+// another line, so empty text has no lines at all.
+func builtinSplitLines(i *Interpreter, args []types.Value) (types.Value, error) {
+	texts, err := textArguments("splitLines", args)
+	if err != nil {
+		return nil, err
+	}
+	if texts[0] == "" {
+		return types.ListValue{Elements: []types.Value{}}, nil
+	}
+	lines := strings.Split(strings.TrimSuffix(texts[0], "\n"), "\n")
+	for n, line := range lines {
+		lines[n] = strings.TrimSuffix(line, "\r")
+	}
+	return textList(lines), nil
+}
+
+// listAndFunction checks the list and function arguments that the
+// higher-order built-ins take first
+func listAndFunction(name string, args []types.Value) (types.ListValue, FunctionValue, error) {
+	list, ok := args[0].(types.ListValue)
+	if !ok {
+		return types.ListValue{}, FunctionValue{}, fmt.Errorf("%s: first argument must be a list, got %s", name, args[0].Type().String())
+	}
+	function, ok := args[1].(FunctionValue)
+	if !ok {
+		return types.ListValue{}, FunctionValue{}, fmt.Errorf("%s: second argument must be a function, got %s", name, args[1].Type().String())
+	}
+	return list, function, nil
+}
+
+// callback calls a function passed to a built-in. It counts towards the
+// recursion limit like a call written in the program, and any error it
+// fails with stops the built-in.
+func (i *Interpreter) callback(function FunctionValue, args ...types.Value) (types.Value, error) {
+	i.depth++
+	defer func() {
+		i.depth--
+	}()
+	if i.depth > i.maxDepth {
+		return nil, fmt.Errorf("maximum recursion depth exceeded (%d)", i.maxDepth)
+	}
+	return i.callFunction(function, args)
+}
+
+// CallFunction calls a function the program declared, as a built-in calling
+// it back would, for another backend that has no compiled code for it, such
+// as a function declared by an included file
+func (i *Interpreter) CallFunction(function FunctionValue, args []types.Value) (types.Value, error) {
+	return i.callback(function, args...)
+}
+
+// builtinMap returns a new list of the results of calling the function on
+// each element in turn
+func builtinMap(i *Interpreter, args []types.Value) (types.Value, error) {
+	list, function, err := listAndFunction("map", args)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]types.Value, len(list.Elements))
+	for n, element := range list.Elements {
+		if results[n], err = i.callback(function, element); err != nil {
+			return nil, err
+		}
+	}
+	return types.ListValue{Elements: results}, nil
+}
+
+// builtinFilter returns a new list of the elements for which the predicate
+// returns true
+func builtinFilter(i *Interpreter, args []types.Value) (types.Value, error) {
+	list, predicate, err := listAndFunction("filter", args)
+	if err != nil {
+		return nil, err
+	}
+
+	kept := []types.Value{}
+	for _, element := range list.Elements {
+		result, err := i.callback(predicate, element)
+		if err != nil {
+			return nil, err
+		}
+		keep, ok := result.(types.BooleanValue)
+		if !ok {
+			return nil, fmt.Errorf("filter: predicate must return a boolean, got %s", result.Type().String())
+		}
+		if keep.Value {
+			kept = append(kept, element)
+		}
+	}
+	return types.ListValue{Elements: kept}, nil
+}
+
+// builtinReduce threads an accumulator through the list, starting from the
+// initial value and replacing it with the result of calling the function
+// with the accumulator and each element. An empty list reduces to the
+// initial value.
+func builtinReduce(i *Interpreter, args []types.Value) (types.Value, error) {
+	list, function, err := listAndFunction("reduce", args)
+	if err != nil {
+		return nil, err
+	}
+
+	accumulator := args[2]
+	for _, element := range list.Elements {
+		if accumulator, err = i.callback(function, accumulator, element); err != nil {
+			return nil, err
+		}
+	}
+	return accumulator, nil
+}
+
+// CheckBuiltinArguments reports whether name is a built-in function and, if
+// it is, fails when count is the wrong number of arguments for it
+func CheckBuiltinArguments(name string, count int) (bool, error) {
+	b, ok := builtins[name]
+	if !ok {
+		return false, nil
+	}
+	return true, b.checkArity(name, count)
+}
+
+// builtinRandom draws a number from 0 up to but not including 1
+func builtinRandom(i *Interpreter, args []types.Value) (types.Value, error) {
+	return types.NumberValue{Value: i.random.Float64()}, nil
+}
+
+// builtinRandomInt draws an integer from min to max, both included. The
+// bounds may be numbers as long as they are whole.
+func builtinRandomInt(i *Interpreter, args []types.Value) (types.Value, error) {
+	var bounds [2]int64
+	for n, arg := range args {
+		bound, err := indexPosition(arg)
+		if err != nil {
+			return nil, fmt.Errorf("randomInt: argument %d must be a whole number, got %s", n+1, describeArgument(arg))
+		}
+		bounds[n] = bound
+	}
+	min, max := bounds[0], bounds[1]
+	if min > max {
+		return nil, fmt.Errorf("randomInt: min must not be greater than max, got %d and %d", min, max)
+	}
+
+	if span := max - min + 1; span > 0 {
+		return types.IntValue{Value: min + i.random.Int63n(span)}, nil
+	}
+	// The range covers more than half of all integers, so its size does not
+	// fit in an int64; drawing from all of them until one lands inside takes
+	// fewer than two tries on average
+	for {
+		if n := int64(i.random.Uint64()); n >= min && n <= max {
+			return types.IntValue{Value: n}, nil
+		}
+	}
+}
+
+// builtinNow gives the current time as seconds since the Unix epoch, with
+// the fraction of a second after the point
+func builtinNow(i *Interpreter, args []types.Value) (types.Value, error) {
+	return types.NumberValue{Value: float64(i.clock().UnixNano()) / float64(time.Second)}, nil
+}
+
+// builtinSleep pauses the program for a number of seconds, waking early with
+// the context's error if the context the program runs under is done first.
+// A sleep longer than a time.Duration can hold, even an infinite one, lasts
+// as long as the longest Duration instead.
+func builtinSleep(i *Interpreter, args []types.Value) (types.Value, error) {
+	var seconds float64
+	switch value := args[0].(type) {
+	case types.IntValue:
+		seconds = float64(value.Value)
+	case types.NumberValue:
+		seconds = value.Value
+	default:
+		return nil, fmt.Errorf("sleep: argument must be a number, got %s", describeArgument(value))
+	}
+	if seconds < 0 || math.IsNaN(seconds) {
+		return nil, fmt.Errorf("sleep: cannot sleep for %s seconds", describeArgument(args[0]))
+	}
+
+	duration := time.Duration(math.MaxInt64)
+	if nanoseconds := seconds * float64(time.Second); nanoseconds < math.MaxInt64 {
+		duration = time.Duration(nanoseconds)
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return types.VoidValue{}, nil
+	case <-i.ctx.Done():
+		return nil, &cancellation{err: i.ctx.Err()}
+	}
+}
+
+// builtinEval runs source code in the scope it is called from, so that what
+// the code declares stays declared there, and gives the value of the code
+// when it is a single expression or else that of its last statement. Each
+// eval counts towards the recursion limit like a call, so code that evals
+// itself stops with an error rather than overflowing.
+func builtinEval(i *Interpreter, args []types.Value) (types.Value, error) {
+	source, ok := args[0].(types.TextValue)
+	if !ok {
+		return nil, fmt.Errorf("eval: argument must be text, got %s", describeArgument(args[0]))
+	}
+
+	i.depth++
+	defer func() {
+		i.depth--
+	}()
+	if i.depth > i.maxDepth {
+		return nil, fmt.Errorf("eval: maximum recursion depth exceeded (%d)", i.maxDepth)
+	}
+
+	tokens, err := lexer.NewLexer(source.Value).Tokenize()
+	if err != nil {
+		return nil, fmt.Errorf("eval: %v", err)
+	}
+	// Code that is a single expression gives its value, even one such as
+	// 1 + 2 that could not stand as a statement
+	if expr, err := parser.ParseExpression(tokens); err == nil {
+		value, err := i.evaluateExpression(expr)
+		if err != nil {
+			return nil, evalFailure(newRuntimeError(expr.Pos(), err))
+		}
+		return value, nil
+	}
+	program, err := parser.NewParser(tokens).Parse()
+	if err != nil {
+		return nil, fmt.Errorf("eval: %v", err)
+	}
+
+	// Functions the code declares can be called before their declaration,
+	// as in a program
+	for _, statement := range program.Statements {
+		if function, ok := statement.(*ast.FunctionDeclaration); ok {
+			i.functions.Declare(i.environment, function)
+		}
+	}
+
+	var result types.Value = types.VoidValue{}
+	for _, statement := range program.Statements {
+		result, err = i.executeStatement(statement)
+		if err != nil {
+			return nil, evalFailure(err)
+		}
+	}
+	return result, nil
+}
+
+// evalFailure prefixes an error from evaluated code with eval:. A failure
+// that already came out of an eval nested in that code passes through as it
+// is, so that code evaluating itself reports the innermost failure once
+// rather than once per level.
+func evalFailure(err error) error {
+	switch e := err.(type) {
+	case *cancellation, *limitExceeded:
+		return err
+	case *RuntimeError:
+		if strings.HasPrefix(e.Message, "eval: ") {
+			return errors.New(e.Message)
+		}
+	}
+	return fmt.Errorf("eval: %v", err)
+}
+
+// builtinDumpEnv prints every variable visible from where it is called, scope
+// by scope from the innermost outwards, for debugging
+func builtinDumpEnv(i *Interpreter, args []types.Value) (types.Value, error) {
+	i.environment.Dump(i.output)
+	return types.VoidValue{}, nil
+}
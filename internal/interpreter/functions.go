@@ -0,0 +1,241 @@
+package interpreter
+
+import (
+	"fmt"
+	"simplelang/internal/ast"
+	"simplelang/internal/types"
+	"strings"
+)
+
+// FunctionValue is a function used as a value: stored in a variable, passed
+// as an argument or returned from another function. Closure is the
+// environment the function was declared in, which its body keeps seeing for
+// as long as the value exists.
+//
+// It lives here rather than in the types package because it refers to both
+// the AST and the Environment, which types cannot import.
+type FunctionValue struct {
+	Declaration *ast.FunctionDeclaration
+	Closure     *Environment
+}
+
+func (f FunctionValue) Type() types.Type { return types.FunctionType{} }
+func (f FunctionValue) String() string   { return "<function " + f.Declaration.Name + ">" }
+
+// ModuleValue is a module brought in by an import statement, the value its
+// alias holds. Scope holds what the module's file declared, which the
+// program reaches through qualified names such as m.add.
+type ModuleValue struct {
+	Path  string
+	Scope *Environment
+}
+
+func (m ModuleValue) Type() types.Type { return types.ModuleType{} }
+func (m ModuleValue) String() string   { return "<module " + m.Path + ">" }
+
+// moduleMember splits a qualified name such as m.add into the scope of the
+// module its first part names and the rest of the name. It reports false
+// when the first part is not a module, as for an enum member such as
+// Color.red.
+func moduleMember(env *Environment, name string) (*Environment, string, bool) {
+	dot := strings.IndexByte(name, '.')
+	if dot < 0 {
+		return nil, "", false
+	}
+	value, exists := env.GetVariable(name[:dot])
+	if !exists {
+		return nil, "", false
+	}
+	module, ok := value.(ModuleValue)
+	if !ok {
+		return nil, "", false
+	}
+	return module.Scope, name[dot+1:], true
+}
+
+// returnSignal carries the value of a return statement up through the
+// statements enclosing it to the call that ran the function
+type returnSignal struct {
+	value types.Value
+}
+
+func (r *returnSignal) Error() string {
+	return "return outside of a function"
+}
+
+// GetFunctionValue finds a function declared in a nested scope by name,
+// together with the environment that declared it. Top-level functions are
+// not in any environment; see FunctionTable.
+func (e *Environment) GetFunctionValue(name string) (FunctionValue, bool) {
+	for env := e; env != nil; env = env.parent {
+		if function, exists := env.functions[name]; exists {
+			return FunctionValue{Declaration: function, Closure: env}, true
+		}
+	}
+	return FunctionValue{}, false
+}
+
+// FunctionTable holds the functions declared at the top level of a program,
+// apart from the scopes that hold variables, so that a top-level function is
+// found the same way from the main program and from any depth of calls.
+// Functions declared inside a function or a block stay in the environment
+// they were declared in, which their bodies close over.
+type FunctionTable struct {
+	declarations map[string]*ast.FunctionDeclaration
+	globals      *Environment
+}
+
+// NewFunctionTable creates an empty table for the top level whose variables
+// live in globals
+func NewFunctionTable(globals *Environment) *FunctionTable {
+	return &FunctionTable{
+		declarations: make(map[string]*ast.FunctionDeclaration),
+		globals:      globals,
+	}
+}
+
+// Declare records a function declared in env: in the table when env is the
+// top level, and in env itself otherwise
+func (t *FunctionTable) Declare(env *Environment, decl *ast.FunctionDeclaration) {
+	if env == t.globals {
+		t.declarations[decl.Name] = decl
+		return
+	}
+	env.SetFunction(decl.Name, decl)
+}
+
+// Find looks a function up by name from env: first among the functions
+// declared in env and the scopes enclosing it, so a nested function hides a
+// top-level one of the same name, then in the table
+func (t *FunctionTable) Find(env *Environment, name string) (FunctionValue, bool) {
+	if function, exists := env.GetFunctionValue(name); exists {
+		return function, true
+	}
+	if decl, exists := t.declarations[name]; exists {
+		return FunctionValue{Declaration: decl, Closure: t.globals}, true
+	}
+	return FunctionValue{}, false
+}
+
+// functionInfo is what every call to one function needs to know about its
+// declaration, worked out on the first call and reused by the rest
+type functionInfo struct {
+	// required is how many parameters have no default
+	required int
+	// slots is how many variables a call's environment is expected to
+	// hold: the parameters and the locals the body declares in that same
+	// scope. It sizes the environment up front and is only a hint.
+	slots int
+}
+
+// info returns the cached functionInfo for decl, working it out if this is
+// the first call
+func (i *Interpreter) info(decl *ast.FunctionDeclaration) *functionInfo {
+	if info, ok := i.infos[decl]; ok {
+		return info
+	}
+	info := &functionInfo{
+		required: decl.RequiredParameters(),
+		slots:    len(decl.Parameters) + countLocals(decl.Body),
+	}
+	i.infos[decl] = info
+	return info
+}
+
+// countLocals counts the variables body declares in its own scope. The
+// blocks within it have scopes of their own, so what they declare does not
+// count.
+func countLocals(body []ast.Statement) int {
+	count := 0
+	for _, statement := range body {
+		switch stmt := statement.(type) {
+		case *ast.VariableDeclaration:
+			count++
+		case *ast.MultiVariableDeclaration:
+			count += len(stmt.Declarations)
+		case *ast.DestructuringDeclaration:
+			count += len(stmt.Names)
+		case *ast.EnumDeclaration:
+			count += len(stmt.Members)
+		}
+	}
+	return count
+}
+
+// LookupName finds what a name read as a value refers to: a variable, then a
+// declared function, then a variable or function that a module declared, for
+// a qualified name such as m.total
+func LookupName(env *Environment, functions *FunctionTable, name string) (types.Value, error) {
+	if value, exists := env.GetVariable(name); exists {
+		return value, nil
+	}
+	if function, exists := functions.Find(env, name); exists {
+		return function, nil
+	}
+	if scope, member, ok := moduleMember(env, name); ok {
+		if value, exists := scope.GetVariable(member); exists {
+			return value, nil
+		}
+		if function, exists := scope.GetFunctionValue(member); exists {
+			return function, nil
+		}
+	}
+	return nil, UndefinedVariable(env, name)
+}
+
+// LookupCallee finds what a call by name refers to: a declared function, then
+// a variable holding a function, then for a qualified name such as m.add the
+// function a module declared, then a built-in. It returns nil without an
+// error when name is a built-in.
+func LookupCallee(env *Environment, functions *FunctionTable, name string) (*FunctionValue, error) {
+	if function, exists := functions.Find(env, name); exists {
+		return &function, nil
+	}
+
+	if value, exists := env.GetVariable(name); exists {
+		return callableVariable(name, value)
+	}
+
+	if scope, member, ok := moduleMember(env, name); ok {
+		if function, exists := scope.GetFunctionValue(member); exists {
+			return &function, nil
+		}
+		if value, exists := scope.GetVariable(member); exists {
+			return callableVariable(name, value)
+		}
+		return nil, fmt.Errorf("undefined function: %s", name)
+	}
+
+	if _, ok := builtins[name]; ok {
+		return nil, nil
+	}
+	return nil, fmt.Errorf("undefined function: %s", name)
+}
+
+// callableVariable checks that the variable a call names holds a function
+func callableVariable(name string, value types.Value) (*FunctionValue, error) {
+	function, ok := value.(FunctionValue)
+	if !ok {
+		return nil, fmt.Errorf("cannot call %s: %s is not a function", name, value.Type().String())
+	}
+	return &function, nil
+}
+
+// CheckMethod checks that a method call names a built-in. Methods always
+// call the built-in, whatever the program declares with the same name.
+func CheckMethod(name string) error {
+	if _, ok := builtins[name]; !ok {
+		return fmt.Errorf("unknown method %s: a method must name a built-in function", name)
+	}
+	return nil
+}
+
+// CalleeValue checks that a value produced by an expression being called is
+// a function
+func CalleeValue(value types.Value) (*FunctionValue, error) {
+	function, ok := value.(FunctionValue)
+	if !ok {
+		return nil, fmt.Errorf("cannot call a value of type %s", value.Type().String())
+	}
+	return &function, nil
+}
@@ -0,0 +1,175 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"simplelang/internal/ast"
+	"simplelang/internal/lexer"
+	"simplelang/internal/parser"
+	"simplelang/internal/types"
+)
+
+// SetBaseDir sets the directory that the include and import statements of
+// the program resolve their paths against, normally the directory of the
+// file the program was read from. Without it they resolve against the
+// working directory.
+func (i *Interpreter) SetBaseDir(dir string) {
+	i.baseDir = dir
+}
+
+// LoadInclude reads and parses the file an include or import statement
+// names, resolving path against baseDir. It returns the file's cleaned
+// absolute path, which identifies it however it was reached, along with its
+// program. The error names path as the statement wrote it, and keyword, the
+// statement it failed for.
+func LoadInclude(baseDir, path, keyword string) (string, *ast.Program, error) {
+	file := path
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(baseDir, file)
+	}
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot %s %s: %v", keyword, path, err)
+	}
+
+	source, err := os.ReadFile(file)
+	if err != nil {
+		var pathErr *fs.PathError
+		if errors.As(err, &pathErr) {
+			err = pathErr.Err
+		}
+		return "", nil, fmt.Errorf("cannot %s %s: %v", keyword, path, err)
+	}
+	tokens, err := lexer.NewLexer(string(source)).Tokenize()
+	if err != nil {
+		return "", nil, fmt.Errorf("in %s: %v", path, err)
+	}
+	program, err := parser.NewParser(tokens).Parse()
+	if err != nil {
+		return "", nil, fmt.Errorf("in %s: %v", path, err)
+	}
+	return file, program, nil
+}
+
+// Include runs the file path names in env, as an include statement there
+// would. Another backend running the program, such as the VM, calls it to
+// include files the way the interpreter does.
+func (i *Interpreter) Include(env *Environment, path string) error {
+	previous := i.environment
+	i.environment = env
+	defer func() {
+		i.environment = previous
+	}()
+	_, err := i.executeIncludeStatement(&ast.IncludeStatement{Path: path})
+	return err
+}
+
+// executeIncludeStatement runs the included file in the current scope, so
+// that what it declares is there for the rest of the program. A file runs
+// at most once: it is marked before it starts, so including it again, even
+// from a file it includes itself, does nothing. Its own includes resolve
+// against its directory.
+func (i *Interpreter) executeIncludeStatement(stmt *ast.IncludeStatement) (types.Value, error) {
+	file, program, err := LoadInclude(i.baseDir, stmt.Path, "include")
+	if err != nil {
+		return nil, err
+	}
+	if i.included[file] {
+		return types.VoidValue{}, nil
+	}
+	if i.included == nil {
+		i.included = make(map[string]bool)
+	}
+	i.included[file] = true
+
+	if err := i.runFile(file, stmt.Path, program); err != nil {
+		return nil, err
+	}
+	return types.VoidValue{}, nil
+}
+
+// Import imports the file path names as module alias in env, as an import
+// statement there would. Another backend running the program, such as the
+// VM, calls it to import modules the way the interpreter does.
+func (i *Interpreter) Import(env *Environment, path, alias string) error {
+	previous := i.environment
+	i.environment = env
+	defer func() {
+		i.environment = previous
+	}()
+	_, err := i.executeImportStatement(&ast.ImportStatement{Path: path, Alias: alias})
+	return err
+}
+
+// executeImportStatement runs the imported file in a scope of its own, the
+// module, and declares the alias as a variable holding it. The module sees
+// the host's presets but none of the importing program's variables. A
+// file runs once however many times it is imported; importing it again
+// gives another alias for the same module, and a cycle of imports reaches
+// the module that is still running, with what it has declared so far.
+func (i *Interpreter) executeImportStatement(stmt *ast.ImportStatement) (types.Value, error) {
+	file, program, err := LoadInclude(i.baseDir, stmt.Path, "import")
+	if err != nil {
+		return nil, err
+	}
+
+	scope, cached := i.modules[file]
+	if !cached {
+		if i.modules == nil {
+			i.modules = make(map[string]*Environment)
+		}
+		scope = NewEnvironment(i.globals.parent)
+		i.modules[file] = scope
+
+		previous := i.environment
+		i.environment = scope
+		err := i.runFile(file, stmt.Path, program)
+		i.environment = previous
+		if err != nil {
+			delete(i.modules, file)
+			return nil, err
+		}
+	}
+
+	// Importing a module again under the alias it already has does nothing
+	if value, exists := i.environment.GetVariable(stmt.Alias); exists {
+		if module, ok := value.(ModuleValue); ok && module.Scope == scope {
+			return types.VoidValue{}, nil
+		}
+	}
+	module := ModuleValue{Path: stmt.Path, Scope: scope}
+	if err := DeclareVariable(i.environment, stmt.Alias, nil, module); err != nil {
+		return nil, err
+	}
+	return types.VoidValue{}, nil
+}
+
+// runFile runs the program read from file, which a statement named as path,
+// in the current scope. Its own includes and imports resolve against its
+// directory, and its errors are prefixed with path.
+func (i *Interpreter) runFile(file, path string, program *ast.Program) error {
+	previous := i.baseDir
+	i.baseDir = filepath.Dir(file)
+	defer func() {
+		i.baseDir = previous
+	}()
+
+	for _, statement := range program.Statements {
+		if function, ok := statement.(*ast.FunctionDeclaration); ok {
+			i.functions.Declare(i.environment, function)
+		}
+	}
+	for _, statement := range program.Statements {
+		if _, err := i.executeStatement(statement); err != nil {
+			switch err.(type) {
+			case *cancellation, *limitExceeded:
+				return err
+			}
+			return fmt.Errorf("in %s: %v", path, err)
+		}
+	}
+	return nil
+}
@@ -1,74 +1,369 @@
 package interpreter
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"math"
+	"math/rand"
+	"os"
+	"reflect"
 	"simplelang/internal/ast"
 	"simplelang/internal/types"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
-// Environment represents the execution environment
+// Environment represents the execution environment. Most scopes, such as a
+// loop body or a function call, hold only a handful of variables, so they
+// are kept in a slice and found by comparing names, which is cheaper than
+// hashing them; a scope that grows past indexThreshold variables also gets a
+// map from name to slot. Neither is allocated until something is declared.
 type Environment struct {
-	variables map[string]types.Value
+	variables []binding
+	index     map[string]int
 	functions map[string]*ast.FunctionDeclaration
 	parent    *Environment
 }
 
+// binding is one variable held by an Environment. A variable declared with
+// a type keeps it, and every value later assigned to it must suit it.
+type binding struct {
+	name     string
+	declared types.Type
+	value    types.Value
+}
+
+// indexThreshold is how many variables a scope holds before it indexes them
+// by name rather than scanning them in order
+const indexThreshold = 8
+
 // NewEnvironment creates a new environment
 func NewEnvironment(parent *Environment) *Environment {
-	return &Environment{
-		variables: make(map[string]types.Value),
-		functions: make(map[string]*ast.FunctionDeclaration),
-		parent:    parent,
+	return &Environment{parent: parent}
+}
+
+// newEnvironmentSized creates a new environment with room for size
+// variables before it has to grow
+func newEnvironmentSized(parent *Environment, size int) *Environment {
+	return &Environment{variables: make([]binding, 0, size), parent: parent}
+}
+
+// slot returns the position of name among this environment's own
+// variables, or -1 if it holds no variable by that name
+func (e *Environment) slot(name string) int {
+	if e.index != nil {
+		if j, exists := e.index[name]; exists {
+			return j
+		}
+		return -1
+	}
+	for j := range e.variables {
+		if e.variables[j].name == name {
+			return j
+		}
 	}
+	return -1
 }
 
 // SetVariable sets a variable in the current environment
 func (e *Environment) SetVariable(name string, value types.Value) {
-	e.variables[name] = value
+	e.define(name, nil, value)
+}
+
+// define sets a variable declared with the given type, or with none when
+// declared is nil, in the current environment
+func (e *Environment) define(name string, declared types.Type, value types.Value) {
+	if j := e.slot(name); j >= 0 {
+		e.variables[j] = binding{name: name, declared: declared, value: value}
+		return
+	}
+	e.variables = append(e.variables, binding{name: name, declared: declared, value: value})
+	switch {
+	case e.index != nil:
+		e.index[name] = len(e.variables) - 1
+	case len(e.variables) > indexThreshold:
+		e.index = make(map[string]int, len(e.variables))
+		for j, b := range e.variables {
+			e.index[b.name] = j
+		}
+	}
 }
 
 // GetVariable gets a variable from the current environment or parent
 func (e *Environment) GetVariable(name string) (types.Value, bool) {
-	if value, exists := e.variables[name]; exists {
-		return value, true
-	}
-	if e.parent != nil {
-		return e.parent.GetVariable(name)
+	for env := e; env != nil; env = env.parent {
+		if j := env.slot(name); j >= 0 {
+			return env.variables[j].value, true
+		}
 	}
 	return nil, false
 }
 
+// DeclaresVariable reports whether this environment itself, rather than an
+// enclosing one, holds a variable called name
+func (e *Environment) DeclaresVariable(name string) bool {
+	return e.slot(name) >= 0
+}
+
+// VariableNames returns the names of every variable visible from this
+// environment, including those of enclosing scopes
+func (e *Environment) VariableNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for env := e; env != nil; env = env.parent {
+		for _, b := range env.variables {
+			if !seen[b.name] {
+				seen[b.name] = true
+				names = append(names, b.name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Dump writes the variables visible from this environment to w with their
+// types and values. Each scope gets a numbered heading, starting from this
+// one as scope 1 and going outwards; scopes that hold no variables, such as
+// a loop body before it declares anything, are left out.
+func (e *Environment) Dump(w io.Writer) {
+	level := 0
+	for env := e; env != nil; env = env.parent {
+		if len(env.variables) == 0 {
+			continue
+		}
+		level++
+		fmt.Fprintf(w, "--- scope %d ---\n", level)
+
+		bindings := append([]binding(nil), env.variables...)
+		sort.Slice(bindings, func(a, b int) bool { return bindings[a].name < bindings[b].name })
+		for _, b := range bindings {
+			fmt.Fprintf(w, "%s: %s = %s\n", b.name, b.value.Type(), types.Quoted(b.value))
+		}
+	}
+}
+
+// AssignVariable updates an existing variable in the nearest environment that
+// defines it. It fails if there is no such variable, or if the variable was
+// declared with a type that value does not suit.
+func (e *Environment) AssignVariable(name string, value types.Value) error {
+	for env := e; env != nil; env = env.parent {
+		if j := env.slot(name); j >= 0 {
+			b := &env.variables[j]
+			if b.declared != nil {
+				if !b.declared.IsCompatibleWith(value.Type()) {
+					return fmt.Errorf("type mismatch: cannot assign %s to variable of type %s", value.Type().String(), b.declared.String())
+				}
+				value = convertForType(b.declared, value)
+			}
+			b.value = value
+			return nil
+		}
+	}
+	return UndefinedVariable(e, name)
+}
+
 // SetFunction sets a function in the current environment
 func (e *Environment) SetFunction(name string, function *ast.FunctionDeclaration) {
+	if e.functions == nil {
+		e.functions = make(map[string]*ast.FunctionDeclaration)
+	}
 	e.functions[name] = function
 }
 
-// GetFunction gets a function from the current environment or parent
-func (e *Environment) GetFunction(name string) (*ast.FunctionDeclaration, bool) {
-	if function, exists := e.functions[name]; exists {
-		return function, true
-	}
-	if e.parent != nil {
-		return e.parent.GetFunction(name)
+// RuntimeError is an error raised while executing a program, annotated with
+// the position of the statement or expression that failed
+type RuntimeError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *RuntimeError) Error() string {
+	return fmt.Sprintf("runtime error at line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+// newRuntimeError attaches a source position to err unless an inner node
+// already did, so the reported position is that of the innermost failure
+func newRuntimeError(pos ast.Position, err error) error {
+	switch e := err.(type) {
+	case *RuntimeError, *cancellation:
+		return err
+	case *limitExceeded:
+		if e.Line == 0 {
+			e.Line, e.Column = pos.Line, pos.Column
+		}
+		return err
 	}
-	return nil, false
+	return &RuntimeError{Line: pos.Line, Column: pos.Column, Message: err.Error()}
+}
+
+// cancellation stops a program whose context is done. Unlike a runtime
+// error, no try statement catches it.
+type cancellation struct {
+	err error
 }
 
+func (c *cancellation) Error() string { return c.err.Error() }
+
+// limitExceeded stops a program that goes over its step limit. It reads as
+// a runtime error at the innermost statement or expression that was running,
+// but like a cancellation no try statement catches it, so a program cannot
+// carry on past its budget.
+type limitExceeded struct {
+	RuntimeError
+}
+
+// DefaultMaxDepth is the default limit on nested function calls
+const DefaultMaxDepth = 1000
+
 // Interpreter executes the AST
 type Interpreter struct {
+	globals     *Environment
+	functions   *FunctionTable
+	infos       map[*ast.FunctionDeclaration]*functionInfo
 	environment *Environment
+	output      io.Writer
+	depth       int
+	maxDepth    int
+	hosts       map[string]HostFunction
+	ctx         context.Context
+	steps       int
+	stepLimit   int
+	random      *rand.Rand
+	clock       func() time.Time
+	baseDir     string
+	included    map[string]bool
+	modules     map[string]*Environment
+	profile     *Profile
 }
 
-// NewInterpreter creates a new interpreter
+// NewInterpreter creates a new interpreter that prints to standard output
 func NewInterpreter() *Interpreter {
+	globals := NewEnvironment(NewEnvironment(nil))
 	return &Interpreter{
-		environment: NewEnvironment(nil),
+		globals:     globals,
+		functions:   NewFunctionTable(globals),
+		infos:       make(map[*ast.FunctionDeclaration]*functionInfo),
+		environment: globals,
+		output:      os.Stdout,
+		maxDepth:    DefaultMaxDepth,
+		hosts:       make(map[string]HostFunction),
+		ctx:         context.Background(),
+		random:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		clock:       time.Now,
 	}
 }
 
+// SetMaxDepth limits how deeply function calls may nest before the program
+// fails with a recursion error
+func (i *Interpreter) SetMaxDepth(n int) {
+	i.maxDepth = n
+}
+
+// SetStepLimit bounds how much work a program may do: every statement run,
+// expression evaluated and loop iteration is one step, and a program that
+// would take more than n steps fails with a "step limit exceeded" error
+// instead. Unlike a timeout, the point at which it stops does not depend on
+// the speed of the machine. A limit of zero, the default, means no limit.
+func (i *Interpreter) SetStepLimit(n int) {
+	i.stepLimit = n
+}
+
+// Steps returns how many steps the last program run took
+func (i *Interpreter) Steps() int {
+	return i.steps
+}
+
+// SetSeed seeds the source random and randomInt draw from, so that every
+// run from the same seed produces the same numbers. Without it the source
+// is seeded from the clock.
+func (i *Interpreter) SetSeed(seed int64) {
+	i.random = rand.New(rand.NewSource(seed))
+}
+
+// SetClock makes now read the time from clock instead of the system clock,
+// so that a test can fix the time a program sees
+func (i *Interpreter) SetClock(clock func() time.Time) {
+	i.clock = clock
+}
+
+// SetFunctionTable makes the interpreter look top-level functions up in t
+// instead of its own table, so that another backend running the program can
+// call functions on it that call back into the program
+func (i *Interpreter) SetFunctionTable(t *FunctionTable) {
+	i.functions = t
+}
+
+// SetOutput redirects everything the program prints to w
+func (i *Interpreter) SetOutput(w io.Writer) {
+	i.output = w
+}
+
+// SetGlobal defines a top-level variable before a program runs, so that a
+// host can hand it configuration values. The program sees it like any other
+// global and may assign it. Presets live in a scope enclosing the globals, so
+// a program that declares the same name shadows the preset instead of
+// failing as a redeclaration.
+func (i *Interpreter) SetGlobal(name string, value types.Value) {
+	i.globals.parent.SetVariable(name, value)
+}
+
+// GetGlobal returns the value of a top-level variable, such as one a program
+// that has run declared or changed
+func (i *Interpreter) GetGlobal(name string) (types.Value, bool) {
+	return i.globals.GetVariable(name)
+}
+
 // Interpret executes a program
 func (i *Interpreter) Interpret(program *ast.Program) error {
+	return i.InterpretContext(context.Background(), program)
+}
+
+// InterpretContext executes a program until it finishes or ctx is done. The
+// context is checked before every statement and on every loop iteration, and
+// once it is done the program stops with the context's error, such as
+// context.DeadlineExceeded.
+//
+// A panic while running, which points at a bug or at a malformed tree built
+// by hand rather than by the parser, is returned as an internal interpreter
+// error instead of bringing down the host.
+func (i *Interpreter) InterpretContext(ctx context.Context, program *ast.Program) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("internal interpreter error: %v", recovered)
+		}
+	}()
+
+	i.ctx = ctx
+	i.steps = 0
+	if i.profile != nil {
+		i.profile = newProfile()
+	}
+	err = i.interpret(program)
+	switch stopped := err.(type) {
+	case *cancellation:
+		return stopped.err
+	case *limitExceeded:
+		return &stopped.RuntimeError
+	}
+	return err
+}
+
+func (i *Interpreter) interpret(program *ast.Program) error {
+	// Register top-level functions up front so they can call each other
+	// regardless of the order they are declared in
+	for _, statement := range program.Statements {
+		if function, ok := statement.(*ast.FunctionDeclaration); ok {
+			i.functions.Declare(i.globals, function)
+		}
+	}
+
 	for _, statement := range program.Statements {
 		_, err := i.executeStatement(statement)
 		if err != nil {
@@ -78,24 +373,106 @@ func (i *Interpreter) Interpret(program *ast.Program) error {
 	return nil
 }
 
-// executeStatement executes a single statement
+// EvaluateExpression evaluates a single expression against the interpreter's
+// current environment, which holds whatever the programs it has already run
+// declared. Paired with parser.ParseExpression it evaluates an expression on
+// its own, without wrapping it in a program.
+func (i *Interpreter) EvaluateExpression(expr ast.Expression) (types.Value, error) {
+	return i.evaluateExpression(expr)
+}
+
+// executeStatement executes a single statement, tallying it when profiling
 func (i *Interpreter) executeStatement(statement ast.Statement) (types.Value, error) {
+	if i.profile == nil {
+		return i.execute(statement)
+	}
+	stop := i.profile.enter(statement)
+	value, err := i.execute(statement)
+	stop()
+	return value, err
+}
+
+func (i *Interpreter) execute(statement ast.Statement) (types.Value, error) {
+	if err := i.interrupted(); err != nil {
+		return nil, err
+	}
+	if err := i.step(); err != nil {
+		return nil, newRuntimeError(statement.Pos(), err)
+	}
+
+	var value types.Value
+	var err error
+
 	switch stmt := statement.(type) {
 	case *ast.VariableDeclaration:
-		return i.executeVariableDeclaration(stmt)
+		value, err = i.executeVariableDeclaration(stmt)
+	case *ast.MultiVariableDeclaration:
+		value, err = i.executeMultiVariableDeclaration(stmt)
+	case *ast.DestructuringDeclaration:
+		value, err = i.executeDestructuringDeclaration(stmt)
 	case *ast.Assignment:
-		return i.executeAssignment(stmt)
+		value, err = i.executeAssignment(stmt)
 	case *ast.IfStatement:
-		return i.executeIfStatement(stmt)
+		value, err = i.executeIfStatement(stmt)
 	case *ast.LoopStatement:
-		return i.executeLoopStatement(stmt)
+		value, err = i.executeLoopStatement(stmt)
+	case *ast.ForEachStatement:
+		value, err = i.executeForEachStatement(stmt)
+	case *ast.RepeatStatement:
+		value, err = i.executeRepeatStatement(stmt)
+	case *ast.SwitchStatement:
+		value, err = i.executeSwitchStatement(stmt)
+	case *ast.TryStatement:
+		value, err = i.executeTryStatement(stmt)
+	case *ast.BlockStatement:
+		value, err = i.executeBlockStatement(stmt)
 	case *ast.FunctionDeclaration:
-		return i.executeFunctionDeclaration(stmt)
+		value, err = i.executeFunctionDeclaration(stmt)
+	case *ast.ReturnStatement:
+		value, err = i.executeReturnStatement(stmt)
 	case *ast.PrintStatement:
-		return i.executePrintStatement(stmt)
+		value, err = i.executePrintStatement(stmt)
+	case *ast.AssertStatement:
+		value, err = i.executeAssertStatement(stmt)
+	case *ast.RaiseStatement:
+		value, err = i.executeRaiseStatement(stmt)
+	case *ast.EnumDeclaration:
+		value, err = i.executeEnumDeclaration(stmt)
+	case *ast.IncludeStatement:
+		value, err = i.executeIncludeStatement(stmt)
+	case *ast.ImportStatement:
+		value, err = i.executeImportStatement(stmt)
+	case *ast.ExpressionStatement:
+		value, err = i.evaluateExpression(stmt.Expression)
 	default:
-		return nil, fmt.Errorf("unknown statement type: %T", statement)
+		err = fmt.Errorf("unknown statement type: %T", statement)
 	}
+
+	if err != nil {
+		// A return unwinds to its call unchanged
+		if _, ok := err.(*returnSignal); ok {
+			return nil, err
+		}
+		return nil, newRuntimeError(statement.Pos(), err)
+	}
+	return value, nil
+}
+
+// interrupted fails once the context the program runs under is done
+func (i *Interpreter) interrupted() error {
+	if err := i.ctx.Err(); err != nil {
+		return &cancellation{err: err}
+	}
+	return nil
+}
+
+// step counts one step of work, failing if it would go over the step limit
+func (i *Interpreter) step() error {
+	if i.stepLimit > 0 && i.steps >= i.stepLimit {
+		return &limitExceeded{RuntimeError{Message: fmt.Sprintf("step limit exceeded (%d)", i.stepLimit)}}
+	}
+	i.steps++
+	return nil
 }
 
 // executeVariableDeclaration executes a variable declaration
@@ -105,32 +482,115 @@ func (i *Interpreter) executeVariableDeclaration(stmt *ast.VariableDeclaration)
 		return nil, err
 	}
 
+	if err := DeclareVariable(i.environment, stmt.Name, stmt.Type, value); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// DeclareVariable defines name in env after checking value against the
+// declared type, widening integers stored as numbers. A nil type, as written
+// by 'let', is inferred from the value, which cannot be nil.
+func DeclareVariable(env *Environment, name string, declared types.Type, value types.Value) error {
+	if env.DeclaresVariable(name) {
+		return fmt.Errorf("variable %s is already declared in this scope", name)
+	}
+
+	if declared == nil {
+		if _, ok := value.(types.NilValue); ok {
+			return fmt.Errorf("cannot infer the type of %s from nil; declare its type instead", name)
+		}
+		env.define(name, value.Type(), value)
+		return nil
+	}
+
 	// Type checking
-	if !stmt.Type.IsCompatibleWith(value.Type()) {
-		return nil, fmt.Errorf("type mismatch: cannot assign %s to variable of type %s", value.Type().String(), stmt.Type.String())
+	if !declared.IsCompatibleWith(value.Type()) {
+		return fmt.Errorf("type mismatch: cannot assign %s to variable of type %s", value.Type().String(), declared.String())
 	}
 
-	i.environment.SetVariable(stmt.Name, value)
-	return value, nil
+	env.define(name, declared, convertForType(declared, value))
+	return nil
+}
+
+// executeMultiVariableDeclaration declares each variable in turn, so later
+// initializers can refer to earlier ones
+func (i *Interpreter) executeMultiVariableDeclaration(stmt *ast.MultiVariableDeclaration) (types.Value, error) {
+	for _, decl := range stmt.Declarations {
+		if _, err := i.executeStatement(decl); err != nil {
+			return nil, err
+		}
+	}
+	return types.VoidValue{}, nil
+}
+
+// executeDestructuringDeclaration declares each name with the matching
+// element of the list its value produces
+func (i *Interpreter) executeDestructuringDeclaration(stmt *ast.DestructuringDeclaration) (types.Value, error) {
+	value, err := i.evaluateExpression(stmt.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	elements, err := Unpack(value, len(stmt.Names))
+	if err != nil {
+		return nil, err
+	}
+	for j, name := range stmt.Names {
+		if err := DeclareVariable(i.environment, name, stmt.Type, elements[j]); err != nil {
+			return nil, err
+		}
+	}
+	return types.VoidValue{}, nil
+}
+
+// Unpack returns the elements of a list being destructured into count
+// variables, failing unless value is a list of exactly that many
+func Unpack(value types.Value, count int) ([]types.Value, error) {
+	list, ok := value.(types.ListValue)
+	if !ok {
+		return nil, fmt.Errorf("cannot unpack %s into %d variables", value.Type().String(), count)
+	}
+	if len(list.Elements) != count {
+		return nil, fmt.Errorf("cannot unpack %d values into %d variables", len(list.Elements), count)
+	}
+	return list.Elements, nil
 }
 
 // executeAssignment executes a variable assignment
 func (i *Interpreter) executeAssignment(stmt *ast.Assignment) (types.Value, error) {
+	if stmt.Shorthand != "" {
+		if err := CheckShorthand(i.environment, stmt.Name, stmt.Shorthand); err != nil {
+			return nil, err
+		}
+	}
+
 	value, err := i.evaluateExpression(stmt.Value)
 	if err != nil {
 		return nil, err
 	}
 
-	// Check if variable exists
-	if _, exists := i.environment.GetVariable(stmt.Name); !exists {
-		return nil, fmt.Errorf("undefined variable: %s", stmt.Name)
+	if err := i.environment.AssignVariable(stmt.Name, value); err != nil {
+		return nil, err
 	}
-
-	i.environment.SetVariable(stmt.Name, value)
 	return value, nil
 }
 
-// executeIfStatement executes an if statement
+// CheckShorthand verifies that x++ or x-- applies to an existing numeric
+// variable, since the desugared x + 1 would otherwise append to text
+func CheckShorthand(env *Environment, name, shorthand string) error {
+	current, exists := env.GetVariable(name)
+	if !exists {
+		return UndefinedVariable(env, name)
+	}
+	if !isNumeric(current) {
+		return fmt.Errorf("cannot apply %s to %s variable %s", shorthand, current.Type().String(), name)
+	}
+	return nil
+}
+
+// executeIfStatement runs the branch the condition picks in a scope of its
+// own, so nothing declared in either branch outlives the statement
 func (i *Interpreter) executeIfStatement(stmt *ast.IfStatement) (types.Value, error) {
 	condition, err := i.evaluateExpression(stmt.Condition)
 	if err != nil {
@@ -142,29 +602,51 @@ func (i *Interpreter) executeIfStatement(stmt *ast.IfStatement) (types.Value, er
 		return nil, fmt.Errorf("condition must be boolean, got %s", condition.Type().String())
 	}
 
-	booleanValue := condition.(types.BooleanValue)
-	if booleanValue.Value {
-		// Execute then body
-		for _, statement := range stmt.ThenBody {
-			_, err := i.executeStatement(statement)
-			if err != nil {
-				return nil, err
-			}
-		}
-	} else {
-		// Execute else body
-		for _, statement := range stmt.ElseBody {
-			_, err := i.executeStatement(statement)
-			if err != nil {
-				return nil, err
-			}
-		}
+	body := stmt.ElseBody
+	if condition.(types.BooleanValue).Value {
+		body = stmt.ThenBody
 	}
+	if err := i.executeBlock(body); err != nil {
+		return nil, err
+	}
+
+	return types.VoidValue{}, nil
+}
 
+// executeBlockStatement runs a begin ... end block in a scope of its own
+func (i *Interpreter) executeBlockStatement(stmt *ast.BlockStatement) (types.Value, error) {
+	if err := i.executeBlock(stmt.Body); err != nil {
+		return nil, err
+	}
 	return types.VoidValue{}, nil
 }
 
-// executeLoopStatement executes a loop statement
+// executeBlock runs body in a child scope, so that whatever it declares goes
+// out of scope when it ends
+func (i *Interpreter) executeBlock(body []ast.Statement) error {
+	return i.executeIn(NewEnvironment(i.environment), body)
+}
+
+// executeIn runs body in env, a child of the current scope, and goes back to
+// the current scope when it ends
+func (i *Interpreter) executeIn(env *Environment, body []ast.Statement) error {
+	oldEnv := i.environment
+	i.environment = env
+
+	defer func() {
+		i.environment = oldEnv
+	}()
+
+	for _, statement := range body {
+		if _, err := i.executeStatement(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// executeLoopStatement executes a loop statement, counting as LoopCounter
+// does. The number of passes is fixed before the first one.
 func (i *Interpreter) executeLoopStatement(stmt *ast.LoopStatement) (types.Value, error) {
 	fromValue, err := i.evaluateExpression(stmt.From)
 	if err != nil {
@@ -177,115 +659,626 @@ func (i *Interpreter) executeLoopStatement(stmt *ast.LoopStatement) (types.Value
 	}
 
 	// Check if both values are numbers
-	if _, ok := fromValue.Type().(types.NumberType); !ok {
+	if !isNumeric(fromValue) || !isNumeric(toValue) {
 		return nil, fmt.Errorf("loop bounds must be numbers")
 	}
-	if _, ok := toValue.Type().(types.NumberType); !ok {
-		return nil, fmt.Errorf("loop bounds must be numbers")
+
+	var stepValue types.Value
+	if stmt.Step != nil {
+		stepValue, err = i.evaluateExpression(stmt.Step)
+		if err != nil {
+			return nil, err
+		}
+	}
+	counter, err := NewLoopCounter(fromValue, toValue, stepValue)
+	if err != nil {
+		return nil, err
 	}
 
-	from := fromValue.(types.NumberValue).Value
-	to := toValue.(types.NumberValue).Value
+	if err := CheckLoopVariable(i.environment, stmt.Variable); err != nil {
+		return nil, err
+	}
 
-	// Create new environment for loop variables
-	loopEnv := NewEnvironment(i.environment)
+	for ; !counter.Done(); counter.Advance() {
+		if err := i.interrupted(); err != nil {
+			return nil, err
+		}
+		if err := i.step(); err != nil {
+			return nil, err
+		}
+		if err := i.executeIteration(stmt.Variable, counter.Value(), stmt.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	return types.VoidValue{}, nil
+}
+
+// executeForEachStatement runs the body once per list element, binding the
+// element to the loop variable in a scope of its own
+func (i *Interpreter) executeForEachStatement(stmt *ast.ForEachStatement) (types.Value, error) {
+	iterable, err := i.evaluateExpression(stmt.Iterable)
+	if err != nil {
+		return nil, err
+	}
+
+	list, ok := iterable.(types.ListValue)
+	if !ok {
+		return nil, fmt.Errorf("cannot iterate over %s, expected a list", iterable.Type().String())
+	}
+
+	if err := CheckLoopVariable(i.environment, stmt.Variable); err != nil {
+		return nil, err
+	}
+
+	for _, element := range list.Elements {
+		if err := i.interrupted(); err != nil {
+			return nil, err
+		}
+		if err := i.step(); err != nil {
+			return nil, err
+		}
+		if err := i.executeIteration(stmt.Variable, element, stmt.Body); err != nil {
+			return nil, err
+		}
+	}
+
+	return types.VoidValue{}, nil
+}
+
+// LoopPasses returns how many times a loop from 'from' to 'to' by step runs
+// its body. Pass n, counting from 0, sets the loop variable to from + n*step
+// rather than adding step to the previous value, so a fractional step does
+// not drift. The upper bound is included when the last value lands within a
+// billionth of a step of it: 0 to 0.3 step 0.1 runs four times, although
+// adding 0.1 three times overshoots 0.3. A zero step gives no passes.
+func LoopPasses(from, to, step float64) float64 {
+	passes := math.Floor((to-from)/step+1e-9) + 1
+	if step == 0 || !(passes > 0) {
+		return 0
+	}
+	return passes
+}
+
+// LoopCounter is the state of a running 'loop' statement. The loop variable
+// is an integer when the bounds and step all are, and is then counted in
+// int64 rather than float64, so that it stays exact past 2^53 and the loop
+// stops rather than stepping past the largest or smallest integer. Any other
+// loop runs as LoopPasses describes.
+type LoopCounter struct {
+	integral bool
+	// current is an integral loop's variable, last its bound and by its step
+	current, last, by int64
+	done              bool
+	// from and step are any other loop's, which has made pass of its passes
+	from, step, pass, passes float64
+}
+
+// NewLoopCounter starts a loop over the numeric bounds fromValue and toValue.
+// Without a step, which stepValue is nil for, it counts by one towards its
+// upper bound, downwards if the bound is lower than the start.
+func NewLoopCounter(fromValue, toValue, stepValue types.Value) (LoopCounter, error) {
+	first, last, integral := integerOperands(fromValue, toValue)
+	from, to := toFloat(fromValue), toFloat(toValue)
+	var by int64 = 1
+	if integral && first > last || !integral && from > to {
+		by = -1
+	}
+	step := float64(by)
+	if stepValue != nil {
+		if !isNumeric(stepValue) {
+			return LoopCounter{}, fmt.Errorf("loop step must be a number")
+		}
+		step = toFloat(stepValue)
+		if step == 0 {
+			return LoopCounter{}, fmt.Errorf("loop step cannot be zero")
+		}
+		integer, ok := stepValue.(types.IntValue)
+		by, integral = integer.Value, integral && ok
+	}
+
+	if !integral {
+		return LoopCounter{from: from, step: step, passes: LoopPasses(from, to, step)}, nil
+	}
+	return LoopCounter{
+		integral: true,
+		current:  first,
+		last:     last,
+		by:       by,
+		done:     by > 0 && first > last || by < 0 && first < last,
+	}, nil
+}
+
+// Done reports whether the loop has made its last pass
+func (c *LoopCounter) Done() bool {
+	if c.integral {
+		return c.done
+	}
+	return c.pass >= c.passes
+}
+
+// Value is the loop variable on the current pass
+func (c *LoopCounter) Value() types.Value {
+	if c.integral {
+		return types.IntValue{Value: c.current}
+	}
+	return types.NumberValue{Value: c.from + c.pass*c.step}
+}
+
+// Advance moves on to the next pass. An integral loop is done once the
+// distance left to its bound, taken unsigned so that it cannot overflow, is
+// less than a step.
+func (c *LoopCounter) Advance() {
+	if !c.integral {
+		c.pass++
+		return
+	}
+	var left, stride uint64
+	if c.by > 0 {
+		left, stride = uint64(c.last)-uint64(c.current), uint64(c.by)
+	} else {
+		left, stride = uint64(c.current)-uint64(c.last), uint64(-c.by)
+	}
+	if left < stride {
+		c.done = true
+		return
+	}
+	c.current += c.by
+}
+
+// CheckLoopVariable fails if a loop's variable would collide with a
+// variable declared in the same scope as the loop itself
+func CheckLoopVariable(env *Environment, name string) error {
+	if env.DeclaresVariable(name) {
+		return fmt.Errorf("loop variable %s is already declared in this scope", name)
+	}
+	return nil
+}
+
+// executeRepeatStatement runs the body until the condition after it is true,
+// always running it at least once
+func (i *Interpreter) executeRepeatStatement(stmt *ast.RepeatStatement) (types.Value, error) {
+	for {
+		if err := i.interrupted(); err != nil {
+			return nil, err
+		}
+		if err := i.step(); err != nil {
+			return nil, err
+		}
+		done, err := i.executeRepeatPass(stmt)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			return types.VoidValue{}, nil
+		}
+	}
+}
+
+// executeRepeatPass runs one pass of a repeat statement's body and then its
+// condition, both in a scope of their own, reporting whether the condition
+// was true
+func (i *Interpreter) executeRepeatPass(stmt *ast.RepeatStatement) (bool, error) {
 	oldEnv := i.environment
-	i.environment = loopEnv
+	i.environment = NewEnvironment(oldEnv)
 
 	defer func() {
 		i.environment = oldEnv
 	}()
 
-	for j := from; j <= to; j++ {
-		// Set loop variable
-		loopEnv.SetVariable(stmt.Variable, types.NumberValue{Value: j})
+	for _, statement := range stmt.Body {
+		if _, err := i.executeStatement(statement); err != nil {
+			return false, err
+		}
+	}
 
-		// Execute loop body
-		for _, statement := range stmt.Body {
-			_, err := i.executeStatement(statement)
-			if err != nil {
-				return nil, err
-			}
+	condition, err := i.evaluateExpression(stmt.Condition)
+	if err != nil {
+		return false, newRuntimeError(stmt.Condition.Pos(), err)
+	}
+	done, ok := condition.(types.BooleanValue)
+	if !ok {
+		return false, newRuntimeError(stmt.Condition.Pos(), fmt.Errorf("condition must be boolean, got %s", condition.Type().String()))
+	}
+	return done.Value, nil
+}
+
+// executeIteration runs one pass of a loop body in a fresh scope holding the
+// loop variable, so the body's declarations start over on every pass
+func (i *Interpreter) executeIteration(variable string, value types.Value, body []ast.Statement) error {
+	env := NewEnvironment(i.environment)
+	env.SetVariable(variable, value)
+	return i.executeIn(env, body)
+}
+
+// executeSwitchStatement runs the first case whose value equals the subject,
+// falling back to the default body when no case matches, in a scope of its
+// own
+func (i *Interpreter) executeSwitchStatement(stmt *ast.SwitchStatement) (types.Value, error) {
+	subject, err := i.evaluateExpression(stmt.Subject)
+	if err != nil {
+		return nil, err
+	}
+
+	body := stmt.Default
+	for _, c := range stmt.Cases {
+		value, err := i.evaluateExpression(c.Value)
+		if err != nil {
+			return nil, err
+		}
+
+		matched, err := equal(subject, value)
+		if err != nil {
+			return nil, err
+		}
+		if matched.(types.BooleanValue).Value {
+			body = c.Body
+			break
 		}
 	}
 
+	if err := i.executeBlock(body); err != nil {
+		return nil, err
+	}
+
+	return types.VoidValue{}, nil
+}
+
+// executeTryStatement runs the try body in a scope of its own. If a runtime
+// error stops it, the catch body runs in another, where the variable holds
+// the error message. A return is not an error and passes through to its
+// function.
+func (i *Interpreter) executeTryStatement(stmt *ast.TryStatement) (types.Value, error) {
+	err := i.executeBlock(stmt.Body)
+	if err == nil {
+		return types.VoidValue{}, nil
+	}
+	failure, ok := err.(*RuntimeError)
+	if !ok {
+		return nil, err
+	}
+
+	catchEnv := NewEnvironment(i.environment)
+	catchEnv.SetVariable(stmt.Variable, types.TextValue{Value: failure.Message})
+	if err := i.executeIn(catchEnv, stmt.CatchBody); err != nil {
+		return nil, err
+	}
 	return types.VoidValue{}, nil
 }
 
 // executeFunctionDeclaration executes a function declaration
 func (i *Interpreter) executeFunctionDeclaration(stmt *ast.FunctionDeclaration) (types.Value, error) {
-	i.environment.SetFunction(stmt.Name, stmt)
+	i.functions.Declare(i.environment, stmt)
 	return types.VoidValue{}, nil
 }
 
-// executePrintStatement executes a print statement
+// executeReturnStatement evaluates the returned value and unwinds to the
+// function call, which receives it through a returnSignal
+func (i *Interpreter) executeReturnStatement(stmt *ast.ReturnStatement) (types.Value, error) {
+	var value types.Value = types.VoidValue{}
+	if stmt.Value != nil {
+		var err error
+		value, err = i.evaluateExpression(stmt.Value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, &returnSignal{value: value}
+}
+
+// executePrintStatement executes a print or write statement
 func (i *Interpreter) executePrintStatement(stmt *ast.PrintStatement) (types.Value, error) {
 	value, err := i.evaluateExpression(stmt.Value)
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Println(value.String())
-	return types.VoidValue{}, nil
+	if stmt.Write {
+		fmt.Fprint(i.output, value.String())
+	} else {
+		fmt.Fprintln(i.output, value.String())
+	}
+	return types.VoidValue{}, nil
+}
+
+// executeAssertStatement fails when the condition is false. The message is
+// only evaluated then; without one the failure quotes the condition.
+func (i *Interpreter) executeAssertStatement(stmt *ast.AssertStatement) (types.Value, error) {
+	condition, err := i.evaluateExpression(stmt.Condition)
+	if err != nil {
+		return nil, err
+	}
+
+	holds, ok := condition.(types.BooleanValue)
+	if !ok {
+		return nil, fmt.Errorf("condition must be boolean, got %s", condition.Type().String())
+	}
+	if holds.Value {
+		return types.VoidValue{}, nil
+	}
+
+	if stmt.Message == nil {
+		return nil, AssertionFailed(types.TextValue{Value: ast.NewFormatter().FormatExpression(stmt.Condition)})
+	}
+	message, err := i.evaluateExpression(stmt.Message)
+	if err != nil {
+		return nil, err
+	}
+	return nil, AssertionFailed(message)
+}
+
+// AssertionFailed is the error of an assert statement whose condition is
+// false, giving message as the reason
+func AssertionFailed(message types.Value) error {
+	return fmt.Errorf("assertion failed: %s", message.String())
+}
+
+// executeRaiseStatement fails with the raised text as the error message
+func (i *Interpreter) executeRaiseStatement(stmt *ast.RaiseStatement) (types.Value, error) {
+	value, err := i.evaluateExpression(stmt.Value)
+	if err != nil {
+		return nil, err
+	}
+	return nil, Raise(value)
+}
+
+// Raise is the error of a raise statement, whose value must be text
+func Raise(value types.Value) error {
+	message, ok := value.(types.TextValue)
+	if !ok {
+		return fmt.Errorf("can only raise text, got %s", value.Type().String())
+	}
+	return errors.New(message.Value)
+}
+
+// executeEnumDeclaration declares each member of an enum as a constant
+// numbered by its position
+func (i *Interpreter) executeEnumDeclaration(stmt *ast.EnumDeclaration) (types.Value, error) {
+	for j, member := range stmt.Members {
+		value := types.EnumValue{Enum: stmt.Name, Name: member, Value: int64(j)}
+		if err := DeclareVariable(i.environment, ast.MemberName(stmt.Name, member), nil, value); err != nil {
+			return nil, err
+		}
+	}
+	return types.VoidValue{}, nil
+}
+
+// evaluateExpression evaluates an expression, tallying it when profiling
+func (i *Interpreter) evaluateExpression(expr ast.Expression) (types.Value, error) {
+	if i.profile == nil {
+		return i.evaluate(expr)
+	}
+	stop := i.profile.enter(expr)
+	value, err := i.evaluate(expr)
+	stop()
+	return value, err
+}
+
+func (i *Interpreter) evaluate(expr ast.Expression) (types.Value, error) {
+	if err := i.step(); err != nil {
+		return nil, newRuntimeError(expr.Pos(), err)
+	}
+
+	var value types.Value
+	var err error
+
+	switch e := expr.(type) {
+	case *ast.Literal:
+		value, err = i.evaluateLiteral(e)
+	case *ast.Identifier:
+		value, err = i.evaluateIdentifier(e)
+	case *ast.ListLiteral:
+		value, err = i.evaluateListLiteral(e)
+	case *ast.BinaryExpression:
+		value, err = i.evaluateBinaryExpression(e)
+	case *ast.UnaryExpression:
+		value, err = i.evaluateUnaryExpression(e)
+	case *ast.IndexExpression:
+		value, err = i.evaluateIndexExpression(e)
+	case *ast.SliceExpression:
+		value, err = i.evaluateSliceExpression(e)
+	case *ast.FunctionCall:
+		value, err = i.evaluateFunctionCall(e)
+	case *ast.FunctionLiteral:
+		// Like a declared function, a literal sees the scope it appears in
+		value = FunctionValue{Declaration: e.Function, Closure: i.environment}
+	default:
+		err = fmt.Errorf("unknown expression type: %T", expr)
+	}
+
+	if err != nil {
+		return nil, newRuntimeError(expr.Pos(), err)
+	}
+	return value, nil
+}
+
+// evaluateLiteral evaluates a literal
+func (i *Interpreter) evaluateLiteral(lit *ast.Literal) (types.Value, error) {
+	return LiteralValue(lit)
+}
+
+// LiteralValue converts a literal node into the value it denotes
+func LiteralValue(lit *ast.Literal) (types.Value, error) {
+	switch lit.Type.(type) {
+	case types.NumberType:
+		if str, ok := lit.Value.(string); ok {
+			var num float64
+			_, err := fmt.Sscanf(str, "%f", &num)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number: %s", str)
+			}
+			return types.NumberValue{Value: num}, nil
+		}
+		return nil, fmt.Errorf("invalid number literal")
+	case types.IntType:
+		if str, ok := lit.Value.(string); ok {
+			num, err := strconv.ParseInt(str, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid integer: %s", str)
+			}
+			return types.IntValue{Value: num}, nil
+		}
+		return nil, fmt.Errorf("invalid integer literal")
+	case types.TextType:
+		if str, ok := lit.Value.(string); ok {
+			return types.TextValue{Value: str}, nil
+		}
+		return nil, fmt.Errorf("invalid text literal")
+	case types.CharType:
+		if char, ok := lit.Value.(rune); ok {
+			return types.CharValue{Value: char}, nil
+		}
+		return nil, fmt.Errorf("invalid char literal")
+	case types.BooleanType:
+		if b, ok := lit.Value.(bool); ok {
+			return types.BooleanValue{Value: b}, nil
+		}
+		return nil, fmt.Errorf("invalid boolean literal")
+	case types.NilType:
+		return types.NilValue{}, nil
+	default:
+		return nil, fmt.Errorf("unknown literal type: %s", lit.Type.String())
+	}
+}
+
+// evaluateListLiteral evaluates each element in order
+func (i *Interpreter) evaluateListLiteral(lit *ast.ListLiteral) (types.Value, error) {
+	elements := make([]types.Value, 0, len(lit.Elements))
+	for _, element := range lit.Elements {
+		value, err := i.evaluateExpression(element)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, value)
+	}
+	return types.ListValue{Elements: elements}, nil
+}
+
+func (i *Interpreter) evaluateIndexExpression(expr *ast.IndexExpression) (types.Value, error) {
+	target, err := i.evaluateExpression(expr.Target)
+	if err != nil {
+		return nil, err
+	}
+	index, err := i.evaluateExpression(expr.Index)
+	if err != nil {
+		return nil, err
+	}
+	return Index(target, index)
+}
+
+// evaluateSliceExpression leaves a bound nil when the slice leaves it out
+func (i *Interpreter) evaluateSliceExpression(expr *ast.SliceExpression) (types.Value, error) {
+	target, err := i.evaluateExpression(expr.Target)
+	if err != nil {
+		return nil, err
+	}
+	var start, end types.Value
+	if expr.Start != nil {
+		if start, err = i.evaluateExpression(expr.Start); err != nil {
+			return nil, err
+		}
+	}
+	if expr.End != nil {
+		if end, err = i.evaluateExpression(expr.End); err != nil {
+			return nil, err
+		}
+	}
+	return Slice(target, start, end)
+}
+
+// Index returns the element of target at a zero-based index: a list's
+// element, or a one-character text holding the character of text at that
+// position. Positions count characters rather than bytes, so "né"[1] is "é".
+func Index(target, index types.Value) (types.Value, error) {
+	length, err := indexableLength(target)
+	if err != nil {
+		return nil, err
+	}
+	n, err := indexPosition(index)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 || n >= int64(length) {
+		return nil, fmt.Errorf("index %d is out of range for %s of length %d", n, target.Type().String(), length)
+	}
+
+	if list, ok := target.(types.ListValue); ok {
+		return list.Elements[n], nil
+	}
+	return types.TextValue{Value: string([]rune(target.(types.TextValue).Value)[n])}, nil
+}
+
+// Slice returns the part of a text or list from start up to but not
+// including end. A nil bound stands for the beginning or the end.
+func Slice(target, start, end types.Value) (types.Value, error) {
+	length, err := indexableLength(target)
+	if err != nil {
+		return nil, err
+	}
+	from, to := int64(0), int64(length)
+	if start != nil {
+		if from, err = indexPosition(start); err != nil {
+			return nil, err
+		}
+	}
+	if end != nil {
+		if to, err = indexPosition(end); err != nil {
+			return nil, err
+		}
+	}
+	if from < 0 || to > int64(length) || from > to {
+		return nil, fmt.Errorf("slice %d:%d is out of range for %s of length %d", from, to, target.Type().String(), length)
+	}
+
+	if list, ok := target.(types.ListValue); ok {
+		elements := make([]types.Value, to-from)
+		copy(elements, list.Elements[from:to])
+		return types.ListValue{Elements: elements}, nil
+	}
+	return types.TextValue{Value: string([]rune(target.(types.TextValue).Value)[from:to])}, nil
 }
 
-// evaluateExpression evaluates an expression
-func (i *Interpreter) evaluateExpression(expr ast.Expression) (types.Value, error) {
-	switch e := expr.(type) {
-	case *ast.Literal:
-		return i.evaluateLiteral(e)
-	case *ast.Identifier:
-		return i.evaluateIdentifier(e)
-	case *ast.BinaryExpression:
-		return i.evaluateBinaryExpression(e)
-	case *ast.UnaryExpression:
-		return i.evaluateUnaryExpression(e)
-	case *ast.FunctionCall:
-		return i.evaluateFunctionCall(e)
+// indexableLength returns the number of characters in text or elements in
+// a list, failing for any other value
+func indexableLength(target types.Value) (int, error) {
+	switch t := target.(type) {
+	case types.TextValue:
+		return utf8.RuneCountInString(t.Value), nil
+	case types.ListValue:
+		return len(t.Elements), nil
 	default:
-		return nil, fmt.Errorf("unknown expression type: %T", expr)
+		return 0, fmt.Errorf("cannot index %s, expected text or a list", target.Type().String())
 	}
 }
 
-// evaluateLiteral evaluates a literal
-func (i *Interpreter) evaluateLiteral(lit *ast.Literal) (types.Value, error) {
-	switch lit.Type.(type) {
-	case types.NumberType:
-		if str, ok := lit.Value.(string); ok {
-			var num float64
-			_, err := fmt.Sscanf(str, "%f", &num)
-			if err != nil {
-				return nil, fmt.Errorf("invalid number: %s", str)
-			}
-			return types.NumberValue{Value: num}, nil
-		}
-		return nil, fmt.Errorf("invalid number literal")
-	case types.TextType:
-		if str, ok := lit.Value.(string); ok {
-			return types.TextValue{Value: str}, nil
-		}
-		return nil, fmt.Errorf("invalid text literal")
-	case types.BooleanType:
-		if b, ok := lit.Value.(bool); ok {
-			return types.BooleanValue{Value: b}, nil
+// indexPosition converts an index or slice bound to an integer
+func indexPosition(value types.Value) (int64, error) {
+	switch v := value.(type) {
+	case types.IntValue:
+		return v.Value, nil
+	case types.NumberValue:
+		if v.Value == math.Trunc(v.Value) && v.Value >= math.MinInt64 && v.Value < math.MaxInt64 {
+			return int64(v.Value), nil
 		}
-		return nil, fmt.Errorf("invalid boolean literal")
+		return 0, fmt.Errorf("index must be a whole number, got %g", v.Value)
 	default:
-		return nil, fmt.Errorf("unknown literal type: %s", lit.Type.String())
+		return 0, fmt.Errorf("index must be a whole number, got %s", value.Type().String())
 	}
 }
 
-// evaluateIdentifier evaluates an identifier
+// evaluateIdentifier evaluates an identifier. A name that is not a variable
+// but a declared function evaluates to that function as a value, and a
+// qualified name reads what an imported module declared.
 func (i *Interpreter) evaluateIdentifier(ident *ast.Identifier) (types.Value, error) {
-	value, exists := i.environment.GetVariable(ident.Name)
-	if !exists {
-		return nil, fmt.Errorf("undefined variable: %s", ident.Name)
-	}
-	return value, nil
+	return LookupName(i.environment, i.functions, ident.Name)
 }
 
 // evaluateBinaryExpression evaluates a binary expression
 func (i *Interpreter) evaluateBinaryExpression(expr *ast.BinaryExpression) (types.Value, error) {
+	if expr.Operator == "and" || expr.Operator == "or" {
+		return i.evaluateLogicalExpression(expr)
+	}
+
 	left, err := i.evaluateExpression(expr.Left)
 	if err != nil {
 		return nil, err
@@ -296,34 +1289,84 @@ func (i *Interpreter) evaluateBinaryExpression(expr *ast.BinaryExpression) (type
 		return nil, err
 	}
 
-	switch expr.Operator {
+	return ApplyBinary(expr.Operator, left, right)
+}
+
+// ApplyBinary applies a binary operator to two evaluated operands. 'and' and
+// 'or' are applied to both operands as they are; see ShortCircuit for
+// deciding whether the right one needs evaluating at all.
+func ApplyBinary(operator string, left, right types.Value) (types.Value, error) {
+	switch operator {
 	case "+":
-		return i.add(left, right)
+		return add(left, right)
 	case "-":
-		return i.subtract(left, right)
+		return subtract(left, right)
 	case "*":
-		return i.multiply(left, right)
+		return multiply(left, right)
 	case "/":
-		return i.divide(left, right)
+		return divide(left, right)
+	case "//":
+		return intDivide(left, right)
+	case "%":
+		return modulo(left, right)
+	case "^":
+		return power(left, right)
+	case "&", "|", "xor", "<<", ">>":
+		return bitwise(operator, left, right)
 	case "==":
-		return i.equal(left, right)
+		return equal(left, right)
 	case "!=":
-		return i.notEqual(left, right)
+		return notEqual(left, right)
 	case "<":
-		return i.lessThan(left, right)
+		return lessThan(left, right)
 	case "<=":
-		return i.lessEqual(left, right)
+		return lessEqual(left, right)
 	case ">":
-		return i.greaterThan(left, right)
+		return greaterThan(left, right)
 	case ">=":
-		return i.greaterEqual(left, right)
+		return greaterEqual(left, right)
 	case "and":
-		return i.logicalAnd(left, right)
+		return logicalAnd(left, right)
 	case "or":
-		return i.logicalOr(left, right)
+		return logicalOr(left, right)
 	default:
-		return nil, fmt.Errorf("unknown binary operator: %s", expr.Operator)
+		return nil, fmt.Errorf("unknown binary operator: %s", operator)
+	}
+}
+
+// evaluateLogicalExpression evaluates 'and' and 'or' with short-circuiting:
+// the right operand is evaluated only when the left one does not already
+// decide the result
+func (i *Interpreter) evaluateLogicalExpression(expr *ast.BinaryExpression) (types.Value, error) {
+	left, err := i.evaluateExpression(expr.Left)
+	if err != nil {
+		return nil, err
+	}
+
+	done, err := ShortCircuit(expr.Operator, left)
+	if err != nil {
+		return nil, err
+	}
+	if done {
+		return left, nil
+	}
+
+	right, err := i.evaluateExpression(expr.Right)
+	if err != nil {
+		return nil, err
+	}
+
+	return ApplyBinary(expr.Operator, left, right)
+}
+
+// ShortCircuit reports whether the left operand of 'and' or 'or' already
+// decides the result, in which case the result is the left operand itself
+func ShortCircuit(operator string, left types.Value) (bool, error) {
+	l, ok := left.(types.BooleanValue)
+	if !ok {
+		return false, fmt.Errorf("left operand of '%s' must be boolean, got %s", operator, left.Type().String())
 	}
+	return l.Value == (operator == "or"), nil
 }
 
 // evaluateUnaryExpression evaluates a unary expression
@@ -333,60 +1376,101 @@ func (i *Interpreter) evaluateUnaryExpression(expr *ast.UnaryExpression) (types.
 		return nil, err
 	}
 
-	switch expr.Operator {
+	return ApplyUnary(expr.Operator, operand)
+}
+
+// ApplyUnary applies a unary operator to an evaluated operand
+func ApplyUnary(operator string, operand types.Value) (types.Value, error) {
+	switch operator {
 	case "-":
-		if _, ok := operand.Type().(types.NumberType); !ok {
+		switch num := operand.(type) {
+		case types.IntValue:
+			if num.Value == math.MinInt64 {
+				return nil, fmt.Errorf("-(%d) is too large for an integer", num.Value)
+			}
+			return types.IntValue{Value: -num.Value}, nil
+		case types.NumberValue:
+			return types.NumberValue{Value: -num.Value}, nil
+		default:
 			return nil, fmt.Errorf("cannot negate non-number value")
 		}
-		num := operand.(types.NumberValue)
-		return types.NumberValue{Value: -num.Value}, nil
-	case "!":
+	case "!", "not":
 		if _, ok := operand.Type().(types.BooleanType); !ok {
 			return nil, fmt.Errorf("cannot negate non-boolean value")
 		}
 		b := operand.(types.BooleanValue)
 		return types.BooleanValue{Value: !b.Value}, nil
 	default:
-		return nil, fmt.Errorf("unknown unary operator: %s", expr.Operator)
+		return nil, fmt.Errorf("unknown unary operator: %s", operator)
 	}
 }
 
-// evaluateFunctionCall evaluates a function call
+// evaluateFunctionCall evaluates a function call, falling back to the
+// built-in functions when the program declares none by that name
 func (i *Interpreter) evaluateFunctionCall(call *ast.FunctionCall) (types.Value, error) {
-	function, exists := i.environment.GetFunction(call.Name)
-	if !exists {
-		return nil, fmt.Errorf("undefined function: %s", call.Name)
+	var function *FunctionValue
+	var err error
+	if call.Method {
+		err = CheckMethod(call.Name)
+	} else if call.Callee != nil {
+		function, err = i.evaluateCallee(call.Callee)
+	} else if host, ok := i.hostFunction(call.Name); ok {
+		args, err := i.evaluateArguments(call.Arguments)
+		if err != nil {
+			return nil, err
+		}
+		return callHost(host, args)
+	} else {
+		function, err = LookupCallee(i.environment, i.functions, call.Name)
 	}
-
-	// Evaluate arguments
-	var args []types.Value
-	for _, arg := range call.Arguments {
-		value, err := i.evaluateExpression(arg)
+	if err != nil {
+		return nil, err
+	}
+	if function == nil {
+		args, err := i.evaluateArguments(call.Arguments)
 		if err != nil {
 			return nil, err
 		}
-		args = append(args, value)
+		return i.callBuiltin(call.Name, builtins[call.Name], args)
+	}
+
+	i.depth++
+	defer func() {
+		i.depth--
+	}()
+	if i.depth > i.maxDepth {
+		return nil, fmt.Errorf("maximum recursion depth exceeded (%d)", i.maxDepth)
 	}
 
-	// Check argument count
-	if len(args) != len(function.Parameters) {
-		return nil, fmt.Errorf("function %s expects %d arguments, got %d", call.Name, len(function.Parameters), len(args))
+	args, err := i.evaluateArguments(call.Arguments)
+	if err != nil {
+		return nil, err
 	}
+	return i.callFunction(*function, args)
+}
 
-	// Create new environment for function execution
-	funcEnv := NewEnvironment(i.environment)
+// evaluateCallee evaluates an expression being called, which must produce a
+// function
+func (i *Interpreter) evaluateCallee(expr ast.Expression) (*FunctionValue, error) {
+	value, err := i.evaluateExpression(expr)
+	if err != nil {
+		return nil, err
+	}
+	return CalleeValue(value)
+}
 
-	// Set parameters
-	for j, param := range function.Parameters {
-		// Type checking
-		if !param.Type.IsCompatibleWith(args[j].Type()) {
-			return nil, fmt.Errorf("type mismatch in function %s: parameter %s expects %s, got %s",
-				call.Name, param.Name, param.Type.String(), args[j].Type().String())
-		}
-		funcEnv.SetVariable(param.Name, args[j])
+// callFunction runs the body of function with args bound to its parameters
+// and returns the value of the return statement that ends it, or void
+func (i *Interpreter) callFunction(function FunctionValue, args []types.Value) (types.Value, error) {
+	// Functions are lexically scoped: the body sees its parameters and the
+	// environment it was declared in, never the local variables of whoever
+	// called it
+	info := i.info(function.Declaration)
+	funcEnv := newEnvironmentSized(function.Closure, info.slots)
+	if err := bindArguments(funcEnv, function.Declaration, info.required, args); err != nil {
+		return nil, err
 	}
 
-	// Execute function body
 	oldEnv := i.environment
 	i.environment = funcEnv
 
@@ -394,8 +1478,24 @@ func (i *Interpreter) evaluateFunctionCall(call *ast.FunctionCall) (types.Value,
 		i.environment = oldEnv
 	}()
 
-	for _, statement := range function.Body {
+	// Defaults are evaluated inside the function, so they can refer to the
+	// parameters before them
+	for j := len(args); j < len(function.Declaration.Parameters); j++ {
+		param := function.Declaration.Parameters[j]
+		value, err := i.evaluateExpression(param.Default)
+		if err != nil {
+			return nil, err
+		}
+		if err := BindParameter(funcEnv, function.Declaration, j, value); err != nil {
+			return nil, newRuntimeError(param.Default.Pos(), err)
+		}
+	}
+
+	for _, statement := range function.Declaration.Body {
 		_, err := i.executeStatement(statement)
+		if signal, ok := err.(*returnSignal); ok {
+			return signal.value, nil
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -404,15 +1504,141 @@ func (i *Interpreter) evaluateFunctionCall(call *ast.FunctionCall) (types.Value,
 	return types.VoidValue{}, nil
 }
 
-// Arithmetic operations
-func (i *Interpreter) add(left, right types.Value) (types.Value, error) {
-	// Number + Number = Number
-	if _, ok := left.Type().(types.NumberType); ok {
-		if _, ok := right.Type().(types.NumberType); ok {
-			l := left.(types.NumberValue).Value
-			r := right.(types.NumberValue).Value
-			return types.NumberValue{Value: l + r}, nil
+// BindArguments checks a call's arguments against the parameters of function
+// and defines each parameter that was passed in env. Parameters left out are
+// bound with BindParameter once their default has been evaluated.
+func BindArguments(env *Environment, function *ast.FunctionDeclaration, args []types.Value) error {
+	return bindArguments(env, function, function.RequiredParameters(), args)
+}
+
+// bindArguments is BindArguments for a function whose required parameters
+// have already been counted
+func bindArguments(env *Environment, function *ast.FunctionDeclaration, required int, args []types.Value) error {
+	if len(args) < required || len(args) > len(function.Parameters) {
+		callee := "function " + function.Name
+		if function.Name == ast.AnonymousFunction {
+			callee = "lambda"
+		}
+		if required == len(function.Parameters) {
+			return fmt.Errorf("%s expects %d arguments, got %d", callee, required, len(args))
+		}
+		return fmt.Errorf("%s expects %d to %d arguments, got %d", callee, required, len(function.Parameters), len(args))
+	}
+
+	for j, arg := range args {
+		if err := BindParameter(env, function, j, arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BindParameter checks value against the j-th parameter of function and
+// defines that parameter in env
+func BindParameter(env *Environment, function *ast.FunctionDeclaration, j int, value types.Value) error {
+	param := function.Parameters[j]
+	if !param.Type.IsCompatibleWith(value.Type()) {
+		return fmt.Errorf("type mismatch in function %s: parameter %s expects %s, got %s",
+			function.Name, param.Name, param.Type.String(), value.Type().String())
+	}
+	env.define(param.Name, param.Type, convertForType(param.Type, value))
+	return nil
+}
+
+// evaluateArguments evaluates call arguments from left to right
+func (i *Interpreter) evaluateArguments(arguments []ast.Expression) ([]types.Value, error) {
+	var args []types.Value
+	for _, arg := range arguments {
+		value, err := i.evaluateExpression(arg)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, value)
+	}
+	return args, nil
+}
+
+// isNumeric reports whether a value is an integer or a floating-point number
+func isNumeric(value types.Value) bool {
+	switch value.(type) {
+	case types.IntValue, types.NumberValue:
+		return true
+	default:
+		return false
+	}
+}
+
+// toFloat widens a numeric value to floating point
+func toFloat(value types.Value) float64 {
+	switch v := value.(type) {
+	case types.IntValue:
+		return float64(v.Value)
+	case types.NumberValue:
+		return v.Value
+	default:
+		return 0
+	}
+}
+
+// integerOperands returns both operands when they are integers, in which case
+// arithmetic stays integral. Any other numeric pairing widens to floating point.
+func integerOperands(left, right types.Value) (int64, int64, bool) {
+	l, ok := left.(types.IntValue)
+	if !ok {
+		return 0, 0, false
+	}
+	r, ok := right.(types.IntValue)
+	if !ok {
+		return 0, 0, false
+	}
+	return l.Value, r.Value, true
+}
+
+// convertForType widens an integer into a floating-point number when it is
+// stored somewhere declared as number or number?
+func convertForType(t types.Type, value types.Value) types.Value {
+	if nullable, ok := t.(types.NullableType); ok {
+		t = nullable.Inner
+	}
+	if _, ok := t.(types.NumberType); ok {
+		if integer, ok := value.(types.IntValue); ok {
+			return types.NumberValue{Value: float64(integer.Value)}
+		}
+	}
+	return value
+}
+
+// overflow is the error for integer arithmetic whose result does not fit
+// in an integer
+func overflow(l int64, operator string, r int64) error {
+	return fmt.Errorf("%d %s %d is too large for an integer", l, operator, r)
+}
+
+// multiplyIntegers returns l * r, reporting false if the product does not
+// fit in an integer
+func multiplyIntegers(l, r int64) (int64, bool) {
+	product := l * r
+	if l != 0 && (product/l != r || l == -1 && r == math.MinInt64) {
+		return 0, false
+	}
+	return product, true
+}
+
+// Arithmetic operations. Integer arithmetic is checked, so a result that
+// does not fit in an integer is an error rather than wrapping around.
+func add(left, right types.Value) (types.Value, error) {
+	// Integer + Integer = Integer
+	if l, r, ok := integerOperands(left, right); ok {
+		sum := l + r
+		if r > 0 && sum < l || r < 0 && sum > l {
+			return nil, overflow(l, "+", r)
 		}
+		return types.IntValue{Value: sum}, nil
+	}
+
+	// Number + Number = Number, widening an integer operand
+	if isNumeric(left) && isNumeric(right) {
+		return types.NumberValue{Value: toFloat(left) + toFloat(right)}, nil
 	}
 
 	// Text + Text = Text (concatenation)
@@ -424,138 +1650,424 @@ func (i *Interpreter) add(left, right types.Value) (types.Value, error) {
 		}
 	}
 
-	// Text + Number = Text (concatenation with number converted to string)
-	if _, ok := left.Type().(types.TextType); ok {
-		if _, ok := right.Type().(types.NumberType); ok {
-			l := left.(types.TextValue).Value
-			r := right.(types.NumberValue).Value
-			return types.TextValue{Value: l + fmt.Sprintf("%g", r)}, nil
+	// Char + Char, Char + Text or Text + Char = Text
+	if l, ok := characters(left); ok {
+		if r, ok := characters(right); ok {
+			return types.TextValue{Value: l + r}, nil
 		}
 	}
 
+	// Text + Number = Text (concatenation with number converted to string)
+	if _, ok := left.Type().(types.TextType); ok && isNumeric(right) {
+		l := left.(types.TextValue).Value
+		return types.TextValue{Value: l + right.String()}, nil
+	}
+
 	// Number + Text = Text (concatenation with number converted to string)
-	if _, ok := left.Type().(types.NumberType); ok {
-		if _, ok := right.Type().(types.TextType); ok {
-			l := left.(types.NumberValue).Value
-			r := right.(types.TextValue).Value
-			return types.TextValue{Value: fmt.Sprintf("%g", l) + r}, nil
-		}
+	if _, ok := right.Type().(types.TextType); ok && isNumeric(left) {
+		r := right.(types.TextValue).Value
+		return types.TextValue{Value: left.String() + r}, nil
 	}
 
 	return nil, fmt.Errorf("cannot add %s and %s", left.Type().String(), right.Type().String())
 }
 
-func (i *Interpreter) subtract(left, right types.Value) (types.Value, error) {
-	if _, ok := left.Type().(types.NumberType); ok {
-		if _, ok := right.Type().(types.NumberType); ok {
-			l := left.(types.NumberValue).Value
-			r := right.(types.NumberValue).Value
-			return types.NumberValue{Value: l - r}, nil
+// characters gives the text of a text or char value, and reports false for
+// any other value
+func characters(value types.Value) (string, bool) {
+	switch v := value.(type) {
+	case types.TextValue:
+		return v.Value, true
+	case types.CharValue:
+		return string(v.Value), true
+	default:
+		return "", false
+	}
+}
+
+func subtract(left, right types.Value) (types.Value, error) {
+	if l, r, ok := integerOperands(left, right); ok {
+		difference := l - r
+		if r > 0 && difference > l || r < 0 && difference < l {
+			return nil, overflow(l, "-", r)
 		}
+		return types.IntValue{Value: difference}, nil
+	}
+	if isNumeric(left) && isNumeric(right) {
+		return types.NumberValue{Value: toFloat(left) - toFloat(right)}, nil
 	}
 	return nil, fmt.Errorf("cannot subtract %s from %s", right.Type().String(), left.Type().String())
 }
 
-func (i *Interpreter) multiply(left, right types.Value) (types.Value, error) {
-	if _, ok := left.Type().(types.NumberType); ok {
-		if _, ok := right.Type().(types.NumberType); ok {
-			l := left.(types.NumberValue).Value
-			r := right.(types.NumberValue).Value
-			return types.NumberValue{Value: l * r}, nil
+func multiply(left, right types.Value) (types.Value, error) {
+	if l, r, ok := integerOperands(left, right); ok {
+		product, ok := multiplyIntegers(l, r)
+		if !ok {
+			return nil, overflow(l, "*", r)
 		}
+		return types.IntValue{Value: product}, nil
+	}
+	if isNumeric(left) && isNumeric(right) {
+		return types.NumberValue{Value: toFloat(left) * toFloat(right)}, nil
+	}
+	if text, ok := left.(types.TextValue); ok && isNumeric(right) {
+		return repeatText(text.Value, right)
+	}
+	if text, ok := right.(types.TextValue); ok && isNumeric(left) {
+		return repeatText(text.Value, left)
 	}
 	return nil, fmt.Errorf("cannot multiply %s and %s", left.Type().String(), right.Type().String())
 }
 
-func (i *Interpreter) divide(left, right types.Value) (types.Value, error) {
-	if _, ok := left.Type().(types.NumberType); ok {
-		if _, ok := right.Type().(types.NumberType); ok {
-			l := left.(types.NumberValue).Value
-			r := right.(types.NumberValue).Value
-			if r == 0 {
-				return nil, fmt.Errorf("division by zero")
-			}
-			return types.NumberValue{Value: l / r}, nil
+// maxRepeatedText is the longest text, in bytes, that '*' builds by
+// repeating text
+const maxRepeatedText = 1 << 30
+
+// repeatText multiplies text by a count, joining that many copies of it. The
+// count must be a whole number and not negative; a count of zero gives empty
+// text.
+func repeatText(text string, count types.Value) (types.Value, error) {
+	n, ok := count.(types.IntValue)
+	if number, isNumber := count.(types.NumberValue); isNumber {
+		if number.Value != math.Trunc(number.Value) || math.IsInf(number.Value, 0) {
+			return nil, fmt.Errorf("text can only be repeated a whole number of times, got %g", number.Value)
+		}
+		n, ok = types.IntValue{Value: int64(number.Value)}, true
+	}
+	if !ok {
+		return nil, fmt.Errorf("cannot multiply text and %s", count.Type().String())
+	}
+	if n.Value < 0 {
+		return nil, fmt.Errorf("cannot repeat text a negative number of times: %d", n.Value)
+	}
+	if n.Value > 0 && int64(len(text)) > maxRepeatedText/n.Value {
+		return nil, fmt.Errorf("repeated text would be longer than %d bytes", maxRepeatedText)
+	}
+	return types.TextValue{Value: strings.Repeat(text, int(n.Value))}, nil
+}
+
+// divide always performs floating-point division, even for two integers
+func divide(left, right types.Value) (types.Value, error) {
+	if isNumeric(left) && isNumeric(right) {
+		r := toFloat(right)
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return types.NumberValue{Value: toFloat(left) / r}, nil
+	}
+	return nil, fmt.Errorf("cannot divide %s by %s", left.Type().String(), right.Type().String())
+}
+
+// intDivide divides and truncates the quotient toward zero, so that 7 // 2
+// is 3 and -7 // 2 is -3. The result is always an integer.
+func intDivide(left, right types.Value) (types.Value, error) {
+	if l, r, ok := integerOperands(left, right); ok {
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		if l == math.MinInt64 && r == -1 {
+			return nil, overflow(l, "//", r)
+		}
+		return types.IntValue{Value: l / r}, nil
+	}
+	if isNumeric(left) && isNumeric(right) {
+		r := toFloat(right)
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		quotient := math.Trunc(toFloat(left) / r)
+		if quotient < math.MinInt64 || quotient >= math.MaxInt64 || math.IsNaN(quotient) {
+			return nil, fmt.Errorf("'//' result %g is too large for an integer", quotient)
 		}
+		return types.IntValue{Value: int64(quotient)}, nil
 	}
 	return nil, fmt.Errorf("cannot divide %s by %s", left.Type().String(), right.Type().String())
 }
 
+// modulo returns the remainder of a division, which takes the sign of the
+// dividend. It is integral when both operands are integers.
+func modulo(left, right types.Value) (types.Value, error) {
+	if l, r, ok := integerOperands(left, right); ok {
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return types.IntValue{Value: l % r}, nil
+	}
+	if isNumeric(left) && isNumeric(right) {
+		r := toFloat(right)
+		if r == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return types.NumberValue{Value: math.Mod(toFloat(left), r)}, nil
+	}
+	return nil, fmt.Errorf("cannot take the remainder of %s by %s", left.Type().String(), right.Type().String())
+}
+
+// power raises left to the power of right. An integer raised to a
+// non-negative integer stays integral; anything else is floating point.
+func power(left, right types.Value) (types.Value, error) {
+	if l, r, ok := integerOperands(left, right); ok && r >= 0 {
+		// Square the base only while a higher bit of the exponent is still
+		// to be multiplied in, so that squaring past the largest integer
+		// always means the result is past it too
+		base, exponent, result := l, r, int64(1)
+		for exponent > 0 {
+			if exponent%2 == 1 {
+				if result, ok = multiplyIntegers(result, base); !ok {
+					return nil, overflow(l, "^", r)
+				}
+			}
+			exponent /= 2
+			if exponent > 0 {
+				if base, ok = multiplyIntegers(base, base); !ok {
+					return nil, overflow(l, "^", r)
+				}
+			}
+		}
+		return types.IntValue{Value: result}, nil
+	}
+	if isNumeric(left) && isNumeric(right) {
+		return types.NumberValue{Value: math.Pow(toFloat(left), toFloat(right))}, nil
+	}
+	return nil, fmt.Errorf("cannot raise %s to the power of %s", left.Type().String(), right.Type().String())
+}
+
+// bitwise applies '&', '|', 'xor', '<<' or '>>' to two whole numbers and
+// gives an integer. A number is accepted when it has no fractional part. A
+// left shift drops bits shifted past the top, and a right shift keeps the
+// sign, so -8 >> 1 is -4.
+func bitwise(operator string, left, right types.Value) (types.Value, error) {
+	l, err := wholeNumber(operator, left)
+	if err != nil {
+		return nil, err
+	}
+	r, err := wholeNumber(operator, right)
+	if err != nil {
+		return nil, err
+	}
+
+	switch operator {
+	case "&":
+		return types.IntValue{Value: l & r}, nil
+	case "|":
+		return types.IntValue{Value: l | r}, nil
+	case "xor":
+		return types.IntValue{Value: l ^ r}, nil
+	}
+
+	if r < 0 {
+		return nil, fmt.Errorf("cannot shift by a negative amount: %d", r)
+	}
+	if operator == "<<" {
+		return types.IntValue{Value: l << r}, nil
+	}
+	return types.IntValue{Value: l >> r}, nil
+}
+
+// wholeNumber converts an operand of a bitwise operator to an integer
+func wholeNumber(operator string, value types.Value) (int64, error) {
+	switch v := value.(type) {
+	case types.IntValue:
+		return v.Value, nil
+	case types.NumberValue:
+		if v.Value == math.Trunc(v.Value) && v.Value >= math.MinInt64 && v.Value < math.MaxInt64 {
+			return int64(v.Value), nil
+		}
+		return 0, fmt.Errorf("'%s' needs whole numbers, got %g", operator, v.Value)
+	default:
+		return 0, fmt.Errorf("cannot apply '%s' to %s", operator, value.Type().String())
+	}
+}
+
 // Comparison operations
-func (i *Interpreter) equal(left, right types.Value) (types.Value, error) {
+
+// equal decides '=='. Numbers are equal when they are within 1e-9 of each
+// other, whether integers or not. Any other value is only ever equal to a
+// value of its own type: text and booleans when they hold the same value,
+// nil to nil, void to void, and functions when they are the same function
+// closed over the same scope. Lists are equal when they have the same length
+// and their elements are equal in order, and maps when they have the same
+// keys with equal values, so numbers inside them get the same tolerance.
+// Values of different types are unequal rather than an error, so that
+// testing for nil or switching over mixed cases works.
+func equal(left, right types.Value) (types.Value, error) {
+	same, err := (&equality{}).equal(left, right)
+	if err != nil {
+		return nil, err
+	}
+	return types.BooleanValue{Value: same}, nil
+}
+
+// equality compares two values, remembering the pairs of lists and maps it
+// is in the middle of comparing. A host can build a map that contains
+// itself; meeting the same pair again inside itself adds nothing, so it is
+// taken as equal rather than compared forever.
+type equality struct {
+	comparing map[[2]interface{}]bool
+}
+
+func (e *equality) equal(left, right types.Value) (bool, error) {
+	if isNumeric(left) && isNumeric(right) {
+		if l, r, ok := integerOperands(left, right); ok {
+			return l == r, nil
+		}
+		return math.Abs(toFloat(left)-toFloat(right)) < 1e-9, nil
+	}
+
 	if left.Type() != right.Type() {
-		return types.BooleanValue{Value: false}, nil
+		return false, nil
 	}
 
 	switch l := left.(type) {
-	case types.NumberValue:
-		r := right.(types.NumberValue)
-		return types.BooleanValue{Value: math.Abs(l.Value-r.Value) < 1e-9}, nil
 	case types.TextValue:
-		r := right.(types.TextValue)
-		return types.BooleanValue{Value: l.Value == r.Value}, nil
+		return l.Value == right.(types.TextValue).Value, nil
+	case types.CharValue:
+		return l.Value == right.(types.CharValue).Value, nil
 	case types.BooleanValue:
-		r := right.(types.BooleanValue)
-		return types.BooleanValue{Value: l.Value == r.Value}, nil
+		return l.Value == right.(types.BooleanValue).Value, nil
+	case types.NilValue, types.VoidValue:
+		return true, nil
+	case types.EnumValue:
+		return l.Value == right.(types.EnumValue).Value, nil
+	case FunctionValue:
+		r := right.(FunctionValue)
+		return l.Declaration == r.Declaration && l.Closure == r.Closure, nil
+	case types.ListValue:
+		r := right.(types.ListValue)
+		if len(l.Elements) != len(r.Elements) {
+			return false, nil
+		}
+		if len(l.Elements) == 0 || e.begin(&l.Elements[0], &r.Elements[0]) {
+			return true, nil
+		}
+		for i := range l.Elements {
+			if same, err := e.equal(l.Elements[i], r.Elements[i]); !same || err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	case types.MapValue:
+		r := right.(types.MapValue)
+		if len(l.Entries) != len(r.Entries) {
+			return false, nil
+		}
+		if len(l.Entries) == 0 || e.begin(reflect.ValueOf(l.Entries).Pointer(), reflect.ValueOf(r.Entries).Pointer()) {
+			return true, nil
+		}
+		for key, value := range l.Entries {
+			other, ok := r.Entries[key]
+			if !ok {
+				return false, nil
+			}
+			if same, err := e.equal(value, other); !same || err != nil {
+				return false, err
+			}
+		}
+		return true, nil
 	default:
-		return types.BooleanValue{Value: false}, nil
+		return false, fmt.Errorf("cannot compare %s values for equality", left.Type().String())
+	}
+}
+
+// begin records that the composites identified by left and right are being
+// compared, and reports whether they already were
+func (e *equality) begin(left, right interface{}) bool {
+	pair := [2]interface{}{left, right}
+	if e.comparing[pair] {
+		return true
 	}
+	if e.comparing == nil {
+		e.comparing = make(map[[2]interface{}]bool)
+	}
+	e.comparing[pair] = true
+	return false
 }
 
-func (i *Interpreter) notEqual(left, right types.Value) (types.Value, error) {
-	result, err := i.equal(left, right)
+func notEqual(left, right types.Value) (types.Value, error) {
+	result, err := equal(left, right)
 	if err != nil {
 		return nil, err
 	}
 	return types.BooleanValue{Value: !result.(types.BooleanValue).Value}, nil
 }
 
-func (i *Interpreter) lessThan(left, right types.Value) (types.Value, error) {
-	if _, ok := left.Type().(types.NumberType); ok {
-		if _, ok := right.Type().(types.NumberType); ok {
-			l := left.(types.NumberValue).Value
-			r := right.(types.NumberValue).Value
-			return types.BooleanValue{Value: l < r}, nil
+// compareNumbers orders two numeric operands, returning a negative, zero or
+// positive result. It reports false if either operand is not numeric.
+func compareNumbers(left, right types.Value) (int, bool) {
+	if !isNumeric(left) || !isNumeric(right) {
+		return 0, false
+	}
+
+	if l, r, ok := integerOperands(left, right); ok {
+		switch {
+		case l < r:
+			return -1, true
+		case l > r:
+			return 1, true
+		default:
+			return 0, true
 		}
 	}
+
+	l, r := toFloat(left), toFloat(right)
+	switch {
+	case l < r:
+		return -1, true
+	case l > r:
+		return 1, true
+	default:
+		return 0, true
+	}
+}
+
+// compareValues orders two numbers, two texts by comparing their bytes, or
+// two chars by their code points, returning a negative, zero or positive
+// result. It reports false for any other pair of operands, including a text
+// and a number.
+func compareValues(left, right types.Value) (int, bool) {
+	l, leftText := left.(types.TextValue)
+	r, rightText := right.(types.TextValue)
+	if leftText && rightText {
+		return strings.Compare(l.Value, r.Value), true
+	}
+	lc, leftChar := left.(types.CharValue)
+	rc, rightChar := right.(types.CharValue)
+	if leftChar && rightChar {
+		return int(lc.Value - rc.Value), true
+	}
+	return compareNumbers(left, right)
+}
+
+func lessThan(left, right types.Value) (types.Value, error) {
+	if c, ok := compareValues(left, right); ok {
+		return types.BooleanValue{Value: c < 0}, nil
+	}
 	return nil, fmt.Errorf("cannot compare %s and %s", left.Type().String(), right.Type().String())
 }
 
-func (i *Interpreter) lessEqual(left, right types.Value) (types.Value, error) {
-	if _, ok := left.Type().(types.NumberType); ok {
-		if _, ok := right.Type().(types.NumberType); ok {
-			l := left.(types.NumberValue).Value
-			r := right.(types.NumberValue).Value
-			return types.BooleanValue{Value: l <= r}, nil
-		}
+func lessEqual(left, right types.Value) (types.Value, error) {
+	if c, ok := compareValues(left, right); ok {
+		return types.BooleanValue{Value: c <= 0}, nil
 	}
 	return nil, fmt.Errorf("cannot compare %s and %s", left.Type().String(), right.Type().String())
 }
 
-func (i *Interpreter) greaterThan(left, right types.Value) (types.Value, error) {
-	if _, ok := left.Type().(types.NumberType); ok {
-		if _, ok := right.Type().(types.NumberType); ok {
-			l := left.(types.NumberValue).Value
-			r := right.(types.NumberValue).Value
-			return types.BooleanValue{Value: l > r}, nil
-		}
+func greaterThan(left, right types.Value) (types.Value, error) {
+	if c, ok := compareValues(left, right); ok {
+		return types.BooleanValue{Value: c > 0}, nil
 	}
 	return nil, fmt.Errorf("cannot compare %s and %s", left.Type().String(), right.Type().String())
 }
 
-func (i *Interpreter) greaterEqual(left, right types.Value) (types.Value, error) {
-	if _, ok := left.Type().(types.NumberType); ok {
-		if _, ok := right.Type().(types.NumberType); ok {
-			l := left.(types.NumberValue).Value
-			r := right.(types.NumberValue).Value
-			return types.BooleanValue{Value: l >= r}, nil
-		}
+func greaterEqual(left, right types.Value) (types.Value, error) {
+	if c, ok := compareValues(left, right); ok {
+		return types.BooleanValue{Value: c >= 0}, nil
 	}
 	return nil, fmt.Errorf("cannot compare %s and %s", left.Type().String(), right.Type().String())
 }
 
 // Logical operations
-func (i *Interpreter) logicalAnd(left, right types.Value) (types.Value, error) {
+func logicalAnd(left, right types.Value) (types.Value, error) {
 	if _, ok := left.Type().(types.BooleanType); ok {
 		if _, ok := right.Type().(types.BooleanType); ok {
 			l := left.(types.BooleanValue).Value
@@ -566,7 +2078,7 @@ func (i *Interpreter) logicalAnd(left, right types.Value) (types.Value, error) {
 	return nil, fmt.Errorf("cannot perform logical AND on %s and %s", left.Type().String(), right.Type().String())
 }
 
-func (i *Interpreter) logicalOr(left, right types.Value) (types.Value, error) {
+func logicalOr(left, right types.Value) (types.Value, error) {
 	if _, ok := left.Type().(types.BooleanType); ok {
 		if _, ok := right.Type().(types.BooleanType); ok {
 			l := left.(types.BooleanValue).Value
@@ -0,0 +1,83 @@
+package interpreter
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"simplelang/internal/ast"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// Profile tallies what a program did as it ran, by kind of node, such as
+// PrintStatement or BinaryExpression: how many times nodes of that kind ran
+// and how long they took. A node's time leaves out the nodes within it, so
+// the time of a loop is that of counting its passes, its body's statements
+// being timed on their own, and the times of all kinds add up to the run.
+type Profile struct {
+	Counts map[string]int
+	Times  map[string]time.Duration
+	// nested is how long the nodes within the one being timed took so far
+	nested time.Duration
+}
+
+func newProfile() *Profile {
+	return &Profile{
+		Counts: make(map[string]int),
+		Times:  make(map[string]time.Duration),
+	}
+}
+
+// EnableProfiling makes the interpreter tally every statement it executes
+// and expression it evaluates in a Profile, at some cost in speed. Each run
+// starts a new profile.
+func (i *Interpreter) EnableProfiling() {
+	i.profile = newProfile()
+}
+
+// Profile returns the profile of the last program run, or nil unless
+// profiling is enabled
+func (i *Interpreter) Profile() *Profile {
+	return i.profile
+}
+
+// enter counts node and starts timing it, returning the function that stops
+func (p *Profile) enter(node ast.Node) func() {
+	kind := reflect.TypeOf(node).Elem().Name()
+	p.Counts[kind]++
+	outer := p.nested
+	p.nested = 0
+	start := time.Now()
+	return func() {
+		elapsed := time.Since(start)
+		p.Times[kind] += elapsed - p.nested
+		p.nested = outer + elapsed
+	}
+}
+
+// WriteSummary writes a table of each kind of node with its count and time,
+// the slowest first, and a total
+func (p *Profile) WriteSummary(w io.Writer) {
+	kinds := make([]string, 0, len(p.Counts))
+	for kind := range p.Counts {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(a, b int) bool {
+		if p.Times[kinds[a]] != p.Times[kinds[b]] {
+			return p.Times[kinds[a]] > p.Times[kinds[b]]
+		}
+		return kinds[a] < kinds[b]
+	})
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "NODE\tCOUNT\tTIME")
+	count, total := 0, time.Duration(0)
+	for _, kind := range kinds {
+		fmt.Fprintf(table, "%s\t%d\t%s\n", kind, p.Counts[kind], p.Times[kind])
+		count += p.Counts[kind]
+		total += p.Times[kind]
+	}
+	fmt.Fprintf(table, "TOTAL\t%d\t%s\n", count, total)
+	table.Flush()
+}
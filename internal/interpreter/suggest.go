@@ -0,0 +1,55 @@
+package interpreter
+
+import "fmt"
+
+// maxSuggestionDistance is the largest edit distance at which a defined
+// name is offered as a correction for an undefined one
+const maxSuggestionDistance = 2
+
+// UndefinedVariable reports a reference to a variable unknown in env,
+// suggesting the closest visible name when one is near enough to be a likely
+// typo
+func UndefinedVariable(env *Environment, name string) error {
+	if suggestion, ok := closestName(name, env.VariableNames()); ok {
+		return fmt.Errorf("undefined variable: %s; did you mean '%s'?", name, suggestion)
+	}
+	return fmt.Errorf("undefined variable: %s", name)
+}
+
+// closestName finds the candidate with the smallest edit distance to name,
+// preferring the alphabetically first on a tie. It reports false when no
+// candidate is within maxSuggestionDistance.
+func closestName(name string, candidates []string) (string, bool) {
+	best := ""
+	bestDistance := maxSuggestionDistance + 1
+	for _, candidate := range candidates {
+		if d := levenshtein(name, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best, best != ""
+}
+
+// levenshtein counts the single-character insertions, deletions and
+// substitutions needed to turn a into b
+func levenshtein(a, b string) int {
+	source, target := []rune(a), []rune(b)
+	previous := make([]int, len(target)+1)
+	current := make([]int, len(target)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(source); i++ {
+		current[0] = i
+		for j := 1; j <= len(target); j++ {
+			cost := 1
+			if source[i-1] == target[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(target)]
+}
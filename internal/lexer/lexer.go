@@ -1,8 +1,15 @@
 package lexer
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"io"
+	"simplelang/internal/diagnostic"
+	"strconv"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 // TokenType represents the type of a token
@@ -15,16 +22,22 @@ const (
 
 	// Literals
 	TokenNumber
+	TokenInteger
 	TokenText
+	TokenChar
 	TokenBoolean
+	TokenNil
 
 	// Identifiers
 	TokenIdentifier
 
 	// Keywords
 	TokenNumberKeyword
+	TokenIntegerKeyword
 	TokenTextKeyword
 	TokenBooleanKeyword
+	TokenListKeyword
+	TokenLet
 	TokenFunction
 	TokenIf
 	TokenThen
@@ -33,13 +46,37 @@ const (
 	TokenLoop
 	TokenFrom
 	TokenTo
+	TokenStep
 	TokenPrint
+	TokenSwitch
+	TokenCase
+	TokenDefault
+	TokenFor
+	TokenIn
+	TokenReturn
+	TokenWrite
+	TokenAssert
+	TokenTry
+	TokenCatch
+	TokenRaise
+	TokenEnum
+	TokenRepeat
+	TokenUntil
+	TokenBegin
+	TokenInclude
+	TokenImport
+	TokenAs
 
 	// Operators
 	TokenPlus
 	TokenMinus
 	TokenMultiply
 	TokenDivide
+	TokenIntDivide
+	TokenModulo
+	TokenPower
+	TokenIncrement
+	TokenDecrement
 	TokenAssign
 	TokenEqual
 	TokenNotEqual
@@ -50,56 +87,166 @@ const (
 	TokenAnd
 	TokenOr
 	TokenNot
+	TokenBitAnd
+	TokenBitOr
+	TokenXor
+	TokenShiftLeft
+	TokenShiftRight
 
 	// Delimiters
 	TokenLeftParen
 	TokenRightParen
 	TokenLeftBrace
 	TokenRightBrace
+	TokenLeftBracket
+	TokenRightBracket
 	TokenComma
 	TokenSemicolon
 	TokenColon
+	TokenQuestion
+	TokenDot
 )
 
-// Token represents a single token from the source code
+var tokenNames = map[TokenType]string{
+	TokenEOF:            "EOF",
+	TokenError:          "Error",
+	TokenNumber:         "Number",
+	TokenInteger:        "Integer",
+	TokenText:           "Text",
+	TokenChar:           "Char",
+	TokenBoolean:        "Boolean",
+	TokenNil:            "Nil",
+	TokenIdentifier:     "Identifier",
+	TokenNumberKeyword:  "NumberKeyword",
+	TokenIntegerKeyword: "IntegerKeyword",
+	TokenTextKeyword:    "TextKeyword",
+	TokenBooleanKeyword: "BooleanKeyword",
+	TokenListKeyword:    "ListKeyword",
+	TokenLet:            "Let",
+	TokenFunction:       "Function",
+	TokenIf:             "If",
+	TokenThen:           "Then",
+	TokenElse:           "Else",
+	TokenEnd:            "End",
+	TokenLoop:           "Loop",
+	TokenFrom:           "From",
+	TokenTo:             "To",
+	TokenStep:           "Step",
+	TokenPrint:          "Print",
+	TokenSwitch:         "Switch",
+	TokenCase:           "Case",
+	TokenDefault:        "Default",
+	TokenFor:            "For",
+	TokenIn:             "In",
+	TokenReturn:         "Return",
+	TokenWrite:          "Write",
+	TokenAssert:         "Assert",
+	TokenTry:            "Try",
+	TokenCatch:          "Catch",
+	TokenRaise:          "Raise",
+	TokenEnum:           "Enum",
+	TokenRepeat:         "Repeat",
+	TokenUntil:          "Until",
+	TokenBegin:          "Begin",
+	TokenInclude:        "Include",
+	TokenImport:         "Import",
+	TokenAs:             "As",
+	TokenPlus:           "Plus",
+	TokenMinus:          "Minus",
+	TokenMultiply:       "Multiply",
+	TokenDivide:         "Divide",
+	TokenIntDivide:      "IntDivide",
+	TokenModulo:         "Modulo",
+	TokenPower:          "Power",
+	TokenIncrement:      "Increment",
+	TokenDecrement:      "Decrement",
+	TokenAssign:         "Assign",
+	TokenEqual:          "Equal",
+	TokenNotEqual:       "NotEqual",
+	TokenLessThan:       "LessThan",
+	TokenLessEqual:      "LessEqual",
+	TokenGreaterThan:    "GreaterThan",
+	TokenGreaterEqual:   "GreaterEqual",
+	TokenAnd:            "And",
+	TokenOr:             "Or",
+	TokenNot:            "Not",
+	TokenBitAnd:         "BitAnd",
+	TokenBitOr:          "BitOr",
+	TokenXor:            "Xor",
+	TokenShiftLeft:      "ShiftLeft",
+	TokenShiftRight:     "ShiftRight",
+	TokenLeftParen:      "LeftParen",
+	TokenRightParen:     "RightParen",
+	TokenLeftBrace:      "LeftBrace",
+	TokenRightBrace:     "RightBrace",
+	TokenLeftBracket:    "LeftBracket",
+	TokenRightBracket:   "RightBracket",
+	TokenComma:          "Comma",
+	TokenSemicolon:      "Semicolon",
+	TokenColon:          "Colon",
+	TokenQuestion:       "Question",
+	TokenDot:            "Dot",
+}
+
+// String returns the human-readable name of the token type
+func (t TokenType) String() string {
+	if name, ok := tokenNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("TokenType(%d)", int(t))
+}
+
+// Token represents a single token from the source code. Line and Column
+// give where it starts, and EndLine and EndColumn the position just after
+// its last character, so a token on one line covers the columns from Column
+// up to but not including EndColumn.
 type Token struct {
-	Type    TokenType
-	Value   string
-	Line    int
-	Column  int
-	Literal interface{}
+	Type      TokenType
+	Value     string
+	Line      int
+	Column    int
+	EndLine   int
+	EndColumn int
+	Literal   interface{}
 }
 
 func (t Token) String() string {
-	return fmt.Sprintf("Token{Type: %d, Value: '%s', Line: %d, Column: %d}", t.Type, t.Value, t.Line, t.Column)
+	return fmt.Sprintf("Token{Type: %s, Value: '%s', Line: %d, Column: %d}", t.Type, t.Value, t.Line, t.Column)
 }
 
 // Lexer breaks source code into tokens
 type Lexer struct {
-	input    string
-	position int
-	line     int
-	column   int
-	tokens   []Token
+	input  *bufio.Reader
+	line   int
+	column int
+	tokens []Token
+	// err is the first error reading the input other than reaching its end
+	err error
 }
 
 // NewLexer creates a new lexer for the given input
 func NewLexer(input string) *Lexer {
+	return NewLexerReader(strings.NewReader(input))
+}
+
+// NewLexerReader creates a lexer that reads its input from r as it goes,
+// rather than needing all of it in memory first. It produces the same
+// tokens as NewLexer given the same source.
+func NewLexerReader(r io.Reader) *Lexer {
 	return &Lexer{
-		input:    input,
-		position: 0,
-		line:     1,
-		column:   1,
-		tokens:   []Token{},
+		input:  bufio.NewReader(r),
+		line:   1,
+		column: 1,
+		tokens: []Token{},
 	}
 }
 
 // Tokenize breaks the input into tokens
 func (l *Lexer) Tokenize() ([]Token, error) {
-	for l.position < len(l.input) {
+	for l.more() {
 		l.skipWhitespace()
 
-		if l.position >= len(l.input) {
+		if !l.more() {
 			break
 		}
 
@@ -107,15 +254,22 @@ func (l *Lexer) Tokenize() ([]Token, error) {
 		if err != nil {
 			return nil, err
 		}
+		if l.err != nil {
+			return nil, fmt.Errorf("error reading source: %w", l.err)
+		}
 
 		if token.Type == TokenError {
-			return nil, fmt.Errorf("lexical error at line %d, column %d: %s", token.Line, token.Column, token.Value)
+			return nil, l.diagnose(token)
 		}
+		token.EndLine, token.EndColumn = l.line, l.column
 
 		l.tokens = append(l.tokens, token)
 	}
 
-	l.tokens = append(l.tokens, Token{Type: TokenEOF, Line: l.line, Column: l.column})
+	if l.err != nil {
+		return nil, fmt.Errorf("error reading source: %w", l.err)
+	}
+	l.tokens = append(l.tokens, Token{Type: TokenEOF, Line: l.line, Column: l.column, EndLine: l.line, EndColumn: l.column})
 	return l.tokens, nil
 }
 
@@ -125,22 +279,50 @@ func (l *Lexer) nextToken() (Token, error) {
 	switch {
 	case unicode.IsDigit(char):
 		return l.readNumber(), nil
+	case l.atTripleQuote():
+		return l.readRawText(), nil
 	case char == '"':
 		return l.readText(), nil
+	case char == '\'':
+		return l.readChar(), nil
 	case unicode.IsLetter(char):
 		return l.readIdentifierOrKeyword(), nil
 	case char == '+':
 		l.advance()
+		if l.currentChar() == '+' {
+			l.advance()
+			return Token{Type: TokenIncrement, Value: "++", Line: l.line, Column: l.column - 2}, nil
+		}
 		return Token{Type: TokenPlus, Value: "+", Line: l.line, Column: l.column - 1}, nil
 	case char == '-':
 		l.advance()
+		if l.currentChar() == '-' {
+			l.advance()
+			return Token{Type: TokenDecrement, Value: "--", Line: l.line, Column: l.column - 2}, nil
+		}
 		return Token{Type: TokenMinus, Value: "-", Line: l.line, Column: l.column - 1}, nil
 	case char == '*':
 		l.advance()
 		return Token{Type: TokenMultiply, Value: "*", Line: l.line, Column: l.column - 1}, nil
 	case char == '/':
 		l.advance()
+		if l.currentChar() == '/' {
+			l.advance()
+			return Token{Type: TokenIntDivide, Value: "//", Line: l.line, Column: l.column - 2}, nil
+		}
 		return Token{Type: TokenDivide, Value: "/", Line: l.line, Column: l.column - 1}, nil
+	case char == '%':
+		l.advance()
+		return Token{Type: TokenModulo, Value: "%", Line: l.line, Column: l.column - 1}, nil
+	case char == '^':
+		l.advance()
+		return Token{Type: TokenPower, Value: "^", Line: l.line, Column: l.column - 1}, nil
+	case char == '&':
+		l.advance()
+		return Token{Type: TokenBitAnd, Value: "&", Line: l.line, Column: l.column - 1}, nil
+	case char == '|':
+		l.advance()
+		return Token{Type: TokenBitOr, Value: "|", Line: l.line, Column: l.column - 1}, nil
 	case char == '=':
 		l.advance()
 		if l.currentChar() == '=' {
@@ -154,6 +336,10 @@ func (l *Lexer) nextToken() (Token, error) {
 			l.advance()
 			return Token{Type: TokenLessEqual, Value: "<=", Line: l.line, Column: l.column - 2}, nil
 		}
+		if l.currentChar() == '<' {
+			l.advance()
+			return Token{Type: TokenShiftLeft, Value: "<<", Line: l.line, Column: l.column - 2}, nil
+		}
 		return Token{Type: TokenLessThan, Value: "<", Line: l.line, Column: l.column - 1}, nil
 	case char == '>':
 		l.advance()
@@ -161,6 +347,10 @@ func (l *Lexer) nextToken() (Token, error) {
 			l.advance()
 			return Token{Type: TokenGreaterEqual, Value: ">=", Line: l.line, Column: l.column - 2}, nil
 		}
+		if l.currentChar() == '>' {
+			l.advance()
+			return Token{Type: TokenShiftRight, Value: ">>", Line: l.line, Column: l.column - 2}, nil
+		}
 		return Token{Type: TokenGreaterThan, Value: ">", Line: l.line, Column: l.column - 1}, nil
 	case char == '!':
 		l.advance()
@@ -181,6 +371,12 @@ func (l *Lexer) nextToken() (Token, error) {
 	case char == '}':
 		l.advance()
 		return Token{Type: TokenRightBrace, Value: "}", Line: l.line, Column: l.column - 1}, nil
+	case char == '[':
+		l.advance()
+		return Token{Type: TokenLeftBracket, Value: "[", Line: l.line, Column: l.column - 1}, nil
+	case char == ']':
+		l.advance()
+		return Token{Type: TokenRightBracket, Value: "]", Line: l.line, Column: l.column - 1}, nil
 	case char == ',':
 		l.advance()
 		return Token{Type: TokenComma, Value: ",", Line: l.line, Column: l.column - 1}, nil
@@ -190,22 +386,65 @@ func (l *Lexer) nextToken() (Token, error) {
 	case char == ':':
 		l.advance()
 		return Token{Type: TokenColon, Value: ":", Line: l.line, Column: l.column - 1}, nil
+	case char == '?':
+		l.advance()
+		return Token{Type: TokenQuestion, Value: "?", Line: l.line, Column: l.column - 1}, nil
+	case char == '.':
+		l.advance()
+		return Token{Type: TokenDot, Value: ".", Line: l.line, Column: l.column - 1}, nil
 	default:
 		return Token{Type: TokenError, Value: fmt.Sprintf("unexpected character: %c", char), Line: l.line, Column: l.column}, nil
 	}
 }
 
+// readNumber reads a numeric literal. Literals without a decimal point are
+// integers; those with one are floating-point numbers. A decimal point must
+// be followed by at least one digit, so both "3.14.15" and a trailing dot as
+// in "5." are rejected. Underscores may group digits, as in 1_000_000, but
+// only between two digits; they are dropped from the token's value. An
+// integer may be at most 9223372036854775808, which only fits once negated,
+// as the smallest integer.
 func (l *Lexer) readNumber() Token {
-	start := l.position
-	startColumn := l.column
+	if l.currentChar() == '0' && strings.ContainsRune("xXbB", l.peekChar()) {
+		return l.readRadixInteger()
+	}
 
-	for l.position < len(l.input) && (unicode.IsDigit(l.currentChar()) || l.currentChar() == '.') {
+	startColumn := l.column
+	tokenType := TokenInteger
+	var digits strings.Builder
+	var previous rune
+
+	for l.more() && (unicode.IsDigit(l.currentChar()) || l.currentChar() == '.' || l.currentChar() == '_') {
+		char := l.currentChar()
+		switch char {
+		case '_':
+			if !unicode.IsDigit(previous) || !unicode.IsDigit(l.peekChar()) {
+				return Token{Type: TokenError, Value: "invalid number literal: '_' must separate digits", Line: l.line, Column: l.column}
+			}
+		case '.':
+			if tokenType == TokenNumber {
+				return Token{Type: TokenError, Value: "invalid number literal: multiple decimal points", Line: l.line, Column: l.column}
+			}
+			if !unicode.IsDigit(l.peekChar()) {
+				return Token{Type: TokenError, Value: "invalid number literal: expected a digit after the decimal point", Line: l.line, Column: l.column}
+			}
+			tokenType = TokenNumber
+			digits.WriteRune(char)
+		default:
+			digits.WriteRune(char)
+		}
+		previous = char
 		l.advance()
 	}
 
-	value := l.input[start:l.position]
+	value := digits.String()
+	if tokenType == TokenInteger {
+		if n, err := strconv.ParseUint(value, 10, 64); err != nil || n > 1<<63 {
+			return Token{Type: TokenError, Value: fmt.Sprintf("invalid number literal: %s is too large for an integer", value), Line: l.line, Column: startColumn}
+		}
+	}
 	return Token{
-		Type:    TokenNumber,
+		Type:    tokenType,
 		Value:   value,
 		Line:    l.line,
 		Column:  startColumn,
@@ -213,52 +452,311 @@ func (l *Lexer) readNumber() Token {
 	}
 }
 
+// readRadixInteger reads an integer written in hexadecimal after 0x or in
+// binary after 0b. Underscores may group its digits as in a decimal literal.
+// The token holds the decimal value, so the rest of the compiler sees the
+// same literal as if it had been written in decimal.
+func (l *Lexer) readRadixInteger() Token {
+	startColumn := l.column
+	base, name := 16, "hexadecimal"
+	if unicode.ToLower(l.peekChar()) == 'b' {
+		base, name = 2, "binary"
+	}
+	prefix := string([]rune{l.currentChar(), l.peekChar()})
+	l.advance() // skip 0
+	l.advance() // skip x or b
+
+	var digits strings.Builder
+	var previous rune
+	for l.more() && (unicode.IsLetter(l.currentChar()) || unicode.IsDigit(l.currentChar()) || l.currentChar() == '_') {
+		char := l.currentChar()
+		switch {
+		case char == '_':
+			if !isDigitOf(previous, base) || !isDigitOf(l.peekChar(), base) {
+				return Token{Type: TokenError, Value: "invalid number literal: '_' must separate digits", Line: l.line, Column: l.column}
+			}
+		case !isDigitOf(char, base):
+			return Token{Type: TokenError, Value: fmt.Sprintf("invalid number literal: '%c' is not a %s digit", char, name), Line: l.line, Column: l.column}
+		default:
+			digits.WriteRune(char)
+		}
+		previous = char
+		l.advance()
+	}
+
+	if digits.Len() == 0 {
+		return Token{Type: TokenError, Value: fmt.Sprintf("invalid number literal: expected a %s digit after %s", name, prefix), Line: l.line, Column: l.column}
+	}
+	value, err := strconv.ParseInt(digits.String(), base, 64)
+	if err != nil {
+		return Token{Type: TokenError, Value: fmt.Sprintf("invalid number literal: %s%s is too large for an integer", prefix, digits.String()), Line: l.line, Column: startColumn}
+	}
+
+	decimal := strconv.FormatInt(value, 10)
+	return Token{
+		Type:    TokenInteger,
+		Value:   decimal,
+		Line:    l.line,
+		Column:  startColumn,
+		Literal: decimal,
+	}
+}
+
+// isDigitOf reports whether char is a digit in the given base, which is at
+// most 16
+func isDigitOf(char rune, base int) bool {
+	return char != 0 && strings.ContainsRune("0123456789abcdef"[:base], unicode.ToLower(char))
+}
+
+// readText reads a text literal. A literal may span several lines; its token
+// is placed where the opening quote is.
 func (l *Lexer) readText() Token {
+	startLine := l.line
 	startColumn := l.column
 	l.advance() // skip opening quote
 
-	start := l.position
-	for l.position < len(l.input) && l.currentChar() != '"' {
-		if l.currentChar() == '\n' {
-			l.line++
-			l.column = 1
+	// raw keeps the literal as written, escapes and all, for the token's
+	// value
+	var raw, decoded strings.Builder
+	for l.more() && l.currentChar() != '"' {
+		if l.currentChar() == '\\' {
+			escapeColumn := l.column
+			raw.WriteByte(l.advance()) // skip backslash
+			if !l.more() {
+				break
+			}
+
+			if l.currentChar() == 'u' {
+				char, err := l.readUnicodeEscape(&raw)
+				if err != nil {
+					return Token{Type: TokenError, Value: err.Error(), Line: l.line, Column: escapeColumn}
+				}
+				decoded.WriteRune(char)
+				continue
+			}
+			escaped, ok := escapeSequences[l.currentChar()]
+			if !ok {
+				return Token{
+					Type:   TokenError,
+					Value:  fmt.Sprintf("unknown escape sequence: \\%c", l.currentChar()),
+					Line:   l.line,
+					Column: escapeColumn,
+				}
+			}
+			decoded.WriteRune(escaped)
+			raw.WriteByte(l.advance())
+			continue
 		}
-		l.advance()
+
+		char := l.advance()
+		raw.WriteByte(char)
+		decoded.WriteByte(char)
 	}
 
-	if l.position >= len(l.input) {
+	if !l.more() {
 		return Token{
 			Type:   TokenError,
 			Value:  "unterminated string",
-			Line:   l.line,
+			Line:   startLine,
 			Column: startColumn,
 		}
 	}
 
-	value := l.input[start:l.position]
+	value := raw.String()
 	l.advance() // skip closing quote
 
 	return Token{
 		Type:    TokenText,
 		Value:   value,
-		Line:    l.line,
+		Line:    startLine,
+		Column:  startColumn,
+		Literal: decoded.String(),
+	}
+}
+
+// readRawText reads a triple-quoted text literal, which runs to the next
+// three quotes and takes everything in between as written: backslashes
+// start no escapes and newlines stay in the text. Where more than three
+// quotes end it, the last three close it and the rest belong to the text.
+func (l *Lexer) readRawText() Token {
+	startLine := l.line
+	startColumn := l.column
+	for n := 0; n < 3; n++ {
+		l.advance() // skip opening quotes
+	}
+
+	var raw strings.Builder
+	for l.more() && (!l.atTripleQuote() || l.peekCharAt(3) == '"') {
+		raw.WriteByte(l.advance())
+	}
+
+	if !l.more() {
+		return Token{
+			Type:   TokenError,
+			Value:  "unterminated triple-quoted string",
+			Line:   startLine,
+			Column: startColumn,
+		}
+	}
+
+	for n := 0; n < 3; n++ {
+		l.advance() // skip closing quotes
+	}
+
+	value := raw.String()
+	return Token{
+		Type:    TokenText,
+		Value:   value,
+		Line:    startLine,
 		Column:  startColumn,
 		Literal: value,
 	}
 }
 
+// atTripleQuote reports whether the next three characters are quotes, which
+// open or close a triple-quoted text literal
+func (l *Lexer) atTripleQuote() bool {
+	return l.currentChar() == '"' && l.peekChar() == '"' && l.peekCharAt(2) == '"'
+}
+
+// readChar reads a character literal: exactly one character between single
+// quotes, which may be any of the escapes text allows or \'
+func (l *Lexer) readChar() Token {
+	startLine := l.line
+	startColumn := l.column
+	l.advance() // skip opening quote
+
+	var raw strings.Builder
+	var chars []rune
+	for l.more() && l.currentChar() != '\'' && l.currentChar() != '\n' {
+		if l.currentChar() == '\\' {
+			escapeColumn := l.column
+			raw.WriteByte(l.advance()) // skip backslash
+			if !l.more() {
+				break
+			}
+
+			if l.currentChar() == 'u' {
+				char, err := l.readUnicodeEscape(&raw)
+				if err != nil {
+					return Token{Type: TokenError, Value: err.Error(), Line: l.line, Column: escapeColumn}
+				}
+				chars = append(chars, char)
+				continue
+			}
+			escaped, ok := escapeSequences[l.currentChar()]
+			if l.currentChar() == '\'' {
+				escaped, ok = '\'', true
+			}
+			if !ok {
+				return Token{
+					Type:   TokenError,
+					Value:  fmt.Sprintf("unknown escape sequence: \\%c", l.currentChar()),
+					Line:   l.line,
+					Column: escapeColumn,
+				}
+			}
+			chars = append(chars, escaped)
+			raw.WriteByte(l.advance())
+			continue
+		}
+
+		// Gather the bytes of one UTF-8 character
+		var encoded []byte
+		encoded = append(encoded, l.advance())
+		for l.more() && !utf8.RuneStart(byte(l.currentChar())) {
+			encoded = append(encoded, l.advance())
+		}
+		raw.Write(encoded)
+		char, _ := utf8.DecodeRune(encoded)
+		chars = append(chars, char)
+	}
+
+	if !l.more() || l.currentChar() != '\'' {
+		return Token{Type: TokenError, Value: "unterminated character literal", Line: startLine, Column: startColumn}
+	}
+	l.advance() // skip closing quote
+
+	if len(chars) != 1 {
+		message := "character literal must hold exactly one character"
+		if len(chars) == 0 {
+			message = "empty character literal"
+		}
+		return Token{Type: TokenError, Value: message, Line: startLine, Column: startColumn}
+	}
+
+	return Token{
+		Type:    TokenChar,
+		Value:   raw.String(),
+		Line:    startLine,
+		Column:  startColumn,
+		Literal: chars[0],
+	}
+}
+
+// readUnicodeEscape reads the rest of a \u escape, from the u on, writing
+// it to raw as it goes, and returns the character it stands for. The code
+// point is written either as exactly four hexadecimal digits, as in \u00e9,
+// or as one to six between braces, as in \u{1F600}, and must be one that
+// UTF-8 can encode, which rules out surrogates.
+func (l *Lexer) readUnicodeEscape(raw *strings.Builder) (rune, error) {
+	raw.WriteByte(l.advance()) // skip u
+
+	braced := l.currentChar() == '{'
+	var digits strings.Builder
+	if braced {
+		raw.WriteByte(l.advance())
+		for isDigitOf(l.currentChar(), 16) {
+			digits.WriteByte(l.advance())
+		}
+		raw.WriteString(digits.String())
+		if digits.Len() == 0 || l.currentChar() != '}' {
+			return 0, errors.New(`invalid Unicode escape: expected hexadecimal digits and '}' after \u{`)
+		}
+		raw.WriteByte(l.advance())
+	} else {
+		for digits.Len() < 4 && isDigitOf(l.currentChar(), 16) {
+			digits.WriteByte(l.advance())
+		}
+		raw.WriteString(digits.String())
+		if digits.Len() < 4 {
+			return 0, errors.New(`invalid Unicode escape: expected four hexadecimal digits or braces after \u`)
+		}
+	}
+
+	code, err := strconv.ParseUint(digits.String(), 16, 32)
+	if err != nil || digits.Len() > 6 || !utf8.ValidRune(rune(code)) {
+		escape := `\u` + digits.String()
+		if braced {
+			escape = `\u{` + digits.String() + `}`
+		}
+		return 0, fmt.Errorf("invalid Unicode escape: %s is not a valid code point", escape)
+	}
+	return rune(code), nil
+}
+
+// escapeSequences maps the character following a backslash in a text
+// literal to the character it stands for
+var escapeSequences = map[rune]rune{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'\\': '\\',
+	'"':  '"',
+}
+
 func (l *Lexer) readIdentifierOrKeyword() Token {
-	start := l.position
 	startColumn := l.column
 
-	for l.position < len(l.input) && (unicode.IsLetter(l.currentChar()) || unicode.IsDigit(l.currentChar()) || l.currentChar() == '_') {
-		l.advance()
+	var name strings.Builder
+	for l.more() && (unicode.IsLetter(l.currentChar()) || unicode.IsDigit(l.currentChar()) || l.currentChar() == '_') {
+		name.WriteByte(l.advance())
 	}
 
-	value := l.input[start:l.position]
-	tokenType := l.getKeywordType(value)
+	value := name.String()
+	tokenType := getKeywordType(value)
 
-	if tokenType == TokenBoolean && (value == "true" || value == "false") {
+	if tokenType == TokenBoolean {
 		return Token{
 			Type:    TokenBoolean,
 			Value:   value,
@@ -277,14 +775,26 @@ func (l *Lexer) readIdentifierOrKeyword() Token {
 	}
 }
 
-func (l *Lexer) getKeywordType(value string) TokenType {
+// IsKeyword reports whether word is reserved by the language, and so cannot
+// name a variable, parameter or function
+func IsKeyword(word string) bool {
+	return getKeywordType(word) != TokenIdentifier
+}
+
+func getKeywordType(value string) TokenType {
 	switch value {
 	case "number":
 		return TokenNumberKeyword
+	case "integer":
+		return TokenIntegerKeyword
 	case "text":
 		return TokenTextKeyword
 	case "boolean":
 		return TokenBooleanKeyword
+	case "list":
+		return TokenListKeyword
+	case "let":
+		return TokenLet
 	case "function":
 		return TokenFunction
 	case "if":
@@ -301,33 +811,144 @@ func (l *Lexer) getKeywordType(value string) TokenType {
 		return TokenFrom
 	case "to":
 		return TokenTo
+	case "step":
+		return TokenStep
 	case "print":
 		return TokenPrint
+	case "switch":
+		return TokenSwitch
+	case "case":
+		return TokenCase
+	case "default":
+		return TokenDefault
+	case "for":
+		return TokenFor
+	case "in":
+		return TokenIn
+	case "return":
+		return TokenReturn
+	case "write":
+		return TokenWrite
+	case "assert":
+		return TokenAssert
+	case "try":
+		return TokenTry
+	case "catch":
+		return TokenCatch
+	case "raise":
+		return TokenRaise
+	case "enum":
+		return TokenEnum
+	case "repeat":
+		return TokenRepeat
+	case "until":
+		return TokenUntil
+	case "begin":
+		return TokenBegin
+	case "include":
+		return TokenInclude
+	case "import":
+		return TokenImport
+	case "as":
+		return TokenAs
+	case "and":
+		return TokenAnd
+	case "or":
+		return TokenOr
+	case "not":
+		return TokenNot
+	case "xor":
+		return TokenXor
+	case "nil":
+		return TokenNil
+	case "true", "false":
+		return TokenBoolean
 	default:
 		return TokenIdentifier
 	}
 }
 
 func (l *Lexer) skipWhitespace() {
-	for l.position < len(l.input) && unicode.IsSpace(l.currentChar()) {
-		if l.currentChar() == '\n' {
-			l.line++
-			l.column = 1
-		} else {
-			l.column++
-		}
+	for l.more() && unicode.IsSpace(l.currentChar()) {
 		l.advance()
 	}
 }
 
-func (l *Lexer) currentChar() rune {
-	if l.position >= len(l.input) {
-		return 0
+// diagnose turns an error token into the diagnostic Tokenize returns. The
+// problem spans from the token to where the lexer stopped, if that is
+// further along the same line.
+func (l *Lexer) diagnose(token Token) *diagnostic.Diagnostic {
+	length := 0
+	if l.line == token.Line && l.column > token.Column {
+		length = l.column - token.Column
+	}
+	return &diagnostic.Diagnostic{
+		Severity: diagnostic.Error,
+		Phase:    diagnostic.Lexical,
+		Message:  token.Value,
+		Line:     token.Line,
+		Column:   token.Column,
+		Length:   length,
 	}
-	return rune(l.input[l.position])
 }
 
-func (l *Lexer) advance() {
-	l.position++
-	l.column++
+// more reports whether any input is left to read
+func (l *Lexer) more() bool {
+	_, ok := l.lookahead(0)
+	return ok
+}
+
+// lookahead returns the byte n places past the current one without
+// consuming anything. Reaching the end of the input, or failing to read it,
+// leaves nothing to return.
+func (l *Lexer) lookahead(n int) (byte, bool) {
+	if l.err != nil {
+		return 0, false
+	}
+	bytes, err := l.input.Peek(n + 1)
+	if len(bytes) > n {
+		return bytes[n], true
+	}
+	if err != io.EOF {
+		l.err = err
+	}
+	return 0, false
+}
+
+func (l *Lexer) currentChar() rune {
+	b, _ := l.lookahead(0)
+	return rune(b)
+}
+
+// peekChar returns the character after the current one without consuming it
+func (l *Lexer) peekChar() rune {
+	b, _ := l.lookahead(1)
+	return rune(b)
+}
+
+// peekCharAt returns the character n places after the current one without
+// consuming anything
+func (l *Lexer) peekCharAt(n int) rune {
+	b, _ := l.lookahead(n)
+	return rune(b)
+}
+
+// advance consumes and returns the current byte, keeping line and column at
+// the position of the next character. Columns count characters rather than
+// bytes, so only the first byte of a multi-byte UTF-8 character moves the
+// column.
+func (l *Lexer) advance() byte {
+	b, ok := l.lookahead(0)
+	if !ok {
+		return 0
+	}
+	l.input.ReadByte()
+	switch {
+	case b == '\n':
+		l.line++
+		l.column = 1
+	case utf8.RuneStart(b):
+		l.column++
+	}
+	return b
 }
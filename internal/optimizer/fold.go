@@ -0,0 +1,313 @@
+package optimizer
+
+import (
+	"math"
+	"simplelang/internal/ast"
+	"simplelang/internal/interpreter"
+	"simplelang/internal/types"
+	"strconv"
+	"strings"
+)
+
+// Folder replaces operator expressions whose operands are all literals with
+// the literal they evaluate to, so that 2 + 3 * 4 becomes 14. Expressions
+// that would fail at runtime, such as 1 / 0, are left alone so the error
+// still happens when and where the program expects it.
+type Folder struct {
+	evaluator *interpreter.Interpreter
+	folded    int
+}
+
+// NewFolder creates a new constant folder
+func NewFolder() *Folder {
+	return &Folder{evaluator: interpreter.NewInterpreter()}
+}
+
+// Fold rewrites program in place and returns it
+func (f *Folder) Fold(program *ast.Program) *ast.Program {
+	f.folded = 0
+	program.Accept(f)
+	return program
+}
+
+// Folded returns how many expressions the last call to Fold replaced
+func (f *Folder) Folded() int {
+	return f.folded
+}
+
+// Fold folds the constant expressions of program with a new folder
+func Fold(program *ast.Program) *ast.Program {
+	return NewFolder().Fold(program)
+}
+
+func (f *Folder) expr(expr ast.Expression) ast.Expression {
+	return expr.Accept(f).(ast.Expression)
+}
+
+func (f *Folder) block(body []ast.Statement) {
+	for _, stmt := range body {
+		stmt.Accept(f)
+	}
+}
+
+// constant evaluates an expression built only from literals and returns it
+// as a literal. It reports false if evaluation fails or the result cannot be
+// written as a literal.
+func (f *Folder) constant(expr ast.Expression) (ast.Expression, bool) {
+	value, err := f.evaluator.EvaluateExpression(expr)
+	if err != nil {
+		return nil, false
+	}
+
+	literal := &ast.Literal{Position: expr.Pos(), Type: value.Type()}
+	switch v := value.(type) {
+	case types.IntValue:
+		literal.Value = strconv.FormatInt(v.Value, 10)
+	case types.NumberValue:
+		if math.IsInf(v.Value, 0) || math.IsNaN(v.Value) {
+			return nil, false
+		}
+		// Keep a decimal point so the literal still reads as a number
+		text := strconv.FormatFloat(v.Value, 'f', -1, 64)
+		if !strings.Contains(text, ".") {
+			text += ".0"
+		}
+		literal.Value = text
+	case types.TextValue:
+		literal.Value = v.Value
+	case types.CharValue:
+		literal.Value = v.Value
+	case types.BooleanValue:
+		literal.Value = v.Value
+	default:
+		return nil, false
+	}
+
+	f.folded++
+	return literal, true
+}
+
+func isLiteral(expr ast.Expression) bool {
+	_, ok := expr.(*ast.Literal)
+	return ok
+}
+
+func (f *Folder) VisitProgram(node *ast.Program) interface{} {
+	f.block(node.Statements)
+	return node
+}
+
+func (f *Folder) VisitStatement(node ast.Statement) interface{} {
+	return node.Accept(f)
+}
+
+func (f *Folder) VisitExpression(node ast.Expression) interface{} {
+	return node.Accept(f)
+}
+
+func (f *Folder) VisitVariableDeclaration(node *ast.VariableDeclaration) interface{} {
+	node.Value = f.expr(node.Value)
+	return node
+}
+
+func (f *Folder) VisitMultiVariableDeclaration(node *ast.MultiVariableDeclaration) interface{} {
+	for _, decl := range node.Declarations {
+		decl.Accept(f)
+	}
+	return node
+}
+
+func (f *Folder) VisitDestructuringDeclaration(node *ast.DestructuringDeclaration) interface{} {
+	node.Value = f.expr(node.Value)
+	return node
+}
+
+func (f *Folder) VisitAssignment(node *ast.Assignment) interface{} {
+	node.Value = f.expr(node.Value)
+	return node
+}
+
+func (f *Folder) VisitIfStatement(node *ast.IfStatement) interface{} {
+	node.Condition = f.expr(node.Condition)
+	f.block(node.ThenBody)
+	f.block(node.ElseBody)
+	return node
+}
+
+func (f *Folder) VisitLoopStatement(node *ast.LoopStatement) interface{} {
+	node.From = f.expr(node.From)
+	node.To = f.expr(node.To)
+	if node.Step != nil {
+		node.Step = f.expr(node.Step)
+	}
+	f.block(node.Body)
+	return node
+}
+
+func (f *Folder) VisitSwitchStatement(node *ast.SwitchStatement) interface{} {
+	node.Subject = f.expr(node.Subject)
+	for i := range node.Cases {
+		node.Cases[i].Value = f.expr(node.Cases[i].Value)
+		f.block(node.Cases[i].Body)
+	}
+	f.block(node.Default)
+	return node
+}
+
+func (f *Folder) VisitForEachStatement(node *ast.ForEachStatement) interface{} {
+	node.Iterable = f.expr(node.Iterable)
+	f.block(node.Body)
+	return node
+}
+
+func (f *Folder) VisitRepeatStatement(node *ast.RepeatStatement) interface{} {
+	f.block(node.Body)
+	node.Condition = f.expr(node.Condition)
+	return node
+}
+
+func (f *Folder) VisitTryStatement(node *ast.TryStatement) interface{} {
+	f.block(node.Body)
+	f.block(node.CatchBody)
+	return node
+}
+
+func (f *Folder) VisitBlockStatement(node *ast.BlockStatement) interface{} {
+	f.block(node.Body)
+	return node
+}
+
+func (f *Folder) VisitFunctionDeclaration(node *ast.FunctionDeclaration) interface{} {
+	for i, param := range node.Parameters {
+		if param.Default != nil {
+			node.Parameters[i].Default = f.expr(param.Default)
+		}
+	}
+	f.block(node.Body)
+	return node
+}
+
+func (f *Folder) VisitReturnStatement(node *ast.ReturnStatement) interface{} {
+	if node.Value != nil {
+		node.Value = f.expr(node.Value)
+	}
+	return node
+}
+
+func (f *Folder) VisitFunctionCall(node *ast.FunctionCall) interface{} {
+	if node.Callee != nil {
+		node.Callee = f.expr(node.Callee)
+	}
+	for i, arg := range node.Arguments {
+		node.Arguments[i] = f.expr(arg)
+	}
+	return node
+}
+
+func (f *Folder) VisitFunctionLiteral(node *ast.FunctionLiteral) interface{} {
+	f.VisitFunctionDeclaration(node.Function)
+	return node
+}
+
+func (f *Folder) VisitPrintStatement(node *ast.PrintStatement) interface{} {
+	node.Value = f.expr(node.Value)
+	return node
+}
+
+func (f *Folder) VisitAssertStatement(node *ast.AssertStatement) interface{} {
+	node.Condition = f.expr(node.Condition)
+	if node.Message != nil {
+		node.Message = f.expr(node.Message)
+	}
+	return node
+}
+
+func (f *Folder) VisitRaiseStatement(node *ast.RaiseStatement) interface{} {
+	node.Value = f.expr(node.Value)
+	return node
+}
+
+func (f *Folder) VisitEnumDeclaration(node *ast.EnumDeclaration) interface{} {
+	return node
+}
+
+func (f *Folder) VisitIncludeStatement(node *ast.IncludeStatement) interface{} {
+	return node
+}
+
+func (f *Folder) VisitImportStatement(node *ast.ImportStatement) interface{} {
+	return node
+}
+
+func (f *Folder) VisitExpressionStatement(node *ast.ExpressionStatement) interface{} {
+	node.Expression = f.expr(node.Expression)
+	return node
+}
+
+func (f *Folder) VisitBinaryExpression(node *ast.BinaryExpression) interface{} {
+	node.Left = f.expr(node.Left)
+	node.Right = f.expr(node.Right)
+	if isLiteral(node.Left) && isLiteral(node.Right) {
+		if literal, ok := f.constant(node); ok {
+			return literal
+		}
+	}
+	return node
+}
+
+func (f *Folder) VisitUnaryExpression(node *ast.UnaryExpression) interface{} {
+	node.Operand = f.expr(node.Operand)
+	if isLiteral(node.Operand) {
+		if literal, ok := f.constant(node); ok {
+			return literal
+		}
+	}
+	return node
+}
+
+func (f *Folder) VisitLiteral(node *ast.Literal) interface{} {
+	return node
+}
+
+func (f *Folder) VisitListLiteral(node *ast.ListLiteral) interface{} {
+	for i, element := range node.Elements {
+		node.Elements[i] = f.expr(element)
+	}
+	return node
+}
+
+func (f *Folder) VisitIndexExpression(node *ast.IndexExpression) interface{} {
+	node.Target = f.expr(node.Target)
+	node.Index = f.expr(node.Index)
+	if isLiteral(node.Target) && isLiteral(node.Index) {
+		if literal, ok := f.constant(node); ok {
+			return literal
+		}
+	}
+	return node
+}
+
+// VisitSliceExpression folds a slice of literal text whose bounds are
+// literals or left out
+func (f *Folder) VisitSliceExpression(node *ast.SliceExpression) interface{} {
+	node.Target = f.expr(node.Target)
+	constant := isLiteral(node.Target)
+	if node.Start != nil {
+		node.Start = f.expr(node.Start)
+		constant = constant && isLiteral(node.Start)
+	}
+	if node.End != nil {
+		node.End = f.expr(node.End)
+		constant = constant && isLiteral(node.End)
+	}
+	if constant {
+		if literal, ok := f.constant(node); ok {
+			return literal
+		}
+	}
+	return node
+}
+
+func (f *Folder) VisitIdentifier(node *ast.Identifier) interface{} {
+	return node
+}
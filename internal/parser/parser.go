@@ -3,14 +3,29 @@ package parser
 import (
 	"fmt"
 	"simplelang/internal/ast"
+	"simplelang/internal/diagnostic"
 	"simplelang/internal/lexer"
 	"simplelang/internal/types"
+	"strings"
 )
 
 // Parser converts tokens into an AST
 type Parser struct {
 	tokens []lexer.Token
 	pos    int
+	// functionDepth counts the function bodies being parsed, to reject a
+	// 'return' outside of any
+	functionDepth int
+	// statementDepth counts the statements being parsed, one for a statement
+	// at the top level of the program, to reject an 'include' or 'import'
+	// inside a block
+	statementDepth int
+	// modules holds the aliases of the modules imported so far, after which
+	// alias.name(...) calls into the module rather than being a method call
+	modules map[string]bool
+	// names holds the positions of keywords rejected as names, which
+	// synchronize passes over rather than taking them for the keyword
+	names map[int]bool
 }
 
 // NewParser creates a new parser
@@ -21,59 +36,322 @@ func NewParser(tokens []lexer.Token) *Parser {
 	}
 }
 
-// Parse parses the tokens and returns an AST
+// DeclareModule tells the parser that alias names a module imported by
+// source parsed earlier, such as a previous input at the REPL prompt, so that
+// alias.name(...) calls the module's function
+func (p *Parser) DeclareModule(alias string) {
+	if p.modules == nil {
+		p.modules = make(map[string]bool)
+	}
+	p.modules[alias] = true
+}
+
+// Error is a syntax error at a position in the source, a diagnostic of the
+// parse phase
+type Error = diagnostic.Diagnostic
+
+// ErrorList holds every syntax error found in a program, in source order
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	messages := make([]string, len(l))
+	for i, err := range l {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Parse parses the tokens and returns an AST. A statement that fails to parse
+// is skipped and parsing resumes at the next one, so that a single run
+// reports every syntax error; they are returned together as an ErrorList
+// alongside the statements that did parse.
 func (p *Parser) Parse() (*ast.Program, error) {
 	program := &ast.Program{}
+	var errs ErrorList
 
 	for p.current().Type != lexer.TokenEOF {
+		start := p.pos
 		stmt, err := p.parseStatement()
 		if err != nil {
-			return nil, err
+			errs = append(errs, err.(*Error))
+			p.synchronize(start)
+			continue
 		}
 		program.Statements = append(program.Statements, stmt)
 	}
 
+	if len(errs) > 0 {
+		return program, errs
+	}
 	return program, nil
 }
 
+// ParseExpression parses tokens that hold a single expression rather than a
+// program, such as a formula supplied to a host application. Any token left
+// over after the expression is an error.
+func ParseExpression(tokens []lexer.Token) (ast.Expression, error) {
+	p := NewParser(tokens)
+	expr, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	if p.current().Type != lexer.TokenEOF {
+		return nil, p.errorf("unexpected token after expression: %s", p.current().Value)
+	}
+	return expr, nil
+}
+
+// synchronize skips the rest of a statement that failed to parse, which
+// began at token index start. Any block the statement opened is skipped
+// through its matching 'end' or 'until'; otherwise parsing resumes at the
+// next token that can begin a statement.
+func (p *Parser) synchronize(start int) {
+	depth := 0
+	for i := start; i < len(p.tokens); i++ {
+		token := p.tokens[i]
+		if p.names[i] {
+			continue
+		}
+		if i > start && i >= p.pos && depth <= 0 && isStatementStart(token.Type) {
+			p.pos = i
+			return
+		}
+
+		switch {
+		case opensBlock(token.Type):
+			depth++
+		case token.Type == lexer.TokenEnd:
+			depth--
+			if depth <= 0 && i >= p.pos {
+				p.pos = i + 1
+				return
+			}
+		case token.Type == lexer.TokenUntil:
+			// The condition after 'until' still belongs to the statement, so
+			// resume at whatever starts the next one
+			depth--
+		case token.Type == lexer.TokenEOF:
+			p.pos = i
+			return
+		}
+	}
+	p.pos = len(p.tokens)
+}
+
+// errorf creates a syntax error spanning the current token
+func (p *Parser) errorf(format string, args ...interface{}) *Error {
+	token := p.current()
+	length := 0
+	if token.EndLine == token.Line {
+		length = token.EndColumn - token.Column
+	}
+	return &Error{
+		Severity: diagnostic.Error,
+		Phase:    diagnostic.Parse,
+		Message:  fmt.Sprintf(format, args...),
+		Line:     token.Line,
+		Column:   token.Column,
+		Length:   length,
+	}
+}
+
+// expectEnd checks for the 'end' that closes the block opener started,
+// naming the opening line when the file runs out first, since the point
+// where the error is found is then far from the mistake
+func (p *Parser) expectEnd(opener lexer.Token, context string) error {
+	switch p.current().Type {
+	case lexer.TokenEnd:
+		return nil
+	case lexer.TokenEOF:
+		return p.errorf("unexpected end of file; expected 'end' to close the %s started at line %d", opener.Value, opener.Line)
+	}
+	return p.errorf("expected 'end' %s, got %s", context, describe(p.current()))
+}
+
+// describe names a token in an error message: its text, or "end of input"
+// for the end of the source, which has none
+func describe(token lexer.Token) string {
+	if token.Type == lexer.TokenEOF {
+		return "end of input"
+	}
+	return token.Value
+}
+
+// expectName checks that the current token can name what is being declared,
+// described by expected. A reserved keyword is reported as such, rather than
+// left to fail more confusingly as the keyword it is.
+func (p *Parser) expectName(expected string) error {
+	token := p.current()
+	switch {
+	case token.Type == lexer.TokenIdentifier:
+		return nil
+	case lexer.IsKeyword(token.Value):
+		if p.names == nil {
+			p.names = make(map[int]bool)
+		}
+		p.names[p.pos] = true
+		return p.errorf("'%s' is a reserved keyword and cannot be used as a name", token.Value)
+	}
+	return p.errorf("expected %s, got %s", expected, describe(token))
+}
+
+// parseStatement parses one statement and the boundary after it. A statement
+// ends at the end of its line, at a ';' that lets another follow on the same
+// line, or at a keyword such as 'end' or 'else' that closes the enclosing
+// block. An expression carries on over a line break while the next line
+// continues it, as with a leading '+' or 'and', but a '-', '(' or '[' that
+// starts a line begins a new statement rather than subtracting, calling or
+// indexing. A '.' that starts a line continues a chain of method calls.
 func (p *Parser) parseStatement() (ast.Statement, error) {
+	p.statementDepth++
+	defer func() { p.statementDepth-- }()
+
+	stmt, err := p.parseUnterminatedStatement()
+	if err != nil {
+		return nil, err
+	}
+
+	token := p.current()
+	switch {
+	case token.Type == lexer.TokenSemicolon:
+		p.advance()
+	case token.Type == lexer.TokenEOF || closesBlock(token.Type):
+	case !p.onNewLine():
+		return nil, p.errorf("unexpected %s after the end of a statement; put the next statement on a new line or separate them with ';'", token.Value)
+	}
+	return stmt, nil
+}
+
+func (p *Parser) parseUnterminatedStatement() (ast.Statement, error) {
 	token := p.current()
 
 	switch token.Type {
-	case lexer.TokenNumberKeyword, lexer.TokenTextKeyword, lexer.TokenBooleanKeyword:
+	case lexer.TokenNumberKeyword, lexer.TokenIntegerKeyword, lexer.TokenTextKeyword, lexer.TokenBooleanKeyword, lexer.TokenListKeyword, lexer.TokenLet:
 		return p.parseVariableDeclaration()
 	case lexer.TokenIdentifier:
 		// Look ahead to see if this is an assignment
-		if p.peek().Type == lexer.TokenAssign {
+		switch p.peek().Type {
+		case lexer.TokenAssign:
 			return p.parseAssignment()
+		case lexer.TokenIncrement, lexer.TokenDecrement:
+			return p.parseIncrement()
 		}
 		return p.parseExpressionStatement()
 	case lexer.TokenIf:
 		return p.parseIfStatement()
 	case lexer.TokenLoop:
 		return p.parseLoopStatement()
+	case lexer.TokenFor:
+		return p.parseForEachStatement()
+	case lexer.TokenRepeat:
+		return p.parseRepeatStatement()
+	case lexer.TokenSwitch:
+		return p.parseSwitchStatement()
+	case lexer.TokenTry:
+		return p.parseTryStatement()
+	case lexer.TokenBegin:
+		return p.parseBlockStatement()
 	case lexer.TokenFunction:
+		// 'function' straight followed by '(' starts an anonymous function
+		// used as an expression, such as one called on the spot
+		if p.peek().Type == lexer.TokenLeftParen {
+			return p.parseExpressionStatement()
+		}
 		return p.parseFunctionDeclaration()
-	case lexer.TokenPrint:
+	case lexer.TokenPrint, lexer.TokenWrite:
 		return p.parsePrintStatement()
+	case lexer.TokenReturn:
+		return p.parseReturnStatement()
+	case lexer.TokenAssert:
+		return p.parseAssertStatement()
+	case lexer.TokenRaise:
+		return p.parseRaiseStatement()
+	case lexer.TokenEnum:
+		return p.parseEnumDeclaration()
+	case lexer.TokenInclude:
+		return p.parseIncludeStatement()
+	case lexer.TokenImport:
+		return p.parseImportStatement()
 	default:
-		return nil, fmt.Errorf("unexpected token at line %d, column %d: %s", token.Line, token.Column, token.Value)
+		return nil, p.errorf("unexpected token: %s", describe(token))
 	}
 }
 
-func (p *Parser) parseVariableDeclaration() (*ast.VariableDeclaration, error) {
+// parseVariableDeclaration parses a declaration of one or more
+// comma-separated variables sharing a type. A declaration starting with 'let'
+// leaves the type nil to be inferred.
+func (p *Parser) parseVariableDeclaration() (ast.Statement, error) {
 	typeToken := p.current()
-	p.advance()
+	var varType types.Type
+	if typeToken.Type == lexer.TokenLet {
+		p.advance()
+	} else {
+		var err error
+		varType, err = p.parseType()
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	if p.current().Type != lexer.TokenIdentifier {
-		return nil, fmt.Errorf("expected identifier after type, got %s", p.current().Value)
+	if err := p.expectName("identifier after type"); err != nil {
+		return nil, err
+	}
+	if p.peek().Type == lexer.TokenComma {
+		return p.parseDestructuringDeclaration(typeToken, varType)
 	}
 
-	name := p.current().Value
+	first, err := p.parseDeclarator(typeToken, varType)
+	if err != nil {
+		return nil, err
+	}
+	if p.current().Type != lexer.TokenComma {
+		return first, nil
+	}
+
+	declarations := []*ast.VariableDeclaration{first}
+	for p.current().Type == lexer.TokenComma {
+		p.advance()
+
+		if err := p.expectName("identifier after ','"); err != nil {
+			return nil, err
+		}
+
+		decl, err := p.parseDeclarator(p.current(), varType)
+		if err != nil {
+			return nil, err
+		}
+		declarations = append(declarations, decl)
+	}
+
+	return &ast.MultiVariableDeclaration{
+		Position:     position(typeToken),
+		Declarations: declarations,
+	}, nil
+}
+
+// parseDestructuringDeclaration parses the 'a, b = value' part of a
+// declaration that binds several names to the elements of one value
+func (p *Parser) parseDestructuringDeclaration(startToken lexer.Token, varType types.Type) (*ast.DestructuringDeclaration, error) {
+	names := []string{p.current().Value}
 	p.advance()
 
+	for p.current().Type == lexer.TokenComma {
+		p.advance()
+
+		if err := p.expectName("identifier after ','"); err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if name == p.current().Value {
+				return nil, p.errorf("variable %s is declared twice", name)
+			}
+		}
+		names = append(names, p.current().Value)
+		p.advance()
+	}
+
 	if p.current().Type != lexer.TokenAssign {
-		return nil, fmt.Errorf("expected '=' after variable name, got %s", p.current().Value)
+		return nil, p.errorf("expected '=' after variable names, got %s", describe(p.current()))
 	}
 	p.advance()
 
@@ -82,24 +360,44 @@ func (p *Parser) parseVariableDeclaration() (*ast.VariableDeclaration, error) {
 		return nil, err
 	}
 
-	varType, err := types.TypeFromString(typeToken.Value)
+	return &ast.DestructuringDeclaration{
+		Position: position(startToken),
+		Type:     varType,
+		Names:    names,
+		Value:    value,
+	}, nil
+}
+
+// parseDeclarator parses the 'name = value' part of a declaration. The
+// declaration is reported at startToken.
+func (p *Parser) parseDeclarator(startToken lexer.Token, varType types.Type) (*ast.VariableDeclaration, error) {
+	name := p.current().Value
+	p.advance()
+
+	if p.current().Type != lexer.TokenAssign {
+		return nil, p.errorf("expected '=' after variable name, got %s", describe(p.current()))
+	}
+	p.advance()
+
+	value, err := p.parseExpression()
 	if err != nil {
 		return nil, err
 	}
 
 	return &ast.VariableDeclaration{
-		Type:  varType,
-		Name:  name,
-		Value: value,
+		Position: position(startToken),
+		Type:     varType,
+		Name:     name,
+		Value:    value,
 	}, nil
 }
 
 func (p *Parser) parseAssignment() (*ast.Assignment, error) {
-	name := p.current().Value
+	nameToken := p.current()
 	p.advance() // consume identifier
 
 	if p.current().Type != lexer.TokenAssign {
-		return nil, fmt.Errorf("expected '=' after variable name, got %s", p.current().Value)
+		return nil, p.errorf("expected '=' after variable name, got %s", describe(p.current()))
 	}
 	p.advance()
 
@@ -109,12 +407,40 @@ func (p *Parser) parseAssignment() (*ast.Assignment, error) {
 	}
 
 	return &ast.Assignment{
-		Name:  name,
-		Value: value,
+		Position: position(nameToken),
+		Name:     nameToken.Value,
+		Value:    value,
+	}, nil
+}
+
+// parseIncrement parses x++ or x-- into an assignment of x + 1 or x - 1
+func (p *Parser) parseIncrement() (*ast.Assignment, error) {
+	nameToken := p.current()
+	p.advance() // consume identifier
+
+	operatorToken := p.current()
+	p.advance() // consume '++' or '--'
+
+	operator := "+"
+	if operatorToken.Type == lexer.TokenDecrement {
+		operator = "-"
+	}
+
+	return &ast.Assignment{
+		Position: position(nameToken),
+		Name:     nameToken.Value,
+		Value: &ast.BinaryExpression{
+			Position: position(operatorToken),
+			Left:     &ast.Identifier{Position: position(nameToken), Name: nameToken.Value},
+			Operator: operator,
+			Right:    &ast.Literal{Position: position(operatorToken), Value: "1", Type: types.IntType{}},
+		},
+		Shorthand: operatorToken.Value,
 	}, nil
 }
 
 func (p *Parser) parseIfStatement() (*ast.IfStatement, error) {
+	ifToken := p.current()
 	p.advance() // consume 'if'
 
 	condition, err := p.parseExpression()
@@ -123,7 +449,7 @@ func (p *Parser) parseIfStatement() (*ast.IfStatement, error) {
 	}
 
 	if p.current().Type != lexer.TokenThen {
-		return nil, fmt.Errorf("expected 'then' after condition, got %s", p.current().Value)
+		return nil, p.errorf("expected 'then' after condition, got %s", describe(p.current()))
 	}
 	p.advance()
 
@@ -148,12 +474,13 @@ func (p *Parser) parseIfStatement() (*ast.IfStatement, error) {
 		}
 	}
 
-	if p.current().Type != lexer.TokenEnd {
-		return nil, fmt.Errorf("expected 'end' after if statement, got %s", p.current().Value)
+	if err := p.expectEnd(ifToken, "after if statement"); err != nil {
+		return nil, err
 	}
 	p.advance()
 
 	return &ast.IfStatement{
+		Position:  position(ifToken),
 		Condition: condition,
 		ThenBody:  thenBody,
 		ElseBody:  elseBody,
@@ -161,17 +488,18 @@ func (p *Parser) parseIfStatement() (*ast.IfStatement, error) {
 }
 
 func (p *Parser) parseLoopStatement() (*ast.LoopStatement, error) {
+	loopToken := p.current()
 	p.advance() // consume 'loop'
 
-	if p.current().Type != lexer.TokenIdentifier {
-		return nil, fmt.Errorf("expected identifier after 'loop', got %s", p.current().Value)
+	if err := p.expectName("identifier after 'loop'"); err != nil {
+		return nil, err
 	}
 
 	variable := p.current().Value
 	p.advance()
 
 	if p.current().Type != lexer.TokenFrom {
-		return nil, fmt.Errorf("expected 'from' after loop variable, got %s", p.current().Value)
+		return nil, p.errorf("expected 'from' after loop variable, got %s", describe(p.current()))
 	}
 	p.advance()
 
@@ -181,7 +509,7 @@ func (p *Parser) parseLoopStatement() (*ast.LoopStatement, error) {
 	}
 
 	if p.current().Type != lexer.TokenTo {
-		return nil, fmt.Errorf("expected 'to' after 'from' expression, got %s", p.current().Value)
+		return nil, p.errorf("expected 'to' after 'from' expression, got %s", describe(p.current()))
 	}
 	p.advance()
 
@@ -190,6 +518,15 @@ func (p *Parser) parseLoopStatement() (*ast.LoopStatement, error) {
 		return nil, err
 	}
 
+	var stepExpr ast.Expression
+	if p.current().Type == lexer.TokenStep {
+		p.advance()
+		stepExpr, err = p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	var body []ast.Statement
 	for p.current().Type != lexer.TokenEnd && p.current().Type != lexer.TokenEOF {
 		stmt, err := p.parseStatement()
@@ -199,122 +536,663 @@ func (p *Parser) parseLoopStatement() (*ast.LoopStatement, error) {
 		body = append(body, stmt)
 	}
 
-	if p.current().Type != lexer.TokenEnd {
-		return nil, fmt.Errorf("expected 'end' after loop body, got %s", p.current().Value)
+	if err := p.expectEnd(loopToken, "after loop body"); err != nil {
+		return nil, err
 	}
 	p.advance()
 
 	return &ast.LoopStatement{
+		Position: position(loopToken),
 		Variable: variable,
 		From:     fromExpr,
 		To:       toExpr,
+		Step:     stepExpr,
 		Body:     body,
 	}, nil
 }
 
+func (p *Parser) parseForEachStatement() (*ast.ForEachStatement, error) {
+	forToken := p.current()
+	p.advance() // consume 'for'
+
+	if err := p.expectName("identifier after 'for'"); err != nil {
+		return nil, err
+	}
+
+	variable := p.current().Value
+	p.advance()
+
+	if p.current().Type != lexer.TokenIn {
+		return nil, p.errorf("expected 'in' after loop variable, got %s", describe(p.current()))
+	}
+	p.advance()
+
+	iterable, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	var body []ast.Statement
+	for p.current().Type != lexer.TokenEnd && p.current().Type != lexer.TokenEOF {
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, stmt)
+	}
+
+	if err := p.expectEnd(forToken, "after for body"); err != nil {
+		return nil, err
+	}
+	p.advance()
+
+	return &ast.ForEachStatement{
+		Position: position(forToken),
+		Variable: variable,
+		Iterable: iterable,
+		Body:     body,
+	}, nil
+}
+
+// parseRepeatStatement parses 'repeat', a body and the condition after
+// 'until' that ends it
+func (p *Parser) parseRepeatStatement() (*ast.RepeatStatement, error) {
+	repeatToken := p.current()
+	p.advance() // consume 'repeat'
+
+	var body []ast.Statement
+	for p.current().Type != lexer.TokenUntil && p.current().Type != lexer.TokenEnd && p.current().Type != lexer.TokenEOF {
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, stmt)
+	}
+
+	switch p.current().Type {
+	case lexer.TokenEOF:
+		return nil, p.errorf("unexpected end of file; expected 'until' to close the repeat started at line %d", repeatToken.Line)
+	case lexer.TokenEnd:
+		return nil, p.errorf("expected 'until' after repeat body, got end")
+	}
+	p.advance()
+
+	condition, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ast.RepeatStatement{
+		Position:  position(repeatToken),
+		Body:      body,
+		Condition: condition,
+	}, nil
+}
+
+func (p *Parser) parseSwitchStatement() (*ast.SwitchStatement, error) {
+	switchToken := p.current()
+	p.advance() // consume 'switch'
+
+	subject, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.current().Type != lexer.TokenCase && p.current().Type != lexer.TokenDefault && p.current().Type != lexer.TokenEnd {
+		return nil, p.errorf("expected 'case' after switch subject, got %s", describe(p.current()))
+	}
+
+	var cases []ast.SwitchCase
+	for p.current().Type == lexer.TokenCase {
+		p.advance() // consume 'case'
+
+		value, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+
+		var body []ast.Statement
+		for p.current().Type != lexer.TokenCase && p.current().Type != lexer.TokenDefault &&
+			p.current().Type != lexer.TokenEnd && p.current().Type != lexer.TokenEOF {
+			stmt, err := p.parseStatement()
+			if err != nil {
+				return nil, err
+			}
+			body = append(body, stmt)
+		}
+
+		cases = append(cases, ast.SwitchCase{Value: value, Body: body})
+	}
+
+	var defaultBody []ast.Statement
+	if p.current().Type == lexer.TokenDefault {
+		p.advance()
+		defaultBody = []ast.Statement{}
+		for p.current().Type != lexer.TokenEnd && p.current().Type != lexer.TokenEOF {
+			stmt, err := p.parseStatement()
+			if err != nil {
+				return nil, err
+			}
+			defaultBody = append(defaultBody, stmt)
+		}
+	}
+
+	if err := p.expectEnd(switchToken, "after switch statement"); err != nil {
+		return nil, err
+	}
+	p.advance()
+
+	return &ast.SwitchStatement{
+		Position: position(switchToken),
+		Subject:  subject,
+		Cases:    cases,
+		Default:  defaultBody,
+	}, nil
+}
+
+// parseTryStatement parses 'try', its body, then 'catch' with the name that
+// receives the error message, and the catch body
+// parseBlockStatement parses 'begin', a body and the 'end' that closes it
+func (p *Parser) parseBlockStatement() (*ast.BlockStatement, error) {
+	beginToken := p.current()
+	p.advance() // consume 'begin'
+
+	var body []ast.Statement
+	for p.current().Type != lexer.TokenEnd && p.current().Type != lexer.TokenEOF {
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, stmt)
+	}
+
+	if err := p.expectEnd(beginToken, "after block body"); err != nil {
+		return nil, err
+	}
+	p.advance()
+
+	return &ast.BlockStatement{
+		Position: position(beginToken),
+		Body:     body,
+	}, nil
+}
+
+func (p *Parser) parseTryStatement() (*ast.TryStatement, error) {
+	tryToken := p.current()
+	p.advance() // consume 'try'
+
+	var body []ast.Statement
+	for p.current().Type != lexer.TokenCatch && p.current().Type != lexer.TokenEnd && p.current().Type != lexer.TokenEOF {
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, stmt)
+	}
+
+	if p.current().Type == lexer.TokenEOF {
+		return nil, p.errorf("unexpected end of file; expected 'catch' to follow the try started at line %d", tryToken.Line)
+	}
+	if p.current().Type != lexer.TokenCatch {
+		return nil, p.errorf("expected 'catch' after try body, got %s", describe(p.current()))
+	}
+	p.advance()
+
+	if err := p.expectName("error variable after 'catch'"); err != nil {
+		return nil, err
+	}
+	variable := p.current().Value
+	p.advance()
+
+	var catchBody []ast.Statement
+	for p.current().Type != lexer.TokenEnd && p.current().Type != lexer.TokenEOF {
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		catchBody = append(catchBody, stmt)
+	}
+
+	if err := p.expectEnd(tryToken, "after catch body"); err != nil {
+		return nil, err
+	}
+	p.advance()
+
+	return &ast.TryStatement{
+		Position:  position(tryToken),
+		Body:      body,
+		Variable:  variable,
+		CatchBody: catchBody,
+	}, nil
+}
+
 func (p *Parser) parseFunctionDeclaration() (*ast.FunctionDeclaration, error) {
+	functionToken := p.current()
+	p.advance() // consume 'function'
+
+	if err := p.expectName("function name after 'function'"); err != nil {
+		return nil, err
+	}
+
+	name := p.current().Value
+	p.advance()
+
+	if p.current().Type != lexer.TokenLeftParen {
+		return nil, p.errorf("expected '(' after function name, got %s", describe(p.current()))
+	}
+	return p.parseFunction(functionToken, name)
+}
+
+// parseFunctionLiteral parses an anonymous function used as an expression:
+// 'function' directly followed by its parameter list
+func (p *Parser) parseFunctionLiteral() (*ast.FunctionLiteral, error) {
+	functionToken := p.current()
 	p.advance() // consume 'function'
 
-	if p.current().Type != lexer.TokenIdentifier {
-		return nil, fmt.Errorf("expected function name after 'function', got %s", p.current().Value)
+	function, err := p.parseFunction(functionToken, ast.AnonymousFunction)
+	if err != nil {
+		return nil, err
+	}
+	return &ast.FunctionLiteral{Position: position(functionToken), Function: function}, nil
+}
+
+// parseFunction parses the parameter list and body shared by function
+// declarations and function literals, starting at the '('
+func (p *Parser) parseFunction(functionToken lexer.Token, name string) (*ast.FunctionDeclaration, error) {
+	p.advance() // consume '('
+
+	var parameters []ast.Parameter
+	for p.current().Type != lexer.TokenRightParen {
+		if len(parameters) > 0 {
+			if p.current().Type != lexer.TokenComma {
+				return nil, p.errorf("expected ',' between parameters, got %s", describe(p.current()))
+			}
+			p.advance()
+		}
+
+		// A parameter may also take a function, written with the type name
+		// 'function'
+		if !isTypeKeyword(p.current().Type) && p.current().Type != lexer.TokenFunction {
+			return nil, p.errorf("expected parameter type, got %s", describe(p.current()))
+		}
+
+		paramType, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expectName("parameter name"); err != nil {
+			return nil, err
+		}
+
+		// Parameters with defaults may only be followed by more of them, so
+		// that leaving out trailing arguments is never ambiguous
+		param := ast.Parameter{Name: p.current().Value, Type: paramType}
+		optional := len(parameters) > 0 && parameters[len(parameters)-1].Default != nil
+		if optional && p.peek().Type != lexer.TokenAssign {
+			return nil, p.errorf("parameter %s needs a default value because the parameters before it have one", param.Name)
+		}
+		p.advance()
+
+		if p.current().Type == lexer.TokenAssign {
+			p.advance()
+			param.Default, err = p.parseExpression()
+			if err != nil {
+				return nil, err
+			}
+		}
+		parameters = append(parameters, param)
+	}
+	p.advance() // consume ')'
+
+	p.functionDepth++
+	defer func() {
+		p.functionDepth--
+	}()
+
+	var body []ast.Statement
+	for p.current().Type != lexer.TokenEnd && p.current().Type != lexer.TokenEOF {
+		stmt, err := p.parseStatement()
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, stmt)
+	}
+
+	if err := p.expectEnd(functionToken, "after function body"); err != nil {
+		return nil, err
+	}
+	p.advance()
+
+	return &ast.FunctionDeclaration{
+		Position:   position(functionToken),
+		Name:       name,
+		Parameters: parameters,
+		ReturnType: types.VoidType{},
+		Body:       body,
+	}, nil
+}
+
+// parseReturnStatement parses 'return', followed by the returned value when
+// one starts on the same line
+func (p *Parser) parseReturnStatement() (*ast.ReturnStatement, error) {
+	returnToken := p.current()
+	if p.functionDepth == 0 {
+		return nil, p.errorf("'return' outside of a function")
+	}
+	p.advance() // consume 'return'
+
+	stmt := &ast.ReturnStatement{Position: position(returnToken)}
+	if p.current().Line == returnToken.Line && startsExpression(p.current().Type) {
+		value, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		stmt.Value = value
+
+		if p.current().Type == lexer.TokenComma {
+			values := &ast.ListLiteral{Position: value.Pos(), Elements: []ast.Expression{value}}
+			for p.current().Type == lexer.TokenComma {
+				p.advance()
+				value, err := p.parseExpression()
+				if err != nil {
+					return nil, err
+				}
+				values.Elements = append(values.Elements, value)
+			}
+			stmt.Value = values
+			stmt.Multiple = true
+		}
+	}
+	return stmt, nil
+}
+
+// parsePrintStatement parses 'print', or 'write' which shares its syntax
+func (p *Parser) parsePrintStatement() (*ast.PrintStatement, error) {
+	printToken := p.current()
+	p.advance() // consume 'print' or 'write'
+
+	value, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ast.PrintStatement{
+		Position: position(printToken),
+		Value:    value,
+		Write:    printToken.Type == lexer.TokenWrite,
+	}, nil
+}
+
+// parseAssertStatement parses 'assert' followed by a condition and,
+// optionally, a comma and a message
+func (p *Parser) parseAssertStatement() (*ast.AssertStatement, error) {
+	assertToken := p.current()
+	p.advance() // consume 'assert'
+
+	condition, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := &ast.AssertStatement{Position: position(assertToken), Condition: condition}
+	if p.current().Type == lexer.TokenComma {
+		p.advance()
+		stmt.Message, err = p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stmt, nil
+}
+
+// parseRaiseStatement parses 'raise' followed by the error message
+func (p *Parser) parseRaiseStatement() (*ast.RaiseStatement, error) {
+	raiseToken := p.current()
+	p.advance() // consume 'raise'
+
+	value, err := p.parseExpression()
+	if err != nil {
+		return nil, err
+	}
+	return &ast.RaiseStatement{Position: position(raiseToken), Value: value}, nil
+}
+
+// parseIncludeStatement parses 'include' and the text naming the included
+// file. An include is only allowed at the top level, since the file is run
+// once however often the include is reached.
+func (p *Parser) parseIncludeStatement() (*ast.IncludeStatement, error) {
+	includeToken := p.current()
+	if p.statementDepth > 1 {
+		return nil, p.errorf("'include' is only allowed at the top level of a program")
+	}
+	p.advance() // consume 'include'
+
+	if p.current().Type != lexer.TokenText {
+		return nil, p.errorf("expected file name text after 'include', got %s", describe(p.current()))
+	}
+	path := p.current().Literal.(string)
+	p.advance()
+	return &ast.IncludeStatement{Position: position(includeToken), Path: path}, nil
+}
+
+// parseImportStatement parses 'import', the text naming the imported file,
+// 'as' and the alias the module is reached through. Like an include, an
+// import is only allowed at the top level.
+func (p *Parser) parseImportStatement() (*ast.ImportStatement, error) {
+	importToken := p.current()
+	if p.statementDepth > 1 {
+		return nil, p.errorf("'import' is only allowed at the top level of a program")
+	}
+	p.advance() // consume 'import'
+
+	if p.current().Type != lexer.TokenText {
+		return nil, p.errorf("expected file name text after 'import', got %s", describe(p.current()))
+	}
+	path := p.current().Literal.(string)
+	p.advance()
+
+	if p.current().Type != lexer.TokenAs {
+		return nil, p.errorf("expected 'as' and a module name after the imported file, got %s", describe(p.current()))
+	}
+	p.advance() // consume 'as'
+	if err := p.expectName("module name after 'as'"); err != nil {
+		return nil, err
+	}
+	alias := p.current().Value
+	p.advance()
+
+	p.DeclareModule(alias)
+	return &ast.ImportStatement{Position: position(importToken), Path: path, Alias: alias}, nil
+}
+
+// parseEnumDeclaration parses 'enum', the enum's name and the names of its
+// members up to 'end'. The members may share a line or take one each.
+func (p *Parser) parseEnumDeclaration() (*ast.EnumDeclaration, error) {
+	enumToken := p.current()
+	p.advance() // consume 'enum'
+
+	if err := p.expectName("enum name after 'enum'"); err != nil {
+		return nil, err
+	}
+	name := p.current().Value
+	p.advance()
+
+	var members []string
+	seen := make(map[string]bool)
+	for p.current().Type != lexer.TokenEnd && p.current().Type != lexer.TokenEOF {
+		if err := p.expectName("member name in enum " + name); err != nil {
+			return nil, err
+		}
+		member := p.current().Value
+		if seen[member] {
+			return nil, p.errorf("duplicate member %s in enum %s", member, name)
+		}
+		seen[member] = true
+		members = append(members, member)
+		p.advance()
+	}
+
+	if err := p.expectEnd(enumToken, "after enum members"); err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, p.errorf("enum %s has no members", name)
+	}
+	p.advance()
+
+	return &ast.EnumDeclaration{Position: position(enumToken), Name: name, Members: members}, nil
+}
+
+// parseExpression parses an expression. Operators bind as follows, loosest
+// first; all binary operators are left associative except '^':
+//
+//	or                  left
+//	and                 left
+//	== !=               left
+//	< <= > >=           left
+//	|                   left
+//	xor                 left
+//	&                   left
+//	<< >>               left
+//	+ -                 left
+//	* / // %            left
+//	- ! not  (prefix)   -2 ^ 2 is -(2 ^ 2)
+//	^                   right: 2 ^ 3 ^ 2 is 2 ^ (3 ^ 2)
+//	f(x)  (call)        f(1)(2) calls the result of f(1)
+//
+// The exponent of '^' may itself carry a prefix operator, as in 2 ^ -1.
+func (p *Parser) parseExpression() (ast.Expression, error) {
+	return p.parseLogicalOr()
+}
+
+func (p *Parser) parseLogicalOr() (ast.Expression, error) {
+	left, err := p.parseLogicalAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.current().Type == lexer.TokenOr {
+		operatorToken := p.current()
+		p.advance()
+
+		right, err := p.parseLogicalAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &ast.BinaryExpression{
+			Position: position(operatorToken),
+			Left:     left,
+			Operator: operatorToken.Value,
+			Right:    right,
+		}
 	}
 
-	name := p.current().Value
-	p.advance()
+	return left, nil
+}
 
-	if p.current().Type != lexer.TokenLeftParen {
-		return nil, fmt.Errorf("expected '(' after function name, got %s", p.current().Value)
+func (p *Parser) parseLogicalAnd() (ast.Expression, error) {
+	left, err := p.parseEquality()
+	if err != nil {
+		return nil, err
 	}
-	p.advance()
-
-	var parameters []ast.Parameter
-	for p.current().Type != lexer.TokenRightParen {
-		if len(parameters) > 0 {
-			if p.current().Type != lexer.TokenComma {
-				return nil, fmt.Errorf("expected ',' between parameters, got %s", p.current().Value)
-			}
-			p.advance()
-		}
 
-		if p.current().Type != lexer.TokenNumberKeyword && p.current().Type != lexer.TokenTextKeyword && p.current().Type != lexer.TokenBooleanKeyword {
-			return nil, fmt.Errorf("expected parameter type, got %s", p.current().Value)
-		}
+	for p.current().Type == lexer.TokenAnd {
+		operatorToken := p.current()
+		p.advance()
 
-		paramType, err := types.TypeFromString(p.current().Value)
+		right, err := p.parseEquality()
 		if err != nil {
 			return nil, err
 		}
-		p.advance()
 
-		if p.current().Type != lexer.TokenIdentifier {
-			return nil, fmt.Errorf("expected parameter name, got %s", p.current().Value)
+		left = &ast.BinaryExpression{
+			Position: position(operatorToken),
+			Left:     left,
+			Operator: operatorToken.Value,
+			Right:    right,
 		}
+	}
 
-		parameters = append(parameters, ast.Parameter{
-			Name: p.current().Value,
-			Type: paramType,
-		})
-		p.advance()
+	return left, nil
+}
+
+func (p *Parser) parseEquality() (ast.Expression, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
 	}
-	p.advance() // consume ')'
 
-	var body []ast.Statement
-	for p.current().Type != lexer.TokenEnd && p.current().Type != lexer.TokenEOF {
-		stmt, err := p.parseStatement()
+	for p.current().Type == lexer.TokenEqual || p.current().Type == lexer.TokenNotEqual {
+		operatorToken := p.current()
+		p.advance()
+
+		right, err := p.parseComparison()
 		if err != nil {
 			return nil, err
 		}
-		body = append(body, stmt)
-	}
 
-	if p.current().Type != lexer.TokenEnd {
-		return nil, fmt.Errorf("expected 'end' after function body, got %s", p.current().Value)
+		left = &ast.BinaryExpression{
+			Position: position(operatorToken),
+			Left:     left,
+			Operator: operatorToken.Value,
+			Right:    right,
+		}
 	}
-	p.advance()
 
-	return &ast.FunctionDeclaration{
-		Name:       name,
-		Parameters: parameters,
-		ReturnType: types.VoidType{},
-		Body:       body,
-	}, nil
+	return left, nil
 }
 
-func (p *Parser) parsePrintStatement() (*ast.PrintStatement, error) {
-	p.advance() // consume 'print'
-
-	value, err := p.parseExpression()
+func (p *Parser) parseComparison() (ast.Expression, error) {
+	left, err := p.parseBitwiseOr()
 	if err != nil {
 		return nil, err
 	}
 
-	return &ast.PrintStatement{
-		Value: value,
-	}, nil
-}
+	for p.current().Type == lexer.TokenLessThan || p.current().Type == lexer.TokenLessEqual ||
+		p.current().Type == lexer.TokenGreaterThan || p.current().Type == lexer.TokenGreaterEqual {
+		operatorToken := p.current()
+		p.advance()
 
-func (p *Parser) parseExpression() (ast.Expression, error) {
-	return p.parseLogicalOr()
+		right, err := p.parseBitwiseOr()
+		if err != nil {
+			return nil, err
+		}
+
+		left = &ast.BinaryExpression{
+			Position: position(operatorToken),
+			Left:     left,
+			Operator: operatorToken.Value,
+			Right:    right,
+		}
+	}
+
+	return left, nil
 }
 
-func (p *Parser) parseLogicalOr() (ast.Expression, error) {
-	left, err := p.parseLogicalAnd()
+// The bitwise operators sit between comparison and arithmetic, loosest
+// first: '|', 'xor', '&', then the shifts. So 1 + 1 << 2 shifts 2, and
+// flags & 4 == 4 compares the masked value.
+func (p *Parser) parseBitwiseOr() (ast.Expression, error) {
+	left, err := p.parseBitwiseXor()
 	if err != nil {
 		return nil, err
 	}
 
-	for p.current().Type == lexer.TokenOr {
-		operator := p.current().Value
+	for p.current().Type == lexer.TokenBitOr {
+		operatorToken := p.current()
 		p.advance()
 
-		right, err := p.parseLogicalAnd()
+		right, err := p.parseBitwiseXor()
 		if err != nil {
 			return nil, err
 		}
 
 		left = &ast.BinaryExpression{
+			Position: position(operatorToken),
 			Left:     left,
-			Operator: operator,
+			Operator: operatorToken.Value,
 			Right:    right,
 		}
 	}
@@ -322,24 +1200,25 @@ func (p *Parser) parseLogicalOr() (ast.Expression, error) {
 	return left, nil
 }
 
-func (p *Parser) parseLogicalAnd() (ast.Expression, error) {
-	left, err := p.parseEquality()
+func (p *Parser) parseBitwiseXor() (ast.Expression, error) {
+	left, err := p.parseBitwiseAnd()
 	if err != nil {
 		return nil, err
 	}
 
-	for p.current().Type == lexer.TokenAnd {
-		operator := p.current().Value
+	for p.current().Type == lexer.TokenXor {
+		operatorToken := p.current()
 		p.advance()
 
-		right, err := p.parseEquality()
+		right, err := p.parseBitwiseAnd()
 		if err != nil {
 			return nil, err
 		}
 
 		left = &ast.BinaryExpression{
+			Position: position(operatorToken),
 			Left:     left,
-			Operator: operator,
+			Operator: operatorToken.Value,
 			Right:    right,
 		}
 	}
@@ -347,24 +1226,25 @@ func (p *Parser) parseLogicalAnd() (ast.Expression, error) {
 	return left, nil
 }
 
-func (p *Parser) parseEquality() (ast.Expression, error) {
-	left, err := p.parseComparison()
+func (p *Parser) parseBitwiseAnd() (ast.Expression, error) {
+	left, err := p.parseShift()
 	if err != nil {
 		return nil, err
 	}
 
-	for p.current().Type == lexer.TokenEqual || p.current().Type == lexer.TokenNotEqual {
-		operator := p.current().Value
+	for p.current().Type == lexer.TokenBitAnd {
+		operatorToken := p.current()
 		p.advance()
 
-		right, err := p.parseComparison()
+		right, err := p.parseShift()
 		if err != nil {
 			return nil, err
 		}
 
 		left = &ast.BinaryExpression{
+			Position: position(operatorToken),
 			Left:     left,
-			Operator: operator,
+			Operator: operatorToken.Value,
 			Right:    right,
 		}
 	}
@@ -372,15 +1252,14 @@ func (p *Parser) parseEquality() (ast.Expression, error) {
 	return left, nil
 }
 
-func (p *Parser) parseComparison() (ast.Expression, error) {
+func (p *Parser) parseShift() (ast.Expression, error) {
 	left, err := p.parseTerm()
 	if err != nil {
 		return nil, err
 	}
 
-	for p.current().Type == lexer.TokenLessThan || p.current().Type == lexer.TokenLessEqual ||
-		p.current().Type == lexer.TokenGreaterThan || p.current().Type == lexer.TokenGreaterEqual {
-		operator := p.current().Value
+	for p.current().Type == lexer.TokenShiftLeft || p.current().Type == lexer.TokenShiftRight {
+		operatorToken := p.current()
 		p.advance()
 
 		right, err := p.parseTerm()
@@ -389,8 +1268,9 @@ func (p *Parser) parseComparison() (ast.Expression, error) {
 		}
 
 		left = &ast.BinaryExpression{
+			Position: position(operatorToken),
 			Left:     left,
-			Operator: operator,
+			Operator: operatorToken.Value,
 			Right:    right,
 		}
 	}
@@ -404,8 +1284,8 @@ func (p *Parser) parseTerm() (ast.Expression, error) {
 		return nil, err
 	}
 
-	for p.current().Type == lexer.TokenPlus || p.current().Type == lexer.TokenMinus {
-		operator := p.current().Value
+	for p.current().Type == lexer.TokenPlus || (p.current().Type == lexer.TokenMinus && !p.onNewLine()) {
+		operatorToken := p.current()
 		p.advance()
 
 		right, err := p.parseFactor()
@@ -414,8 +1294,9 @@ func (p *Parser) parseTerm() (ast.Expression, error) {
 		}
 
 		left = &ast.BinaryExpression{
+			Position: position(operatorToken),
 			Left:     left,
-			Operator: operator,
+			Operator: operatorToken.Value,
 			Right:    right,
 		}
 	}
@@ -429,8 +1310,9 @@ func (p *Parser) parseFactor() (ast.Expression, error) {
 		return nil, err
 	}
 
-	for p.current().Type == lexer.TokenMultiply || p.current().Type == lexer.TokenDivide {
-		operator := p.current().Value
+	for p.current().Type == lexer.TokenMultiply || p.current().Type == lexer.TokenDivide ||
+		p.current().Type == lexer.TokenIntDivide || p.current().Type == lexer.TokenModulo {
+		operatorToken := p.current()
 		p.advance()
 
 		right, err := p.parseUnary()
@@ -439,8 +1321,9 @@ func (p *Parser) parseFactor() (ast.Expression, error) {
 		}
 
 		left = &ast.BinaryExpression{
+			Position: position(operatorToken),
 			Left:     left,
-			Operator: operator,
+			Operator: operatorToken.Value,
 			Right:    right,
 		}
 	}
@@ -448,9 +1331,13 @@ func (p *Parser) parseFactor() (ast.Expression, error) {
 	return left, nil
 }
 
+// minIntMagnitude is the integer literal that is only in range negated, as
+// -9223372036854775808. Anywhere else it is too large for an integer.
+const minIntMagnitude = "9223372036854775808"
+
 func (p *Parser) parseUnary() (ast.Expression, error) {
 	if p.current().Type == lexer.TokenMinus || p.current().Type == lexer.TokenNot {
-		operator := p.current().Value
+		operatorToken := p.current()
 		p.advance()
 
 		operand, err := p.parseUnary()
@@ -458,13 +1345,167 @@ func (p *Parser) parseUnary() (ast.Expression, error) {
 			return nil, err
 		}
 
+		// The smallest integer is written as the negation of a literal one
+		// past the largest, which is only an integer once negated
+		if literal, ok := operand.(*ast.Literal); ok && operatorToken.Type == lexer.TokenMinus && literal.Value == minIntMagnitude {
+			if _, isInt := literal.Type.(types.IntType); isInt {
+				return &ast.Literal{Position: position(operatorToken), Value: "-" + minIntMagnitude, Type: types.IntType{}}, nil
+			}
+		}
+
 		return &ast.UnaryExpression{
-			Operator: operator,
+			Position: position(operatorToken),
+			Operator: operatorToken.Value,
 			Operand:  operand,
 		}, nil
 	}
 
-	return p.parsePrimary()
+	return p.parsePower()
+}
+
+// parsePower parses exponentiation. The exponent is parsed as a unary
+// expression, which makes '^' right associative.
+func (p *Parser) parsePower() (ast.Expression, error) {
+	base, err := p.parseCall()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.current().Type != lexer.TokenPower {
+		return base, nil
+	}
+	operatorToken := p.current()
+	p.advance()
+
+	exponent, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	return &ast.BinaryExpression{
+		Position: position(operatorToken),
+		Left:     base,
+		Operator: operatorToken.Value,
+		Right:    exponent,
+	}, nil
+}
+
+// parseCall parses a primary expression followed by any number of suffixes:
+// argument lists, each calling the function the expression before it
+// produced, and brackets that index or slice it
+func (p *Parser) parseCall() (ast.Expression, error) {
+	expr, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	// A method call may start the next line, so that a chain of them can be
+	// split one call to a line
+	for !p.onNewLine() || p.current().Type == lexer.TokenDot {
+		switch p.current().Type {
+		case lexer.TokenDot:
+			expr, err = p.parseMethodCall(expr)
+			if err != nil {
+				return nil, err
+			}
+		case lexer.TokenLeftParen:
+			arguments, err := p.parseArguments()
+			if err != nil {
+				return nil, err
+			}
+			expr = &ast.FunctionCall{
+				Position:  expr.Pos(),
+				Callee:    expr,
+				Arguments: arguments,
+			}
+		case lexer.TokenLeftBracket:
+			expr, err = p.parseIndex(expr)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			return expr, nil
+		}
+	}
+	return expr, nil
+}
+
+// parseMethodCall parses '.', a name and its arguments after receiver. The
+// call is sugar for the function of that name with receiver as its first
+// argument, so s.upper() becomes upper(s), unless receiver is the alias of an
+// imported module, whose function m.add(1) calls. A name without arguments
+// after a plain name, as in Color.red or m.total, reads the enum member or
+// the module's variable declared under both.
+func (p *Parser) parseMethodCall(receiver ast.Expression) (ast.Expression, error) {
+	p.advance() // consume '.'
+
+	nameToken := p.current()
+	if nameToken.Type != lexer.TokenIdentifier {
+		return nil, p.errorf("expected method name after '.', got %s", describe(nameToken))
+	}
+	p.advance()
+	if enum, ok := receiver.(*ast.Identifier); ok && p.current().Type != lexer.TokenLeftParen {
+		return &ast.Identifier{Position: enum.Position, Name: ast.MemberName(enum.Name, nameToken.Value)}, nil
+	}
+	if p.current().Type != lexer.TokenLeftParen {
+		return nil, p.errorf("expected '(' after method name %s, got %s", nameToken.Value, describe(p.current()))
+	}
+
+	arguments, err := p.parseArguments()
+	if err != nil {
+		return nil, err
+	}
+	if module, ok := receiver.(*ast.Identifier); ok && p.modules[module.Name] {
+		return &ast.FunctionCall{
+			Position:  module.Position,
+			Name:      ast.MemberName(module.Name, nameToken.Value),
+			Arguments: arguments,
+		}, nil
+	}
+	return &ast.FunctionCall{
+		Position:  position(nameToken),
+		Name:      nameToken.Value,
+		Arguments: append([]ast.Expression{receiver}, arguments...),
+		Method:    true,
+	}, nil
+}
+
+// parseIndex parses the brackets after target, holding either an index or a
+// slice whose bounds on either side of the ':' may be left out
+func (p *Parser) parseIndex(target ast.Expression) (ast.Expression, error) {
+	bracket := p.current()
+	p.advance() // consume '['
+
+	var start ast.Expression
+	if p.current().Type != lexer.TokenColon {
+		index, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if p.current().Type == lexer.TokenRightBracket {
+			p.advance()
+			return &ast.IndexExpression{Position: position(bracket), Target: target, Index: index}, nil
+		}
+		if p.current().Type != lexer.TokenColon {
+			return nil, p.errorf("expected ']' or ':' after index, got %s", describe(p.current()))
+		}
+		start = index
+	}
+	p.advance() // consume ':'
+
+	slice := &ast.SliceExpression{Position: position(bracket), Target: target, Start: start}
+	if p.current().Type != lexer.TokenRightBracket {
+		end, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		slice.End = end
+	}
+	if p.current().Type != lexer.TokenRightBracket {
+		return nil, p.errorf("expected ']' after slice, got %s", describe(p.current()))
+	}
+	p.advance()
+	return slice, nil
 }
 
 func (p *Parser) parsePrimary() (ast.Expression, error) {
@@ -474,34 +1515,69 @@ func (p *Parser) parsePrimary() (ast.Expression, error) {
 	case lexer.TokenNumber:
 		p.advance()
 		return &ast.Literal{
-			Value: token.Literal,
-			Type:  types.NumberType{},
+			Position: position(token),
+			Value:    token.Literal,
+			Type:     types.NumberType{},
+		}, nil
+
+	case lexer.TokenInteger:
+		p.advance()
+		return &ast.Literal{
+			Position: position(token),
+			Value:    token.Literal,
+			Type:     types.IntType{},
 		}, nil
 
 	case lexer.TokenText:
 		p.advance()
 		return &ast.Literal{
-			Value: token.Literal,
-			Type:  types.TextType{},
+			Position: position(token),
+			Value:    token.Literal,
+			Type:     types.TextType{},
+		}, nil
+
+	case lexer.TokenChar:
+		p.advance()
+		return &ast.Literal{
+			Position: position(token),
+			Value:    token.Literal,
+			Type:     types.CharType{},
 		}, nil
 
 	case lexer.TokenBoolean:
 		p.advance()
 		return &ast.Literal{
-			Value: token.Literal,
-			Type:  types.BooleanType{},
+			Position: position(token),
+			Value:    token.Literal,
+			Type:     types.BooleanType{},
+		}, nil
+
+	case lexer.TokenNil:
+		p.advance()
+		return &ast.Literal{
+			Position: position(token),
+			Value:    token.Value,
+			Type:     types.NilType{},
 		}, nil
 
 	case lexer.TokenIdentifier:
-		name := token.Value
 		p.advance()
 
 		// Check if this is a function call
-		if p.current().Type == lexer.TokenLeftParen {
-			return p.parseFunctionCall(name)
+		if p.current().Type == lexer.TokenLeftParen && !p.onNewLine() {
+			return p.parseFunctionCall(token)
 		}
 
-		return &ast.Identifier{Name: name}, nil
+		return &ast.Identifier{Position: position(token), Name: token.Value}, nil
+
+	case lexer.TokenLeftBracket:
+		return p.parseListLiteral()
+
+	case lexer.TokenFunction:
+		if p.peek().Type != lexer.TokenLeftParen {
+			return nil, p.errorf("expected '(' after 'function' in an expression, got %s", describe(p.peek()))
+		}
+		return p.parseFunctionLiteral()
 
 	case lexer.TokenLeftParen:
 		p.advance()
@@ -511,25 +1587,39 @@ func (p *Parser) parsePrimary() (ast.Expression, error) {
 		}
 
 		if p.current().Type != lexer.TokenRightParen {
-			return nil, fmt.Errorf("expected ')', got %s", p.current().Value)
+			return nil, p.errorf("expected ')', got %s", describe(p.current()))
 		}
 		p.advance()
 
 		return expr, nil
 
 	default:
-		return nil, fmt.Errorf("unexpected token: %s", token.Value)
+		return nil, p.errorf("unexpected token: %s", describe(token))
+	}
+}
+
+func (p *Parser) parseFunctionCall(nameToken lexer.Token) (*ast.FunctionCall, error) {
+	arguments, err := p.parseArguments()
+	if err != nil {
+		return nil, err
 	}
+
+	return &ast.FunctionCall{
+		Position:  position(nameToken),
+		Name:      nameToken.Value,
+		Arguments: arguments,
+	}, nil
 }
 
-func (p *Parser) parseFunctionCall(name string) (*ast.FunctionCall, error) {
+// parseArguments parses a parenthesized, comma-separated argument list
+func (p *Parser) parseArguments() ([]ast.Expression, error) {
 	p.advance() // consume '('
 
 	var arguments []ast.Expression
 	for p.current().Type != lexer.TokenRightParen {
 		if len(arguments) > 0 {
 			if p.current().Type != lexer.TokenComma {
-				return nil, fmt.Errorf("expected ',' between arguments, got %s", p.current().Value)
+				return nil, p.errorf("expected ',' between arguments, got %s", describe(p.current()))
 			}
 			p.advance()
 		}
@@ -542,14 +1632,39 @@ func (p *Parser) parseFunctionCall(name string) (*ast.FunctionCall, error) {
 	}
 
 	if p.current().Type != lexer.TokenRightParen {
-		return nil, fmt.Errorf("expected ')', got %s", p.current().Value)
+		return nil, p.errorf("expected ')', got %s", describe(p.current()))
 	}
 	p.advance()
 
-	return &ast.FunctionCall{
-		Name:      name,
-		Arguments: arguments,
-	}, nil
+	return arguments, nil
+}
+
+func (p *Parser) parseListLiteral() (*ast.ListLiteral, error) {
+	bracketToken := p.current()
+	p.advance() // consume '['
+
+	var elements []ast.Expression
+	for p.current().Type != lexer.TokenRightBracket && p.current().Type != lexer.TokenEOF {
+		if len(elements) > 0 {
+			if p.current().Type != lexer.TokenComma {
+				return nil, p.errorf("expected ',' between list elements, got %s", describe(p.current()))
+			}
+			p.advance()
+		}
+
+		element, err := p.parseExpression()
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+	}
+
+	if p.current().Type != lexer.TokenRightBracket {
+		return nil, p.errorf("expected ']' after list elements, got %s", describe(p.current()))
+	}
+	p.advance()
+
+	return &ast.ListLiteral{Position: position(bracketToken), Elements: elements}, nil
 }
 
 func (p *Parser) parseExpressionStatement() (ast.Statement, error) {
@@ -558,23 +1673,118 @@ func (p *Parser) parseExpressionStatement() (ast.Statement, error) {
 		return nil, err
 	}
 
-	// For now, we'll just return the expression as a statement
-	// In a more sophisticated parser, you might want to handle this differently
-	return &ast.PrintStatement{Value: expr}, nil
+	return &ast.ExpressionStatement{Position: expr.Pos(), Expression: expr}, nil
 }
 
-func (p *Parser) current() lexer.Token {
-	if p.pos >= len(p.tokens) {
-		return lexer.Token{Type: lexer.TokenEOF}
+// parseType consumes a type keyword and an optional '?' marking the type as
+// nullable
+func (p *Parser) parseType() (types.Type, error) {
+	baseType, err := types.TypeFromString(p.current().Value)
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	p.advance()
+
+	if p.current().Type == lexer.TokenQuestion {
+		p.advance()
+		return types.NullableType{Inner: baseType}, nil
+	}
+	return baseType, nil
+}
+
+// isStatementStart reports whether a token can only begin a statement, which
+// makes it a safe place to resume after a syntax error
+func isStatementStart(tokenType lexer.TokenType) bool {
+	switch tokenType {
+	case lexer.TokenLet, lexer.TokenIf, lexer.TokenLoop, lexer.TokenFor, lexer.TokenSwitch, lexer.TokenTry, lexer.TokenFunction, lexer.TokenPrint, lexer.TokenWrite, lexer.TokenReturn, lexer.TokenAssert, lexer.TokenRaise, lexer.TokenEnum, lexer.TokenRepeat, lexer.TokenBegin, lexer.TokenInclude, lexer.TokenImport:
+		return true
+	default:
+		return isTypeKeyword(tokenType)
+	}
+}
+
+// startsExpression reports whether a token can begin an expression
+func startsExpression(tokenType lexer.TokenType) bool {
+	switch tokenType {
+	case lexer.TokenNumber, lexer.TokenInteger, lexer.TokenText, lexer.TokenChar, lexer.TokenBoolean, lexer.TokenNil,
+		lexer.TokenIdentifier, lexer.TokenLeftParen, lexer.TokenLeftBracket,
+		lexer.TokenMinus, lexer.TokenNot, lexer.TokenFunction:
+		return true
+	default:
+		return false
+	}
+}
+
+// opensBlock reports whether a token starts a block closed by 'end', or by
+// 'until' for 'repeat'
+func opensBlock(tokenType lexer.TokenType) bool {
+	switch tokenType {
+	case lexer.TokenIf, lexer.TokenLoop, lexer.TokenFor, lexer.TokenSwitch, lexer.TokenTry, lexer.TokenFunction, lexer.TokenEnum, lexer.TokenRepeat, lexer.TokenBegin:
+		return true
+	default:
+		return false
+	}
+}
+
+// closesBlock reports whether a token ends the body of the block around it
+func closesBlock(tokenType lexer.TokenType) bool {
+	switch tokenType {
+	case lexer.TokenEnd, lexer.TokenElse, lexer.TokenCase, lexer.TokenDefault, lexer.TokenCatch, lexer.TokenUntil:
+		return true
+	default:
+		return false
+	}
+}
+
+// isTypeKeyword reports whether a token names a type
+func isTypeKeyword(tokenType lexer.TokenType) bool {
+	switch tokenType {
+	case lexer.TokenNumberKeyword, lexer.TokenIntegerKeyword, lexer.TokenTextKeyword, lexer.TokenBooleanKeyword, lexer.TokenListKeyword:
+		return true
+	default:
+		return false
 	}
-	return p.tokens[p.pos]
+}
+
+// position returns the source position of a token
+func position(token lexer.Token) ast.Position {
+	return ast.Position{Line: token.Line, Column: token.Column}
+}
+
+func (p *Parser) current() lexer.Token {
+	return p.peekN(0)
 }
 
 func (p *Parser) peek() lexer.Token {
-	if p.pos+1 >= len(p.tokens) {
-		return lexer.Token{Type: lexer.TokenEOF}
+	return p.peekN(1)
+}
+
+// peekN returns the token offset places after the current one without
+// consuming anything. Past the last token it returns an EOF token placed
+// where the tokens end, so that an error there still has a position.
+func (p *Parser) peekN(offset int) lexer.Token {
+	if i := p.pos + offset; i < len(p.tokens) {
+		return p.tokens[i]
+	}
+	if len(p.tokens) == 0 {
+		return lexer.Token{Type: lexer.TokenEOF, Line: 1, Column: 1, EndLine: 1, EndColumn: 1}
+	}
+
+	last := p.tokens[len(p.tokens)-1]
+	if last.Type == lexer.TokenEOF {
+		return last
+	}
+	line, column := last.EndLine, last.EndColumn
+	if line == 0 {
+		// Tokens made by hand may not say where they end
+		line, column = last.Line, last.Column
 	}
-	return p.tokens[p.pos+1]
+	return lexer.Token{Type: lexer.TokenEOF, Line: line, Column: column, EndLine: line, EndColumn: column}
+}
+
+// onNewLine reports whether the current token is the first on its line
+func (p *Parser) onNewLine() bool {
+	return p.pos > 0 && p.current().Line > p.tokens[p.pos-1].Line
 }
 
 func (p *Parser) advance() {
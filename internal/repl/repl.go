@@ -0,0 +1,127 @@
+package repl
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"simplelang/internal/ast"
+	"simplelang/internal/interpreter"
+	"simplelang/internal/lexer"
+	"simplelang/internal/parser"
+	"simplelang/internal/types"
+	"strings"
+)
+
+const (
+	prompt             = ">>> "
+	continuationPrompt = "... "
+)
+
+// Run reads statements from in and executes them one at a time against a
+// single interpreter, so declarations persist between inputs. Bare
+// expressions echo their value. Input that opens a block (if, loop, for,
+// switch, function) is buffered until the matching 'end' arrives.
+func Run(in io.Reader, out io.Writer) error {
+	interp := interpreter.NewInterpreter()
+	interp.SetOutput(out)
+	// The aliases of modules imported at earlier prompts, which later inputs
+	// call into
+	modules := make(map[string]bool)
+
+	scanner := bufio.NewScanner(in)
+	var buffer strings.Builder
+
+	fmt.Fprintln(out, "SimpleLang REPL - press Ctrl-D to exit")
+	fmt.Fprint(out, prompt)
+
+	for scanner.Scan() {
+		buffer.WriteString(scanner.Text())
+		buffer.WriteString("\n")
+
+		tokens, err := lexer.NewLexer(buffer.String()).Tokenize()
+		if err != nil {
+			fmt.Fprintf(out, "Error: %v\n", err)
+			buffer.Reset()
+			fmt.Fprint(out, prompt)
+			continue
+		}
+
+		if blockDepth(tokens) > 0 {
+			fmt.Fprint(out, continuationPrompt)
+			continue
+		}
+		buffer.Reset()
+
+		if err := execute(interp, modules, tokens, out); err != nil {
+			fmt.Fprintf(out, "Error: %v\n", err)
+		}
+		fmt.Fprint(out, prompt)
+	}
+
+	fmt.Fprintln(out)
+	return scanner.Err()
+}
+
+// execute parses one complete input and runs it, adding the aliases of any
+// modules it imports to modules
+func execute(interp *interpreter.Interpreter, modules map[string]bool, tokens []lexer.Token, out io.Writer) error {
+	p := parser.NewParser(tokens)
+	for alias := range modules {
+		p.DeclareModule(alias)
+	}
+	program, err := p.Parse()
+	if err != nil {
+		// An expression such as 1 + 2 or (3) cannot stand as a statement in
+		// a program, but is still one to echo at the prompt
+		expr, exprErr := parser.ParseExpression(tokens)
+		if exprErr == nil {
+			return echo(interp, expr, out)
+		}
+		// Input that cannot even start a statement was meant as an
+		// expression, and is better told what is wrong with it as one
+		if errs, ok := err.(parser.ErrorList); ok && errs[0].Line == tokens[0].Line && errs[0].Column == tokens[0].Column {
+			return exprErr
+		}
+		return err
+	}
+	for _, statement := range program.Statements {
+		if stmt, ok := statement.(*ast.ImportStatement); ok {
+			modules[stmt.Alias] = true
+		}
+	}
+
+	// At the prompt a bare expression echoes its value unless it has none
+	if len(program.Statements) == 1 {
+		if stmt, ok := program.Statements[0].(*ast.ExpressionStatement); ok {
+			return echo(interp, stmt.Expression, out)
+		}
+	}
+
+	return interp.Interpret(program)
+}
+
+// echo evaluates expr and prints its value, unless it has none
+func echo(interp *interpreter.Interpreter, expr ast.Expression, out io.Writer) error {
+	value, err := interp.EvaluateExpression(expr)
+	if err != nil {
+		return err
+	}
+	if _, isVoid := value.(types.VoidValue); !isVoid {
+		fmt.Fprintln(out, value.String())
+	}
+	return nil
+}
+
+// blockDepth counts how many blocks the tokens open without closing
+func blockDepth(tokens []lexer.Token) int {
+	depth := 0
+	for _, token := range tokens {
+		switch token.Type {
+		case lexer.TokenIf, lexer.TokenLoop, lexer.TokenFor, lexer.TokenSwitch, lexer.TokenTry, lexer.TokenFunction, lexer.TokenEnum, lexer.TokenRepeat, lexer.TokenBegin:
+			depth++
+		case lexer.TokenEnd, lexer.TokenUntil:
+			depth--
+		}
+	}
+	return depth
+}
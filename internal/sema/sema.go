@@ -0,0 +1,1029 @@
+package sema
+
+import (
+	"fmt"
+	"path/filepath"
+	"simplelang/internal/ast"
+	"simplelang/internal/diagnostic"
+	"simplelang/internal/interpreter"
+	"simplelang/internal/types"
+	"strconv"
+	"strings"
+)
+
+// Error is a problem found in a program before it runs, a diagnostic of the
+// semantic phase. Nodes record only where they start, so its Length is 0.
+type Error = diagnostic.Diagnostic
+
+// scope records the variables and functions declared in one block, and the
+// scopes of the modules imported there under their aliases. A variable whose
+// type cannot be known without running the program maps to nil.
+type scope struct {
+	variables map[string]types.Type
+	// typed holds the variables declared with a type, written or inferred by
+	// 'let', which every value assigned to them must suit
+	typed     map[string]bool
+	functions map[string]*ast.FunctionDeclaration
+	modules   map[string]*scope
+	parent    *scope
+	// evals is set once the block has called eval, whose code may have
+	// declared any name there
+	evals bool
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{
+		variables: make(map[string]types.Type),
+		typed:     make(map[string]bool),
+		functions: make(map[string]*ast.FunctionDeclaration),
+		parent:    parent,
+	}
+}
+
+// declare records a variable, which typed tells was declared with type t,
+// so that what is assigned to it must suit t. Declaring a name twice in one
+// scope with different types leaves its type unknown.
+func (s *scope) declare(name string, t types.Type, typed bool) {
+	if previous, exists := s.variables[name]; exists {
+		if previous == nil || t == nil || previous.String() != t.String() {
+			t = nil
+		}
+		typed = typed && s.typed[name]
+	}
+	s.variables[name] = t
+	s.typed[name] = typed && t != nil
+}
+
+func (s *scope) lookup(name string) (types.Type, bool) {
+	for current := s; current != nil; current = current.parent {
+		if t, exists := current.variables[name]; exists {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// hasDeclaredType reports whether the variable called name was declared
+// with a type, written or inferred
+func (s *scope) hasDeclaredType(name string) bool {
+	for current := s; current != nil; current = current.parent {
+		if _, exists := current.variables[name]; exists {
+			return current.typed[name]
+		}
+	}
+	return false
+}
+
+// evaluates reports whether this scope or one around it has called eval, so
+// that a name not declared in the program may still be defined when it runs
+func (s *scope) evaluates() bool {
+	for current := s; current != nil; current = current.parent {
+		if current.evals {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *scope) function(name string) (*ast.FunctionDeclaration, bool) {
+	for current := s; current != nil; current = current.parent {
+		if function, exists := current.functions[name]; exists {
+			return function, true
+		}
+	}
+	return nil, false
+}
+
+// member splits a qualified name such as m.add into the scope of the module
+// its first part names and the rest of the name, as the interpreter does
+func (s *scope) member(name string) (*scope, string, bool) {
+	dot := strings.IndexByte(name, '.')
+	if dot < 0 {
+		return nil, "", false
+	}
+	for current := s; current != nil; current = current.parent {
+		if module, exists := current.modules[name[:dot]]; exists {
+			return module, name[dot+1:], true
+		}
+		if _, exists := current.variables[name[:dot]]; exists {
+			return nil, "", false
+		}
+	}
+	return nil, "", false
+}
+
+// Checker walks a program without executing it and reports undefined names,
+// calls with the wrong number of arguments and type mismatches that are
+// certain from the declared types and literals alone. A value of a nullable
+// type is taken to be of its inner type, as whether it is nil is only known
+// at runtime. Scoping mirrors the interpreter: every block, such as an if
+// branch, a case or a loop body, has a scope of its own, and function bodies
+// see their parameters and the scope they were declared in.
+type Checker struct {
+	predeclared map[string]types.Type
+	globals     *scope
+	scope       *scope
+	pending     []pendingFunction
+	reassigned  map[string]bool
+	// declared holds the names the statements of the block being checked
+	// have declared so far, which running the block certainly declares
+	declared map[string]bool
+	errors   []*Error
+	// baseDir is the directory include statements resolve against, and file
+	// the path of the included file being checked, if any, which errors name
+	baseDir  string
+	file     string
+	included map[string]bool
+	// modules holds the scope of each module imported so far, by file
+	modules map[string]*scope
+}
+
+// pendingFunction is a function whose body is still to be checked, with the
+// scope it was declared in and can see variables of, and the included file
+// it came from, if any
+type pendingFunction struct {
+	declaration *ast.FunctionDeclaration
+	scope       *scope
+	file        string
+}
+
+// NewChecker creates a new checker
+func NewChecker() *Checker {
+	return &Checker{predeclared: make(map[string]types.Type)}
+}
+
+// Declare tells the checker about a global variable that will exist before
+// the program runs, as one set with Interpreter.SetGlobal does
+func (c *Checker) Declare(name string, t types.Type) {
+	c.predeclared[name] = t
+}
+
+// SetBaseDir sets the directory that include statements resolve their paths
+// against, as Interpreter.SetBaseDir does
+func (c *Checker) SetBaseDir(dir string) {
+	c.baseDir = dir
+}
+
+// Check analyses program and returns every problem found, in the order
+// they were encountered
+func (c *Checker) Check(program *ast.Program) []*Error {
+	c.globals = newScope(nil)
+	c.scope = c.globals
+	c.pending = nil
+	c.errors = nil
+	c.file = ""
+	c.included = make(map[string]bool)
+	c.modules = make(map[string]*scope)
+	c.reassigned = make(map[string]bool)
+	collectAssignments(program.Statements, c.reassigned)
+	for name, t := range c.predeclared {
+		c.declare(name, t, false)
+	}
+	program.Accept(c)
+	return c.errors
+}
+
+// Check analyses program with a new checker
+func Check(program *ast.Program) []*Error {
+	return NewChecker().Check(program)
+}
+
+// declare records a variable in the current scope. Only a variable declared
+// with a type has what is assigned to it checked, so any other variable
+// assigned anywhere in the program may hold a value of any type and is
+// recorded without one.
+func (c *Checker) declare(name string, t types.Type, typed bool) {
+	if c.reassigned[name] && !typed {
+		t = nil
+	}
+	c.scope.declare(name, t, typed)
+}
+
+// declareOnce declares a variable as a statement of the current block does,
+// reporting it at pos when the block has already declared the name
+func (c *Checker) declareOnce(pos ast.Position, name string, t types.Type, typed bool) {
+	if c.declared[name] {
+		c.report(pos, "variable %s is already declared in this scope", name)
+	}
+	c.declared[name] = true
+	c.declare(name, t, typed)
+}
+
+// collectAssignments records the name of every variable that an ordinary
+// assignment in body, or in any block nested within it, changes. The bodies
+// of function literals count as nested blocks wherever the literal appears.
+func collectAssignments(body []ast.Statement, names map[string]bool) {
+	for _, stmt := range body {
+		switch s := stmt.(type) {
+		case *ast.VariableDeclaration:
+			collectLiteralAssignments(s.Value, names)
+		case *ast.MultiVariableDeclaration:
+			for _, decl := range s.Declarations {
+				collectLiteralAssignments(decl.Value, names)
+			}
+		case *ast.DestructuringDeclaration:
+			collectLiteralAssignments(s.Value, names)
+		case *ast.Assignment:
+			if s.Shorthand == "" {
+				names[s.Name] = true
+				collectLiteralAssignments(s.Value, names)
+			}
+		case *ast.IfStatement:
+			collectLiteralAssignments(s.Condition, names)
+			collectAssignments(s.ThenBody, names)
+			collectAssignments(s.ElseBody, names)
+		case *ast.LoopStatement:
+			collectLiteralAssignments(s.From, names)
+			collectLiteralAssignments(s.To, names)
+			collectLiteralAssignments(s.Step, names)
+			collectAssignments(s.Body, names)
+		case *ast.ForEachStatement:
+			collectLiteralAssignments(s.Iterable, names)
+			collectAssignments(s.Body, names)
+		case *ast.RepeatStatement:
+			collectAssignments(s.Body, names)
+			collectLiteralAssignments(s.Condition, names)
+		case *ast.SwitchStatement:
+			collectLiteralAssignments(s.Subject, names)
+			for _, switchCase := range s.Cases {
+				collectLiteralAssignments(switchCase.Value, names)
+				collectAssignments(switchCase.Body, names)
+			}
+			collectAssignments(s.Default, names)
+		case *ast.TryStatement:
+			collectAssignments(s.Body, names)
+			collectAssignments(s.CatchBody, names)
+		case *ast.BlockStatement:
+			collectAssignments(s.Body, names)
+		case *ast.FunctionDeclaration:
+			collectFunctionAssignments(s, names)
+		case *ast.ReturnStatement:
+			collectLiteralAssignments(s.Value, names)
+		case *ast.PrintStatement:
+			collectLiteralAssignments(s.Value, names)
+		case *ast.AssertStatement:
+			collectLiteralAssignments(s.Condition, names)
+			collectLiteralAssignments(s.Message, names)
+		case *ast.RaiseStatement:
+			collectLiteralAssignments(s.Value, names)
+		case *ast.ExpressionStatement:
+			collectLiteralAssignments(s.Expression, names)
+		}
+	}
+}
+
+func collectFunctionAssignments(function *ast.FunctionDeclaration, names map[string]bool) {
+	for _, param := range function.Parameters {
+		collectLiteralAssignments(param.Default, names)
+	}
+	collectAssignments(function.Body, names)
+}
+
+// collectLiteralAssignments collects the assignments in the bodies of any
+// function literals within expr, which may be nil
+func collectLiteralAssignments(expr ast.Expression, names map[string]bool) {
+	switch e := expr.(type) {
+	case *ast.FunctionLiteral:
+		collectFunctionAssignments(e.Function, names)
+	case *ast.BinaryExpression:
+		collectLiteralAssignments(e.Left, names)
+		collectLiteralAssignments(e.Right, names)
+	case *ast.UnaryExpression:
+		collectLiteralAssignments(e.Operand, names)
+	case *ast.ListLiteral:
+		for _, element := range e.Elements {
+			collectLiteralAssignments(element, names)
+		}
+	case *ast.IndexExpression:
+		collectLiteralAssignments(e.Target, names)
+		collectLiteralAssignments(e.Index, names)
+	case *ast.SliceExpression:
+		collectLiteralAssignments(e.Target, names)
+		collectLiteralAssignments(e.Start, names)
+		collectLiteralAssignments(e.End, names)
+	case *ast.FunctionCall:
+		collectLiteralAssignments(e.Callee, names)
+		for _, arg := range e.Arguments {
+			collectLiteralAssignments(arg, names)
+		}
+	}
+}
+
+// report records an error at pos, naming the included file it is in, if any
+func (c *Checker) report(pos ast.Position, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if c.file != "" {
+		message = fmt.Sprintf("in %s: %s", c.file, message)
+	}
+	c.errors = append(c.errors, &Error{
+		Severity: diagnostic.Error,
+		Phase:    diagnostic.Semantic,
+		Message:  message,
+		Line:     pos.Line,
+		Column:   pos.Column,
+		File:     c.file,
+	})
+}
+
+// typeOf checks an expression and returns its type, or nil if the type is
+// only known at runtime
+func (c *Checker) typeOf(expr ast.Expression) types.Type {
+	t, _ := expr.Accept(c).(types.Type)
+	return t
+}
+
+// block checks body in the current scope, the names given being already
+// declared there when it starts
+func (c *Checker) block(body []ast.Statement, names ...string) {
+	outer := c.declared
+	c.declared = make(map[string]bool)
+	for _, name := range names {
+		c.declared[name] = true
+	}
+	for _, stmt := range body {
+		stmt.Accept(c)
+	}
+	c.declared = outer
+}
+
+// scoped checks body in a new scope of its own
+func (c *Checker) scoped(body []ast.Statement) {
+	outer := c.scope
+	c.scope = newScope(outer)
+	c.block(body)
+	c.scope = outer
+}
+
+// nested checks body in a new scope holding a single variable
+func (c *Checker) nested(body []ast.Statement, variable string, t types.Type) {
+	outer := c.scope
+	c.scope = newScope(outer)
+	c.declare(variable, t, false)
+	c.block(body, variable)
+	c.scope = outer
+}
+
+// loop checks a loop's body as nested does, reporting the loop's variable
+// at pos when the block the loop is in has already declared the name
+func (c *Checker) loop(pos ast.Position, body []ast.Statement, variable string, t types.Type) {
+	if c.declared[variable] {
+		c.report(pos, "loop variable %s is already declared in this scope", variable)
+	}
+	c.nested(body, variable, t)
+}
+
+func (c *Checker) VisitProgram(node *ast.Program) interface{} {
+	// Top-level functions can be called before they are declared
+	for _, stmt := range node.Statements {
+		if function, ok := stmt.(*ast.FunctionDeclaration); ok {
+			c.globals.functions[function.Name] = function
+		}
+	}
+
+	c.block(node.Statements)
+
+	// A function body sees the scope it was declared in as it stands when
+	// the function is called, so bodies are checked once every declaration
+	// around them has been seen
+	for len(c.pending) > 0 {
+		pending := c.pending[0]
+		c.pending = c.pending[1:]
+
+		function := pending.declaration
+		c.scope = newScope(pending.scope)
+		c.file = pending.file
+		var names []string
+		for _, param := range function.Parameters {
+			// A default sees the parameters declared before it
+			if param.Default != nil {
+				if t := c.typeOf(param.Default); t != nil && !suits(param.Type, t) {
+					c.report(param.Default.Pos(), "type mismatch in function %s: parameter %s expects %s, got %s",
+						function.Name, param.Name, param.Type, t)
+				}
+			}
+			c.declare(param.Name, param.Type, true)
+			names = append(names, param.Name)
+		}
+		c.block(function.Body, names...)
+	}
+	c.scope = c.globals
+	c.file = ""
+	return nil
+}
+
+func (c *Checker) VisitStatement(node ast.Statement) interface{} {
+	return node.Accept(c)
+}
+
+func (c *Checker) VisitExpression(node ast.Expression) interface{} {
+	return node.Accept(c)
+}
+
+func (c *Checker) VisitVariableDeclaration(node *ast.VariableDeclaration) interface{} {
+	valueType := c.typeOf(node.Value)
+
+	if node.Type == nil {
+		if _, isNil := valueType.(types.NilType); isNil {
+			c.report(node.Pos(), "cannot infer the type of %s from nil; declare its type instead", node.Name)
+			valueType = nil
+		}
+		c.declareOnce(node.Pos(), node.Name, valueType, true)
+		return nil
+	}
+
+	if valueType != nil && !suits(node.Type, valueType) {
+		c.report(node.Pos(), "type mismatch: cannot assign %s to variable of type %s", valueType, node.Type)
+	}
+	c.declareOnce(node.Pos(), node.Name, node.Type, true)
+	return nil
+}
+
+func (c *Checker) VisitMultiVariableDeclaration(node *ast.MultiVariableDeclaration) interface{} {
+	for _, decl := range node.Declarations {
+		decl.Accept(c)
+	}
+	return nil
+}
+
+// VisitDestructuringDeclaration checks what can be known of the value being
+// unpacked: that it is a list, and how many elements it has when it is a
+// literal. The elements' types are not tracked, so a 'let' declares each
+// name without one.
+func (c *Checker) VisitDestructuringDeclaration(node *ast.DestructuringDeclaration) interface{} {
+	valueType := c.typeOf(node.Value)
+	if valueType != nil && !isList(valueType) {
+		c.report(node.Pos(), "cannot unpack %s into %d variables", valueType, len(node.Names))
+	} else if list, ok := node.Value.(*ast.ListLiteral); ok && len(list.Elements) != len(node.Names) {
+		c.report(node.Pos(), "cannot unpack %d values into %d variables", len(list.Elements), len(node.Names))
+	}
+
+	for _, name := range node.Names {
+		c.declareOnce(node.Pos(), name, node.Type, node.Type != nil)
+	}
+	return nil
+}
+
+func (c *Checker) VisitAssignment(node *ast.Assignment) interface{} {
+	current, exists := c.scope.lookup(node.Name)
+	if !exists {
+		if !c.scope.evaluates() {
+			c.report(node.Pos(), "undefined variable: %s", node.Name)
+		}
+		return nil
+	}
+
+	if node.Shorthand != "" {
+		if current != nil && !isNumeric(current) {
+			c.report(node.Pos(), "cannot apply %s to %s variable %s", node.Shorthand, current, node.Name)
+		}
+		return nil
+	}
+
+	if t := c.typeOf(node.Value); t != nil && c.scope.hasDeclaredType(node.Name) && !suits(current, t) {
+		c.report(node.Pos(), "type mismatch: cannot assign %s to variable of type %s", t, current)
+	}
+	return nil
+}
+
+func (c *Checker) VisitIfStatement(node *ast.IfStatement) interface{} {
+	if t := c.typeOf(node.Condition); t != nil && !isBoolean(t) {
+		c.report(node.Condition.Pos(), "condition must be boolean, got %s", t)
+	}
+	c.scoped(node.ThenBody)
+	c.scoped(node.ElseBody)
+	return nil
+}
+
+func (c *Checker) VisitLoopStatement(node *ast.LoopStatement) interface{} {
+	bounds := []ast.Expression{node.From, node.To}
+	if node.Step != nil {
+		bounds = append(bounds, node.Step)
+	}
+	// The loop variable is an integer when every bound is, and of unknown
+	// type when the type of any bound is unknown
+	var variable types.Type = types.IntType{}
+	for _, bound := range bounds {
+		t := c.typeOf(bound)
+		switch {
+		case t == nil:
+			variable = nil
+		case !isNumeric(t):
+			c.report(bound.Pos(), "loop bounds must be numbers, got %s", t)
+		case variable != nil && !isInteger(t):
+			variable = types.NumberType{}
+		}
+	}
+
+	c.loop(node.Pos(), node.Body, node.Variable, variable)
+	return nil
+}
+
+func (c *Checker) VisitSwitchStatement(node *ast.SwitchStatement) interface{} {
+	c.typeOf(node.Subject)
+	for _, switchCase := range node.Cases {
+		c.typeOf(switchCase.Value)
+		c.scoped(switchCase.Body)
+	}
+	c.scoped(node.Default)
+	return nil
+}
+
+func (c *Checker) VisitForEachStatement(node *ast.ForEachStatement) interface{} {
+	if t := c.typeOf(node.Iterable); t != nil {
+		if !isList(t) {
+			c.report(node.Iterable.Pos(), "cannot iterate over %s, expected a list", t)
+		}
+	}
+
+	c.loop(node.Pos(), node.Body, node.Variable, nil)
+	return nil
+}
+
+// VisitRepeatStatement checks the condition in the body's scope, where it is
+// evaluated
+func (c *Checker) VisitRepeatStatement(node *ast.RepeatStatement) interface{} {
+	outer := c.scope
+	c.scope = newScope(outer)
+	c.block(node.Body)
+	if t := c.typeOf(node.Condition); t != nil && !isBoolean(t) {
+		c.report(node.Condition.Pos(), "condition must be boolean, got %s", t)
+	}
+	c.scope = outer
+	return nil
+}
+
+func (c *Checker) VisitTryStatement(node *ast.TryStatement) interface{} {
+	c.scoped(node.Body)
+	c.nested(node.CatchBody, node.Variable, types.TextType{})
+	return nil
+}
+
+func (c *Checker) VisitBlockStatement(node *ast.BlockStatement) interface{} {
+	c.scoped(node.Body)
+	return nil
+}
+
+func (c *Checker) VisitFunctionDeclaration(node *ast.FunctionDeclaration) interface{} {
+	c.scope.functions[node.Name] = node
+	c.pending = append(c.pending, pendingFunction{declaration: node, scope: c.scope, file: c.file})
+	return nil
+}
+
+func (c *Checker) VisitReturnStatement(node *ast.ReturnStatement) interface{} {
+	if node.Value != nil {
+		c.typeOf(node.Value)
+	}
+	return nil
+}
+
+func (c *Checker) VisitFunctionCall(node *ast.FunctionCall) interface{} {
+	var calleeType types.Type
+	if node.Callee != nil {
+		calleeType = c.typeOf(node.Callee)
+	}
+
+	argTypes := make([]types.Type, len(node.Arguments))
+	for i, arg := range node.Arguments {
+		argTypes[i] = c.typeOf(arg)
+	}
+
+	// Which function an expression produces is only known at runtime
+	if node.Callee != nil {
+		if _, isFunction := calleeType.(types.FunctionType); calleeType != nil && !isFunction {
+			c.report(node.Pos(), "cannot call a value of type %s", calleeType)
+		}
+		return nil
+	}
+
+	// A method names a built-in, even where the program declares a function
+	// of its own with the same name
+	if node.Method {
+		if err := interpreter.CheckMethod(node.Name); err != nil {
+			c.report(node.Pos(), "%v", err)
+		} else if _, err := interpreter.CheckBuiltinArguments(node.Name, len(argTypes)); err != nil {
+			c.report(node.Pos(), "%v", err)
+		}
+		c.noteEval(node.Name)
+		return nil
+	}
+
+	// A qualified name such as m.add calls a function the module declared
+	scope, name := c.scope, node.Name
+	module, member, qualified := c.scope.member(node.Name)
+	if qualified {
+		scope, name = module, member
+	}
+
+	function, exists := scope.function(name)
+	if !exists {
+		// A variable may hold a function, whose parameters are only known
+		// at runtime
+		if t, isVariable := scope.lookup(name); isVariable {
+			if _, isFunction := t.(types.FunctionType); t != nil && !isFunction {
+				c.report(node.Pos(), "cannot call %s: %s is not a function", node.Name, t)
+			}
+			return nil
+		}
+		if isBuiltin, err := interpreter.CheckBuiltinArguments(node.Name, len(argTypes)); isBuiltin && !qualified {
+			if err != nil {
+				c.report(node.Pos(), "%v", err)
+			}
+			c.noteEval(node.Name)
+			return nil
+		}
+		if !scope.evaluates() {
+			c.report(node.Pos(), "undefined function: %s", node.Name)
+		}
+		return nil
+	}
+
+	required := function.RequiredParameters()
+	if len(argTypes) < required || len(argTypes) > len(function.Parameters) {
+		if required == len(function.Parameters) {
+			c.report(node.Pos(), "function %s expects %d arguments, got %d", node.Name, required, len(argTypes))
+		} else {
+			c.report(node.Pos(), "function %s expects %d to %d arguments, got %d", node.Name, required, len(function.Parameters), len(argTypes))
+		}
+		return nil
+	}
+
+	for i, argType := range argTypes {
+		param := function.Parameters[i]
+		if argType != nil && !suits(param.Type, argType) {
+			c.report(node.Arguments[i].Pos(), "type mismatch in function %s: parameter %s expects %s, got %s",
+				node.Name, param.Name, param.Type, argType)
+		}
+	}
+	return nil
+}
+
+// VisitFunctionLiteral queues the literal's body to be checked like that of
+// a declared function, in the scope the literal appears in
+func (c *Checker) VisitFunctionLiteral(node *ast.FunctionLiteral) interface{} {
+	c.pending = append(c.pending, pendingFunction{declaration: node.Function, scope: c.scope, file: c.file})
+	return types.FunctionType{}
+}
+
+func (c *Checker) VisitPrintStatement(node *ast.PrintStatement) interface{} {
+	c.typeOf(node.Value)
+	return nil
+}
+
+func (c *Checker) VisitAssertStatement(node *ast.AssertStatement) interface{} {
+	if t := c.typeOf(node.Condition); t != nil && !isBoolean(t) {
+		c.report(node.Condition.Pos(), "condition must be boolean, got %s", t)
+	}
+	if node.Message != nil {
+		c.typeOf(node.Message)
+	}
+	return nil
+}
+
+func (c *Checker) VisitRaiseStatement(node *ast.RaiseStatement) interface{} {
+	// A nullable text may well hold text by the time it is raised
+	maybeText := types.NullableType{Inner: types.TextType{}}
+	if t := c.typeOf(node.Value); t != nil && !maybeText.IsCompatibleWith(t) {
+		c.report(node.Value.Pos(), "can only raise text, got %s", t)
+	}
+	return nil
+}
+
+// VisitEnumDeclaration declares each member as a value of the enum's type
+func (c *Checker) VisitEnumDeclaration(node *ast.EnumDeclaration) interface{} {
+	for _, member := range node.Members {
+		c.declareOnce(node.Pos(), ast.MemberName(node.Name, member), types.EnumType{Name: node.Name}, false)
+	}
+	return nil
+}
+
+// VisitIncludeStatement checks the included file where it is included, as
+// the interpreter runs it, so that what it declares is known to the rest of
+// the program. A file is checked once, however often it is included.
+func (c *Checker) VisitIncludeStatement(node *ast.IncludeStatement) interface{} {
+	file, program, err := interpreter.LoadInclude(c.baseDir, node.Path, "include")
+	if err != nil {
+		c.report(node.Position, "%v", err)
+		return nil
+	}
+	if c.included[file] {
+		return nil
+	}
+	c.included[file] = true
+	c.checkFile(file, node.Path, program)
+	return nil
+}
+
+// VisitImportStatement checks the imported file in a scope of its own, which
+// sees the predeclared globals but nothing the program declares, and
+// declares the alias as the way into it. A module imported again is not
+// checked again.
+func (c *Checker) VisitImportStatement(node *ast.ImportStatement) interface{} {
+	file, program, err := interpreter.LoadInclude(c.baseDir, node.Path, "import")
+	if err != nil {
+		c.report(node.Position, "%v", err)
+		return nil
+	}
+
+	module, cached := c.modules[file]
+	if !cached {
+		module = newScope(nil)
+		for name, t := range c.predeclared {
+			module.declare(name, t, false)
+		}
+		c.modules[file] = module
+
+		outer := c.scope
+		c.scope = module
+		c.checkFile(file, node.Path, program)
+		c.scope = outer
+	}
+
+	c.declareOnce(node.Position, node.Alias, types.ModuleType{}, false)
+	if c.scope.modules == nil {
+		c.scope.modules = make(map[string]*scope)
+	}
+	c.scope.modules[node.Alias] = module
+	return nil
+}
+
+// checkFile checks the program read from file, which a statement named as
+// path, in the current scope. Errors in it are reported at their place in
+// the file, naming the path, and its own includes and imports resolve
+// against its directory.
+func (c *Checker) checkFile(file, path string, program *ast.Program) {
+	outerDir, outerFile := c.baseDir, c.file
+	c.baseDir = filepath.Dir(file)
+	if outerFile == "" {
+		c.file = path
+	} else {
+		c.file = filepath.Join(filepath.Dir(outerFile), path)
+	}
+	collectAssignments(program.Statements, c.reassigned)
+	for _, stmt := range program.Statements {
+		if function, ok := stmt.(*ast.FunctionDeclaration); ok {
+			c.scope.functions[function.Name] = function
+		}
+	}
+	c.block(program.Statements)
+	c.baseDir, c.file = outerDir, outerFile
+}
+
+func (c *Checker) VisitExpressionStatement(node *ast.ExpressionStatement) interface{} {
+	c.typeOf(node.Expression)
+	return nil
+}
+
+func (c *Checker) VisitBinaryExpression(node *ast.BinaryExpression) interface{} {
+	left := c.typeOf(node.Left)
+
+	// The right operand of and/or may never run, so only the left one is
+	// required to be boolean
+	if node.Operator == "and" || node.Operator == "or" {
+		if left != nil && !isBoolean(left) {
+			c.report(node.Pos(), "left operand of '%s' must be boolean, got %s", node.Operator, left)
+		}
+		c.typeOf(node.Right)
+		return types.BooleanType{}
+	}
+
+	right := c.typeOf(node.Right)
+
+	switch node.Operator {
+	case "==", "!=":
+		return types.BooleanType{}
+	case "<", "<=", ">", ">=":
+		ordered := isNumeric(left) && isNumeric(right) || isText(left) && isText(right) || isChar(left) && isChar(right)
+		if left != nil && right != nil && !ordered {
+			c.report(node.Pos(), "cannot compare %s and %s", left, right)
+		}
+		return types.BooleanType{}
+	case "&", "|", "xor", "<<", ">>":
+		// Whether a number is whole is only known at runtime
+		if left != nil && right != nil && !(isNumeric(left) && isNumeric(right)) {
+			c.report(node.Pos(), "cannot apply '%s' to %s and %s", node.Operator, left, right)
+		}
+		return types.IntType{}
+	}
+
+	if left == nil || right == nil {
+		return nil
+	}
+	if isNumeric(left) && isNumeric(right) {
+		return arithmeticType(node.Operator, left, right)
+	}
+	if node.Operator == "+" && (isChar(left) || isChar(right)) && isCharacters(left) && isCharacters(right) {
+		return types.TextType{}
+	}
+	if node.Operator == "+" && (isText(left) || isText(right)) && !isOther(left) && !isOther(right) {
+		return types.TextType{}
+	}
+	if node.Operator == "*" && (isText(left) && isNumeric(right) || isNumeric(left) && isText(right)) {
+		return types.TextType{}
+	}
+
+	c.report(node.Pos(), "cannot apply '%s' to %s and %s", node.Operator, left, right)
+	return nil
+}
+
+func (c *Checker) VisitUnaryExpression(node *ast.UnaryExpression) interface{} {
+	operand := c.typeOf(node.Operand)
+	if operand == nil {
+		return nil
+	}
+
+	if node.Operator == "-" {
+		if !isNumeric(operand) {
+			c.report(node.Pos(), "cannot negate non-number value")
+			return nil
+		}
+		return nonNullable(operand)
+	}
+
+	if !isBoolean(operand) {
+		c.report(node.Pos(), "cannot negate non-boolean value")
+		return nil
+	}
+	return types.BooleanType{}
+}
+
+func (c *Checker) VisitLiteral(node *ast.Literal) interface{} {
+	// The lexer lets through the one literal that is in range negated, which
+	// the parser folds into the smallest integer; anywhere else it is too large
+	if digits, ok := node.Value.(string); ok {
+		if _, isInt := node.Type.(types.IntType); isInt {
+			if _, err := strconv.ParseInt(digits, 10, 64); err != nil {
+				c.report(node.Position, "integer literal %s is too large for an integer", digits)
+			}
+		}
+	}
+	return node.Type
+}
+
+func (c *Checker) VisitListLiteral(node *ast.ListLiteral) interface{} {
+	for _, element := range node.Elements {
+		c.typeOf(element)
+	}
+	return types.ListType{}
+}
+
+// VisitIndexExpression gives text for an element of text, and an unknown
+// type for an element of a list, whose elements may be of any type
+func (c *Checker) VisitIndexExpression(node *ast.IndexExpression) interface{} {
+	target := c.indexable(node.Target)
+	c.position(node.Index)
+	if isText(target) {
+		return types.TextType{}
+	}
+	return nil
+}
+
+// VisitSliceExpression gives the type of what is sliced, as a slice of text
+// is text and a slice of a list is a list
+func (c *Checker) VisitSliceExpression(node *ast.SliceExpression) interface{} {
+	target := c.indexable(node.Target)
+	for _, bound := range []ast.Expression{node.Start, node.End} {
+		if bound != nil {
+			c.position(bound)
+		}
+	}
+	return target
+}
+
+// indexable checks the target of an index or slice and returns its type when
+// it is known to be text or a list
+func (c *Checker) indexable(target ast.Expression) types.Type {
+	t := c.typeOf(target)
+	if t == nil {
+		return nil
+	}
+	switch t := nonNullable(t).(type) {
+	case types.TextType, types.ListType:
+		return t
+	}
+	c.report(target.Pos(), "cannot index %s, expected text or a list", t)
+	return nil
+}
+
+// position checks an index or slice bound, which must be a number
+func (c *Checker) position(expr ast.Expression) {
+	if t := c.typeOf(expr); t != nil && !isNumeric(t) {
+		c.report(expr.Pos(), "index must be a whole number, got %s", t)
+	}
+}
+
+func (c *Checker) VisitIdentifier(node *ast.Identifier) interface{} {
+	scope, name := c.scope, node.Name
+	if t, exists := scope.lookup(name); exists {
+		return t
+	}
+	// A qualified name such as m.total reads what the module declared
+	if module, member, ok := scope.member(name); ok {
+		scope, name = module, member
+		if t, exists := scope.lookup(name); exists {
+			return t
+		}
+	}
+	if _, isFunction := scope.function(name); isFunction {
+		return types.FunctionType{}
+	}
+	if !scope.evaluates() {
+		c.report(node.Pos(), "undefined variable: %s", node.Name)
+	}
+	return nil
+}
+
+// noteEval marks the current scope as having called eval when builtin is
+// it. What the evaluated code declares is only known at runtime, so from
+// then on names undefined in the program are not reported there.
+func (c *Checker) noteEval(builtin string) {
+	if builtin == "eval" {
+		c.scope.evals = true
+	}
+}
+
+// arithmeticType is the result of an arithmetic operator on two numeric
+// types, or nil when it depends on the values
+func arithmeticType(operator string, left, right types.Type) types.Type {
+	leftInt, rightInt := isInteger(left), isInteger(right)
+
+	switch operator {
+	case "/":
+		return types.NumberType{}
+	case "//":
+		return types.IntType{}
+	case "^":
+		// A negative integer exponent gives a number
+		if leftInt && rightInt {
+			return nil
+		}
+		return types.NumberType{}
+	default:
+		if leftInt && rightInt {
+			return types.IntType{}
+		}
+		return types.NumberType{}
+	}
+}
+
+// nonNullable returns the type a nullable type lets be nil, and any other
+// type as it is
+func nonNullable(t types.Type) types.Type {
+	if nullable, ok := t.(types.NullableType); ok {
+		return nullable.Inner
+	}
+	return t
+}
+
+// suits reports whether a value of type actual may be stored where expected
+// is declared, one of a nullable type counting as one of its inner type
+func suits(expected, actual types.Type) bool {
+	return expected.IsCompatibleWith(nonNullable(actual))
+}
+
+// isNumeric reports whether a type is a number or an integer, or one that
+// may be nil
+func isNumeric(t types.Type) bool {
+	switch nonNullable(t).(type) {
+	case types.IntType, types.NumberType:
+		return true
+	default:
+		return false
+	}
+}
+
+func isInteger(t types.Type) bool {
+	_, ok := nonNullable(t).(types.IntType)
+	return ok
+}
+
+func isBoolean(t types.Type) bool {
+	_, ok := nonNullable(t).(types.BooleanType)
+	return ok
+}
+
+func isText(t types.Type) bool {
+	_, ok := nonNullable(t).(types.TextType)
+	return ok
+}
+
+func isChar(t types.Type) bool {
+	_, ok := nonNullable(t).(types.CharType)
+	return ok
+}
+
+// isCharacters reports whether a type is text or a char, which add together
+// into text
+func isCharacters(t types.Type) bool {
+	return isText(t) || isChar(t)
+}
+
+// isList reports whether a type is a list, or a list that may be nil
+func isList(t types.Type) bool {
+	_, ok := nonNullable(t).(types.ListType)
+	return ok
+}
+
+// isOther reports whether a type can be neither added to text nor to a number
+func isOther(t types.Type) bool {
+	return !isText(t) && !isNumeric(t)
+}
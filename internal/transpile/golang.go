@@ -0,0 +1,1171 @@
+package transpile
+
+import (
+	"fmt"
+	"go/format"
+	"math"
+	"simplelang/internal/ast"
+	"simplelang/internal/interpreter"
+	"simplelang/internal/types"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Go operator precedences, from loosest to tightest. Translated expressions
+// carry theirs so that an enclosing operator knows when to parenthesize.
+const (
+	precOr = iota + 1
+	precAnd
+	precCompare
+	precAdd
+	precMultiply
+	precUnary
+	precPrimary
+)
+
+// function is a top-level SimpleLang function and its translation
+type function struct {
+	declaration *ast.FunctionDeclaration
+	name        string
+	started     bool
+	result      types.Type // nil until inferred
+	source      []output
+	err         *Error
+}
+
+// emitter collects the Go lines of one function body
+type emitter struct {
+	lines    []output
+	pending  ast.Position // of the statement whose first line is next
+	indent   int
+	scope    *scope
+	function *function // nil for the main program
+	err      *Error
+
+	// inferring is set on a first pass over a function body that only
+	// records the types it returns
+	inferring bool
+	returns   []types.Type
+	bare      bool
+	unknown   bool
+}
+
+// GoTranspiler translates a program into the source of an equivalent Go
+// program. Integers become int64, numbers float64, text string, and
+// functions declared at the top level become Go functions whose result type
+// is inferred from their return statements. Lists, nil, function values and
+// error handling have no translation and are reported as errors.
+type GoTranspiler struct {
+	e         *emitter
+	globals   *scope
+	functions map[string]*function
+	order     []*function
+	imports   map[string]bool
+	helpers   map[string]bool
+
+	lineComments bool
+}
+
+// NewGoTranspiler creates a new Go transpiler
+func NewGoTranspiler() *GoTranspiler {
+	return &GoTranspiler{}
+}
+
+// SetLineComments sets whether each statement's first line of Go ends in a
+// comment giving the SimpleLang line it was translated from
+func (g *GoTranspiler) SetLineComments(enabled bool) {
+	g.lineComments = enabled
+}
+
+// Go translates program with a new Go transpiler
+func Go(program *ast.Program) (string, error) {
+	return NewGoTranspiler().Transpile(program)
+}
+
+// Transpile returns the formatted source of a Go main package that does what
+// program does, or the first construct that could not be translated
+func (g *GoTranspiler) Transpile(program *ast.Program) (string, error) {
+	g.globals = newScope(nil)
+	g.globals.global = true
+	g.functions = make(map[string]*function)
+	g.order = nil
+	g.imports = make(map[string]bool)
+	g.helpers = make(map[string]bool)
+
+	for _, stmt := range program.Statements {
+		decl, ok := stmt.(*ast.FunctionDeclaration)
+		if !ok {
+			continue
+		}
+		if _, ok := g.functions[decl.Name]; ok {
+			return "", unsupported("Go", decl.Position, "declaring function %s more than once", decl.Name)
+		}
+		f := &function{declaration: decl, name: goName(decl.Name)}
+		g.functions[decl.Name] = f
+		g.order = append(g.order, f)
+	}
+
+	main := &emitter{indent: 1, scope: g.globals}
+	g.e = main
+	program.Accept(g)
+	for _, f := range g.order {
+		g.translate(f)
+	}
+
+	// Report the error nearest the start of the source
+	err := main.err
+	for _, f := range g.order {
+		if f.err != nil && (err == nil || f.err.Line < err.Line ||
+			f.err.Line == err.Line && f.err.Column < err.Column) {
+			err = f.err
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	source := g.assemble(main.lines)
+	formatted, ferr := format.Source([]byte(source))
+	if ferr != nil {
+		return "", fmt.Errorf("generated Go does not parse: %v", ferr)
+	}
+	return string(formatted), nil
+}
+
+// assemble puts the translated pieces together into a Go source file
+func (g *GoTranspiler) assemble(main []output) string {
+	var b strings.Builder
+	b.WriteString("// Code generated by simplelang. DO NOT EDIT.\n\npackage main\n")
+
+	imports := make([]string, 0, len(g.imports))
+	for name := range g.imports {
+		imports = append(imports, name)
+	}
+	sort.Strings(imports)
+	if len(imports) > 0 {
+		b.WriteString("\nimport (\n")
+		for _, name := range imports {
+			fmt.Fprintf(&b, "\t%q\n", name)
+		}
+		b.WriteString(")\n")
+	}
+
+	if len(g.globals.order) > 0 {
+		b.WriteString("\nvar (\n")
+		for _, v := range g.globals.order {
+			fmt.Fprintf(&b, "\t%s %s\n", v.name, goType(v.t))
+		}
+		b.WriteString(")\n")
+	}
+
+	b.WriteString("\nfunc main() {\n")
+	g.write(&b, main)
+	b.WriteString("}\n")
+
+	for _, f := range g.order {
+		b.WriteString("\n")
+		g.write(&b, f.source)
+		b.WriteString("}\n")
+	}
+
+	helpers := make([]string, 0, len(g.helpers))
+	for name := range g.helpers {
+		helpers = append(helpers, name)
+	}
+	sort.Strings(helpers)
+	for _, name := range helpers {
+		b.WriteString("\n")
+		b.WriteString(goHelpers[name])
+	}
+	return b.String()
+}
+
+// write adds lines to b, with line comments if they are enabled
+func (g *GoTranspiler) write(b *strings.Builder, lines []output) {
+	for _, line := range lines {
+		b.WriteString(line.text)
+		if g.lineComments && line.pos.Line > 0 {
+			fmt.Fprintf(b, " // line %d", line.pos.Line)
+		}
+		b.WriteString("\n")
+	}
+}
+
+// goHelpers are Go functions the generated code calls for operations with
+// no direct Go equivalent, written out only when used
+var goHelpers = map[string]string{
+	"powInt": `// powInt raises base to a non-negative integer power
+func powInt(base, exponent int64) int64 {
+	result := int64(1)
+	for ; exponent > 0; exponent-- {
+		result *= base
+	}
+	return result
+}
+`,
+	"integerValue": `// integerValue returns x, which as the result of a call is not a constant
+func integerValue(x int64) int64 {
+	return x
+}
+`,
+	"numberValue": `// numberValue returns x, which as the result of a call is not a constant
+func numberValue(x float64) float64 {
+	return x
+}
+`,
+	"loopPasses": `// loopPasses returns how many times a SimpleLang loop runs its body
+func loopPasses(from, to, step float64) float64 {
+	passes := math.Floor((to-from)/step+1e-9) + 1
+	if step == 0 || !(passes > 0) {
+		return 0
+	}
+	return passes
+}
+`,
+}
+
+// goReserved are names a SimpleLang identifier cannot keep in Go: keywords,
+// predeclared identifiers, and names the generated code itself uses
+var goReserved = map[string]bool{
+	"break": true, "case": true, "chan": true, "const": true, "continue": true,
+	"default": true, "defer": true, "else": true, "fallthrough": true, "for": true,
+	"func": true, "go": true, "goto": true, "if": true, "import": true,
+	"interface": true, "map": true, "package": true, "range": true, "return": true,
+	"select": true, "struct": true, "switch": true, "type": true, "var": true,
+	"bool": true, "byte": true, "error": true, "float32": true, "float64": true,
+	"int": true, "int8": true, "int16": true, "int32": true, "int64": true,
+	"rune": true, "string": true, "uint": true, "uint8": true, "uint16": true,
+	"uint32": true, "uint64": true, "uintptr": true, "complex64": true,
+	"complex128": true, "any": true, "true": true, "false": true, "iota": true,
+	"nil": true, "append": true, "cap": true, "clear": true, "close": true,
+	"complex": true, "copy": true, "delete": true, "imag": true, "len": true,
+	"make": true, "max": true, "min": true, "new": true, "panic": true,
+	"print": true, "println": true, "real": true, "recover": true,
+	"main": true, "init": true, "fmt": true, "math": true, "powInt": true,
+	"loopPasses": true, "integerValue": true, "numberValue": true, "pass": true,
+	"passes": true,
+}
+
+// goName returns the Go identifier for a SimpleLang name
+func goName(name string) string {
+	if goReserved[name] {
+		return name + "_"
+	}
+	return name
+}
+
+// goType returns the Go type for a SimpleLang type, or "" if it has none
+func goType(t types.Type) string {
+	switch t.(type) {
+	case types.IntType:
+		return "int64"
+	case types.NumberType:
+		return "float64"
+	case types.TextType:
+		return "string"
+	case types.BooleanType:
+		return "bool"
+	default:
+		return ""
+	}
+}
+
+// fail records that the construct at pos cannot be translated. Translation
+// carries on so that the rest of the tree is still visited, but only the
+// first error is kept.
+func (g *GoTranspiler) fail(pos ast.Position, format string, args ...interface{}) code {
+	if g.e.err == nil {
+		g.e.err = unsupported("Go", pos, format, args...)
+	}
+	return code{text: "nil", prec: precPrimary}
+}
+
+// line writes a line of Go at the current indentation
+func (g *GoTranspiler) line(format string, args ...interface{}) {
+	e := g.e
+	text := strings.Repeat("\t", e.indent) + fmt.Sprintf(format, args...)
+	e.lines = append(e.lines, output{text: text, pos: e.pending})
+	e.pending = ast.Position{}
+}
+
+func (g *GoTranspiler) use(name string) {
+	g.imports[name] = true
+}
+
+func (g *GoTranspiler) block(body []ast.Statement) {
+	for _, stmt := range body {
+		g.e.pending = stmt.Pos()
+		stmt.Accept(g)
+		g.e.pending = ast.Position{}
+	}
+}
+
+// indented translates body one level deeper, as the body of a Go block
+func (g *GoTranspiler) indented(body []ast.Statement) {
+	g.e.indent++
+	g.block(body)
+	g.e.indent--
+}
+
+// nested translates the body of an if or switch, which belongs to the
+// current SimpleLang scope but is its own Go block
+func (g *GoTranspiler) nested(body []ast.Statement) {
+	g.e.scope.nested++
+	g.indented(body)
+	g.e.scope.nested--
+}
+
+// scoped translates body in the new scope s, then declares the variables
+// that had to be hoisted to the start of it and discards the unused ones,
+// which Go would otherwise reject
+func (g *GoTranspiler) scoped(s *scope, body func()) {
+	e := g.e
+	outer := e.scope
+	e.scope = s
+	start := len(e.lines)
+	body()
+	e.scope = outer
+
+	indent := strings.Repeat("\t", e.indent)
+	var hoisted []output
+	for _, v := range s.order {
+		switch {
+		case v.hoisted:
+			hoisted = append(hoisted, output{text: indent + "var " + v.name + " " + goType(v.t)})
+			if !v.used {
+				hoisted = append(hoisted, output{text: indent + "_ = " + v.name})
+			}
+		case v.line >= 0 && !v.used:
+			e.lines[v.line].text += "\n" + indent + "_ = " + v.name
+		}
+	}
+	e.lines = append(e.lines[:start], append(hoisted, e.lines[start:]...)...)
+}
+
+// value translates an expression whose value is used
+func (g *GoTranspiler) value(expr ast.Expression) code {
+	c := expr.Accept(g).(code)
+	if _, ok := c.t.(types.VoidType); ok {
+		return g.fail(expr.Pos(), "using the result of a function that returns nothing")
+	}
+	return c
+}
+
+// condition translates an expression that must be boolean
+func (g *GoTranspiler) condition(expr ast.Expression, what string) string {
+	c := g.value(expr)
+	if c.t != nil && !isBoolean(c.t) {
+		g.fail(expr.Pos(), "%s of type %s", what, c.t)
+	}
+	return c.text
+}
+
+// convert adapts c to be stored as type t, widening integers to numbers. It
+// reports false if SimpleLang would only decide at runtime whether the value
+// fits, which Go's static types cannot express.
+func convert(c code, t types.Type) (code, bool) {
+	if c.t == nil || t == nil || c.t == t {
+		return c, true
+	}
+	if isNumber(t) && isInteger(c.t) {
+		return toFloat(c), true
+	}
+	return c, false
+}
+
+// toFloat widens an integer expression to float64
+func toFloat(c code) code {
+	if !isInteger(c.t) {
+		return c
+	}
+	if c.literal && !strings.HasPrefix(c.text, "-") {
+		return code{text: c.text + ".0", t: types.NumberType{}, prec: precPrimary, literal: true, constant: true}
+	}
+	return code{text: "float64(" + c.text + ")", t: types.NumberType{}, prec: precPrimary, constant: c.constant}
+}
+
+// arithmetic translates an arithmetic operation on left and right. Go would
+// evaluate an operation on two constants exactly when it compiles, failing
+// on one that overflows, and rejects any division by a constant zero, so in
+// those cases the right operand is passed through a helper to make it a
+// value computed at runtime, as SimpleLang's always are.
+func (g *GoTranspiler) arithmetic(left code, operator string, right code, prec int, t types.Type) code {
+	divides := operator == "/" || operator == "%"
+	if right.constant && (left.constant || divides && isZero(right)) {
+		helper := "numberValue"
+		if isInteger(right.t) {
+			helper = "integerValue"
+		}
+		g.helpers[helper] = true
+		right = code{text: helper + "(" + right.text + ")", t: right.t, prec: precPrimary}
+	}
+	return binary(left, operator, right, prec, t)
+}
+
+// isZero reports whether c is a constant that is zero
+func isZero(c code) bool {
+	value, err := strconv.ParseFloat(strings.Trim(c.text, "()"), 64)
+	return c.constant && err == nil && value == 0
+}
+
+// translate writes out the Go function for f. A first pass over the body
+// infers the result type from its return statements, so that the second can
+// convert every returned value to it.
+func (g *GoTranspiler) translate(f *function) {
+	if f.started {
+		return
+	}
+	f.started = true
+	decl := f.declaration
+
+	params := make([]string, len(decl.Parameters))
+	for i, param := range decl.Parameters {
+		if param.Default != nil {
+			f.err = unsupported("Go", decl.Position, "default value for parameter %s", param.Name)
+			return
+		}
+		if goType(param.Type) == "" {
+			f.err = unsupported("Go", decl.Position, "%s parameter %s", param.Type, param.Name)
+			return
+		}
+		params[i] = goName(param.Name) + " " + goType(param.Type)
+	}
+
+	first := g.body(f, true)
+	switch {
+	case first.bare && len(first.returns) > 0:
+		f.err = unsupported("Go", decl.Position, "function %s returns a value on some paths but not others", decl.Name)
+		return
+	case len(first.returns) == 0 && first.unknown:
+		f.err = unsupported("Go", decl.Position, "cannot infer what recursive function %s returns", decl.Name)
+		return
+	case len(first.returns) == 0:
+		f.result = types.VoidType{}
+	default:
+		f.result = first.returns[0]
+		for _, t := range first.returns[1:] {
+			switch {
+			case t == f.result:
+			case isNumeric(t) && isNumeric(f.result):
+				f.result = types.NumberType{}
+			default:
+				f.err = unsupported("Go", decl.Position, "function %s returns both %s and %s", decl.Name, f.result, t)
+				return
+			}
+		}
+	}
+
+	second := g.body(f, false)
+	if second.err != nil {
+		f.err = second.err
+		return
+	}
+	signature := "func " + f.name + "(" + strings.Join(params, ", ") + ")"
+	if result := goType(f.result); result != "" {
+		signature += " " + result
+	}
+	f.source = append([]output{{text: signature + " {", pos: decl.Position}}, second.lines...)
+}
+
+// body translates the body of f with a fresh emitter
+func (g *GoTranspiler) body(f *function, inferring bool) *emitter {
+	e := &emitter{indent: 1, function: f, inferring: inferring}
+	outer := g.e
+	g.e = e
+	defer func() { g.e = outer }()
+
+	s := newScope(g.globals)
+	for _, param := range f.declaration.Parameters {
+		v := &variable{name: goName(param.Name), t: param.Type, used: true, line: -1}
+		s.variables[param.Name] = v
+	}
+	g.scoped(s, func() {
+		g.block(f.declaration.Body)
+		if !inferring && goType(f.result) != "" && !terminates(f.declaration.Body) {
+			g.line("panic(%q)", "function "+f.declaration.Name+" ended without returning a value")
+		}
+	})
+	return e
+}
+
+func (g *GoTranspiler) VisitProgram(node *ast.Program) interface{} {
+	g.block(node.Statements)
+	return nil
+}
+
+func (g *GoTranspiler) VisitStatement(node ast.Statement) interface{} {
+	return node.Accept(g)
+}
+
+func (g *GoTranspiler) VisitExpression(node ast.Expression) interface{} {
+	return node.Accept(g)
+}
+
+// VisitVariableDeclaration declares a global as a package variable and a
+// local where it is first declared, unless that is inside an if or switch
+// body, in which case it is hoisted to the start of its scope
+func (g *GoTranspiler) VisitVariableDeclaration(node *ast.VariableDeclaration) interface{} {
+	value := g.value(node.Value)
+	t := node.Type
+	if t == nil {
+		t = value.t
+	}
+	if t == nil {
+		// Only while inferring, where the declaration itself does not matter
+		return nil
+	}
+	if goType(t) == "" {
+		g.fail(node.Position, "%s variables", t)
+		return nil
+	}
+	value, ok := convert(value, t)
+	if !ok {
+		g.fail(node.Position, "storing %s in %s variable %s", value.t, t, node.Name)
+		return nil
+	}
+
+	s := g.e.scope
+	if v, ok := s.variables[node.Name]; ok {
+		if v.t != t {
+			g.fail(node.Position, "declaring %s again as %s after %s", node.Name, t, v.t)
+			return nil
+		}
+		g.line("%s = %s", v.name, value.text)
+		return nil
+	}
+
+	v := &variable{name: goName(node.Name), t: t, line: -1}
+	s.variables[node.Name] = v
+	s.order = append(s.order, v)
+	switch {
+	case s.global:
+		g.line("%s = %s", v.name, value.text)
+	case s.nested > 0:
+		v.hoisted = true
+		g.line("%s = %s", v.name, value.text)
+	default:
+		v.line = len(g.e.lines)
+		g.line("var %s %s = %s", v.name, goType(t), value.text)
+	}
+	return nil
+}
+
+func (g *GoTranspiler) VisitMultiVariableDeclaration(node *ast.MultiVariableDeclaration) interface{} {
+	for _, decl := range node.Declarations {
+		decl.Accept(g)
+	}
+	return nil
+}
+
+func (g *GoTranspiler) VisitDestructuringDeclaration(node *ast.DestructuringDeclaration) interface{} {
+	return g.fail(node.Position, "destructuring declarations")
+}
+
+func (g *GoTranspiler) VisitAssignment(node *ast.Assignment) interface{} {
+	v := g.e.scope.lookup(node.Name)
+	if v == nil {
+		g.fail(node.Position, "assignment to undefined variable %s", node.Name)
+		return nil
+	}
+	if node.Shorthand != "" {
+		if v.t != nil && !isNumeric(v.t) {
+			g.fail(node.Position, "'%s' on %s variable %s", node.Shorthand, v.t, node.Name)
+			return nil
+		}
+		g.line("%s%s", v.name, node.Shorthand)
+		return nil
+	}
+
+	value, ok := convert(g.value(node.Value), v.t)
+	if !ok {
+		g.fail(node.Position, "storing %s in %s variable %s", value.t, v.t, node.Name)
+		return nil
+	}
+	g.line("%s = %s", v.name, value.text)
+	return nil
+}
+
+// VisitIfStatement writes an else branch holding only another if as else if
+func (g *GoTranspiler) VisitIfStatement(node *ast.IfStatement) interface{} {
+	g.line("if %s {", g.condition(node.Condition, "if condition"))
+	for {
+		g.nested(node.ThenBody)
+		if len(node.ElseBody) == 0 {
+			break
+		}
+		if next, ok := node.ElseBody[0].(*ast.IfStatement); ok && len(node.ElseBody) == 1 {
+			node = next
+			g.line("} else if %s {", g.condition(node.Condition, "if condition"))
+			continue
+		}
+		g.line("} else {")
+		g.nested(node.ElseBody)
+		break
+	}
+	g.line("}")
+	return nil
+}
+
+// VisitLoopStatement counts with an int64 when the bounds and step are all
+// integers, and with a float64 otherwise, as SimpleLang does. A loop with a
+// constant whole start and step, which add up exactly, is written straight
+// into the for clause. Other loops count their passes, working the number
+// out up front and the loop variable from the pass like the interpreter
+// does, so that fractional steps run the same number of times.
+func (g *GoTranspiler) VisitLoopStatement(node *ast.LoopStatement) interface{} {
+	from := g.value(node.From)
+	to := g.value(node.To)
+	var step code
+	if node.Step != nil {
+		step = g.value(node.Step)
+	}
+	for _, c := range []code{from, to, step} {
+		if c.t != nil && !isNumeric(c.t) {
+			return g.fail(node.Position, "loop bounds of type %s", c.t)
+		}
+	}
+
+	integral := integralLoop(from, to, step, node.Step != nil)
+	var counterType types.Type = types.NumberType{}
+	literal := floatLiteral
+	if integral {
+		counterType = types.IntType{}
+		literal = func(value float64) string { return strconv.FormatFloat(value, 'f', -1, 64) }
+	}
+
+	s := newScope(g.e.scope)
+	counter := &variable{name: goName(node.Variable), t: counterType, used: true, line: -1}
+	s.variables[node.Variable] = counter
+	i := counter.name
+
+	first, fromConstant := constantNumber(node.From)
+	last, toConstant := constantNumber(node.To)
+	increment, stepConstant := 1.0, true
+	if first > last {
+		increment = -1
+	}
+	if node.Step != nil {
+		increment, stepConstant = constantNumber(node.Step)
+	}
+
+	constant := fromConstant && toConstant && stepConstant && increment != 0
+	counting := constant && first == math.Trunc(first) && increment == math.Trunc(increment)
+
+	// The pass the loop variable is worked out from, when it is not counted
+	// directly
+	var start, by string
+	switch {
+	case counting:
+		compare, post := "<=", i+"++"
+		switch {
+		case increment == -1:
+			compare, post = ">=", i+"--"
+		case increment < 0:
+			compare, post = ">=", i+" -= "+literal(-increment)
+		case increment != 1:
+			post = i + " += " + literal(increment)
+		}
+		start := literal(first)
+		if integral {
+			start = "int64(" + start + ")"
+		}
+		g.line("for %s := %s; %s %s %s; %s {", i, start, i, compare,
+			strconv.FormatFloat(last, 'g', -1, 64), post)
+	case constant:
+		passes := interpreter.LoopPasses(first, last, increment)
+		g.line("for pass := 0.0; pass < %s; pass++ {", strconv.FormatFloat(passes, 'g', -1, 64))
+		start, by = floatLiteral(first), floatLiteral(increment)
+	case integral:
+		g.line("{")
+		g.e.indent++
+		// Declared with their type, as an untyped constant would give an int
+		g.line("var from, to int64 = %s, %s", from.text, to.text)
+		if node.Step != nil {
+			g.line("var step int64 = %s", step.text)
+		} else {
+			g.line("var step int64 = 1")
+			g.line("if from > to {")
+			g.line("\tstep = -1")
+			g.line("}")
+		}
+		g.helpers["loopPasses"] = true
+		g.use("math")
+		g.line("passes := loopPasses(float64(from), float64(to), float64(step))")
+		g.line("for pass := 0.0; pass < passes; pass++ {")
+		start, by = "from", "step"
+	default:
+		g.line("{")
+		g.e.indent++
+		g.line("from, to := %s, %s", toFloat(from).text, toFloat(to).text)
+		if node.Step != nil {
+			g.line("step := %s", toFloat(step).text)
+		} else {
+			g.line("step := 1.0")
+			g.line("if from > to {")
+			g.line("\tstep = -1")
+			g.line("}")
+		}
+		g.helpers["loopPasses"] = true
+		g.use("math")
+		g.line("passes := loopPasses(from, to, step)")
+		g.line("for pass := 0.0; pass < passes; pass++ {")
+		start, by = "from", "step"
+	}
+
+	g.e.indent++
+	g.scoped(s, func() {
+		if !counting {
+			counter.used, counter.line = false, len(g.e.lines)
+			s.order = append(s.order, counter)
+			if integral {
+				g.line("%s := %s + int64(pass)*%s", i, start, by)
+			} else {
+				g.line("%s := %s + pass*%s", i, start, by)
+			}
+		}
+		g.block(node.Body)
+	})
+	g.e.indent--
+	g.line("}")
+	if !constant {
+		g.e.indent--
+		g.line("}")
+	}
+	return nil
+}
+
+func (g *GoTranspiler) VisitSwitchStatement(node *ast.SwitchStatement) interface{} {
+	subject := g.value(node.Subject)
+	if _, ok := subject.t.(types.NilType); ok {
+		return g.fail(node.Position, "switching on nil")
+	}
+	g.line("switch %s {", subject.text)
+	for _, c := range node.Cases {
+		value := g.value(c.Value)
+		converted, ok := convert(value, subject.t)
+		if !ok {
+			g.fail(c.Value.Pos(), "a %s case in a switch on %s", value.t, subject.t)
+		}
+		g.line("case %s:", converted.text)
+		g.nested(c.Body)
+	}
+	if node.Default != nil {
+		g.line("default:")
+		g.nested(node.Default)
+	}
+	g.line("}")
+	return nil
+}
+
+func (g *GoTranspiler) VisitForEachStatement(node *ast.ForEachStatement) interface{} {
+	return g.fail(node.Position, "for loops over lists")
+}
+
+// VisitRepeatStatement tests the condition at the end of the loop body, in
+// the same Go block, so that it sees what the body declares
+func (g *GoTranspiler) VisitRepeatStatement(node *ast.RepeatStatement) interface{} {
+	g.line("for {")
+	g.e.indent++
+	g.scoped(newScope(g.e.scope), func() {
+		g.block(node.Body)
+		g.line("if %s {", g.condition(node.Condition, "until condition"))
+		g.line("\tbreak")
+		g.line("}")
+	})
+	g.e.indent--
+	g.line("}")
+	return nil
+}
+
+// VisitBlockStatement writes the block as a Go block with a scope of its own
+func (g *GoTranspiler) VisitBlockStatement(node *ast.BlockStatement) interface{} {
+	g.line("{")
+	g.e.indent++
+	g.scoped(newScope(g.e.scope), func() {
+		g.block(node.Body)
+	})
+	g.e.indent--
+	g.line("}")
+	return nil
+}
+
+func (g *GoTranspiler) VisitTryStatement(node *ast.TryStatement) interface{} {
+	return g.fail(node.Position, "try statements")
+}
+
+// VisitFunctionDeclaration writes nothing in place: top-level functions are
+// translated on their own, and no other function can be
+func (g *GoTranspiler) VisitFunctionDeclaration(node *ast.FunctionDeclaration) interface{} {
+	if f, ok := g.functions[node.Name]; ok && f.declaration == node {
+		return nil
+	}
+	return g.fail(node.Position, "functions declared inside other statements")
+}
+
+func (g *GoTranspiler) VisitReturnStatement(node *ast.ReturnStatement) interface{} {
+	e := g.e
+	if node.Value == nil {
+		e.bare = true
+		g.line("return")
+		return nil
+	}
+	if node.Multiple {
+		return g.fail(node.Position, "returning several values")
+	}
+
+	value := g.value(node.Value)
+	if e.inferring {
+		if value.t == nil {
+			e.unknown = true
+		} else {
+			e.returns = append(e.returns, value.t)
+		}
+		return nil
+	}
+	value, _ = convert(value, e.function.result)
+	g.line("return %s", value.text)
+	return nil
+}
+
+func (g *GoTranspiler) VisitFunctionCall(node *ast.FunctionCall) interface{} {
+	if node.Callee != nil {
+		return g.fail(node.Position, "calling a function value")
+	}
+
+	args := make([]code, len(node.Arguments))
+	for i, arg := range node.Arguments {
+		args[i] = g.value(arg)
+	}
+
+	// A method always calls the built-in
+	f, ok := g.functions[node.Name]
+	if !ok || node.Method {
+		if node.Name == "toText" && len(args) == 1 {
+			g.use("fmt")
+			return code{text: "fmt.Sprint(" + args[0].text + ")", t: types.TextType{}, prec: precPrimary}
+		}
+		return g.fail(node.Position, "calling %s", node.Name)
+	}
+
+	g.translate(f)
+	if f.err != nil && g.e.err == nil {
+		g.e.err = f.err
+	}
+	params := f.declaration.Parameters
+	if len(args) != len(params) {
+		return g.fail(node.Position, "function %s expects %d arguments, got %d", node.Name, len(params), len(args))
+	}
+	texts := make([]string, len(args))
+	for i, arg := range args {
+		converted, ok := convert(arg, params[i].Type)
+		if !ok {
+			g.fail(node.Arguments[i].Pos(), "passing %s as %s parameter %s of %s", arg.t, params[i].Type, params[i].Name, node.Name)
+		}
+		texts[i] = converted.text
+	}
+
+	if f.result == nil && !g.e.inferring && f.err == nil {
+		g.fail(node.Position, "cannot infer what recursive function %s returns", node.Name)
+	}
+	return code{text: f.name + "(" + strings.Join(texts, ", ") + ")", t: f.result, prec: precPrimary}
+}
+
+func (g *GoTranspiler) VisitFunctionLiteral(node *ast.FunctionLiteral) interface{} {
+	return g.fail(node.Position, "function literals")
+}
+
+func (g *GoTranspiler) VisitPrintStatement(node *ast.PrintStatement) interface{} {
+	value := g.value(node.Value)
+	g.use("fmt")
+	if node.Write {
+		g.line("fmt.Print(%s)", value.text)
+	} else {
+		g.line("fmt.Println(%s)", value.text)
+	}
+	return nil
+}
+
+func (g *GoTranspiler) VisitAssertStatement(node *ast.AssertStatement) interface{} {
+	return g.fail(node.Position, "assert statements")
+}
+
+func (g *GoTranspiler) VisitRaiseStatement(node *ast.RaiseStatement) interface{} {
+	return g.fail(node.Position, "raise statements")
+}
+
+func (g *GoTranspiler) VisitEnumDeclaration(node *ast.EnumDeclaration) interface{} {
+	return g.fail(node.Position, "enums")
+}
+
+func (g *GoTranspiler) VisitIncludeStatement(node *ast.IncludeStatement) interface{} {
+	return g.fail(node.Position, "include statements")
+}
+
+func (g *GoTranspiler) VisitImportStatement(node *ast.ImportStatement) interface{} {
+	return g.fail(node.Position, "import statements")
+}
+
+// VisitExpressionStatement discards the value of anything but a call, since
+// Go rejects other expressions used as statements
+func (g *GoTranspiler) VisitExpressionStatement(node *ast.ExpressionStatement) interface{} {
+	c := node.Expression.Accept(g).(code)
+	if _, ok := node.Expression.(*ast.FunctionCall); ok {
+		g.line("%s", c.text)
+	} else {
+		g.line("_ = %s", c.text)
+	}
+	return nil
+}
+
+// repeatText translates text multiplied by an integer count, either way
+// round. A negative count panics in strings.Repeat where SimpleLang fails at
+// runtime. A count typed number is rejected, since whether it is whole is
+// only known when the program runs.
+func (g *GoTranspiler) repeatText(node *ast.BinaryExpression, left, right code) interface{} {
+	text, count := left, right
+	if isText(right.t) {
+		text, count = right, left
+	}
+	if !isText(text.t) || !isNumeric(count.t) {
+		return g.fail(node.Position, "'%s' on %s and %s", node.Operator, left.t, right.t)
+	}
+	if !isInteger(count.t) {
+		return g.fail(node.Position, "repeating text a number of times that is not an integer")
+	}
+	g.use("strings")
+	return code{text: "strings.Repeat(" + text.text + ", int(" + count.text + "))", t: types.TextType{}, prec: precPrimary}
+}
+
+// VisitBinaryExpression follows the interpreter's typing rules: arithmetic
+// on two integers stays integral, mixing in a number widens to float64, and
+// adding text to a number concatenates the number's text form
+func (g *GoTranspiler) VisitBinaryExpression(node *ast.BinaryExpression) interface{} {
+	left := g.value(node.Left)
+	right := g.value(node.Right)
+	if left.t == nil || right.t == nil {
+		return code{text: "nil", prec: precPrimary}
+	}
+
+	mismatch := func() interface{} {
+		return g.fail(node.Position, "'%s' on %s and %s", node.Operator, left.t, right.t)
+	}
+	bothNumeric := isNumeric(left.t) && isNumeric(right.t)
+	widen := func() (code, code) {
+		if isInteger(left.t) && isInteger(right.t) {
+			return left, right
+		}
+		return toFloat(left), toFloat(right)
+	}
+
+	switch node.Operator {
+	case "and", "or":
+		if !isBoolean(left.t) || !isBoolean(right.t) {
+			return mismatch()
+		}
+		if node.Operator == "and" {
+			return binary(left, "&&", right, precAnd, types.BooleanType{})
+		}
+		return binary(left, "||", right, precOr, types.BooleanType{})
+
+	case "+":
+		switch {
+		case bothNumeric:
+			l, r := widen()
+			return g.arithmetic(l, "+", r, precAdd, numeric(left, right))
+		case isText(left.t) && isText(right.t):
+			return binary(left, "+", right, precAdd, types.TextType{})
+		case isText(left.t) && isNumeric(right.t):
+			g.use("fmt")
+			right = code{text: "fmt.Sprint(" + right.text + ")", t: types.TextType{}, prec: precPrimary}
+			return binary(left, "+", right, precAdd, types.TextType{})
+		case isNumeric(left.t) && isText(right.t):
+			g.use("fmt")
+			left = code{text: "fmt.Sprint(" + left.text + ")", t: types.TextType{}, prec: precPrimary}
+			return binary(left, "+", right, precAdd, types.TextType{})
+		}
+		return mismatch()
+
+	case "-", "*":
+		if node.Operator == "*" && !bothNumeric {
+			return g.repeatText(node, left, right)
+		}
+		if !bothNumeric {
+			return mismatch()
+		}
+		prec := precAdd
+		if node.Operator == "*" {
+			prec = precMultiply
+		}
+		l, r := widen()
+		return g.arithmetic(l, node.Operator, r, prec, numeric(left, right))
+
+	case "/":
+		if !bothNumeric {
+			return mismatch()
+		}
+		return g.arithmetic(toFloat(left), "/", toFloat(right), precMultiply, types.NumberType{})
+
+	case "//":
+		if !bothNumeric {
+			return mismatch()
+		}
+		if isInteger(left.t) && isInteger(right.t) {
+			return g.arithmetic(left, "/", right, precMultiply, types.IntType{})
+		}
+		g.use("math")
+		quotient := g.arithmetic(toFloat(left), "/", toFloat(right), precMultiply, types.NumberType{})
+		return code{text: "int64(math.Trunc(" + quotient.text + "))", t: types.IntType{}, prec: precPrimary}
+
+	case "%":
+		if !bothNumeric {
+			return mismatch()
+		}
+		if isInteger(left.t) && isInteger(right.t) {
+			return g.arithmetic(left, "%", right, precMultiply, types.IntType{})
+		}
+		g.use("math")
+		return code{text: "math.Mod(" + toFloat(left).text + ", " + toFloat(right).text + ")", t: types.NumberType{}, prec: precPrimary}
+
+	case "^":
+		if !bothNumeric {
+			return mismatch()
+		}
+		if isInteger(left.t) && isInteger(right.t) {
+			exponent, ok := constantNumber(node.Right)
+			if !ok || exponent < 0 {
+				return g.fail(node.Position, "raising an integer to an integer power that is not a non-negative constant")
+			}
+			g.helpers["powInt"] = true
+			return code{text: "powInt(" + left.text + ", " + right.text + ")", t: types.IntType{}, prec: precPrimary}
+		}
+		g.use("math")
+		return code{text: "math.Pow(" + toFloat(left).text + ", " + toFloat(right).text + ")", t: types.NumberType{}, prec: precPrimary}
+
+	case "&", "|", "xor", "<<", ">>":
+		// Numbers that happen to be whole are only known at runtime
+		if !isInteger(left.t) || !isInteger(right.t) {
+			return mismatch()
+		}
+		switch node.Operator {
+		case "|":
+			return g.arithmetic(left, "|", right, precAdd, types.IntType{})
+		case "xor":
+			return g.arithmetic(left, "^", right, precAdd, types.IntType{})
+		}
+		return g.arithmetic(left, node.Operator, right, precMultiply, types.IntType{})
+
+	case "==", "!=":
+		switch {
+		case isInteger(left.t) && isInteger(right.t):
+		case bothNumeric:
+			// Numbers compare equal within the interpreter's tolerance
+			g.use("math")
+			compare := "<"
+			if node.Operator == "!=" {
+				compare = ">="
+			}
+			difference := g.arithmetic(toFloat(left), "-", toFloat(right), precAdd, types.NumberType{})
+			return code{text: "math.Abs(" + difference.text + ") " + compare + " 1e-9", t: types.BooleanType{}, prec: precCompare}
+		case left.t == right.t && (isText(left.t) || isBoolean(left.t)):
+		default:
+			return mismatch()
+		}
+		return binary(left, node.Operator, right, precCompare, types.BooleanType{})
+
+	case "<", "<=", ">", ">=":
+		switch {
+		case bothNumeric:
+			left, right = widen()
+		case isText(left.t) && isText(right.t):
+		default:
+			return mismatch()
+		}
+		return binary(left, node.Operator, right, precCompare, types.BooleanType{})
+	}
+	return g.fail(node.Position, "operator '%s'", node.Operator)
+}
+
+func (g *GoTranspiler) VisitUnaryExpression(node *ast.UnaryExpression) interface{} {
+	operand := g.value(node.Operand)
+	if operand.t == nil {
+		return code{text: "nil", prec: precPrimary}
+	}
+
+	switch node.Operator {
+	case "-":
+		if !isNumeric(operand.t) {
+			return g.fail(node.Position, "negating %s", operand.t)
+		}
+	case "!", "not":
+		if !isBoolean(operand.t) {
+			return g.fail(node.Position, "'%s' on %s", node.Operator, operand.t)
+		}
+	default:
+		return g.fail(node.Position, "operator '%s'", node.Operator)
+	}
+
+	text := wrap(operand, precUnary, false)
+	if strings.HasPrefix(text, "-") {
+		// --x would be a decrement
+		text = "(" + text + ")"
+	}
+	operator := node.Operator
+	if operator == "not" {
+		operator = "!"
+	}
+	return code{text: operator + text, t: operand.t, prec: precUnary, constant: operand.constant}
+}
+
+// VisitLiteral writes numbers in a form Go reads the same way, so that an
+// integer with leading zeros is not taken for octal
+func (g *GoTranspiler) VisitLiteral(node *ast.Literal) interface{} {
+	text := fmt.Sprint(node.Value)
+	prec := precPrimary
+	switch node.Type.(type) {
+	case types.IntType:
+		value, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return g.fail(node.Position, "integer literal %s", text)
+		}
+		text = strconv.FormatInt(value, 10)
+	case types.NumberType:
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil || math.IsInf(value, 0) {
+			return g.fail(node.Position, "number literal %s", text)
+		}
+		text = floatLiteral(value)
+	case types.TextType:
+		text = strconv.Quote(text)
+	case types.BooleanType:
+	default:
+		return g.fail(node.Position, "%s literals", node.Type)
+	}
+	if strings.HasPrefix(text, "-") {
+		prec = precUnary
+	}
+	return code{text: text, t: node.Type, prec: prec, literal: true, constant: isNumeric(node.Type)}
+}
+
+func (g *GoTranspiler) VisitListLiteral(node *ast.ListLiteral) interface{} {
+	return g.fail(node.Position, "list literals")
+}
+
+func (g *GoTranspiler) VisitIndexExpression(node *ast.IndexExpression) interface{} {
+	return g.fail(node.Position, "indexing")
+}
+
+func (g *GoTranspiler) VisitSliceExpression(node *ast.SliceExpression) interface{} {
+	return g.fail(node.Position, "slicing")
+}
+
+func (g *GoTranspiler) VisitIdentifier(node *ast.Identifier) interface{} {
+	if v := g.e.scope.lookup(node.Name); v != nil {
+		v.used = true
+		return code{text: v.name, t: v.t, prec: precPrimary}
+	}
+	if _, ok := g.functions[node.Name]; ok {
+		return g.fail(node.Position, "using function %s as a value", node.Name)
+	}
+	return g.fail(node.Position, "undefined variable %s", node.Name)
+}
@@ -0,0 +1,781 @@
+package transpile
+
+import (
+	"fmt"
+	"math"
+	"simplelang/internal/ast"
+	"simplelang/internal/interpreter"
+	"simplelang/internal/types"
+	"strconv"
+	"strings"
+)
+
+// JavaScript operator precedences, from loosest to tightest
+const (
+	jsPrecOr = iota + 1
+	jsPrecAnd
+	jsPrecEquality
+	jsPrecCompare
+	jsPrecAdd
+	jsPrecMultiply
+	jsPrecPower
+	jsPrecUnary
+	jsPrecPrimary
+)
+
+// jsIndent is one level of indentation in generated JavaScript
+const jsIndent = "  "
+
+// JSTranspiler translates a program into JavaScript that runs in a browser
+// or under Node. Since JavaScript is dynamically typed, most constructs carry
+// over directly, functions and closures included. Static types are tracked
+// where they are known and used to keep '+' and '==' meaning what they do in
+// SimpleLang, and to reject operations SimpleLang would fail at runtime.
+// Lists, nil, write and error handling have no translation and are reported
+// as errors.
+type JSTranspiler struct {
+	lines   []output
+	indent  int
+	scope   *scope
+	err     *Error
+	pending ast.Position // of the statement whose first line is next
+}
+
+// NewJSTranspiler creates a new JavaScript transpiler
+func NewJSTranspiler() *JSTranspiler {
+	return &JSTranspiler{}
+}
+
+// JS translates program with a new JavaScript transpiler
+func JS(program *ast.Program) (string, error) {
+	return NewJSTranspiler().Transpile(program)
+}
+
+// Transpile returns the JavaScript source for program, or the first
+// construct that could not be translated
+func (j *JSTranspiler) Transpile(program *ast.Program) (string, error) {
+	j.lines = []output{{text: "// Code generated by simplelang. DO NOT EDIT."}, {}}
+	j.indent = 0
+	j.err = nil
+	j.scoped(newScope(nil), func() { j.block(program.Statements) })
+	if j.err != nil {
+		return "", j.err
+	}
+
+	var b strings.Builder
+	for _, line := range j.lines {
+		b.WriteString(line.text)
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
+
+// SourceMap returns a version 3 source map for the code the last call to
+// Transpile generated, pointing the first line of each statement back to
+// where the statement starts in the SimpleLang file named source
+func (j *JSTranspiler) SourceMap(source string) ([]byte, error) {
+	return sourceMap(j.lines, source)
+}
+
+// jsReserved are names a SimpleLang identifier cannot keep in JavaScript:
+// reserved words, and globals the generated code itself uses
+var jsReserved = map[string]bool{
+	"await": true, "break": true, "case": true, "catch": true, "class": true,
+	"const": true, "continue": true, "debugger": true, "default": true,
+	"delete": true, "do": true, "else": true, "enum": true, "export": true,
+	"extends": true, "false": true, "finally": true, "for": true,
+	"function": true, "if": true, "implements": true, "import": true,
+	"in": true, "instanceof": true, "interface": true, "let": true, "new": true,
+	"null": true, "package": true, "private": true, "protected": true,
+	"public": true, "return": true, "static": true, "super": true,
+	"switch": true, "this": true, "throw": true, "true": true, "try": true,
+	"typeof": true, "var": true, "void": true, "while": true, "with": true,
+	"yield": true, "arguments": true, "eval": true, "undefined": true,
+	"NaN": true, "Infinity": true, "console": true, "String": true, "Math": true,
+	"pass": true, "passes": true,
+}
+
+// jsName returns the JavaScript identifier for a SimpleLang name
+func jsName(name string) string {
+	if jsReserved[name] {
+		return name + "_"
+	}
+	return name
+}
+
+// jsQuote writes text as a JavaScript string literal
+func jsQuote(text string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range text {
+		switch {
+		case r == '"' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f || r == 0x2028 || r == 0x2029:
+			fmt.Fprintf(&b, `\u%04x`, r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// fail records that the construct at pos cannot be translated. Translation
+// carries on so that the rest of the tree is still visited, but only the
+// first error is kept.
+func (j *JSTranspiler) fail(pos ast.Position, format string, args ...interface{}) code {
+	if j.err == nil {
+		j.err = unsupported("JavaScript", pos, format, args...)
+	}
+	return code{text: "undefined", prec: jsPrecPrimary}
+}
+
+// line writes a line of JavaScript at the current indentation
+func (j *JSTranspiler) line(format string, args ...interface{}) {
+	text := strings.Repeat(jsIndent, j.indent) + fmt.Sprintf(format, args...)
+	j.lines = append(j.lines, output{text: text, pos: j.pending})
+	j.pending = ast.Position{}
+}
+
+func (j *JSTranspiler) block(body []ast.Statement) {
+	for _, stmt := range body {
+		j.pending = stmt.Pos()
+		stmt.Accept(j)
+		j.pending = ast.Position{}
+	}
+}
+
+func (j *JSTranspiler) indented(body []ast.Statement) {
+	j.indent++
+	j.block(body)
+	j.indent--
+}
+
+// nested translates the body of an if or switch, which belongs to the
+// current SimpleLang scope but is its own JavaScript block
+func (j *JSTranspiler) nested(body []ast.Statement) {
+	j.scope.nested++
+	j.indented(body)
+	j.scope.nested--
+}
+
+// scoped translates body in the new scope s, then declares the variables
+// that had to be hoisted to the start of it
+func (j *JSTranspiler) scoped(s *scope, body func()) {
+	outer := j.scope
+	j.scope = s
+	start := len(j.lines)
+	body()
+	j.scope = outer
+
+	var hoisted []output
+	for _, v := range s.order {
+		if v.hoisted {
+			hoisted = append(hoisted, output{text: strings.Repeat(jsIndent, j.indent) + "let " + v.name + ";"})
+		}
+	}
+	j.lines = append(j.lines[:start], append(hoisted, j.lines[start:]...)...)
+}
+
+// value translates an expression whose value is used
+func (j *JSTranspiler) value(expr ast.Expression) code {
+	return expr.Accept(j).(code)
+}
+
+// condition translates an expression that must be boolean
+func (j *JSTranspiler) condition(expr ast.Expression, what string) string {
+	c := j.value(expr)
+	if c.t != nil && !isBoolean(c.t) {
+		j.fail(expr.Pos(), "%s of type %s", what, c.t)
+	}
+	return c.text
+}
+
+// function writes a function whose first line starts with header, such as
+// "function name", and returns its lines
+func (j *JSTranspiler) function(header string, decl *ast.FunctionDeclaration) []output {
+	outer := j.lines
+	j.lines = nil
+
+	s := newScope(j.scope)
+	params := make([]string, len(decl.Parameters))
+	for i, param := range decl.Parameters {
+		v := &variable{name: jsName(param.Name), t: param.Type, line: -1}
+		s.variables[param.Name] = v
+		params[i] = v.name
+		if param.Default != nil {
+			// Defaults are evaluated in the function, seeing earlier parameters
+			outerScope := j.scope
+			j.scope = s
+			params[i] += " = " + j.value(param.Default).text
+			j.scope = outerScope
+		}
+	}
+	j.line("%s(%s) {", header, strings.Join(params, ", "))
+	j.indent++
+	j.scoped(s, func() { j.block(decl.Body) })
+	j.indent--
+	j.line("}")
+
+	lines := j.lines
+	j.lines = outer
+	return lines
+}
+
+func (j *JSTranspiler) VisitProgram(node *ast.Program) interface{} {
+	j.block(node.Statements)
+	return nil
+}
+
+func (j *JSTranspiler) VisitStatement(node ast.Statement) interface{} {
+	return node.Accept(j)
+}
+
+func (j *JSTranspiler) VisitExpression(node ast.Expression) interface{} {
+	return node.Accept(j)
+}
+
+// VisitVariableDeclaration declares a variable with let where it is first
+// declared, unless that is inside an if or switch body, in which case it is
+// hoisted to the start of its scope
+func (j *JSTranspiler) VisitVariableDeclaration(node *ast.VariableDeclaration) interface{} {
+	value := j.value(node.Value)
+	t := node.Type
+	if t == nil {
+		t = value.t
+	}
+
+	s := j.scope
+	if v, ok := s.variables[node.Name]; ok {
+		v.t = t
+		j.line("%s = %s;", v.name, value.text)
+		return nil
+	}
+
+	v := &variable{name: jsName(node.Name), t: t, line: -1}
+	s.variables[node.Name] = v
+	s.order = append(s.order, v)
+	if s.nested > 0 {
+		v.hoisted = true
+		j.line("%s = %s;", v.name, value.text)
+	} else {
+		j.line("let %s = %s;", v.name, value.text)
+	}
+	return nil
+}
+
+func (j *JSTranspiler) VisitMultiVariableDeclaration(node *ast.MultiVariableDeclaration) interface{} {
+	for _, decl := range node.Declarations {
+		decl.Accept(j)
+	}
+	return nil
+}
+
+func (j *JSTranspiler) VisitDestructuringDeclaration(node *ast.DestructuringDeclaration) interface{} {
+	return j.fail(node.Position, "destructuring declarations")
+}
+
+func (j *JSTranspiler) VisitAssignment(node *ast.Assignment) interface{} {
+	name := jsName(node.Name)
+	v := j.scope.lookup(node.Name)
+	if v != nil {
+		name = v.name
+	}
+	if node.Shorthand != "" {
+		if v != nil && v.t != nil && !isNumeric(v.t) {
+			j.fail(node.Position, "'%s' on %s variable %s", node.Shorthand, v.t, node.Name)
+			return nil
+		}
+		j.line("%s%s;", name, node.Shorthand)
+		return nil
+	}
+	j.line("%s = %s;", name, j.value(node.Value).text)
+	return nil
+}
+
+// VisitIfStatement writes an else branch holding only another if as else if
+func (j *JSTranspiler) VisitIfStatement(node *ast.IfStatement) interface{} {
+	j.line("if (%s) {", j.condition(node.Condition, "if condition"))
+	for {
+		j.nested(node.ThenBody)
+		if len(node.ElseBody) == 0 {
+			break
+		}
+		if next, ok := node.ElseBody[0].(*ast.IfStatement); ok && len(node.ElseBody) == 1 {
+			node = next
+			j.line("} else if (%s) {", j.condition(node.Condition, "if condition"))
+			continue
+		}
+		j.line("} else {")
+		j.nested(node.ElseBody)
+		break
+	}
+	j.line("}")
+	return nil
+}
+
+// VisitLoopStatement writes a loop with a constant whole start and step,
+// which add up exactly, straight into the for header. Other loops count
+// their passes, working the number out up front and the loop variable from
+// the pass like the interpreter does, so that fractional steps run the same
+// number of times.
+func (j *JSTranspiler) VisitLoopStatement(node *ast.LoopStatement) interface{} {
+	from := j.value(node.From)
+	to := j.value(node.To)
+	var step code
+	if node.Step != nil {
+		step = j.value(node.Step)
+	}
+	for _, c := range []code{from, to, step} {
+		if c.t != nil && !isNumeric(c.t) {
+			return j.fail(node.Position, "loop bounds of type %s", c.t)
+		}
+	}
+
+	var counterType types.Type = types.NumberType{}
+	if integralLoop(from, to, step, node.Step != nil) {
+		counterType = types.IntType{}
+	}
+	s := newScope(j.scope)
+	counter := &variable{name: jsName(node.Variable), t: counterType, line: -1}
+	s.variables[node.Variable] = counter
+	i := counter.name
+
+	first, fromConstant := constantNumber(node.From)
+	last, toConstant := constantNumber(node.To)
+	increment, stepConstant := 1.0, true
+	if first > last {
+		increment = -1
+	}
+	if node.Step != nil {
+		increment, stepConstant = constantNumber(node.Step)
+	}
+	constant := fromConstant && toConstant && stepConstant && increment != 0
+	counting := constant && first == math.Trunc(first) && increment == math.Trunc(increment)
+
+	// The pass the loop variable is worked out from, when it is not counted
+	// directly
+	var start, by string
+	switch {
+	case counting:
+		compare, post := "<=", i+"++"
+		switch {
+		case increment == -1:
+			compare, post = ">=", i+"--"
+		case increment < 0:
+			compare, post = ">=", i+" -= "+jsNumber(-increment)
+		case increment != 1:
+			post = i + " += " + jsNumber(increment)
+		}
+		j.line("for (let %s = %s; %s %s %s; %s) {", i, jsNumber(first), i, compare, jsNumber(last), post)
+	case constant:
+		passes := interpreter.LoopPasses(first, last, increment)
+		j.line("for (let pass = 0; pass < %s; pass++) {", jsNumber(passes))
+		start, by = jsNumber(first), jsNumber(increment)
+	default:
+		j.line("{")
+		j.indent++
+		j.line("const from = %s, to = %s;", from.text, to.text)
+		if node.Step != nil {
+			j.line("const step = %s;", step.text)
+		} else {
+			j.line("let step = 1;")
+			j.line("if (from > to) {")
+			j.line("%sstep = -1;", jsIndent)
+			j.line("}")
+		}
+		j.line("const passes = step === 0 ? 0 : Math.max(Math.floor((to - from) / step + 1e-9) + 1, 0) || 0;")
+		j.line("for (let pass = 0; pass < passes; pass++) {")
+		start, by = "from", "step"
+	}
+
+	j.indent++
+	j.scoped(s, func() {
+		if !counting {
+			j.line("let %s = %s + pass * %s;", i, start, by)
+		}
+		j.block(node.Body)
+	})
+	j.indent--
+	j.line("}")
+	if !constant {
+		j.indent--
+		j.line("}")
+	}
+	return nil
+}
+
+// jsNumber writes a constant as a JavaScript number literal
+func jsNumber(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// VisitSwitchStatement ends each case with a break unless it already ends
+// in a return, since SimpleLang cases never fall through
+func (j *JSTranspiler) VisitSwitchStatement(node *ast.SwitchStatement) interface{} {
+	j.line("switch (%s) {", j.value(node.Subject).text)
+	clause := func(body []ast.Statement) {
+		j.nested(body)
+		if !terminates(body) {
+			j.indent++
+			j.line("break;")
+			j.indent--
+		}
+	}
+	for _, c := range node.Cases {
+		j.line("case %s:", j.value(c.Value).text)
+		clause(c.Body)
+	}
+	if node.Default != nil {
+		j.line("default:")
+		clause(node.Default)
+	}
+	j.line("}")
+	return nil
+}
+
+func (j *JSTranspiler) VisitForEachStatement(node *ast.ForEachStatement) interface{} {
+	return j.fail(node.Position, "for loops over lists")
+}
+
+// VisitRepeatStatement tests the condition at the end of the loop body
+// rather than using do-while, whose condition cannot see the variables the
+// body declares
+func (j *JSTranspiler) VisitRepeatStatement(node *ast.RepeatStatement) interface{} {
+	j.line("while (true) {")
+	j.indent++
+	j.scoped(newScope(j.scope), func() {
+		j.block(node.Body)
+		j.line("if (%s) {", j.condition(node.Condition, "until condition"))
+		j.line("%sbreak;", jsIndent)
+		j.line("}")
+	})
+	j.indent--
+	j.line("}")
+	return nil
+}
+
+// VisitBlockStatement writes the block as a JavaScript block with a scope of
+// its own
+func (j *JSTranspiler) VisitBlockStatement(node *ast.BlockStatement) interface{} {
+	j.line("{")
+	j.indent++
+	j.scoped(newScope(j.scope), func() {
+		j.block(node.Body)
+	})
+	j.indent--
+	j.line("}")
+	return nil
+}
+
+func (j *JSTranspiler) VisitTryStatement(node *ast.TryStatement) interface{} {
+	return j.fail(node.Position, "try statements")
+}
+
+func (j *JSTranspiler) VisitFunctionDeclaration(node *ast.FunctionDeclaration) interface{} {
+	v := &variable{name: jsName(node.Name), t: types.FunctionType{}, line: -1}
+	j.scope.variables[node.Name] = v
+	j.lines = append(j.lines, j.function("function "+v.name, node)...)
+	return nil
+}
+
+func (j *JSTranspiler) VisitReturnStatement(node *ast.ReturnStatement) interface{} {
+	if node.Value == nil {
+		j.line("return;")
+		return nil
+	}
+	if node.Multiple {
+		return j.fail(node.Position, "returning several values")
+	}
+	j.line("return %s;", j.value(node.Value).text)
+	return nil
+}
+
+func (j *JSTranspiler) VisitFunctionCall(node *ast.FunctionCall) interface{} {
+	args := make([]string, len(node.Arguments))
+	for i, arg := range node.Arguments {
+		args[i] = j.value(arg).text
+	}
+
+	var callee string
+	switch {
+	case node.Callee != nil:
+		callee = wrap(j.value(node.Callee), jsPrecPrimary, false)
+	case !node.Method && j.scope.lookup(node.Name) != nil:
+		callee = j.scope.lookup(node.Name).name
+	default:
+		builtin, _ := interpreter.CheckBuiltinArguments(node.Name, len(args))
+		if builtin && node.Name == "toText" && len(args) == 1 {
+			return code{text: "String(" + args[0] + ")", t: types.TextType{}, prec: jsPrecPrimary}
+		}
+		if builtin {
+			return j.fail(node.Position, "calling %s", node.Name)
+		}
+		// A function declared further on, which JavaScript hoists
+		callee = jsName(node.Name)
+	}
+	return code{text: callee + "(" + strings.Join(args, ", ") + ")", prec: jsPrecPrimary}
+}
+
+// VisitFunctionLiteral writes a function expression spanning several lines,
+// indented to continue the line it starts on. The lines become part of the
+// statement holding the expression, so the statements in the body are not
+// given positions of their own.
+func (j *JSTranspiler) VisitFunctionLiteral(node *ast.FunctionLiteral) interface{} {
+	lines := j.function("function ", node.Function)
+	texts := make([]string, len(lines))
+	for i, line := range lines {
+		texts[i] = line.text
+	}
+	text := strings.TrimLeft(strings.Join(texts, "\n"), " ")
+	return code{text: text, t: types.FunctionType{}, prec: jsPrecUnary}
+}
+
+func (j *JSTranspiler) VisitPrintStatement(node *ast.PrintStatement) interface{} {
+	value := j.value(node.Value)
+	if node.Write {
+		return j.fail(node.Position, "write, since there is no portable way to print without a newline")
+	}
+	j.line("console.log(%s);", value.text)
+	return nil
+}
+
+func (j *JSTranspiler) VisitAssertStatement(node *ast.AssertStatement) interface{} {
+	return j.fail(node.Position, "assert statements")
+}
+
+func (j *JSTranspiler) VisitRaiseStatement(node *ast.RaiseStatement) interface{} {
+	return j.fail(node.Position, "raise statements")
+}
+
+func (j *JSTranspiler) VisitEnumDeclaration(node *ast.EnumDeclaration) interface{} {
+	return j.fail(node.Position, "enums")
+}
+
+func (j *JSTranspiler) VisitIncludeStatement(node *ast.IncludeStatement) interface{} {
+	return j.fail(node.Position, "include statements")
+}
+
+func (j *JSTranspiler) VisitImportStatement(node *ast.ImportStatement) interface{} {
+	return j.fail(node.Position, "import statements")
+}
+
+// VisitExpressionStatement parenthesizes a function literal, which would
+// otherwise start a function declaration
+func (j *JSTranspiler) VisitExpressionStatement(node *ast.ExpressionStatement) interface{} {
+	text := j.value(node.Expression).text
+	if strings.HasPrefix(text, "function") {
+		text = "(" + text + ")"
+	}
+	j.line("%s;", text)
+	return nil
+}
+
+// repeatText translates text multiplied by an integer count, either way
+// round. A negative count throws a RangeError where SimpleLang fails at
+// runtime. A count typed number is rejected, since String.repeat would drop
+// a fraction SimpleLang reports as an error.
+func (j *JSTranspiler) repeatText(node *ast.BinaryExpression, left, right code) interface{} {
+	text, count := left, right
+	if isText(right.t) {
+		text, count = right, left
+	}
+	if !isText(text.t) || !isNumeric(count.t) {
+		return j.fail(node.Position, "'%s' on %s and %s", node.Operator, left.t, right.t)
+	}
+	if !isInteger(count.t) {
+		return j.fail(node.Position, "repeating text a number of times that is not an integer")
+	}
+	return code{text: wrap(text, jsPrecPrimary, false) + ".repeat(" + count.text + ")", t: types.TextType{}, prec: jsPrecPrimary}
+}
+
+// VisitBinaryExpression relies on JavaScript's own '+' to concatenate text
+// with numbers, but converts a number known to be joined to text explicitly,
+// and compares numbers within the interpreter's tolerance
+func (j *JSTranspiler) VisitBinaryExpression(node *ast.BinaryExpression) interface{} {
+	left := j.value(node.Left)
+	right := j.value(node.Right)
+	known := left.t != nil && right.t != nil
+	bothNumeric := isNumeric(left.t) && isNumeric(right.t)
+	mismatch := func() interface{} {
+		return j.fail(node.Position, "'%s' on %s and %s", node.Operator, left.t, right.t)
+	}
+
+	switch node.Operator {
+	case "and", "or":
+		if left.t != nil && !isBoolean(left.t) || right.t != nil && !isBoolean(right.t) {
+			return mismatch()
+		}
+		if node.Operator == "and" {
+			return binary(left, "&&", right, jsPrecAnd, types.BooleanType{})
+		}
+		return binary(left, "||", right, jsPrecOr, types.BooleanType{})
+
+	case "+":
+		if !known {
+			return binary(left, "+", right, jsPrecAdd, nil)
+		}
+		switch {
+		case bothNumeric:
+			return binary(left, "+", right, jsPrecAdd, numeric(left, right))
+		case isText(left.t) && isText(right.t):
+		case isText(left.t) && isNumeric(right.t):
+			right = code{text: "String(" + right.text + ")", t: types.TextType{}, prec: jsPrecPrimary}
+		case isNumeric(left.t) && isText(right.t):
+			left = code{text: "String(" + left.text + ")", t: types.TextType{}, prec: jsPrecPrimary}
+		default:
+			return mismatch()
+		}
+		return binary(left, "+", right, jsPrecAdd, types.TextType{})
+
+	case "-", "*", "/", "%":
+		if node.Operator == "*" && known && !bothNumeric {
+			return j.repeatText(node, left, right)
+		}
+		if known && !bothNumeric {
+			return mismatch()
+		}
+		prec := jsPrecMultiply
+		if node.Operator == "-" {
+			prec = jsPrecAdd
+		}
+		var t types.Type
+		switch {
+		case node.Operator == "/":
+			t = types.NumberType{}
+		case known:
+			t = numeric(left, right)
+		}
+		return binary(left, node.Operator, right, prec, t)
+
+	case "//":
+		if known && !bothNumeric {
+			return mismatch()
+		}
+		quotient := binary(left, "/", right, jsPrecMultiply, types.NumberType{})
+		return code{text: "Math.trunc(" + quotient.text + ")", t: types.IntType{}, prec: jsPrecPrimary}
+
+	case "^":
+		if known && !bothNumeric {
+			return mismatch()
+		}
+		// JavaScript rejects a unary operator on the left of '**'
+		text := wrap(left, jsPrecPrimary, false) + " ** " + wrap(right, jsPrecPower, true)
+		var t types.Type = types.NumberType{}
+		if !known {
+			t = nil
+		} else if isInteger(left.t) && isInteger(right.t) {
+			if exponent, ok := constantNumber(node.Right); ok && exponent >= 0 {
+				t = types.IntType{}
+			} else {
+				t = nil
+			}
+		}
+		return code{text: text, t: t, prec: jsPrecPower}
+
+	case "==", "!=":
+		if bothNumeric && !(isInteger(left.t) && isInteger(right.t)) {
+			compare := "<"
+			if node.Operator == "!=" {
+				compare = ">="
+			}
+			difference := binary(left, "-", right, jsPrecAdd, types.NumberType{})
+			return code{text: "Math.abs(" + difference.text + ") " + compare + " 1e-9", t: types.BooleanType{}, prec: jsPrecCompare}
+		}
+		// JavaScript compares arrays by identity, not by their elements
+		if isList(left.t) || isList(right.t) {
+			return mismatch()
+		}
+		return binary(left, node.Operator+"=", right, jsPrecEquality, types.BooleanType{})
+
+	case "<", "<=", ">", ">=":
+		if known && !bothNumeric && !(isText(left.t) && isText(right.t)) {
+			return mismatch()
+		}
+		return binary(left, node.Operator, right, jsPrecCompare, types.BooleanType{})
+	}
+	return j.fail(node.Position, "operator '%s'", node.Operator)
+}
+
+func (j *JSTranspiler) VisitUnaryExpression(node *ast.UnaryExpression) interface{} {
+	operand := j.value(node.Operand)
+
+	operator := node.Operator
+	switch operator {
+	case "-":
+		if operand.t != nil && !isNumeric(operand.t) {
+			return j.fail(node.Position, "negating %s", operand.t)
+		}
+	case "!", "not":
+		if operand.t != nil && !isBoolean(operand.t) {
+			return j.fail(node.Position, "'%s' on %s", node.Operator, operand.t)
+		}
+		operator = "!"
+	default:
+		return j.fail(node.Position, "operator '%s'", node.Operator)
+	}
+
+	text := wrap(operand, jsPrecUnary, false)
+	if strings.HasPrefix(text, "-") {
+		// --x would be a decrement
+		text = "(" + text + ")"
+	}
+	return code{text: operator + text, t: operand.t, prec: jsPrecUnary}
+}
+
+// VisitLiteral writes numbers in a form JavaScript reads the same way, so
+// that an integer with leading zeros is not taken for octal
+func (j *JSTranspiler) VisitLiteral(node *ast.Literal) interface{} {
+	text := fmt.Sprint(node.Value)
+	switch node.Type.(type) {
+	case types.IntType:
+		value, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return j.fail(node.Position, "integer literal %s", text)
+		}
+		text = strconv.FormatInt(value, 10)
+	case types.NumberType:
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil || math.IsInf(value, 0) {
+			return j.fail(node.Position, "number literal %s", text)
+		}
+		text = jsNumber(value)
+	case types.TextType:
+		text = jsQuote(text)
+	case types.BooleanType:
+	default:
+		return j.fail(node.Position, "%s literals", node.Type)
+	}
+	prec := jsPrecPrimary
+	if strings.HasPrefix(text, "-") {
+		prec = jsPrecUnary
+	}
+	return code{text: text, t: node.Type, prec: prec, literal: true}
+}
+
+func (j *JSTranspiler) VisitListLiteral(node *ast.ListLiteral) interface{} {
+	return j.fail(node.Position, "list literals")
+}
+
+func (j *JSTranspiler) VisitIndexExpression(node *ast.IndexExpression) interface{} {
+	return j.fail(node.Position, "indexing")
+}
+
+func (j *JSTranspiler) VisitSliceExpression(node *ast.SliceExpression) interface{} {
+	return j.fail(node.Position, "slicing")
+}
+
+func (j *JSTranspiler) VisitIdentifier(node *ast.Identifier) interface{} {
+	if v := j.scope.lookup(node.Name); v != nil {
+		return code{text: v.name, t: v.t, prec: jsPrecPrimary}
+	}
+	return code{text: jsName(node.Name), prec: jsPrecPrimary}
+}
@@ -0,0 +1,61 @@
+package transpile
+
+import (
+	"encoding/json"
+	"simplelang/internal/ast"
+	"strings"
+)
+
+// base64Digits are the digits of the base64 VLQ numbers in source map
+// mappings
+const base64Digits = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// vlq encodes value as a base64 variable-length quantity: the sign in the
+// lowest bit, then five bits per digit, least significant first, with the
+// sixth bit set on every digit but the last
+func vlq(value int) string {
+	v := value << 1
+	if value < 0 {
+		v = -value<<1 | 1
+	}
+	var b strings.Builder
+	for {
+		digit := v & 31
+		v >>= 5
+		if v > 0 {
+			digit |= 32
+		}
+		b.WriteByte(base64Digits[digit])
+		if v == 0 {
+			return b.String()
+		}
+	}
+}
+
+// sourceMap builds a version 3 source map for generated lines. Each line
+// that starts a statement gets one segment, mapping its first non-blank
+// column to the statement's position in source.
+func sourceMap(lines []output, source string) ([]byte, error) {
+	var mappings strings.Builder
+	previous := ast.Position{Line: 1, Column: 1}
+	for i, line := range lines {
+		if i > 0 {
+			mappings.WriteByte(';')
+		}
+		if line.pos.Line > 0 {
+			column := len(line.text) - len(strings.TrimLeft(line.text, " \t"))
+			// Generated column, source index, then the source line and
+			// column relative to the previous segment
+			mappings.WriteString(vlq(column) + vlq(0) + vlq(line.pos.Line-previous.Line) + vlq(line.pos.Column-previous.Column))
+			previous = line.pos
+		}
+		mappings.WriteString(strings.Repeat(";", strings.Count(line.text, "\n")))
+	}
+
+	return json.Marshal(struct {
+		Version  int      `json:"version"`
+		Sources  []string `json:"sources"`
+		Names    []string `json:"names"`
+		Mappings string   `json:"mappings"`
+	}{3, []string{source}, []string{}, mappings.String()})
+}
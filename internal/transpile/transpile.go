@@ -0,0 +1,201 @@
+package transpile
+
+import (
+	"fmt"
+	"simplelang/internal/ast"
+	"simplelang/internal/types"
+	"strconv"
+	"strings"
+)
+
+// Error is a construct the transpiler cannot translate
+type Error struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("transpile error at line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+// code is a translated expression: its source in the target language and
+// its SimpleLang type. A nil type means the type is not known, such as the
+// result of calling a function value, or of a recursive function whose
+// result type is still being inferred.
+type code struct {
+	text    string
+	t       types.Type
+	prec    int
+	literal bool
+	// constant is set on a numeric constant expression, which Go evaluates
+	// exactly when it compiles rather than in int64 or float64 when it runs
+	constant bool
+}
+
+// variable is a SimpleLang variable and the identifier standing for it in
+// the target language
+type variable struct {
+	name string
+	t    types.Type
+	used bool
+	// hoisted variables are first declared inside an if or switch body but
+	// belong to the enclosing scope, so Go declares them at its start
+	hoisted bool
+	// line is the index of the Go line declaring the variable, or -1 if the
+	// variable is declared some other way
+	line int
+}
+
+// scope mirrors a SimpleLang scope. Go and JavaScript blocks are stricter,
+// since if and switch bodies share the scope around them, so declarations
+// are placed by the scope rather than by the block they appear in.
+type scope struct {
+	variables map[string]*variable
+	order     []*variable
+	parent    *scope
+	global    bool
+	// nested counts the if and switch bodies being translated in this scope
+	nested int
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{variables: make(map[string]*variable), parent: parent}
+}
+
+func (s *scope) lookup(name string) *variable {
+	for ; s != nil; s = s.parent {
+		if v, ok := s.variables[name]; ok {
+			return v
+		}
+	}
+	return nil
+}
+
+// output is a line of generated code. Pos is the position of the statement
+// the line starts, and is zero for lines that start none, such as closing
+// braces. A line may hold further lines after a newline, which belong to the
+// same statement.
+type output struct {
+	text string
+	pos  ast.Position
+}
+
+// unsupported builds the error for a construct the target language has no
+// translation for
+func unsupported(target string, pos ast.Position, format string, args ...interface{}) *Error {
+	return &Error{Line: pos.Line, Column: pos.Column, Message: "unsupported in " + target + ": " + fmt.Sprintf(format, args...)}
+}
+
+// wrap parenthesizes an operand that binds more loosely than the operator
+// it belongs to. Operators are taken as left-associative, so a right
+// operand at the same precedence needs parentheses too.
+func wrap(c code, prec int, right bool) string {
+	if c.prec < prec || right && c.prec == prec {
+		return "(" + c.text + ")"
+	}
+	return c.text
+}
+
+func binary(left code, operator string, right code, prec int, t types.Type) code {
+	text := wrap(left, prec, false) + " " + operator + " " + wrap(right, prec, true)
+	return code{text: text, t: t, prec: prec}
+}
+
+func isInteger(t types.Type) bool {
+	_, ok := t.(types.IntType)
+	return ok
+}
+
+// integralLoop reports whether a loop counts in integers, which it does when
+// its bounds and any step it has are all integers
+func integralLoop(from, to, step code, hasStep bool) bool {
+	return isInteger(from.t) && isInteger(to.t) && (!hasStep || isInteger(step.t))
+}
+
+func isNumber(t types.Type) bool {
+	_, ok := t.(types.NumberType)
+	return ok
+}
+
+func isNumeric(t types.Type) bool {
+	return isInteger(t) || isNumber(t)
+}
+
+func isText(t types.Type) bool {
+	_, ok := t.(types.TextType)
+	return ok
+}
+
+func isBoolean(t types.Type) bool {
+	_, ok := t.(types.BooleanType)
+	return ok
+}
+
+func isList(t types.Type) bool {
+	_, ok := t.(types.ListType)
+	return ok
+}
+
+// numeric returns the type of an arithmetic result: integer when both
+// operands are integers, number otherwise
+func numeric(left, right code) types.Type {
+	if isInteger(left.t) && isInteger(right.t) {
+		return types.IntType{}
+	}
+	return types.NumberType{}
+}
+
+// constantNumber returns the value of a numeric literal, possibly negated
+func constantNumber(expr ast.Expression) (float64, bool) {
+	switch e := expr.(type) {
+	case *ast.Literal:
+		if _, ok := e.Type.(types.TextType); ok {
+			return 0, false
+		}
+		value, err := strconv.ParseFloat(fmt.Sprint(e.Value), 64)
+		return value, err == nil
+	case *ast.UnaryExpression:
+		if e.Operator == "-" {
+			value, ok := constantNumber(e.Operand)
+			return -value, ok
+		}
+	}
+	return 0, false
+}
+
+// floatLiteral writes a constant as a floating point literal
+func floatLiteral(value float64) string {
+	text := strconv.FormatFloat(value, 'g', -1, 64)
+	if !strings.ContainsAny(text, ".eIN") {
+		text += ".0"
+	}
+	return text
+}
+
+// terminates reports whether body always ends in a return statement, by
+// the rules Go uses to decide whether a function is missing one
+func terminates(body []ast.Statement) bool {
+	if len(body) == 0 {
+		return false
+	}
+	switch stmt := body[len(body)-1].(type) {
+	case *ast.ReturnStatement:
+		return true
+	case *ast.IfStatement:
+		return terminates(stmt.ThenBody) && terminates(stmt.ElseBody)
+	case *ast.BlockStatement:
+		return terminates(stmt.Body)
+	case *ast.SwitchStatement:
+		if stmt.Default == nil || !terminates(stmt.Default) {
+			return false
+		}
+		for _, c := range stmt.Cases {
+			if !terminates(c.Body) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
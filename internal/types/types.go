@@ -1,6 +1,11 @@
 package types
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
 
 // Type represents a SimpleLang data type
 type Type interface {
@@ -10,18 +15,41 @@ type Type interface {
 
 // Basic types
 type NumberType struct{}
+type IntType struct{}
 type TextType struct{}
+type CharType struct{}
 type BooleanType struct{}
+type ListType struct{}
+type MapType struct{}
+type NilType struct{}
+type FunctionType struct{}
 type VoidType struct{}
 
-func (n NumberType) String() string  { return "number" }
-func (t TextType) String() string    { return "text" }
-func (b BooleanType) String() string { return "boolean" }
-func (v VoidType) String() string    { return "void" }
+func (n NumberType) String() string   { return "number" }
+func (i IntType) String() string      { return "integer" }
+func (t TextType) String() string     { return "text" }
+func (c CharType) String() string     { return "char" }
+func (b BooleanType) String() string  { return "boolean" }
+func (l ListType) String() string     { return "list" }
+func (m MapType) String() string      { return "map" }
+func (n NilType) String() string      { return "nil" }
+func (f FunctionType) String() string { return "function" }
+func (v VoidType) String() string     { return "void" }
 
+// IsCompatibleWith allows integers to widen implicitly into numbers
 func (n NumberType) IsCompatibleWith(other Type) bool {
 	switch other.(type) {
-	case NumberType:
+	case NumberType, IntType:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsCompatibleWith only accepts integers; a number never narrows implicitly
+func (i IntType) IsCompatibleWith(other Type) bool {
+	switch other.(type) {
+	case IntType:
 		return true
 	default:
 		return false
@@ -37,6 +65,15 @@ func (t TextType) IsCompatibleWith(other Type) bool {
 	}
 }
 
+func (c CharType) IsCompatibleWith(other Type) bool {
+	switch other.(type) {
+	case CharType:
+		return true
+	default:
+		return false
+	}
+}
+
 func (b BooleanType) IsCompatibleWith(other Type) bool {
 	switch other.(type) {
 	case BooleanType:
@@ -46,6 +83,89 @@ func (b BooleanType) IsCompatibleWith(other Type) bool {
 	}
 }
 
+func (l ListType) IsCompatibleWith(other Type) bool {
+	switch other.(type) {
+	case ListType:
+		return true
+	default:
+		return false
+	}
+}
+
+func (m MapType) IsCompatibleWith(other Type) bool {
+	switch other.(type) {
+	case MapType:
+		return true
+	default:
+		return false
+	}
+}
+
+// IsCompatibleWith only accepts nil itself; nil fits elsewhere only through a
+// nullable type
+func (n NilType) IsCompatibleWith(other Type) bool {
+	switch other.(type) {
+	case NilType:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f FunctionType) IsCompatibleWith(other Type) bool {
+	switch other.(type) {
+	case FunctionType:
+		return true
+	default:
+		return false
+	}
+}
+
+// ModuleType is the type of an imported module's alias, which only names
+// the module in qualified names such as m.add
+type ModuleType struct{}
+
+func (m ModuleType) String() string { return "module" }
+
+func (m ModuleType) IsCompatibleWith(other Type) bool {
+	_, ok := other.(ModuleType)
+	return ok
+}
+
+// EnumType is the type of the members of one enum, named after it
+type EnumType struct {
+	Name string
+}
+
+func (e EnumType) String() string { return e.Name }
+
+// IsCompatibleWith only accepts members of the same enum
+func (e EnumType) IsCompatibleWith(other Type) bool {
+	o, ok := other.(EnumType)
+	return ok && o.Name == e.Name
+}
+
+// NullableType is a type written with a trailing '?', such as number?, whose
+// values may also be nil
+type NullableType struct {
+	Inner Type
+}
+
+func (n NullableType) String() string { return n.Inner.String() + "?" }
+
+// IsCompatibleWith accepts nil, anything the inner type accepts, and other
+// nullable types whose inner type fits
+func (n NullableType) IsCompatibleWith(other Type) bool {
+	switch o := other.(type) {
+	case NilType:
+		return true
+	case NullableType:
+		return n.Inner.IsCompatibleWith(o.Inner)
+	default:
+		return n.Inner.IsCompatibleWith(other)
+	}
+}
+
 func (v VoidType) IsCompatibleWith(other Type) bool {
 	return true
 }
@@ -55,10 +175,16 @@ func TypeFromString(typeStr string) (Type, error) {
 	switch typeStr {
 	case "number":
 		return NumberType{}, nil
+	case "integer":
+		return IntType{}, nil
 	case "text":
 		return TextType{}, nil
 	case "boolean":
 		return BooleanType{}, nil
+	case "list":
+		return ListType{}, nil
+	case "function":
+		return FunctionType{}, nil
 	case "void":
 		return VoidType{}, nil
 	default:
@@ -72,6 +198,21 @@ type Value interface {
 	String() string
 }
 
+// NewNumber returns a floating-point number value
+func NewNumber(value float64) Value { return NumberValue{Value: value} }
+
+// NewInteger returns an integer value
+func NewInteger(value int64) Value { return IntValue{Value: value} }
+
+// NewText returns a text value
+func NewText(value string) Value { return TextValue{Value: value} }
+
+// NewChar returns a character value
+func NewChar(value rune) Value { return CharValue{Value: value} }
+
+// NewBoolean returns a boolean value
+func NewBoolean(value bool) Value { return BooleanValue{Value: value} }
+
 type NumberValue struct {
 	Value float64
 }
@@ -79,6 +220,13 @@ type NumberValue struct {
 func (n NumberValue) Type() Type     { return NumberType{} }
 func (n NumberValue) String() string { return fmt.Sprintf("%g", n.Value) }
 
+type IntValue struct {
+	Value int64
+}
+
+func (i IntValue) Type() Type     { return IntType{} }
+func (i IntValue) String() string { return strconv.FormatInt(i.Value, 10) }
+
 type TextValue struct {
 	Value string
 }
@@ -86,6 +234,14 @@ type TextValue struct {
 func (t TextValue) Type() Type     { return TextType{} }
 func (t TextValue) String() string { return t.Value }
 
+// CharValue is a single character, which prints as itself
+type CharValue struct {
+	Value rune
+}
+
+func (c CharValue) Type() Type     { return CharType{} }
+func (c CharValue) String() string { return string(c.Value) }
+
 type BooleanValue struct {
 	Value bool
 }
@@ -93,6 +249,81 @@ type BooleanValue struct {
 func (b BooleanValue) Type() Type     { return BooleanType{} }
 func (b BooleanValue) String() string { return fmt.Sprintf("%t", b.Value) }
 
+// ListValue is an ordered sequence of values of any type
+type ListValue struct {
+	Elements []Value
+}
+
+func (l ListValue) Type() Type { return ListType{} }
+
+// String shows the elements as Quoted does, so that text elements stand
+// apart, even empty ones
+func (l ListValue) String() string {
+	elements := make([]string, len(l.Elements))
+	for i, element := range l.Elements {
+		elements[i] = Quoted(element)
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// Quoted shows a value as it appears inside a list or map: text in double
+// quotes, a char in single quotes, and anything else as its String
+func Quoted(value Value) string {
+	switch v := value.(type) {
+	case TextValue:
+		return strconv.Quote(v.Value)
+	case CharValue:
+		return strconv.QuoteRune(v.Value)
+	default:
+		return value.String()
+	}
+}
+
+// MapValue associates text keys with values of any type. Programs cannot
+// build one yet, but a host may pass one in.
+type MapValue struct {
+	Entries map[string]Value
+}
+
+// NewMap returns a map value holding entries
+func NewMap(entries map[string]Value) Value { return MapValue{Entries: entries} }
+
+func (m MapValue) Type() Type { return MapType{} }
+
+// String lists the entries in key order, so that a map prints the same way
+// on every run, with keys and values quoted as Quoted does
+func (m MapValue) String() string {
+	keys := make([]string, 0, len(m.Entries))
+	for key := range m.Entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entries := make([]string, len(keys))
+	for i, key := range keys {
+		entries[i] = strconv.Quote(key) + ": " + Quoted(m.Entries[key])
+	}
+	return "{" + strings.Join(entries, ", ") + "}"
+}
+
+// EnumValue is a member of an enum. It is backed by Value, its position
+// among the enum's members counting from 0, and prints as its full name,
+// such as Color.red.
+type EnumValue struct {
+	Enum  string
+	Name  string
+	Value int64
+}
+
+func (e EnumValue) Type() Type     { return EnumType{Name: e.Enum} }
+func (e EnumValue) String() string { return e.Enum + "." + e.Name }
+
+// NilValue is the absence of a value
+type NilValue struct{}
+
+func (n NilValue) Type() Type     { return NilType{} }
+func (n NilValue) String() string { return "nil" }
+
 type VoidValue struct{}
 
 func (v VoidValue) Type() Type     { return VoidType{} }
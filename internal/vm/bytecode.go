@@ -0,0 +1,87 @@
+package vm
+
+import (
+	"simplelang/internal/ast"
+	"simplelang/internal/types"
+)
+
+// Opcode identifies the operation an instruction performs
+type Opcode byte
+
+const (
+	// Stack
+	OpConstant Opcode = iota // push Constants[Arg]
+	OpPop                    // discard the top of the stack
+	OpDup                    // push a copy of the top of the stack
+	OpList                   // replace the top Arg values with a list of them
+	OpUnpack                 // pop a list of exactly Arg values and push them in reverse, the first on top
+
+	// Variables and scopes
+	OpLoad       // push variable Name, or the function Name as a value
+	OpDeclare    // pop a value and declare Name of type Type with it
+	OpAssign     // pop a value and assign it to the existing variable Name
+	OpShorthand  // check that Operator (++ or --) can apply to variable Name
+	OpEnterScope // open a nested scope
+	OpExitScope  // close the innermost scope
+
+	// Operators
+	OpBinary       // pop two operands and push the result of Operator
+	OpUnary        // pop an operand and push the result of Operator
+	OpShortCircuit // jump to Arg if the left operand on the stack decides Operator
+	OpIndex        // pop an index and a text or list and push the element at the index
+	OpSlice        // pop the bounds that Arg marks present, 1 for the start and 2 for the end, and the value to slice, and push the slice
+
+	// Control flow
+	OpJump          // continue at Arg
+	OpJumpIfFalse   // pop a boolean condition and continue at Arg if it is false
+	OpLoopBounds    // check that the two values on top of the stack are numeric
+	OpLoopStart     // pop from, to and, if Arg is 1, step, and start a counting loop over Name
+	OpLoopNext      // set Name to the next count, or end the loop and continue at Arg
+	OpLoopIncrement // advance the innermost counting loop to its next pass
+	OpIterStart     // pop a list and start iterating over it with Name
+	OpIterNext      // set Name to the next element, or end the iteration and continue at Arg
+	OpTry           // start a try body whose errors continue at Arg with the message pushed
+	OpEndTry        // end the innermost try body, which finished without an error
+	OpFunction      // declare Functions[Arg] in the current scope
+	OpClosure       // push Functions[Arg] as a value that sees the current scope
+	OpCallee        // resolve function Name and push it for a later OpCall
+	OpCalleeValue   // pop a value that must be a function and push it for a later OpCall
+	OpMethod        // check that Name is a built-in and push it for a later OpCall
+	OpDefault       // continue at Arg if the call passed an argument for parameter Name
+	OpBindParameter // pop a default value and bind it to parameter Arg
+	OpCall          // pop Arg arguments and the function beneath them, and call it
+	OpReturn        // pop the return value, leave the current function and push it for the caller
+	OpPrint         // pop a value and print it on a line of its own
+	OpWrite         // pop a value and print it without a newline
+	OpAssert        // pop a boolean condition and continue at Arg if it is true
+	OpAssertFail    // pop the message of a failed assertion and fail with it
+	OpRaise         // pop a text and fail with it as the error message
+	OpInclude       // run the file Name names in the current scope
+	OpImport        // pop the path of a file and import it as the module Name
+)
+
+// Instruction is a single VM operation. Only the fields its opcode uses are
+// set; Pos is the source position reported if the instruction fails.
+type Instruction struct {
+	Op       Opcode
+	Arg      int
+	Name     string
+	Operator string
+	Type     types.Type
+	Pos      ast.Position
+}
+
+// Function is a compiled sequence of instructions, either the main program
+// or the body of a declared function
+type Function struct {
+	Name        string
+	Declaration *ast.FunctionDeclaration // nil for the main program
+	Code        []Instruction
+}
+
+// Bytecode is a compiled program
+type Bytecode struct {
+	Main      *Function
+	Functions []*Function
+	Constants []types.Value
+}
@@ -0,0 +1,493 @@
+package vm
+
+import (
+	"fmt"
+	"simplelang/internal/ast"
+	"simplelang/internal/interpreter"
+	"simplelang/internal/types"
+)
+
+// Error is a problem found while compiling a program to bytecode
+type Error struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("compile error at line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+// Compiler lowers an AST into bytecode
+type Compiler struct {
+	bytecode  *Bytecode
+	function  *Function
+	functions map[*ast.FunctionDeclaration]int
+}
+
+// NewCompiler creates a new compiler
+func NewCompiler() *Compiler {
+	return &Compiler{}
+}
+
+// Compile compiles a program with a new compiler
+func Compile(program *ast.Program) (*Bytecode, error) {
+	return NewCompiler().Compile(program)
+}
+
+// Compile lowers program into bytecode
+func (c *Compiler) Compile(program *ast.Program) (*Bytecode, error) {
+	c.bytecode = &Bytecode{Main: &Function{Name: "main"}}
+	c.function = c.bytecode.Main
+	c.functions = make(map[*ast.FunctionDeclaration]int)
+
+	// Declare top-level functions up front, as the interpreter does, so they
+	// can call each other regardless of the order they are declared in
+	for _, statement := range program.Statements {
+		if function, ok := statement.(*ast.FunctionDeclaration); ok {
+			if err := c.compileStatement(function); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := c.compileBlock(program.Statements); err != nil {
+		return nil, err
+	}
+	return c.bytecode, nil
+}
+
+// emit appends an instruction to the function being compiled and returns its
+// index
+func (c *Compiler) emit(instruction Instruction) int {
+	c.function.Code = append(c.function.Code, instruction)
+	return len(c.function.Code) - 1
+}
+
+// emitConstant adds value to the constant pool and emits an instruction
+// pushing it
+func (c *Compiler) emitConstant(value types.Value, pos ast.Position) {
+	c.bytecode.Constants = append(c.bytecode.Constants, value)
+	c.emit(Instruction{Op: OpConstant, Arg: len(c.bytecode.Constants) - 1, Pos: pos})
+}
+
+// patch points the jump at index to the next instruction to be emitted
+func (c *Compiler) patch(index int) {
+	c.function.Code[index].Arg = len(c.function.Code)
+}
+
+func (c *Compiler) compileBlock(statements []ast.Statement) error {
+	for _, statement := range statements {
+		if err := c.compileStatement(statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Compiler) compileStatement(statement ast.Statement) error {
+	switch stmt := statement.(type) {
+	case *ast.VariableDeclaration:
+		if err := c.compileExpression(stmt.Value); err != nil {
+			return err
+		}
+		c.emit(Instruction{Op: OpDeclare, Name: stmt.Name, Type: stmt.Type, Pos: stmt.Pos()})
+	case *ast.MultiVariableDeclaration:
+		for _, decl := range stmt.Declarations {
+			if err := c.compileStatement(decl); err != nil {
+				return err
+			}
+		}
+	case *ast.DestructuringDeclaration:
+		if err := c.compileExpression(stmt.Value); err != nil {
+			return err
+		}
+		c.emit(Instruction{Op: OpUnpack, Arg: len(stmt.Names), Pos: stmt.Pos()})
+		for _, name := range stmt.Names {
+			c.emit(Instruction{Op: OpDeclare, Name: name, Type: stmt.Type, Pos: stmt.Pos()})
+		}
+	case *ast.Assignment:
+		if stmt.Shorthand != "" {
+			c.emit(Instruction{Op: OpShorthand, Name: stmt.Name, Operator: stmt.Shorthand, Pos: stmt.Pos()})
+		}
+		if err := c.compileExpression(stmt.Value); err != nil {
+			return err
+		}
+		c.emit(Instruction{Op: OpAssign, Name: stmt.Name, Pos: stmt.Pos()})
+	case *ast.IfStatement:
+		return c.compileIfStatement(stmt)
+	case *ast.LoopStatement:
+		return c.compileLoopStatement(stmt)
+	case *ast.ForEachStatement:
+		return c.compileForEachStatement(stmt)
+	case *ast.RepeatStatement:
+		return c.compileRepeatStatement(stmt)
+	case *ast.SwitchStatement:
+		return c.compileSwitchStatement(stmt)
+	case *ast.TryStatement:
+		return c.compileTryStatement(stmt)
+	case *ast.BlockStatement:
+		return c.compileScopedBlock(stmt.Body, stmt.Pos())
+	case *ast.FunctionDeclaration:
+		index, err := c.compileFunction(stmt)
+		if err != nil {
+			return err
+		}
+		c.emit(Instruction{Op: OpFunction, Arg: index, Pos: stmt.Pos()})
+	case *ast.ReturnStatement:
+		if stmt.Value == nil {
+			c.emitConstant(types.VoidValue{}, stmt.Pos())
+		} else if err := c.compileExpression(stmt.Value); err != nil {
+			return err
+		}
+		c.emit(Instruction{Op: OpReturn, Pos: stmt.Pos()})
+	case *ast.PrintStatement:
+		if err := c.compileExpression(stmt.Value); err != nil {
+			return err
+		}
+		if stmt.Write {
+			c.emit(Instruction{Op: OpWrite, Pos: stmt.Pos()})
+		} else {
+			c.emit(Instruction{Op: OpPrint, Pos: stmt.Pos()})
+		}
+	case *ast.AssertStatement:
+		return c.compileAssertStatement(stmt)
+	case *ast.RaiseStatement:
+		if err := c.compileExpression(stmt.Value); err != nil {
+			return err
+		}
+		c.emit(Instruction{Op: OpRaise, Pos: stmt.Pos()})
+	case *ast.EnumDeclaration:
+		for j, member := range stmt.Members {
+			c.emitConstant(types.EnumValue{Enum: stmt.Name, Name: member, Value: int64(j)}, stmt.Pos())
+			c.emit(Instruction{Op: OpDeclare, Name: ast.MemberName(stmt.Name, member), Pos: stmt.Pos()})
+		}
+	case *ast.IncludeStatement:
+		c.emit(Instruction{Op: OpInclude, Name: stmt.Path, Pos: stmt.Pos()})
+	case *ast.ImportStatement:
+		c.emitConstant(types.TextValue{Value: stmt.Path}, stmt.Pos())
+		c.emit(Instruction{Op: OpImport, Name: stmt.Alias, Pos: stmt.Pos()})
+	case *ast.ExpressionStatement:
+		if err := c.compileExpression(stmt.Expression); err != nil {
+			return err
+		}
+		c.emit(Instruction{Op: OpPop, Pos: stmt.Pos()})
+	default:
+		pos := statement.Pos()
+		return &Error{Line: pos.Line, Column: pos.Column, Message: fmt.Sprintf("cannot compile statement %T", statement)}
+	}
+	return nil
+}
+
+// compileIfStatement runs each branch in a scope of its own
+func (c *Compiler) compileIfStatement(stmt *ast.IfStatement) error {
+	if err := c.compileExpression(stmt.Condition); err != nil {
+		return err
+	}
+	skipThen := c.emit(Instruction{Op: OpJumpIfFalse, Pos: stmt.Pos()})
+
+	if err := c.compileScopedBlock(stmt.ThenBody, stmt.Pos()); err != nil {
+		return err
+	}
+	if len(stmt.ElseBody) == 0 {
+		c.patch(skipThen)
+		return nil
+	}
+
+	skipElse := c.emit(Instruction{Op: OpJump, Pos: stmt.Pos()})
+	c.patch(skipThen)
+	if err := c.compileScopedBlock(stmt.ElseBody, stmt.Pos()); err != nil {
+		return err
+	}
+	c.patch(skipElse)
+	return nil
+}
+
+// compileScopedBlock compiles body between instructions that open and close
+// a nested scope
+func (c *Compiler) compileScopedBlock(body []ast.Statement, pos ast.Position) error {
+	c.emit(Instruction{Op: OpEnterScope, Pos: pos})
+	if err := c.compileBlock(body); err != nil {
+		return err
+	}
+	c.emit(Instruction{Op: OpExitScope, Pos: pos})
+	return nil
+}
+
+// compileLoopStatement checks the bounds before evaluating the step, in the
+// same order as the interpreter, so the same error wins when several apply
+func (c *Compiler) compileLoopStatement(stmt *ast.LoopStatement) error {
+	if err := c.compileExpression(stmt.From); err != nil {
+		return err
+	}
+	if err := c.compileExpression(stmt.To); err != nil {
+		return err
+	}
+	c.emit(Instruction{Op: OpLoopBounds, Pos: stmt.Pos()})
+
+	hasStep := 0
+	if stmt.Step != nil {
+		if err := c.compileExpression(stmt.Step); err != nil {
+			return err
+		}
+		hasStep = 1
+	}
+	c.emit(Instruction{Op: OpLoopStart, Arg: hasStep, Name: stmt.Variable, Pos: stmt.Pos()})
+
+	// Every pass runs in a scope of its own, which the exit path closes too
+	top := c.emit(Instruction{Op: OpEnterScope, Pos: stmt.Pos()})
+	exit := c.emit(Instruction{Op: OpLoopNext, Name: stmt.Variable, Pos: stmt.Pos()})
+	if err := c.compileBlock(stmt.Body); err != nil {
+		return err
+	}
+	c.emit(Instruction{Op: OpExitScope, Pos: stmt.Pos()})
+	c.emit(Instruction{Op: OpLoopIncrement, Pos: stmt.Pos()})
+	c.emit(Instruction{Op: OpJump, Arg: top, Pos: stmt.Pos()})
+	c.patch(exit)
+	c.emit(Instruction{Op: OpExitScope, Pos: stmt.Pos()})
+	return nil
+}
+
+// compileRepeatStatement evaluates the condition before closing the scope
+// of the pass, which it may read variables from, and goes round again while
+// it is false
+func (c *Compiler) compileRepeatStatement(stmt *ast.RepeatStatement) error {
+	top := c.emit(Instruction{Op: OpEnterScope, Pos: stmt.Pos()})
+	if err := c.compileBlock(stmt.Body); err != nil {
+		return err
+	}
+	if err := c.compileExpression(stmt.Condition); err != nil {
+		return err
+	}
+	c.emit(Instruction{Op: OpExitScope, Pos: stmt.Pos()})
+	c.emit(Instruction{Op: OpJumpIfFalse, Arg: top, Pos: stmt.Condition.Pos()})
+	return nil
+}
+
+func (c *Compiler) compileForEachStatement(stmt *ast.ForEachStatement) error {
+	if err := c.compileExpression(stmt.Iterable); err != nil {
+		return err
+	}
+	c.emit(Instruction{Op: OpIterStart, Name: stmt.Variable, Pos: stmt.Pos()})
+
+	top := c.emit(Instruction{Op: OpEnterScope, Pos: stmt.Pos()})
+	exit := c.emit(Instruction{Op: OpIterNext, Name: stmt.Variable, Pos: stmt.Pos()})
+	if err := c.compileBlock(stmt.Body); err != nil {
+		return err
+	}
+	c.emit(Instruction{Op: OpExitScope, Pos: stmt.Pos()})
+	c.emit(Instruction{Op: OpJump, Arg: top, Pos: stmt.Pos()})
+	c.patch(exit)
+	c.emit(Instruction{Op: OpExitScope, Pos: stmt.Pos()})
+	return nil
+}
+
+// compileSwitchStatement keeps the subject on the stack while the cases are
+// compared against it, and pops it before running the chosen body
+func (c *Compiler) compileSwitchStatement(stmt *ast.SwitchStatement) error {
+	if err := c.compileExpression(stmt.Subject); err != nil {
+		return err
+	}
+
+	var ends []int
+	for _, cs := range stmt.Cases {
+		c.emit(Instruction{Op: OpDup, Pos: stmt.Pos()})
+		if err := c.compileExpression(cs.Value); err != nil {
+			return err
+		}
+		c.emit(Instruction{Op: OpBinary, Operator: "==", Pos: cs.Value.Pos()})
+		next := c.emit(Instruction{Op: OpJumpIfFalse, Pos: stmt.Pos()})
+
+		c.emit(Instruction{Op: OpPop, Pos: stmt.Pos()})
+		if err := c.compileScopedBlock(cs.Body, stmt.Pos()); err != nil {
+			return err
+		}
+		ends = append(ends, c.emit(Instruction{Op: OpJump, Pos: stmt.Pos()}))
+		c.patch(next)
+	}
+
+	c.emit(Instruction{Op: OpPop, Pos: stmt.Pos()})
+	if err := c.compileScopedBlock(stmt.Default, stmt.Pos()); err != nil {
+		return err
+	}
+	for _, end := range ends {
+		c.patch(end)
+	}
+	return nil
+}
+
+// compileTryStatement compiles the try body and the catch body each in a
+// scope of its own. The VM unwinds the try body's scope when it fails, and
+// enters the catch body with the error message on the stack.
+func (c *Compiler) compileTryStatement(stmt *ast.TryStatement) error {
+	try := c.emit(Instruction{Op: OpTry, Pos: stmt.Pos()})
+	if err := c.compileScopedBlock(stmt.Body, stmt.Pos()); err != nil {
+		return err
+	}
+	c.emit(Instruction{Op: OpEndTry, Pos: stmt.Pos()})
+	done := c.emit(Instruction{Op: OpJump, Pos: stmt.Pos()})
+
+	c.patch(try)
+	c.emit(Instruction{Op: OpEnterScope, Pos: stmt.Pos()})
+	c.emit(Instruction{Op: OpDeclare, Name: stmt.Variable, Type: types.TextType{}, Pos: stmt.Pos()})
+	if err := c.compileBlock(stmt.CatchBody); err != nil {
+		return err
+	}
+	c.emit(Instruction{Op: OpExitScope, Pos: stmt.Pos()})
+	c.patch(done)
+	return nil
+}
+
+// compileAssertStatement evaluates the message only when the condition
+// fails. Without one the failure quotes the condition's source.
+func (c *Compiler) compileAssertStatement(stmt *ast.AssertStatement) error {
+	if err := c.compileExpression(stmt.Condition); err != nil {
+		return err
+	}
+	holds := c.emit(Instruction{Op: OpAssert, Pos: stmt.Pos()})
+	if stmt.Message == nil {
+		c.emitConstant(types.TextValue{Value: ast.NewFormatter().FormatExpression(stmt.Condition)}, stmt.Pos())
+	} else if err := c.compileExpression(stmt.Message); err != nil {
+		return err
+	}
+	c.emit(Instruction{Op: OpAssertFail, Pos: stmt.Pos()})
+	c.patch(holds)
+	return nil
+}
+
+// compileFunction compiles the body of a function declaration once, however
+// many times the declaration is reached, and returns its index
+func (c *Compiler) compileFunction(decl *ast.FunctionDeclaration) (int, error) {
+	if index, ok := c.functions[decl]; ok {
+		return index, nil
+	}
+
+	function := &Function{Name: decl.Name, Declaration: decl}
+	index := len(c.bytecode.Functions)
+	c.bytecode.Functions = append(c.bytecode.Functions, function)
+	c.functions[decl] = index
+
+	enclosing := c.function
+	c.function = function
+	defer func() {
+		c.function = enclosing
+	}()
+
+	// Parameters left out of a call take their defaults, evaluated inside
+	// the function so they can refer to the parameters before them
+	for j, param := range decl.Parameters {
+		if param.Default == nil {
+			continue
+		}
+		skip := c.emit(Instruction{Op: OpDefault, Name: param.Name, Pos: param.Default.Pos()})
+		if err := c.compileExpression(param.Default); err != nil {
+			return 0, err
+		}
+		c.emit(Instruction{Op: OpBindParameter, Arg: j, Pos: param.Default.Pos()})
+		c.patch(skip)
+	}
+
+	if err := c.compileBlock(decl.Body); err != nil {
+		return 0, err
+	}
+	// Running off the end of the body returns void
+	c.emitConstant(types.VoidValue{}, decl.Pos())
+	c.emit(Instruction{Op: OpReturn, Pos: decl.Pos()})
+	return index, nil
+}
+
+func (c *Compiler) compileExpression(expr ast.Expression) error {
+	switch e := expr.(type) {
+	case *ast.Literal:
+		value, err := interpreter.LiteralValue(e)
+		if err != nil {
+			return &Error{Line: e.Line, Column: e.Column, Message: err.Error()}
+		}
+		c.emitConstant(value, e.Pos())
+	case *ast.Identifier:
+		c.emit(Instruction{Op: OpLoad, Name: e.Name, Pos: e.Pos()})
+	case *ast.ListLiteral:
+		for _, element := range e.Elements {
+			if err := c.compileExpression(element); err != nil {
+				return err
+			}
+		}
+		c.emit(Instruction{Op: OpList, Arg: len(e.Elements), Pos: e.Pos()})
+	case *ast.IndexExpression:
+		if err := c.compileExpression(e.Target); err != nil {
+			return err
+		}
+		if err := c.compileExpression(e.Index); err != nil {
+			return err
+		}
+		c.emit(Instruction{Op: OpIndex, Pos: e.Pos()})
+	case *ast.SliceExpression:
+		if err := c.compileExpression(e.Target); err != nil {
+			return err
+		}
+		bounds := 0
+		if e.Start != nil {
+			if err := c.compileExpression(e.Start); err != nil {
+				return err
+			}
+			bounds |= 1
+		}
+		if e.End != nil {
+			if err := c.compileExpression(e.End); err != nil {
+				return err
+			}
+			bounds |= 2
+		}
+		c.emit(Instruction{Op: OpSlice, Arg: bounds, Pos: e.Pos()})
+	case *ast.BinaryExpression:
+		if err := c.compileExpression(e.Left); err != nil {
+			return err
+		}
+		shortCircuit := -1
+		if e.Operator == "and" || e.Operator == "or" {
+			shortCircuit = c.emit(Instruction{Op: OpShortCircuit, Operator: e.Operator, Pos: e.Pos()})
+		}
+		if err := c.compileExpression(e.Right); err != nil {
+			return err
+		}
+		c.emit(Instruction{Op: OpBinary, Operator: e.Operator, Pos: e.Pos()})
+		if shortCircuit >= 0 {
+			c.patch(shortCircuit)
+		}
+	case *ast.UnaryExpression:
+		if err := c.compileExpression(e.Operand); err != nil {
+			return err
+		}
+		c.emit(Instruction{Op: OpUnary, Operator: e.Operator, Pos: e.Pos()})
+	case *ast.FunctionCall:
+		// The function is resolved before its arguments are evaluated, so an
+		// undefined function is reported ahead of any error in the arguments
+		switch {
+		case e.Method:
+			c.emit(Instruction{Op: OpMethod, Name: e.Name, Pos: e.Pos()})
+		case e.Callee != nil:
+			if err := c.compileExpression(e.Callee); err != nil {
+				return err
+			}
+			c.emit(Instruction{Op: OpCalleeValue, Pos: e.Pos()})
+		default:
+			c.emit(Instruction{Op: OpCallee, Name: e.Name, Pos: e.Pos()})
+		}
+		for _, arg := range e.Arguments {
+			if err := c.compileExpression(arg); err != nil {
+				return err
+			}
+		}
+		c.emit(Instruction{Op: OpCall, Arg: len(e.Arguments), Pos: e.Pos()})
+	case *ast.FunctionLiteral:
+		index, err := c.compileFunction(e.Function)
+		if err != nil {
+			return err
+		}
+		c.emit(Instruction{Op: OpClosure, Arg: index, Pos: e.Pos()})
+	default:
+		pos := expr.Pos()
+		return &Error{Line: pos.Line, Column: pos.Column, Message: fmt.Sprintf("cannot compile expression %T", expr)}
+	}
+	return nil
+}
@@ -0,0 +1,509 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"simplelang/internal/ast"
+	"simplelang/internal/interpreter"
+	"simplelang/internal/types"
+	"time"
+)
+
+// iterator is the state of a running 'for' statement
+type iterator struct {
+	elements []types.Value
+	index    int
+}
+
+// frame is a function call in progress
+type frame struct {
+	function  *Function
+	ip        int
+	argc      int
+	scopes    []*interpreter.Environment
+	counters  []interpreter.LoopCounter
+	iterators []iterator
+}
+
+func (f *frame) scope() *interpreter.Environment {
+	return f.scopes[len(f.scopes)-1]
+}
+
+// handler is a try statement whose body is running: where its catch body
+// starts, and how far the VM unwinds to reach it if the body fails
+type handler struct {
+	catch     int
+	frames    int
+	scopes    int
+	counters  int
+	iterators int
+	stack     int
+	depth     int
+}
+
+// callee is a function resolved by OpCallee, waiting on the stack beneath its
+// arguments. A nil function means a built-in.
+type callee struct {
+	name     string
+	function *interpreter.FunctionValue
+}
+
+func (c callee) Type() types.Type { return types.VoidType{} }
+func (c callee) String() string   { return c.name }
+
+// VM is a stack machine that runs compiled bytecode. It shares its scoping
+// rules, operators and error messages with the interpreter, so a program
+// behaves the same on either backend.
+type VM struct {
+	bytecode  *Bytecode
+	globals   *interpreter.Environment
+	declared  *interpreter.FunctionTable
+	functions map[*ast.FunctionDeclaration]*Function
+	builtins  *interpreter.Interpreter
+	output    io.Writer
+	stack     []types.Value
+	frames    []*frame
+	handlers  []handler
+	depth     int
+	maxDepth  int
+}
+
+// New creates a VM for bytecode that prints to standard output
+func New(bytecode *Bytecode) *VM {
+	functions := make(map[*ast.FunctionDeclaration]*Function)
+	for _, function := range bytecode.Functions {
+		functions[function.Declaration] = function
+	}
+	globals := interpreter.NewEnvironment(nil)
+	declared := interpreter.NewFunctionTable(globals)
+	// Built-ins such as map call back into functions on the interpreter,
+	// which must find the same top-level functions
+	builtins := interpreter.NewInterpreter()
+	builtins.SetFunctionTable(declared)
+	return &VM{
+		bytecode:  bytecode,
+		globals:   globals,
+		declared:  declared,
+		functions: functions,
+		builtins:  builtins,
+		output:    os.Stdout,
+		maxDepth:  interpreter.DefaultMaxDepth,
+	}
+}
+
+// SetMaxDepth limits how deeply function calls may nest before the program
+// fails with a recursion error
+func (vm *VM) SetMaxDepth(n int) {
+	vm.maxDepth = n
+	vm.builtins.SetMaxDepth(n)
+}
+
+// SetSeed seeds the source random and randomInt draw from, as
+// Interpreter.SetSeed does, so the same seed gives the same numbers on
+// either backend
+func (vm *VM) SetSeed(seed int64) {
+	vm.builtins.SetSeed(seed)
+}
+
+// SetClock makes now read the time from clock instead of the system clock
+func (vm *VM) SetClock(clock func() time.Time) {
+	vm.builtins.SetClock(clock)
+}
+
+// SetBaseDir sets the directory that include and import statements resolve
+// their paths against, as Interpreter.SetBaseDir does
+func (vm *VM) SetBaseDir(dir string) {
+	vm.builtins.SetBaseDir(dir)
+}
+
+// SetOutput redirects everything the program prints to w
+func (vm *VM) SetOutput(w io.Writer) {
+	vm.output = w
+	vm.builtins.SetOutput(w)
+}
+
+// Run executes the main program
+func (vm *VM) Run() error {
+	vm.stack = vm.stack[:0]
+	vm.handlers = nil
+	vm.depth = 0
+	vm.frames = []*frame{{
+		function: vm.bytecode.Main,
+		scopes:   []*interpreter.Environment{vm.globals},
+	}}
+
+	for {
+		f := vm.frames[len(vm.frames)-1]
+		if f.ip >= len(f.function.Code) {
+			// Only the main program runs off its end; functions return
+			return nil
+		}
+
+		instruction := f.function.Code[f.ip]
+		f.ip++
+		if err := vm.execute(f, instruction); err != nil {
+			failure := runtimeError(instruction.Pos, err)
+			if !vm.catch(failure) {
+				return failure
+			}
+		}
+	}
+}
+
+// catch unwinds to the innermost running try statement, if there is one, and
+// continues at its catch body with the error message on the stack
+func (vm *VM) catch(err *interpreter.RuntimeError) bool {
+	if len(vm.handlers) == 0 {
+		return false
+	}
+	h := vm.handlers[len(vm.handlers)-1]
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	vm.frames = vm.frames[:h.frames]
+	f := vm.frames[len(vm.frames)-1]
+	f.scopes = f.scopes[:h.scopes]
+	f.counters = f.counters[:h.counters]
+	f.iterators = f.iterators[:h.iterators]
+	f.ip = h.catch
+	vm.stack = vm.stack[:h.stack]
+	vm.depth = h.depth
+
+	vm.push(types.TextValue{Value: err.Message})
+	return true
+}
+
+// runtimeError attaches the position of the failing instruction to err in
+// the interpreter's format
+func runtimeError(pos ast.Position, err error) *interpreter.RuntimeError {
+	if runtimeErr, ok := err.(*interpreter.RuntimeError); ok {
+		return runtimeErr
+	}
+	return &interpreter.RuntimeError{Line: pos.Line, Column: pos.Column, Message: err.Error()}
+}
+
+func (vm *VM) push(value types.Value) {
+	vm.stack = append(vm.stack, value)
+}
+
+func (vm *VM) pop() types.Value {
+	value := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return value
+}
+
+// popN removes the top n values, returning them in the order they were pushed
+func (vm *VM) popN(n int) []types.Value {
+	values := make([]types.Value, n)
+	copy(values, vm.stack[len(vm.stack)-n:])
+	vm.stack = vm.stack[:len(vm.stack)-n]
+	return values
+}
+
+func (vm *VM) peek() types.Value {
+	return vm.stack[len(vm.stack)-1]
+}
+
+func (vm *VM) execute(f *frame, in Instruction) error {
+	switch in.Op {
+	case OpConstant:
+		vm.push(vm.bytecode.Constants[in.Arg])
+	case OpPop:
+		vm.pop()
+	case OpDup:
+		vm.push(vm.peek())
+	case OpList:
+		vm.push(types.ListValue{Elements: vm.popN(in.Arg)})
+	case OpUnpack:
+		elements, err := interpreter.Unpack(vm.pop(), in.Arg)
+		if err != nil {
+			return err
+		}
+		for j := len(elements) - 1; j >= 0; j-- {
+			vm.push(elements[j])
+		}
+
+	case OpLoad:
+		value, err := interpreter.LookupName(f.scope(), vm.declared, in.Name)
+		if err != nil {
+			return err
+		}
+		vm.push(value)
+	case OpDeclare:
+		return interpreter.DeclareVariable(f.scope(), in.Name, in.Type, vm.pop())
+	case OpAssign:
+		return f.scope().AssignVariable(in.Name, vm.pop())
+	case OpShorthand:
+		return interpreter.CheckShorthand(f.scope(), in.Name, in.Operator)
+	case OpEnterScope:
+		f.scopes = append(f.scopes, interpreter.NewEnvironment(f.scope()))
+	case OpExitScope:
+		f.scopes = f.scopes[:len(f.scopes)-1]
+
+	case OpBinary:
+		right := vm.pop()
+		left := vm.pop()
+		result, err := interpreter.ApplyBinary(in.Operator, left, right)
+		if err != nil {
+			return err
+		}
+		vm.push(result)
+	case OpUnary:
+		result, err := interpreter.ApplyUnary(in.Operator, vm.pop())
+		if err != nil {
+			return err
+		}
+		vm.push(result)
+	case OpShortCircuit:
+		done, err := interpreter.ShortCircuit(in.Operator, vm.peek())
+		if err != nil {
+			return err
+		}
+		if done {
+			f.ip = in.Arg
+		}
+	case OpIndex:
+		index := vm.pop()
+		result, err := interpreter.Index(vm.pop(), index)
+		if err != nil {
+			return err
+		}
+		vm.push(result)
+	case OpSlice:
+		var start, end types.Value
+		if in.Arg&2 != 0 {
+			end = vm.pop()
+		}
+		if in.Arg&1 != 0 {
+			start = vm.pop()
+		}
+		result, err := interpreter.Slice(vm.pop(), start, end)
+		if err != nil {
+			return err
+		}
+		vm.push(result)
+
+	case OpJump:
+		f.ip = in.Arg
+	case OpJumpIfFalse:
+		value := vm.pop()
+		condition, ok := value.(types.BooleanValue)
+		if !ok {
+			return fmt.Errorf("condition must be boolean, got %s", value.Type().String())
+		}
+		if !condition.Value {
+			f.ip = in.Arg
+		}
+	case OpLoopBounds:
+		if !isNumeric(vm.stack[len(vm.stack)-2]) || !isNumeric(vm.peek()) {
+			return fmt.Errorf("loop bounds must be numbers")
+		}
+	case OpLoopStart:
+		if err := vm.startLoop(f, in.Arg == 1); err != nil {
+			return err
+		}
+		return interpreter.CheckLoopVariable(f.scope(), in.Name)
+	case OpLoopNext:
+		c := &f.counters[len(f.counters)-1]
+		if !c.Done() {
+			f.scope().SetVariable(in.Name, c.Value())
+		} else {
+			f.counters = f.counters[:len(f.counters)-1]
+			f.ip = in.Arg
+		}
+	case OpLoopIncrement:
+		f.counters[len(f.counters)-1].Advance()
+	case OpIterStart:
+		iterable := vm.pop()
+		list, ok := iterable.(types.ListValue)
+		if !ok {
+			return fmt.Errorf("cannot iterate over %s, expected a list", iterable.Type().String())
+		}
+		if err := interpreter.CheckLoopVariable(f.scope(), in.Name); err != nil {
+			return err
+		}
+		f.iterators = append(f.iterators, iterator{elements: list.Elements})
+	case OpIterNext:
+		it := &f.iterators[len(f.iterators)-1]
+		if it.index < len(it.elements) {
+			f.scope().SetVariable(in.Name, it.elements[it.index])
+			it.index++
+		} else {
+			f.iterators = f.iterators[:len(f.iterators)-1]
+			f.ip = in.Arg
+		}
+	case OpTry:
+		vm.handlers = append(vm.handlers, handler{
+			catch:     in.Arg,
+			frames:    len(vm.frames),
+			scopes:    len(f.scopes),
+			counters:  len(f.counters),
+			iterators: len(f.iterators),
+			stack:     len(vm.stack),
+			depth:     vm.depth,
+		})
+	case OpEndTry:
+		vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	case OpFunction:
+		decl := vm.bytecode.Functions[in.Arg].Declaration
+		vm.declared.Declare(f.scope(), decl)
+	case OpClosure:
+		decl := vm.bytecode.Functions[in.Arg].Declaration
+		vm.push(interpreter.FunctionValue{Declaration: decl, Closure: f.scope()})
+	case OpCallee:
+		function, err := interpreter.LookupCallee(f.scope(), vm.declared, in.Name)
+		if err != nil {
+			return err
+		}
+		return vm.pushCallee(in.Name, function)
+	case OpCalleeValue:
+		function, err := interpreter.CalleeValue(vm.pop())
+		if err != nil {
+			return err
+		}
+		return vm.pushCallee(function.Declaration.Name, function)
+	case OpMethod:
+		if err := interpreter.CheckMethod(in.Name); err != nil {
+			return err
+		}
+		return vm.pushCallee(in.Name, nil)
+	case OpDefault:
+		for j, param := range f.function.Declaration.Parameters {
+			if param.Name == in.Name && j < f.argc {
+				f.ip = in.Arg
+			}
+		}
+	case OpBindParameter:
+		return interpreter.BindParameter(f.scope(), f.function.Declaration, in.Arg, vm.pop())
+	case OpCall:
+		return vm.call(vm.popN(in.Arg))
+	case OpReturn:
+		// The return value stays on the stack for the caller, and any try
+		// statements the function is leaving no longer apply
+		for len(vm.handlers) > 0 && vm.handlers[len(vm.handlers)-1].frames == len(vm.frames) {
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+		}
+		vm.frames = vm.frames[:len(vm.frames)-1]
+		vm.depth--
+
+	case OpPrint:
+		fmt.Fprintln(vm.output, vm.pop().String())
+	case OpWrite:
+		fmt.Fprint(vm.output, vm.pop().String())
+	case OpAssert:
+		value := vm.pop()
+		condition, ok := value.(types.BooleanValue)
+		if !ok {
+			return fmt.Errorf("condition must be boolean, got %s", value.Type().String())
+		}
+		if condition.Value {
+			f.ip = in.Arg
+		}
+	case OpAssertFail:
+		return interpreter.AssertionFailed(vm.pop())
+	case OpRaise:
+		return interpreter.Raise(vm.pop())
+	case OpInclude:
+		return vm.builtins.Include(f.scope(), in.Name)
+	case OpImport:
+		path := vm.pop().(types.TextValue)
+		return vm.builtins.Import(f.scope(), path.Value, in.Name)
+	default:
+		return fmt.Errorf("unknown opcode: %d", in.Op)
+	}
+	return nil
+}
+
+// startLoop pops the bounds and optional step of a 'loop' statement and
+// starts counting, as the interpreter does
+func (vm *VM) startLoop(f *frame, hasStep bool) error {
+	var stepValue types.Value
+	if hasStep {
+		stepValue = vm.pop()
+	}
+	toValue := vm.pop()
+	fromValue := vm.pop()
+	counter, err := interpreter.NewLoopCounter(fromValue, toValue, stepValue)
+	if err != nil {
+		return err
+	}
+	f.counters = append(f.counters, counter)
+	return nil
+}
+
+// pushCallee pushes a resolved function for a later OpCall, counting it
+// towards the recursion limit unless it is a built-in
+func (vm *VM) pushCallee(name string, function *interpreter.FunctionValue) error {
+	if function != nil {
+		vm.depth++
+		if vm.depth > vm.maxDepth {
+			return fmt.Errorf("maximum recursion depth exceeded (%d)", vm.maxDepth)
+		}
+	}
+	vm.push(callee{name: name, function: function})
+	return nil
+}
+
+// call invokes the callee beneath args. A declared function runs in a new
+// frame whose scope sees its parameters and the environment it was declared
+// in. A built-in runs on the interpreter, which also walks the body
+// of any function the built-in calls back, such as the one passed to map,
+// and of any function declared by an included file, which was never
+// compiled.
+func (vm *VM) call(args []types.Value) error {
+	c := vm.pop().(callee)
+	if c.function == nil {
+		caller := vm.frames[len(vm.frames)-1]
+		result, err := vm.builtins.CallBuiltin(caller.scope(), c.name, args)
+		if err != nil {
+			return err
+		}
+		vm.push(result)
+		return nil
+	}
+
+	function, compiled := vm.functions[c.function.Declaration]
+	if !compiled {
+		// No frame is pushed, so nothing returns to undo pushCallee's count
+		vm.depth--
+		result, err := vm.builtins.CallFunction(*c.function, args)
+		if err != nil {
+			return err
+		}
+		vm.push(result)
+		return nil
+	}
+
+	env := interpreter.NewEnvironment(c.function.Closure)
+	if err := interpreter.BindArguments(env, c.function.Declaration, args); err != nil {
+		return err
+	}
+
+	vm.frames = append(vm.frames, &frame{
+		function: function,
+		argc:     len(args),
+		scopes:   []*interpreter.Environment{env},
+	})
+	return nil
+}
+
+func isNumeric(value types.Value) bool {
+	switch value.(type) {
+	case types.IntValue, types.NumberValue:
+		return true
+	default:
+		return false
+	}
+}
+
+func toFloat(value types.Value) float64 {
+	switch v := value.(type) {
+	case types.IntValue:
+		return float64(v.Value)
+	case types.NumberValue:
+		return v.Value
+	default:
+		return 0
+	}
+}
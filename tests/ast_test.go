@@ -0,0 +1,238 @@
+package tests
+
+import (
+	"encoding/json"
+	"os"
+	"simplelang/internal/ast"
+	"simplelang/internal/lexer"
+	"simplelang/internal/parser"
+	"testing"
+)
+
+// parseProgram lexes and parses source, failing the test on any error
+func parseProgram(t testing.TB, source string) *ast.Program {
+	t.Helper()
+
+	tokens, err := lexer.NewLexer(source).Tokenize()
+	if err != nil {
+		t.Fatalf("Lexer failed: %v", err)
+	}
+
+	program, err := parser.NewParser(tokens).Parse()
+	if err != nil {
+		t.Fatalf("Parser failed: %v", err)
+	}
+	return program
+}
+
+func TestPrettyPrinter(t *testing.T) {
+	source := `number x = 2 + 3
+if x > 4 then
+    print "big"
+else
+    print -x
+end
+function greet(text name)
+    print "Hello " + name
+end
+greet("Sam")`
+
+	expected := `Program
+  VariableDeclaration x: number
+    BinaryExpression +
+      Literal integer 2
+      Literal integer 3
+  IfStatement
+    Condition
+      BinaryExpression >
+        Identifier x
+        Literal integer 4
+    Then
+      PrintStatement
+        Literal text "big"
+    Else
+      PrintStatement
+        UnaryExpression -
+          Identifier x
+  FunctionDeclaration greet(text name)
+    Body
+      PrintStatement
+        BinaryExpression +
+          Literal text "Hello "
+          Identifier name
+  ExpressionStatement
+    FunctionCall greet
+      Literal text "Sam"
+`
+
+	got := ast.NewPrettyPrinter().Print(parseProgram(t, source))
+	if got != expected {
+		t.Errorf("Unexpected tree.\nExpected:\n%s\nGot:\n%s", expected, got)
+	}
+}
+
+func TestFormatterNormalizesSource(t *testing.T) {
+	source := `number   x=1+2*3
+if x>5 then print "big"
+else
+print (x-1)*2
+end
+loop i from 1 to x print i end
+switch x case 7 print "seven" default print "other" end
+print not(x<1)
+for n in [1,2 ,x] print n end
+loop i from x to 0 step -2 print i end
+integer a=1,b=2
+let  p ,q=[1,2]
+let  s="x"
+let ch='\''
+let bell = "\u{7}\u00e9\u{1F600}"+'\u000A'
+include   "lib/utils.sl"
+import "math.sl"   as   m
+print m . add(1,2)+m.calls
+enum Color red
+  green end
+print Color . red
+repeat a++ until a>3
+a ++
+print - -a
+print f (1) ( 2 )
+print s . upper ( ) .length()+ (1).toText().length()
+function g(text a,integer b=1+1) print a end
+if true then let h = function(integer n) return n*2 ,n end end
+write  "x"
+assert x>1 ,"big"
+try print 1/0 catch e raise "again: "+e end`
+
+	expected := `number x = 1 + 2 * 3
+if x > 5 then
+    print "big"
+else
+    print (x - 1) * 2
+end
+loop i from 1 to x
+    print i
+end
+switch x
+case 7
+    print "seven"
+default
+    print "other"
+end
+print not (x < 1)
+for n in [1, 2, x]
+    print n
+end
+loop i from x to 0 step -2
+    print i
+end
+integer a = 1, b = 2
+let p, q = [1, 2]
+let s = "x"
+let ch = '\''
+let bell = "\u0007é😀" + '\n'
+include "lib/utils.sl"
+import "math.sl" as m
+print m.add(1, 2) + m.calls
+enum Color red green end
+print Color.red
+repeat
+    a++
+until a > 3
+a++
+print - -a
+print f(1)(2)
+print s.upper().length() + (1).toText().length()
+
+function g(text a, integer b = 1 + 1)
+    print a
+end
+
+if true then
+    let h = function(integer n)
+        return n * 2, n
+    end
+end
+write "x"
+assert x > 1, "big"
+try
+    print 1 / 0
+catch e
+    raise "again: " + e
+end
+`
+
+	got := ast.NewFormatter().Format(parseProgram(t, source))
+	if got != expected {
+		t.Errorf("Unexpected formatting.\nExpected:\n%s\nGot:\n%s", expected, got)
+	}
+}
+
+func TestFormatterIsIdempotent(t *testing.T) {
+	for _, path := range []string{"../examples/if_else.sl", "../examples/loops.sl", "../examples/functions.sl"} {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+
+		once := ast.NewFormatter().Format(parseProgram(t, string(source)))
+		twice := ast.NewFormatter().Format(parseProgram(t, once))
+		if once != twice {
+			t.Errorf("Formatting %s is not a fixed point.\nFirst pass:\n%s\nSecond pass:\n%s", path, once, twice)
+		}
+	}
+}
+
+func TestJSONEncoder(t *testing.T) {
+	source := `let total = 2.50
+function add(integer a, integer b = 1)
+    return a + b
+end
+print add(3) * -total
+text? nothing = nil`
+
+	encoded, err := ast.NewJSONEncoder().Encode(parseProgram(t, source))
+	if err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	var root map[string]interface{}
+	if err := json.Unmarshal(encoded, &root); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\n%s", err, encoded)
+	}
+	if root["kind"] != "Program" {
+		t.Errorf("Expected a Program root, got %v", root["kind"])
+	}
+	statements, ok := root["statements"].([]interface{})
+	if !ok || len(statements) != 4 {
+		t.Fatalf("Expected 4 statements, got %v", root["statements"])
+	}
+
+	declaration := statements[0].(map[string]interface{})
+	value := declaration["value"].(map[string]interface{})
+	if declaration["kind"] != "VariableDeclaration" || declaration["type"] != nil || declaration["line"] != 1.0 {
+		t.Errorf("Unexpected declaration %v", declaration)
+	}
+	if value["kind"] != "Literal" || value["type"] != "number" || value["value"] != 2.5 {
+		t.Errorf("Unexpected literal %v", value)
+	}
+
+	function := statements[1].(map[string]interface{})
+	params := function["parameters"].([]interface{})
+	if function["name"] != "add" || len(params) != 2 || params[1].(map[string]interface{})["default"] == nil {
+		t.Errorf("Unexpected function %v", function)
+	}
+
+	product := statements[2].(map[string]interface{})["value"].(map[string]interface{})
+	if product["kind"] != "BinaryExpression" || product["operator"] != "*" {
+		t.Errorf("Unexpected expression %v", product)
+	}
+	if call := product["left"].(map[string]interface{}); call["kind"] != "FunctionCall" || call["name"] != "add" {
+		t.Errorf("Unexpected call %v", call)
+	}
+
+	nilValue := statements[3].(map[string]interface{})["value"].(map[string]interface{})
+	if statements[3].(map[string]interface{})["type"] != "text?" || nilValue["value"] != nil {
+		t.Errorf("Unexpected nil declaration %v", statements[3])
+	}
+}
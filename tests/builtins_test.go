@@ -0,0 +1,745 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"simplelang/internal/interpreter"
+	"simplelang/internal/types"
+	"simplelang/internal/vm"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestToNumber(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{`print toNumber("3.5") + 1`, "4.5\n"},
+		{`print toNumber(" 42 ")`, "42\n"},
+		{`print toNumber(7)`, "7\n"},
+		{`print toNumber(2.25)`, "2.25\n"},
+		{`number x = toNumber("-1e3")` + "\nprint x", "-1000\n"},
+	}
+
+	for _, c := range cases {
+		out, err := runProgram(t, c.source)
+		if err != nil {
+			t.Errorf("%q failed: %v", c.source, err)
+			continue
+		}
+		if out != c.expected {
+			t.Errorf("%q: expected %q, got %q", c.source, c.expected, out)
+		}
+	}
+}
+
+func TestToNumberRejectsNonNumericText(t *testing.T) {
+	_, err := runProgram(t, `print toNumber("abc")`)
+	if err == nil || !strings.Contains(err.Error(), `toNumber: cannot convert "abc" to a number`) {
+		t.Errorf("Expected a conversion error, got %v", err)
+	}
+
+	_, err = runProgram(t, `print toNumber(1 < 2)`)
+	if err == nil || !strings.Contains(err.Error(), "toNumber: cannot convert boolean to a number") {
+		t.Errorf("Expected a conversion error, got %v", err)
+	}
+}
+
+func TestToText(t *testing.T) {
+	out, err := runProgram(t, "text a = toText(12)\ntext b = toText(1 < 2)\nprint a + b + toText(0.5) + toText(nil)")
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if out != "12true0.5nil\n" {
+		t.Errorf("Unexpected output: %q", out)
+	}
+}
+
+func TestType(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{`print type(12)`, "integer\n"},
+		{`print type(1.5)`, "number\n"},
+		{`print type("12")`, "text\n"},
+		{`print type(1 < 2)`, "boolean\n"},
+		{`print type([1, 2])`, "list\n"},
+		{`print type(nil)`, "nil\n"},
+		{"function f()\nend\nprint type(f) + \" \" + type(f())", "function void\n"},
+		{`print type(7 / 2) + " " + type(7 % 2) + " " + type("a" + 1)`, "number integer text\n"},
+		{`print type(type(1))`, "text\n"},
+	}
+
+	for _, c := range cases {
+		out, err := runProgram(t, c.source)
+		if err != nil {
+			t.Errorf("For %q: %v", c.source, err)
+			continue
+		}
+		if out != c.expected {
+			t.Errorf("For %q expected %q, got %q", c.source, c.expected, out)
+		}
+	}
+}
+
+func TestToBoolean(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{`print toBoolean("true")`, "true\n"},
+		{`print toBoolean("false")`, "false\n"},
+		{`print toBoolean(0)`, "false\n"},
+		{`print toBoolean(0.0)`, "false\n"},
+		{`print toBoolean(-3)`, "true\n"},
+		{`print toBoolean(1 > 2)`, "false\n"},
+	}
+
+	for _, c := range cases {
+		out, err := runProgram(t, c.source)
+		if err != nil {
+			t.Errorf("%q failed: %v", c.source, err)
+			continue
+		}
+		if out != c.expected {
+			t.Errorf("%q: expected %q, got %q", c.source, c.expected, out)
+		}
+	}
+
+	for _, source := range []string{`print toBoolean("yes")`, `print toBoolean(nil)`} {
+		if _, err := runProgram(t, source); err == nil || !strings.Contains(err.Error(), "toBoolean: cannot convert") {
+			t.Errorf("%q: expected a conversion error, got %v", source, err)
+		}
+	}
+}
+
+func TestConcat(t *testing.T) {
+	source := `list a = [1, "two"]
+list b = [[3], 4.5]
+list both = concat(a, b)
+print both
+print concat(b, a)
+print concat([], a)
+print a`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if out != "[1, \"two\", [3], 4.5]\n[[3], 4.5, 1, \"two\"]\n[1, \"two\"]\n[1, \"two\"]\n" {
+		t.Errorf("Unexpected output: %q", out)
+	}
+}
+
+func TestJoin(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{`print join(["a", "b", "c"], ", ")`, "a, b, c\n"},
+		{`print join(["solo"], "-")`, "solo\n"},
+		{`print join(["x", "y"], "")`, "xy\n"},
+		{`print join([], ", ") == ""`, "true\n"},
+	}
+
+	for _, c := range cases {
+		out, err := runProgram(t, c.source)
+		if err != nil {
+			t.Errorf("%q failed: %v", c.source, err)
+			continue
+		}
+		if out != c.expected {
+			t.Errorf("%q: expected %q, got %q", c.source, c.expected, out)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{`print split("a,b,,c", ",")`, `["a", "b", "", "c"]` + "\n"},
+		{`print split("one, two", ", ")`, `["one", "two"]` + "\n"},
+		{`print split("abc", "x")`, `["abc"]` + "\n"},
+		{`print split("naïve", "")`, `["n", "a", "ï", "v", "e"]` + "\n"},
+		{`print length(split("", ","))`, "1\n"},
+		{`print split("", ",")[0] == ""`, "true\n"},
+		{`print length(split("", ""))`, "0\n"},
+		{`print join(split("a-b", "-"), "+")`, "a+b\n"},
+		{`print "x y".split(" ").length()`, "2\n"},
+		{`print splitLines("first\nsecond\r\nthird\n")`, `["first", "second", "third"]` + "\n"},
+		{`print splitLines("a\n\nb")`, `["a", "", "b"]` + "\n"},
+		{`print length(splitLines("")) + length(splitLines("\n"))`, "1\n"},
+	}
+
+	for _, c := range cases {
+		out, err := runProgram(t, c.source)
+		if err != nil {
+			t.Errorf("%q failed: %v", c.source, err)
+			continue
+		}
+		if out != c.expected {
+			t.Errorf("%q: expected %q, got %q", c.source, c.expected, out)
+		}
+	}
+}
+
+func TestListBuiltinErrors(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{`print concat([1], "2")`, "concat: argument 2 must be a list, got text"},
+		{`print concat(1, [2])`, "concat: argument 1 must be a list, got integer"},
+		{`print join("abc", "")`, "join: first argument must be a list, got text"},
+		{`print join(["a"], 1)`, "join: separator must be text, got integer"},
+		{`print join(["a", 2], ",")`, "join: element 1 is integer, expected text"},
+		{`print split(["a"], ",")`, "split: argument 1 must be text, got list"},
+		{`print split("a", 1)`, "split: argument 2 must be text, got integer"},
+		{`print splitLines(1)`, "splitLines: argument 1 must be text, got integer"},
+	}
+
+	for _, c := range cases {
+		_, err := runProgram(t, c.source)
+		if err == nil || !strings.HasSuffix(err.Error(), c.expected) {
+			t.Errorf("%q: expected %q, got %v", c.source, c.expected, err)
+		}
+	}
+}
+
+func TestMapFilterReduce(t *testing.T) {
+	source := `function double(number x)
+    return x * 2
+end
+list numbers = [1, 2, 3, 4, 5, 6]
+print map(numbers, double)
+print filter(numbers, function(integer n) return n % 2 == 0 end)
+print reduce(numbers, function(integer total, integer n) return total + n end, 0)
+print reduce([], double, "start")
+print map(filter(numbers, function(integer n) return n > 4 end), toText)`
+
+	out, err := runProgram(t, source)
+	if err == nil || !strings.HasSuffix(err.Error(), "undefined variable: toText") {
+		t.Fatalf("Expected built-ins not to be values, got %v", err)
+	}
+	if out != "[2, 4, 6, 8, 10, 12]\n[2, 4, 6]\n21\nstart\n" {
+		t.Errorf("Unexpected output: %q", out)
+	}
+}
+
+func TestCallbackSeesItsClosure(t *testing.T) {
+	source := `integer calls = 0
+function count(text s)
+    calls++
+    return s + calls
+end
+print map(["a", "b"], count)
+print calls`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if out != "[\"a1\", \"b2\"]\n2\n" {
+		t.Errorf("Unexpected output: %q", out)
+	}
+}
+
+func TestHigherOrderBuiltinErrors(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{"print map(3, function(integer n) return n end)", "map: first argument must be a list, got integer"},
+		{"print filter([1], 1)", "filter: second argument must be a function, got integer"},
+		{"print filter([1, 2], function(integer n) return n end)", "filter: predicate must return a boolean, got integer"},
+		{"print reduce([1], function(integer n) return n end, 0)", "lambda expects 1 arguments, got 2"},
+		{"print map([1, 0], function(integer n) return 1 / n end)", "runtime error at line 1, column 48: division by zero"},
+		{"function f(integer n)\n    return map([n], f)\nend\nprint f(1)", "maximum recursion depth exceeded (1000)"},
+	}
+
+	for _, c := range cases {
+		_, err := runProgram(t, c.source)
+		if err == nil || !strings.HasSuffix(err.Error(), c.expected) {
+			t.Errorf("%q: expected %q, got %v", c.source, c.expected, err)
+		}
+	}
+}
+
+func TestBuiltinArgumentCount(t *testing.T) {
+	_, err := runProgram(t, `print toText(1, 2)`)
+	if err == nil || !strings.Contains(err.Error(), "function toText expects 1 arguments, got 2") {
+		t.Errorf("Expected an argument count error, got %v", err)
+	}
+}
+
+func TestDeclaredFunctionShadowsBuiltin(t *testing.T) {
+	source := `function toText(number n)
+    print "mine"
+end
+toText(1)`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if out != "mine\n" {
+		t.Errorf("Expected the declared function to run, got %q", out)
+	}
+}
+
+// runWithHost runs source on an interpreter with the host function double,
+// which doubles a number, and log, which records its argument
+func runWithHost(t *testing.T, source string) (string, []string, error) {
+	t.Helper()
+
+	var out bytes.Buffer
+	var logged []string
+	interp := interpreter.NewInterpreter()
+	interp.SetOutput(&out)
+	interp.RegisterBuiltin("double", func(args []types.Value) (types.Value, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("double expects 1 argument, got %d", len(args))
+		}
+		switch n := args[0].(type) {
+		case types.IntValue:
+			return types.NewInteger(n.Value * 2), nil
+		case types.NumberValue:
+			return types.NewNumber(n.Value * 2), nil
+		}
+		return nil, fmt.Errorf("double expects a number, got %s", args[0].Type())
+	})
+	interp.RegisterBuiltin("log", func(args []types.Value) (types.Value, error) {
+		logged = append(logged, args[0].String())
+		return nil, nil
+	})
+	err := interp.Interpret(parseProgram(t, source))
+	return out.String(), logged, err
+}
+
+func TestRegisterBuiltin(t *testing.T) {
+	out, logged, err := runWithHost(t, "integer n = double(21)\nprint n\nprint double(1.25) + 1\nlog(\"ran \" + n)\nprint type(log(1))")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "42\n3.5\nvoid\n"; out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+	if strings.Join(logged, ",") != "ran 42,1" {
+		t.Errorf("Expected the host to log two calls, got %q", logged)
+	}
+}
+
+func TestHostFunctionErrorIsARuntimeError(t *testing.T) {
+	_, _, err := runWithHost(t, "print \"before\"\nprint double(\"x\")")
+	if err == nil || err.Error() != "runtime error at line 2, column 7: double expects a number, got text" {
+		t.Errorf("Expected the host error at the call, got %v", err)
+	}
+}
+
+func TestDeclaredFunctionShadowsHostFunction(t *testing.T) {
+	out, _, err := runWithHost(t, "function double(integer n)\n    return n + n + 1\nend\nprint double(1)\nlet log = function(text s) print \"own \" + s end\nlog(\"x\")")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "3\nown x\n"; out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+}
+
+func TestDumpEnv(t *testing.T) {
+	source := `integer count = 3
+text label = "total"
+function show(number factor)
+    loop i from 1 to 1
+        dumpEnv()
+    end
+end
+show(1.5)`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `--- scope 1 ---
+i: integer = 1
+--- scope 2 ---
+factor: number = 1.5
+--- scope 3 ---
+count: integer = 3
+label: text = "total"
+`
+	if out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+}
+
+func TestTextBuiltins(t *testing.T) {
+	source := `print upper("Grüße 1")
+print lower("ÀB c")
+print length("naïve") + length([1, [2, 3]]) + length("")`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "GRÜßE 1\nàb c\n7\n"; out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+
+	for source, expected := range map[string]string{
+		"print upper(1)":    "runtime error at line 1, column 7: upper: argument must be text, got integer",
+		"print lower(nil)":  "runtime error at line 1, column 7: lower: argument must be text, got nil",
+		"print length(1.5)": "runtime error at line 1, column 7: length: argument must be text or a list, got number",
+	} {
+		_, err := runProgram(t, source)
+		if err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestTextSearchBuiltins(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{`print contains("haystack", "st")`, "true\n"},
+		{`print contains("haystack", "needle")`, "false\n"},
+		{`print contains("haystack", "")`, "true\n"},
+		{`print contains("", "")`, "true\n"},
+		{`print indexOf("haystack", "st")`, "3\n"},
+		{`print indexOf("haystack", "needle")`, "-1\n"},
+		{`print indexOf("haystack", "")`, "0\n"},
+		{`print indexOf("café au lait", "au")`, "5\n"},
+		{`print indexOf("日本語のテキスト", "テ")`, "4\n"},
+		{`print "naïve naïve"[indexOf("naïve naïve", "ve"):]`, "ve naïve\n"},
+		{`print replace("a-b-c", "-", " + ")`, "a + b + c\n"},
+		{`print replace("abc", "x", "y")`, "abc\n"},
+		{`print replace("ab", "", "-")`, "-a-b-\n"},
+		{`print replace("çaça", "ç", "c")`, "caca\n"},
+		{`print "a.b".replace(".", "").contains("ab")`, "true\n"},
+	}
+
+	for _, c := range cases {
+		out, err := runProgram(t, c.source)
+		if err != nil {
+			t.Errorf("%q failed: %v", c.source, err)
+			continue
+		}
+		if out != c.expected {
+			t.Errorf("%q: expected %q, got %q", c.source, c.expected, out)
+		}
+	}
+
+	for source, expected := range map[string]string{
+		`print contains(1, "1")`:         "runtime error at line 1, column 7: contains: argument 1 must be text, got integer",
+		`print indexOf("abc", ["a"])`:    "runtime error at line 1, column 7: indexOf: argument 2 must be text, got list",
+		`print replace("abc", "a", nil)`: "runtime error at line 1, column 7: replace: argument 3 must be text, got nil",
+		`print replace("abc", "a")`:      "runtime error at line 1, column 7: function replace expects 3 arguments, got 2",
+	} {
+		_, err := runProgram(t, source)
+		if err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestTrimAndPad(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{`print "[" + trim("  \t padded \n ") + "]"`, "[padded]\n"},
+		{`print "[" + trimLeft("  both  ") + "]"`, "[both  ]\n"},
+		{`print "[" + trimRight("  both  ") + "]"`, "[  both]\n"},
+		{`print trim("--a-b--", "-") + trimLeft("xxyx", "x") + trimRight("éaé", "é")`, "a-byxéa\n"},
+		{`print "[" + trim("") + "]"`, "[]\n"},
+		{`print "[" + padLeft("ab", 5) + "]"`, "[   ab]\n"},
+		{`print "[" + padRight("ab", 5) + "]"`, "[ab   ]\n"},
+		{`print padLeft("7", 3, "0") + padRight("x", 3.0, "·")`, "007x··\n"},
+		{`print padLeft("naïve", 6, "*")`, "*naïve\n"},
+		{`print padLeft("already long", 4) + padRight("exact", 5) + padLeft("", 0)`, "already longexact\n"},
+		{`print "ab".padLeft(4).trim()`, "ab\n"},
+	}
+
+	for _, c := range cases {
+		out, err := runProgram(t, c.source)
+		if err != nil {
+			t.Errorf("%q failed: %v", c.source, err)
+			continue
+		}
+		if out != c.expected {
+			t.Errorf("%q: expected %q, got %q", c.source, c.expected, out)
+		}
+	}
+
+	for source, expected := range map[string]string{
+		`print trim(1)`:                "runtime error at line 1, column 7: trim: argument 1 must be text, got integer",
+		`print trimLeft("a", 1)`:       "runtime error at line 1, column 7: trimLeft: argument 2 must be text, got integer",
+		`print trim("a", "b", "c")`:    "runtime error at line 1, column 7: function trim expects 1 to 2 arguments, got 3",
+		`print padLeft("a")`:           "runtime error at line 1, column 7: function padLeft expects 2 to 3 arguments, got 1",
+		`print padLeft(1, 2)`:          "runtime error at line 1, column 7: padLeft: argument 1 must be text, got integer",
+		`print padRight("a", -1)`:      "runtime error at line 1, column 7: padRight: width cannot be negative, got -1",
+		`print padRight("a", 2.5)`:     "runtime error at line 1, column 7: padRight: width must be a whole number, got 2.5",
+		`print padRight("a", "2")`:     "runtime error at line 1, column 7: padRight: width must be a whole number, got \"2\"",
+		`print padLeft("a", 3, "")`:    "runtime error at line 1, column 7: padLeft: padding must be a single character, got \"\"",
+		`print padLeft("a", 3, "ab")`:  "runtime error at line 1, column 7: padLeft: padding must be a single character, got \"ab\"",
+		`print padLeft("a", 3, [" "])`: "runtime error at line 1, column 7: padLeft: padding must be a single character, got list",
+	} {
+		_, err := runProgram(t, source)
+		if err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+}
+
+// runSeeded runs source on a new interpreter seeded with seed
+func runSeeded(t *testing.T, seed int64, source string) string {
+	t.Helper()
+
+	var out bytes.Buffer
+	interp := interpreter.NewInterpreter()
+	interp.SetOutput(&out)
+	interp.SetSeed(seed)
+	if err := interp.Interpret(parseProgram(t, source)); err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	return out.String()
+}
+
+func TestRandomWithSameSeedRepeats(t *testing.T) {
+	source := `loop i from 1 to 20
+    write random()
+    write " "
+    write randomInt(-5, 5)
+    write " "
+end`
+
+	first := runSeeded(t, 42, source)
+	if second := runSeeded(t, 42, source); second != first {
+		t.Errorf("Expected the same sequence from the same seed, got %q and %q", first, second)
+	}
+	if other := runSeeded(t, 7, source); other == first {
+		t.Errorf("Expected a different seed to give a different sequence, got %q twice", first)
+	}
+
+	bytecode, err := vm.Compile(parseProgram(t, source))
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	var out bytes.Buffer
+	machine := vm.New(bytecode)
+	machine.SetOutput(&out)
+	machine.SetSeed(42)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("VM failed: %v", err)
+	}
+	if out.String() != first {
+		t.Errorf("Expected the VM to draw the same sequence, got %q and %q", first, out.String())
+	}
+}
+
+func TestRandomStaysInRange(t *testing.T) {
+	source := `boolean ok = true
+loop i from 1 to 500
+    number r = random()
+    integer n = randomInt(-2, 2)
+    integer same = randomInt(3, 3.0)
+    ok = ok and r >= 0 and r < 1 and n >= -2 and n <= 2 and same == 3
+end
+print ok
+print randomInt(-9223372036854775807, 9223372036854775807) != 0.5`
+
+	if out := runSeeded(t, 1, source); out != "true\ntrue\n" {
+		t.Errorf("Expected every draw to be in range, got %q", out)
+	}
+
+	for source, expected := range map[string]string{
+		"print randomInt(5, 1)":     "runtime error at line 1, column 7: randomInt: min must not be greater than max, got 5 and 1",
+		"print randomInt(1.5, 2)":   "runtime error at line 1, column 7: randomInt: argument 1 must be a whole number, got 1.5",
+		"print randomInt(1, \"2\")": "runtime error at line 1, column 7: randomInt: argument 2 must be a whole number, got \"2\"",
+		"print random(1)":           "runtime error at line 1, column 7: function random expects 0 arguments, got 1",
+	} {
+		_, err := runProgram(t, source)
+		if err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestNowReadsTheInjectedClock(t *testing.T) {
+	source := `number start = now()
+print start
+print now() - start`
+	clock := func() time.Time { return time.Unix(1700000000, 500000000) }
+
+	var out bytes.Buffer
+	interp := interpreter.NewInterpreter()
+	interp.SetOutput(&out)
+	interp.SetClock(clock)
+	if err := interp.Interpret(parseProgram(t, source)); err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if out.String() != "1.7000000005e+09\n0\n" {
+		t.Errorf("Expected the injected time, got %q", out.String())
+	}
+
+	bytecode, err := vm.Compile(parseProgram(t, source))
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	var vmOut bytes.Buffer
+	machine := vm.New(bytecode)
+	machine.SetOutput(&vmOut)
+	machine.SetClock(clock)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("VM failed: %v", err)
+	}
+	if vmOut.String() != out.String() {
+		t.Errorf("Expected the VM to read the same time, got %q and %q", out.String(), vmOut.String())
+	}
+}
+
+func TestSleep(t *testing.T) {
+	out, err := runProgram(t, `sleep(0)
+sleep(0.001)
+print "awake"`)
+	if err != nil || out != "awake\n" {
+		t.Errorf("Expected to wake up, got %q and %v", out, err)
+	}
+
+	for source, expected := range map[string]string{
+		"sleep(-1)":    "runtime error at line 1, column 1: sleep: cannot sleep for -1 seconds",
+		"sleep(\"1\")": "runtime error at line 1, column 1: sleep: argument must be a number, got \"1\"",
+	} {
+		_, err := runProgram(t, source)
+		if err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestSleepStopsWhenTheContextIsDone(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	interp := interpreter.NewInterpreter()
+	interp.SetOutput(io.Discard)
+	source := `try
+    sleep(60)
+catch error
+    print error
+end`
+	started := time.Now()
+	err := interp.InterpretContext(ctx, parseProgram(t, source))
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 10*time.Second {
+		t.Errorf("Expected sleep to wake when the context was done, took %v", elapsed)
+	}
+}
+
+func TestSleepTooLongToMeasureStillSleeps(t *testing.T) {
+	for _, seconds := range []string{"10000000000", "10.0 ^ 400"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		interp := interpreter.NewInterpreter()
+		interp.SetOutput(io.Discard)
+		err := interp.InterpretContext(ctx, parseProgram(t, "sleep("+seconds+")"))
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Errorf("sleep(%s): expected to sleep until the deadline, got %v", seconds, err)
+		}
+	}
+}
+
+func TestEval(t *testing.T) {
+	source := `eval("integer x = 40\nprint \"declared \" + x")
+print x + 1
+print eval("x + 2")
+eval("print twice(4)\nfunction twice(integer n)\n    return n * 2\nend")
+function f()
+    eval("text local = \"inner\"")
+    return local
+end
+print f()
+print eval("")
+print eval("1 + 2") + eval("(x)")
+print eval("\"a\"") + eval("'b'")
+print type(eval("42"))`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if expected := "declared 40\n41\n42\n8\ninner\nvoid\n43\nab\ninteger\n"; out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	for source, expected := range map[string]string{
+		"eval(\"print 1 / 0\")":         "runtime error at line 1, column 1: eval: runtime error at line 1, column 9: division by zero",
+		"eval(\"print (\")":             "runtime error at line 1, column 1: eval: parse error at line 1, column 8: unexpected token: end of input",
+		"eval(\"print 'ab'\")":          "runtime error at line 1, column 1: eval: lexical error at line 1, column 7: character literal must hold exactly one character",
+		"eval(\"2 * (1 / 0)\")":         "runtime error at line 1, column 1: eval: runtime error at line 1, column 8: division by zero",
+		"eval(1)":                       "runtime error at line 1, column 1: eval: argument must be text, got 1",
+		"text s = \"eval(s)\"\neval(s)": "runtime error at line 2, column 1: eval: maximum recursion depth exceeded (1000)",
+	} {
+		_, err := runProgram(t, source)
+		if err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+
+	out, err := runProgram(t, "try\n    eval(\"raise \\\"bad\\\"\")\ncatch e\n    print e\nend")
+	if err != nil || out != "eval: runtime error at line 1, column 1: bad\n" {
+		t.Errorf("Expected try to catch a failure inside eval, got %q and %v", out, err)
+	}
+}
+
+func TestMethodCalls(t *testing.T) {
+	source := `print "hi".upper() == upper("hi")
+print "hi".upper()
+text s = "Mixed"
+print s.lower().upper().length()
+print [s, "x"].join("-")
+    .upper()
+print [3, 1, 2].map(function(integer n) return n * 2 end).length()`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "true\nHI\n5\nMIXED-X\n3\n"; out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+}
+
+func TestMethodCallsAlwaysUseTheBuiltin(t *testing.T) {
+	source := `function upper(text s)
+    return "declared"
+end
+print upper("a")
+print "a".upper()`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "declared\nA\n"; out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+
+	_, err = runProgram(t, "print \"a\".shout()")
+	if err == nil || err.Error() != "runtime error at line 1, column 11: unknown method shout: a method must name a built-in function" {
+		t.Errorf("Expected an unknown method error, got %v", err)
+	}
+}
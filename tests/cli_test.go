@@ -0,0 +1,312 @@
+package tests
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"simplelang/internal/cli"
+	"strings"
+	"testing"
+)
+
+// buildCompiler builds the command-line compiler into a temporary directory
+// and returns the path of the binary
+func buildCompiler(t *testing.T) string {
+	t.Helper()
+
+	if testing.Short() {
+		t.Skip("builds the compiler")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+
+	binary := filepath.Join(t.TempDir(), "simplelang")
+	if out, err := exec.Command(goTool, "build", "-o", binary, "../cmd/compiler").CombinedOutput(); err != nil {
+		t.Fatalf("go build failed: %v\n%s", err, out)
+	}
+	return binary
+}
+
+// runCompiler writes source to a file, runs the compiler on it with args
+// and returns its output and exit code
+func runCompiler(t *testing.T, binary, source string, args ...string) (string, int) {
+	t.Helper()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "main.sl")
+	if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+	cmd := exec.Command(binary, append(args, "main.sl")...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return string(out), exitErr.ExitCode()
+	}
+	if err != nil {
+		t.Fatalf("Failed to run the compiler: %v", err)
+	}
+	return string(out), 0
+}
+
+func TestCheckFlag(t *testing.T) {
+	binary := buildCompiler(t)
+
+	tests := []struct {
+		name     string
+		source   string
+		expected string
+		code     int
+	}{
+		{"valid", "print \"side effect\"\nnumber n = 1 / 0", "OK\n", 0},
+		{"semantic error", "print \"side effect\"\nnumber n = \"one\"\nprint missing",
+			"main.sl:2:1: error: type mismatch: cannot assign text to variable of type number\n" +
+				"number n = \"one\"\n" +
+				"^\n" +
+				"main.sl:3:7: error: undefined variable: missing\n" +
+				"print missing\n" +
+				"      ^\n" +
+				"Found 2 semantic error(s)\n", 1},
+		{"syntax error", "print \"side effect\"\nprint (1", "Found 1 parse error(s)", 1},
+		{"eval declaration", "eval(\"number z = 3\")\nprint z", "OK\n", 0},
+	}
+
+	for _, tt := range tests {
+		out, code := runCompiler(t, binary, tt.source, "--check")
+		if code != tt.code {
+			t.Errorf("%s: expected exit code %d, got %d", tt.name, tt.code, code)
+		}
+		if strings.Contains(out, "side effect") || !strings.Contains(out, tt.expected) {
+			t.Errorf("%s: expected output containing %q and nothing printed by the program, got %q", tt.name, tt.expected, out)
+		}
+	}
+}
+
+// runCLI runs the command line args in-process and returns the output and
+// exit code
+func runCLI(args ...string) (string, int) {
+	var out bytes.Buffer
+	code := cli.Run(args, strings.NewReader(""), &out, io.Discard)
+	return out.String(), code
+}
+
+func TestVersion(t *testing.T) {
+	version, commit := cli.Version, cli.Commit
+	defer func() { cli.Version, cli.Commit = version, commit }()
+	cli.Version, cli.Commit = "1.2.3", "abc123"
+
+	out, code := runCLI("--version")
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	expected := "simplelang 1.2.3\ngo: " + runtime.Version() + "\ncommit: abc123\n"
+	if out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+
+	cli.Commit = ""
+	out, _ = runCLI("--version")
+	if !strings.Contains(out, "\ncommit: ") || strings.Contains(out, "commit: \n") {
+		t.Errorf("expected a commit to be reported, got %q", out)
+	}
+}
+
+func TestCommandLineArguments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "main.sl")
+	if err := os.WriteFile(path, []byte("print 1"), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+
+	tests := []struct {
+		args     []string
+		code     int
+		contains string
+	}{
+		{[]string{"--check", path}, 0, "OK"},
+		{[]string{path, "--check"}, 0, "OK"},
+		{[]string{"--vm", path, "--optimize"}, 0, "✓ Program executed successfully!"},
+		{[]string{"--help"}, 0, "Usage: simplelang"},
+		{[]string{"--check", "--tokens", path}, 1, "Usage: simplelang"},
+		{[]string{"--version", path}, 1, "Usage: simplelang"},
+		{[]string{"--profile", "--vm", path}, 1, "Usage: simplelang"},
+		{[]string{"--profile", "--check", path}, 1, "Usage: simplelang"},
+		{[]string{"--check"}, 1, "Usage: simplelang"},
+		{[]string{path, path}, 1, "Usage: simplelang"},
+		{[]string{"--unknown", path}, 1, "Usage: simplelang"},
+		{[]string{"missing.sl"}, 1, "Error reading file missing.sl"},
+	}
+
+	for _, tt := range tests {
+		out, code := runCLI(tt.args...)
+		if code != tt.code {
+			t.Errorf("%v: expected exit code %d, got %d", tt.args, tt.code, code)
+		}
+		if !strings.Contains(out, tt.contains) {
+			t.Errorf("%v: expected output containing %q, got %q", tt.args, tt.contains, out)
+		}
+	}
+}
+
+func TestRunSource(t *testing.T) {
+	var out bytes.Buffer
+	code := cli.RunSource(strings.NewReader("print 1 + 1"), "<test>", cli.Options{}, &out, io.Discard)
+	if code != 0 || !strings.Contains(out.String(), "Compiling and running: <test>\n") || !strings.Contains(out.String(), "\n2\n") {
+		t.Errorf("expected the program to run, got exit code %d and %q", code, out.String())
+	}
+
+	out.Reset()
+	code = cli.RunSource(strings.NewReader("print missing"), "<test>", cli.Options{Mode: "check"}, &out, io.Discard)
+	expected := "<test>:1:7: error: undefined variable: missing\n"
+	if code != 1 || !strings.HasPrefix(out.String(), expected) {
+		t.Errorf("expected exit code 1 and %q, got %d and %q", expected, code, out.String())
+	}
+}
+
+func TestStdinAndInlineSource(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		stdin    string
+		code     int
+		expected string
+	}{
+		{"stdin", []string{"-"}, "print 1 + 1", 0, "Compiling and running: <stdin>\n"},
+		{"stdin output", []string{"--vm", "-"}, "print 1 + 1", 0, "\n2\n"},
+		{"stdin mode", []string{"-", "--fmt"}, "print 1+1", 0, "print 1 + 1\n"},
+		{"stdin error", []string{"-"}, "print 1 / 0", 1, "<stdin>:1:9: error: division by zero\nprint 1 / 0\n        ^\n"},
+		{"inline", []string{"-e", "print 2 * 3"}, "", 0, "\n6\n"},
+		{"inline mode", []string{"--check", "-e", "print 2 * 3"}, "", 0, "OK\n"},
+		{"inline parse error", []string{"-e", "print (1"}, "", 1, "<inline>:1:9: error: expected ')'"},
+		{"inline lexical error", []string{"-e", "print \"\\q\""}, "", 1, "<inline>:1:8: error: unknown escape sequence: \\q\n"},
+		{"inline semantic error", []string{"--check", "-e", "print x"}, "", 1, "<inline>:1:7: error: undefined variable: x\n"},
+		{"inline and file", []string{"-e", "print 1", "main.sl"}, "", 1, "Usage: simplelang"},
+		{"missing code", []string{"-e"}, "", 1, "Usage: simplelang"},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		code := cli.Run(tt.args, strings.NewReader(tt.stdin), &out, io.Discard)
+		if code != tt.code {
+			t.Errorf("%s: expected exit code %d, got %d", tt.name, tt.code, code)
+		}
+		if !strings.Contains(out.String(), tt.expected) {
+			t.Errorf("%s: expected output containing %q, got %q", tt.name, tt.expected, out.String())
+		}
+	}
+}
+
+func TestIncludesResolveAgainstTheSourceFile(t *testing.T) {
+	for _, args := range [][]string{
+		{"testdata/include/main.sl"},
+		{"--vm", "testdata/include/main.sl"},
+		{"--check", "testdata/include/main.sl"},
+	} {
+		out, code := runCLI(args...)
+		expected := "\nloading utils\nhello\n42\n9\n"
+		if args[0] == "--check" {
+			expected = "OK\n"
+		}
+		if code != 0 || !strings.Contains(out, expected) {
+			t.Errorf("%v: expected exit code 0 and output containing %q, got %d and %q", args, expected, code, out)
+		}
+	}
+}
+
+func TestProfileFlag(t *testing.T) {
+	var out, profile bytes.Buffer
+	args := []string{"--profile", "-e", "loop i from 1 to 3\n    print i\nend"}
+	code := cli.Run(args, strings.NewReader(""), &out, &profile)
+	if code != 0 || !strings.Contains(out.String(), "\n1\n2\n3\n") {
+		t.Fatalf("expected the program to run, got exit code %d and %q", code, out.String())
+	}
+	if strings.Contains(out.String(), "NODE") {
+		t.Errorf("expected the profile to stay out of the program's output, got %q", out.String())
+	}
+	for _, row := range []string{"NODE", "LoopStatement   1", "PrintStatement  3", "TOTAL"} {
+		if !strings.Contains(profile.String(), row) {
+			t.Errorf("expected the profile to contain %q, got %q", row, profile.String())
+		}
+	}
+
+	// A program that fails still has its profile printed
+	profile.Reset()
+	code = cli.Run([]string{"--profile", "-e", "print 1 / 0"}, strings.NewReader(""), &out, &profile)
+	if code != 1 || !strings.Contains(profile.String(), "PrintStatement") {
+		t.Errorf("expected exit code 1 and a profile, got %d and %q", code, profile.String())
+	}
+}
+
+func TestErrorsShowTheirSourceLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		opts     cli.Options
+		expected string
+	}{
+		{"parse", "integer x = 1\nif x > 0 print x end", cli.Options{Mode: "check"},
+			"<test>:2:10: error: expected 'then' after condition, got print\n" +
+				"if x > 0 print x end\n" +
+				"         ^~~~~\n"},
+		{"lexical", "let s = \"caf\\u{110000}\"", cli.Options{},
+			"<test>:1:13: error: invalid Unicode escape: \\u{110000} is not a valid code point\n" +
+				"let s = \"caf\\u{110000}\"\n" +
+				"            ^~~~~~~~~~\n"},
+		{"tabs and wide characters", "if true then\n\tprint \"é\" + missing\nend", cli.Options{Mode: "check"},
+			"<test>:2:14: error: undefined variable: missing\n" +
+				"\tprint \"é\" + missing\n" +
+				"\t            ^\n"},
+		{"runtime", "integer n = 0\nprint 10 / n", cli.Options{},
+			"<test>:2:10: error: division by zero\n" +
+				"print 10 / n\n" +
+				"         ^\n"},
+		{"runtime on the VM", "list xs = [1]\nprint xs[0] + xs[3]", cli.Options{VM: true},
+			"<test>:2:17: error: index 3 is out of range for list of length 1\n" +
+				"print xs[0] + xs[3]\n" +
+				"                ^\n"},
+		{"color", "print missing", cli.Options{Mode: "check", Color: true},
+			"\x1b[1m<test>:1:7: \x1b[1;31merror:\x1b[0m \x1b[1mundefined variable: missing\x1b[0m\n" +
+				"print missing\n" +
+				"      \x1b[1;32m^\x1b[0m\n"},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		code := cli.RunSource(strings.NewReader(tt.source), "<test>", tt.opts, &out, io.Discard)
+		if code != 1 || !strings.Contains(out.String(), tt.expected) {
+			t.Errorf("%s: expected exit code 1 and output containing %q, got %d and %q", tt.name, tt.expected, code, out.String())
+		}
+	}
+
+	// Output that is not a terminal is never highlighted
+	out, _ := runCLI("--check", "-e", "print missing")
+	if strings.Contains(out, "\x1b[") {
+		t.Errorf("expected no color in output that is not a terminal, got %q", out)
+	}
+}
+
+func TestUseColor(t *testing.T) {
+	// A character device stands in for a terminal
+	device, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Skipf("cannot open %s: %v", os.DevNull, err)
+	}
+	defer device.Close()
+
+	t.Setenv("NO_COLOR", "")
+	if !cli.UseColor(device) {
+		t.Errorf("expected color on %s", os.DevNull)
+	}
+	if cli.UseColor(&bytes.Buffer{}) {
+		t.Errorf("expected no color in a buffer")
+	}
+
+	t.Setenv("NO_COLOR", "1")
+	if cli.UseColor(device) {
+		t.Errorf("expected NO_COLOR to turn color off")
+	}
+}
@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"bytes"
 	"simplelang/internal/ast"
 	"simplelang/internal/interpreter"
 	"simplelang/internal/lexer"
@@ -37,8 +38,8 @@ print x`
 	if tokens[2].Type != lexer.TokenAssign {
 		t.Errorf("Expected TokenAssign, got %v", tokens[2].Type)
 	}
-	if tokens[3].Type != lexer.TokenNumber {
-		t.Errorf("Expected TokenNumber, got %v", tokens[3].Type)
+	if tokens[3].Type != lexer.TokenInteger {
+		t.Errorf("Expected TokenInteger, got %v", tokens[3].Type)
 	}
 }
 
@@ -99,11 +100,17 @@ print result`
 		t.Fatalf("Parser failed: %v", err)
 	}
 
+	var out bytes.Buffer
 	interpreter := interpreter.NewInterpreter()
+	interpreter.SetOutput(&out)
 	err = interpreter.Interpret(program)
 	if err != nil {
 		t.Fatalf("Interpreter failed: %v", err)
 	}
+
+	if out.String() != "15\n" {
+		t.Errorf("Expected output %q, got %q", "15\n", out.String())
+	}
 }
 
 func TestTypeSystem(t *testing.T) {
@@ -120,10 +127,27 @@ func TestTypeSystem(t *testing.T) {
 		t.Error("NumberType should not be compatible with TextType")
 	}
 
+	if !numberType.IsCompatibleWith(types.IntType{}) {
+		t.Error("NumberType should accept an IntType by widening")
+	}
+
+	if (types.IntType{}).IsCompatibleWith(numberType) {
+		t.Error("IntType should not accept a NumberType")
+	}
+
 	if !booleanType.IsCompatibleWith(types.BooleanType{}) {
 		t.Error("BooleanType should be compatible with BooleanType")
 	}
 
+	nullableNumber := types.NullableType{Inner: numberType}
+	if !nullableNumber.IsCompatibleWith(types.NilType{}) || !nullableNumber.IsCompatibleWith(types.IntType{}) {
+		t.Error("NullableType should accept nil and whatever its inner type accepts")
+	}
+
+	if numberType.IsCompatibleWith(types.NilType{}) {
+		t.Error("NumberType should not accept nil")
+	}
+
 	// Test type from string
 	if _, err := types.TypeFromString("number"); err != nil {
 		t.Error("Should be able to create NumberType from string")
@@ -154,11 +178,18 @@ print "Division: " + (a / b)`
 		t.Fatalf("Parser failed: %v", err)
 	}
 
+	var out bytes.Buffer
 	interpreter := interpreter.NewInterpreter()
+	interpreter.SetOutput(&out)
 	err = interpreter.Interpret(program)
 	if err != nil {
 		t.Fatalf("Interpreter failed: %v", err)
 	}
+
+	expected := "Addition: 13\nSubtraction: 7\nMultiplication: 30\nDivision: 3.3333333333333335\n"
+	if out.String() != expected {
+		t.Errorf("Expected output %q, got %q", expected, out.String())
+	}
 }
 
 func TestControlFlow(t *testing.T) {
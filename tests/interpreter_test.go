@@ -0,0 +1,2231 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"simplelang/internal/ast"
+	"simplelang/internal/interpreter"
+	"simplelang/internal/lexer"
+	"simplelang/internal/parser"
+	"simplelang/internal/types"
+	"simplelang/internal/vm"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runProgram lexes, parses and interprets source, returning everything the
+// program printed along with any runtime error
+func runProgram(t *testing.T, source string) (string, error) {
+	t.Helper()
+
+	tokens, err := lexer.NewLexer(source).Tokenize()
+	if err != nil {
+		t.Fatalf("Lexer failed: %v", err)
+	}
+
+	program, err := parser.NewParser(tokens).Parse()
+	if err != nil {
+		t.Fatalf("Parser failed: %v", err)
+	}
+
+	var out bytes.Buffer
+	interp := interpreter.NewInterpreter()
+	interp.SetOutput(&out)
+	err = interp.Interpret(program)
+	return out.String(), err
+}
+
+func TestFunctionCannotSeeCallerLocals(t *testing.T) {
+	source := `function show()
+    print x
+end
+
+function caller()
+    number x = 5
+    show()
+end
+
+caller()`
+
+	_, err := runProgram(t, source)
+	if err == nil {
+		t.Fatal("Expected the callee to be unable to see the caller's local variable")
+	}
+	if !strings.Contains(err.Error(), "undefined variable: x") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestRepeatedCallsGiveSameResults(t *testing.T) {
+	source := `function f(integer a, integer b = a * 2)
+    integer local = a + b
+    switch a
+    case 1
+        integer one = 1
+    end
+    return local
+end
+print f(1)
+print f(1, 1)
+for i in [1, 2, 3]
+    print f(i)
+end
+let g = f
+print g(2, 2)
+print f(1, 2, 3)`
+
+	out, err := runProgram(t, source)
+	if expected := "3\n2\n3\n6\n9\n4\n"; out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+	if err == nil || !strings.Contains(err.Error(), "function f expects 1 to 2 arguments, got 3") {
+		t.Errorf("Expected an arity error, got %v", err)
+	}
+	assertSameAsInterpreter(t, "repeated calls", source)
+}
+
+func TestTopLevelFunctionCallableAtAnyDepth(t *testing.T) {
+	source := `function outer(integer n)
+    function middle(integer m)
+        if m > 0 then
+            return middle(m - 1)
+        end
+        loop i from 1 to 1
+            for x in [1]
+                return map([n], function(integer k) return helper(k) end)
+            end
+        end
+    end
+    return middle(n)
+end
+
+print outer(3)
+
+function helper(integer k)
+    return "helped " + k
+end
+
+function shadows()
+    function helper(integer k)
+        return "inner " + k
+    end
+    return helper(1) + ", " + join(outer(1), "")
+end
+print shadows()
+print helper(2)`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	expected := "[\"helped 3\"]\ninner 1, helped 1\nhelped 2\n"
+	if out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+}
+
+func TestFunctionInTopLevelBlockIsNotGlobal(t *testing.T) {
+	source := `if true then
+    function local()
+        print "local"
+    end
+    local()
+end
+local()`
+
+	out, err := runProgram(t, source)
+	if out != "local\n" {
+		t.Errorf("Expected the function to run inside its block, got %q", out)
+	}
+	if err == nil || !strings.Contains(err.Error(), "undefined function: local") {
+		t.Errorf("Expected the function to be out of scope after its block, got %v", err)
+	}
+}
+
+func TestAssignmentUpdatesEnclosingScope(t *testing.T) {
+	source := `number total = 0
+
+function addToTotal(number n)
+    total = total + n
+end
+
+loop i from 1 to 3
+    addToTotal(i)
+end
+print total`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if !strings.HasSuffix(out, "6\n") {
+		t.Errorf("Expected total of 6, got output %q", out)
+	}
+}
+
+func TestRedeclarationInSameScopeIsAnError(t *testing.T) {
+	tests := map[string]string{
+		"top level":     "number x = 1\nnumber x = 2",
+		"let":           "let x = 1\nlet x = \"one\"",
+		"parameter":     "function f(integer n)\n    integer n = 2\nend\nf(1)",
+		"loop variable": "integer i = 0\nloop i from 1 to 3\nend",
+		"nested loops":  "for x in [1]\n    for x in [2]\n    end\nend",
+	}
+
+	for name, source := range tests {
+		_, err := runProgram(t, source)
+		if err == nil || !strings.Contains(err.Error(), "already declared in this scope") {
+			t.Errorf("%s: expected a redeclaration error, got %v", name, err)
+		}
+	}
+}
+
+func TestManyVariablesInOneScope(t *testing.T) {
+	var b strings.Builder
+	for n := 0; n < 20; n++ {
+		fmt.Fprintf(&b, "integer v%d = %d\n", n, n)
+	}
+	b.WriteString("v3 = 30\nv17++\nprint v0 + v3 + v17 + v19\nfunction f()\n    integer v5 = 50\n    print v5 + v18\nend\nf()\nprint v5\ninteger v12 = 1")
+
+	out, err := runProgram(t, b.String())
+	if expected := "67\n68\n5\n"; out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+	if err == nil || !strings.Contains(err.Error(), "v12 is already declared in this scope") {
+		t.Errorf("Expected a redeclaration error, got %v", err)
+	}
+}
+
+func TestShadowingInNestedScope(t *testing.T) {
+	source := `integer x = 1
+function f()
+    text x = "inner"
+    print x
+end
+f()
+loop i from 1 to 2
+    number x = i * 10
+    print x
+end
+print x`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if out != "inner\n10\n20\n1\n" {
+		t.Errorf("Expected nested declarations to shadow x, got %q", out)
+	}
+}
+
+func TestBlocksHaveTheirOwnScope(t *testing.T) {
+	tests := map[string]string{
+		"then":    "if 1 < 2 then\n    integer x = 1\nend\nprint x",
+		"else":    "if 1 > 2 then\n    print 0\nelse\n    integer x = 1\nend\nprint x",
+		"case":    "switch 1\ncase 1\n    integer x = 1\nend\nprint x",
+		"default": "switch 1\ncase 2\n    print 0\ndefault\n    integer x = 1\nend\nprint x",
+		"try":     "try\n    integer x = 1\ncatch e\nend\nprint x",
+		"failed":  "try\n    integer x = 1\n    print 1 / 0\ncatch e\nend\nprint x",
+		"catch":   "try\n    print 1 / 0\ncatch e\n    integer x = 1\nend\nprint x",
+		"begin":   "begin\n    integer x = 1\nend\nprint x",
+	}
+
+	for name, source := range tests {
+		for backend, run := range map[string]func(*testing.T, string) (string, error){"interpreter": runProgram, "vm": runVM} {
+			_, err := run(t, source)
+			if err == nil || !strings.Contains(err.Error(), "undefined variable: x") {
+				t.Errorf("%s: %s: expected x to go out of scope after the block, got %v", backend, name, err)
+			}
+		}
+	}
+}
+
+func TestIfBranchCanShadowOuterVariable(t *testing.T) {
+	source := `integer x = 1
+if x == 1 then
+    integer x = 2
+    x++
+    print x
+end
+print x`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if out != "3\n1\n" {
+		t.Errorf("Expected the branch's x to shadow the outer one, got %q", out)
+	}
+}
+
+func TestBeginBlockRunsInItsOwnScope(t *testing.T) {
+	source := `integer x = 1
+begin
+    integer x = 2
+    text label = "inner "
+    print label + x
+    x = 5
+end
+begin
+    x++
+end
+print x`
+
+	for backend, run := range map[string]func(*testing.T, string) (string, error){"interpreter": runProgram, "vm": runVM} {
+		out, err := run(t, source)
+		if err != nil || out != "inner 2\n2\n" {
+			t.Errorf("%s: expected the block's x to shadow the outer one, got %q and %v", backend, out, err)
+		}
+	}
+}
+
+func TestRecursiveFibonacci(t *testing.T) {
+	source := `number total = 0
+
+function fib(number n)
+    if n < 2 then
+        total = total + n
+    else
+        fib(n - 1)
+        fib(n - 2)
+    end
+end
+
+fib(10)
+print total`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if !strings.HasSuffix(out, "55\n") {
+		t.Errorf("Expected fib(10) to be 55, got output %q", out)
+	}
+}
+
+func TestMutualRecursion(t *testing.T) {
+	source := `isEven(7)
+
+function isEven(number n)
+    if n == 0 then
+        print "even"
+    else
+        isOdd(n - 1)
+    end
+end
+
+function isOdd(number n)
+    if n == 0 then
+        print "odd"
+    else
+        isEven(n - 1)
+    end
+end`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if !strings.HasPrefix(out, "odd\n") {
+		t.Errorf("Expected 7 to be reported odd, got output %q", out)
+	}
+}
+
+func TestInfiniteRecursionIsAnError(t *testing.T) {
+	source := `function forever(number n)
+    forever(n + 1)
+end
+
+forever(0)`
+
+	_, err := runProgram(t, source)
+	if err == nil {
+		t.Fatal("Expected infinite recursion to fail")
+	}
+	if !strings.Contains(err.Error(), "maximum recursion depth exceeded") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestSetMaxDepth(t *testing.T) {
+	source := `function countdown(number n)
+    if n > 0 then
+        countdown(n - 1)
+    end
+end
+
+countdown(10)`
+
+	tokens, err := lexer.NewLexer(source).Tokenize()
+	if err != nil {
+		t.Fatalf("Lexer failed: %v", err)
+	}
+	program, err := parser.NewParser(tokens).Parse()
+	if err != nil {
+		t.Fatalf("Parser failed: %v", err)
+	}
+
+	interp := interpreter.NewInterpreter()
+	interp.SetOutput(&bytes.Buffer{})
+	interp.SetMaxDepth(5)
+	if err := interp.Interpret(program); err == nil {
+		t.Error("Expected a depth of 11 calls to exceed a limit of 5")
+	}
+
+	interp = interpreter.NewInterpreter()
+	interp.SetOutput(&bytes.Buffer{})
+	interp.SetMaxDepth(11)
+	if err := interp.Interpret(program); err != nil {
+		t.Errorf("Expected a depth of 11 calls to fit a limit of 11, got %v", err)
+	}
+}
+
+func TestRuntimeErrorReportsPosition(t *testing.T) {
+	source := `number divisor = 0
+
+loop i from 1 to 3
+    if i == 2 then
+        print 10 / divisor
+    end
+end`
+
+	_, err := runProgram(t, source)
+	if err == nil {
+		t.Fatal("Expected a division by zero error")
+	}
+
+	var runtimeErr *interpreter.RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("Expected a RuntimeError, got %T: %v", err, err)
+	}
+	if runtimeErr.Line != 5 {
+		t.Errorf("Expected error on line 5, got line %d", runtimeErr.Line)
+	}
+	if runtimeErr.Message != "division by zero" {
+		t.Errorf("Expected message %q, got %q", "division by zero", runtimeErr.Message)
+	}
+	if !strings.HasPrefix(err.Error(), "runtime error at line 5, column ") {
+		t.Errorf("Unexpected error text: %v", err)
+	}
+}
+
+func TestExpressionStatementPrintsNothing(t *testing.T) {
+	source := `function add(number a, number b)
+    number sum = a + b
+end
+
+add(1, 2)`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if out != "" {
+		t.Errorf("Expected no output from a call used as a statement, got %q", out)
+	}
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{`print 5 / 2`, "2.5\n"},
+		{`print 7 + 2 * 3`, "13\n"},
+		{`print 7 % 3`, "1\n"},
+		{`print -7 % 3`, "-1\n"},
+		{`print 7.5 % 2`, "1.5\n"},
+		{`print 1 + 0.5`, "1.5\n"},
+		{`print 3 < 3.5`, "true\n"},
+		{`print 2 == 2.0`, "true\n"},
+		{`print 3 + 2.5`, "5.5\n"},
+		{`print 5 < 5.5`, "true\n"},
+		{`print 5.5 >= 5`, "true\n"},
+		{`print type(3 + 2.5) + " " + type(3 + 2) + " " + type(2 * 1.5) + " " + type(7 - 0.5)`, "number integer number number\n"},
+		{`print type(2 ^ 0.5) + " " + type(7 % 2.5) + " " + type(2.0 * 3) + " " + type(4 / 2)`, "number number number number\n"},
+		{"integer n = 6 * 7\nprint n", "42\n"},
+		{"number x = 10\nprint x / 4", "2.5\n"},
+		{`print 0xFF == 255`, "true\n"},
+		{"integer flags = 0b1010\nprint flags + 0x10", "26\n"},
+		{"integer smallest = -9223372036854775808\nprint smallest + 9223372036854775807", "-1\n"},
+		{`print -9223372036854775808 == -(9223372036854775807) - 1`, "true\n"},
+		{`print 2 ^ 62 + (2 ^ 62 - 1)`, "9223372036854775807\n"},
+		{`print (-2) ^ 63`, "-9223372036854775808\n"},
+	}
+
+	for _, c := range cases {
+		out, err := runProgram(t, c.source)
+		if err != nil {
+			t.Errorf("%q failed: %v", c.source, err)
+			continue
+		}
+		if out != c.expected {
+			t.Errorf("%q: expected %q, got %q", c.source, c.expected, out)
+		}
+	}
+}
+
+func TestIntegerOverflowIsAnError(t *testing.T) {
+	cases := map[string]string{
+		"print 9223372036854775807 + 1":                    "9223372036854775807 + 1 is too large for an integer",
+		"print -9223372036854775807 - 2":                   "-9223372036854775807 - 2 is too large for an integer",
+		"print 3037000500 * 3037000500":                    "3037000500 * 3037000500 is too large for an integer",
+		"print -9223372036854775808 // -1":                 "-9223372036854775808 // -1 is too large for an integer",
+		"print 2 ^ 63":                                     "2 ^ 63 is too large for an integer",
+		"print 3 ^ 40":                                     "3 ^ 40 is too large for an integer",
+		"print -(-9223372036854775808)":                    "-(-9223372036854775808) is too large for an integer",
+		"integer n = 9223372036854775807\nn++":             "9223372036854775807 + 1 is too large for an integer",
+		"try\n    print 2 ^ 64\ncatch e\n    print e\nend": "",
+	}
+	for source, expected := range cases {
+		for backend, run := range map[string]func(*testing.T, string) (string, error){"interpreter": runProgram, "vm": runVM} {
+			out, err := run(t, source)
+			if expected == "" {
+				if err != nil || !strings.Contains(out, "2 ^ 64 is too large for an integer") {
+					t.Errorf("%s: %q: expected the overflow to be caught, got %q, %v", backend, source, out, err)
+				}
+				continue
+			}
+			if err == nil || !strings.Contains(err.Error(), expected) {
+				t.Errorf("%s: %q: expected %q, got %v", backend, source, expected, err)
+			}
+		}
+	}
+}
+
+func TestIntegerDoesNotAcceptNumber(t *testing.T) {
+	for _, source := range []string{`integer half = 5 / 2`, `integer pi = 3.14`} {
+		_, err := runProgram(t, source)
+		if err == nil {
+			t.Errorf("%q: expected a type mismatch", source)
+			continue
+		}
+		if !strings.Contains(err.Error(), "cannot assign number to variable of type integer") {
+			t.Errorf("%q: unexpected error: %v", source, err)
+		}
+	}
+}
+
+func TestIntegerDivision(t *testing.T) {
+	source := `print 7 // 2 == 3
+print 7 // 2
+print -7 // 2
+print 7 // -2
+print 7.5 // 2
+print -7.5 // 2
+print 1 // 3
+integer n = 9.9 // 1.1
+print n
+print type(6.0 // 2)`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if expected := "true\n3\n-3\n-3\n3\n-3\n0\n9\ninteger\n"; out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+}
+
+func TestIntegerDivisionErrors(t *testing.T) {
+	cases := map[string]string{
+		"print 7 // 0":                       "runtime error at line 1, column 9: division by zero",
+		"print 7.5 // 0.0":                   "runtime error at line 1, column 11: division by zero",
+		"print 100000000000000000000.0 // 1": "runtime error at line 1, column 31: '//' result 1e+20 is too large for an integer",
+	}
+
+	for source, expected := range cases {
+		_, err := runProgram(t, source)
+		if err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestModuloByZero(t *testing.T) {
+	_, err := runProgram(t, `print 5 % 0`)
+	if err == nil || !strings.Contains(err.Error(), "division by zero") {
+		t.Errorf("Expected a division by zero error, got %v", err)
+	}
+}
+
+func TestSwitchRunsMatchingCase(t *testing.T) {
+	source := `integer day = 7
+switch day
+case 6
+    print "Saturday"
+case 3 + 4
+    print "Sunday"
+case 7
+    print "unreachable"
+default
+    print "Weekday"
+end`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if out != "Sunday\n" {
+		t.Errorf("Expected only the first matching case to run, got %q", out)
+	}
+}
+
+func TestSwitchFallsBackToDefault(t *testing.T) {
+	source := `text color = "green"
+switch color
+case "red"
+    print "stop"
+case "amber"
+    print "wait"
+default
+    print "go"
+end
+switch color
+case "red"
+    print "stop"
+end
+print "done"`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if out != "go\ndone\n" {
+		t.Errorf("Expected the default branch and then nothing, got %q", out)
+	}
+}
+
+func TestSwitchCaseOfDifferentTypeDoesNotMatch(t *testing.T) {
+	source := `switch 1
+case "1"
+    print "text"
+case 1 < 2
+    print "boolean"
+case 1.0
+    print "number"
+default
+    print "none"
+end`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if out != "number\n" {
+		t.Errorf("Expected only the numeric case to match, got %q", out)
+	}
+}
+
+func TestLogicalOperatorsShortCircuit(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{`print 1 > 2 and 1 / 0 > 0`, "false\n"},
+		{`print 1 < 2 or 1 / 0 > 0`, "true\n"},
+		{`print 1 < 2 and 2 < 3`, "true\n"},
+		{`print 1 > 2 or 2 > 3`, "false\n"},
+	}
+
+	for _, c := range cases {
+		out, err := runProgram(t, c.source)
+		if err != nil {
+			t.Errorf("%q failed: %v", c.source, err)
+			continue
+		}
+		if out != c.expected {
+			t.Errorf("%q: expected %q, got %q", c.source, c.expected, out)
+		}
+	}
+
+	_, err := runProgram(t, `print 1 < 2 and 1 / 0 > 0`)
+	if err == nil || !strings.Contains(err.Error(), "division by zero") {
+		t.Errorf("Expected the right operand to be evaluated, got %v", err)
+	}
+}
+
+func TestLogicalOperatorRequiresBooleanLeftOperand(t *testing.T) {
+	_, err := runProgram(t, `print 1 or 1 < 2`)
+	if err == nil || !strings.Contains(err.Error(), "left operand of 'or' must be boolean, got integer") {
+		t.Errorf("Expected a boolean operand error, got %v", err)
+	}
+}
+
+func TestNotKeywordMatchesBang(t *testing.T) {
+	out, err := runProgram(t, "print not (1 < 2)\nprint !(1 < 2)\nprint not (1 > 2) == !(1 > 2)")
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if out != "false\nfalse\ntrue\n" {
+		t.Errorf("Expected not and ! to behave identically, got %q", out)
+	}
+
+	out, err = runProgram(t, "print not true\nprint !true\nprint not false")
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if out != "false\nfalse\ntrue\n" {
+		t.Errorf("Expected not true and !true to both be false, got %q", out)
+	}
+}
+
+func TestTextComparisons(t *testing.T) {
+	source := `print "apple" < "banana"
+print "apple" <= "apple"
+print "pear" > "peach"
+print "Zebra" >= "apple"
+print "" < "a"
+print "abc" > "ab"`
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if out != "true\ntrue\ntrue\nfalse\ntrue\ntrue\n" {
+		t.Errorf("Unexpected text ordering: %q", out)
+	}
+}
+
+func TestEvaluateExpression(t *testing.T) {
+	interp := interpreter.NewInterpreter()
+	if err := interp.Interpret(parseProgram(t, "integer a = 20")); err != nil {
+		t.Fatalf("Interpret failed: %v", err)
+	}
+
+	tokens, err := lexer.NewLexer("a * 2 + 1").Tokenize()
+	if err != nil {
+		t.Fatalf("Lexer failed: %v", err)
+	}
+	expr, err := parser.ParseExpression(tokens)
+	if err != nil {
+		t.Fatalf("ParseExpression failed: %v", err)
+	}
+	value, err := interp.EvaluateExpression(expr)
+	if err != nil {
+		t.Fatalf("EvaluateExpression failed: %v", err)
+	}
+	if value != (types.IntValue{Value: 41}) {
+		t.Errorf("Expected 41, got %v", value)
+	}
+}
+
+func TestSetGlobal(t *testing.T) {
+	source := `print rate * 2
+print name + "!"
+rate = rate + 1
+boolean verbose = 1 < 2`
+
+	var out bytes.Buffer
+	interp := interpreter.NewInterpreter()
+	interp.SetOutput(&out)
+	interp.SetGlobal("rate", types.NewNumber(1.5))
+	interp.SetGlobal("name", types.NewText("config"))
+	interp.SetGlobal("verbose", types.NewBoolean(false))
+	if err := interp.Interpret(parseProgram(t, source)); err != nil {
+		t.Fatalf("Interpret failed: %v", err)
+	}
+
+	if expected := "3\nconfig!\n"; out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+	if rate, ok := interp.GetGlobal("rate"); !ok || rate != types.NewNumber(2.5) {
+		t.Errorf("Expected rate to be 2.5, got %v", rate)
+	}
+	if verbose, ok := interp.GetGlobal("verbose"); !ok || verbose != types.NewBoolean(true) {
+		t.Errorf("Expected the program to redeclare verbose as true, got %v", verbose)
+	}
+	if _, ok := interp.GetGlobal("missing"); ok {
+		t.Error("Expected no global named missing")
+	}
+}
+
+func TestInterpretContextStopsRunawayLoops(t *testing.T) {
+	sources := map[string]string{
+		"empty loop": "loop i from 1 to 1000000000000\nend",
+		"caught":     "try\n    loop i from 1 to 1000000000000\n        integer n = 1\n    end\ncatch e\n    print \"caught \" + e\nend\nprint \"after\"",
+		"recursion":  "function spin(integer n)\n    if n < 30 then\n        loop i from 1 to 10\n            spin(n + 1)\n        end\n    end\nend\nspin(0)",
+	}
+
+	for name, source := range sources {
+		var out bytes.Buffer
+		interp := interpreter.NewInterpreter()
+		interp.SetOutput(&out)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		start := time.Now()
+		err := interp.InterpretContext(ctx, parseProgram(t, source))
+		elapsed := time.Since(start)
+		cancel()
+
+		if err != context.DeadlineExceeded {
+			t.Errorf("%s: expected the deadline to stop the program, got %v", name, err)
+		}
+		if elapsed > time.Second {
+			t.Errorf("%s: took %v to stop", name, elapsed)
+		}
+		if out.Len() > 0 {
+			t.Errorf("%s: expected nothing to run after cancellation, got %q", name, out.String())
+		}
+	}
+}
+
+func TestInterpretContextCanceledBeforeStart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var out bytes.Buffer
+	interp := interpreter.NewInterpreter()
+	interp.SetOutput(&out)
+	if err := interp.InterpretContext(ctx, parseProgram(t, "print 1")); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if out.Len() > 0 {
+		t.Errorf("Expected nothing to be printed, got %q", out.String())
+	}
+
+	if err := interp.Interpret(parseProgram(t, "print 2")); err != nil {
+		t.Errorf("Expected Interpret to run without the old context, got %v", err)
+	}
+}
+
+func TestStepLimit(t *testing.T) {
+	// Declaring count takes two steps, the statement and its value, and
+	// starting the loop three, for the statement and its bounds. Each
+	// iteration then takes five: the iteration itself, the statement count++
+	// and the three expressions of count + 1, which it stands for.
+	source := `integer count = 0
+loop i from 1 to 1000000000000
+    count++
+end`
+
+	interp := interpreter.NewInterpreter()
+	interp.SetStepLimit(105)
+	err := interp.Interpret(parseProgram(t, source))
+	if err == nil || err.Error() != "runtime error at line 2, column 1: step limit exceeded (105)" {
+		t.Fatalf("Expected the step limit to stop the loop, got %v", err)
+	}
+	if interp.Steps() != 105 {
+		t.Errorf("Expected exactly 105 steps, got %d", interp.Steps())
+	}
+	if count, _ := interp.GetGlobal("count"); count != types.NewInteger(20) {
+		t.Errorf("Expected 20 iterations, got %v", count)
+	}
+
+	// The count starts again with each program
+	if err := interp.Interpret(parseProgram(t, "print 1 + 1")); err != nil {
+		t.Errorf("Expected a short program to fit within the limit, got %v", err)
+	}
+}
+
+func TestStepLimitHoldsEverywhere(t *testing.T) {
+	sources := map[string]string{
+		"recursion": "function f(integer n)\n    return f(n)\nend\nf(0)",
+		"caught":    "try\n    loop i from 1 to 1000000000000\n    end\ncatch e\nend\nprint \"escaped\"",
+		"for":       "list xs = [1, 2, 3, 4, 5, 6, 7, 8, 9, 10]\nfor x in xs\nend",
+		// Nothing runs after the try, which must not swallow the limit
+		"caught last":   "try\n    loop i from 1 to 1000000000000\n    end\ncatch e\nend",
+		"caught in map": "try\n    map([1, 2, 3, 4, 5, 6], function(integer n) return n end)\ncatch e\nend",
+		"caught eval":   "try\n    eval(\"loop i from 1 to 1000000000000\\nend\")\ncatch e\nend",
+	}
+
+	for name, source := range sources {
+		var out bytes.Buffer
+		interp := interpreter.NewInterpreter()
+		interp.SetOutput(&out)
+		interp.SetStepLimit(10)
+		err := interp.Interpret(parseProgram(t, source))
+		if err == nil || !strings.Contains(err.Error(), "step limit exceeded (10)") {
+			t.Errorf("%s: expected the step limit to stop the program, got %v", name, err)
+		}
+		if _, ok := err.(*interpreter.RuntimeError); !ok {
+			t.Errorf("%s: expected a runtime error, got %T", name, err)
+		}
+		if out.Len() > 0 {
+			t.Errorf("%s: expected nothing to be printed, got %q", name, out.String())
+		}
+	}
+}
+
+func TestTextIndexingCountsCharacters(t *testing.T) {
+	source := `text s = "naïve café"
+print s[2]
+print s[9]
+print s[6:10]
+print s[:5]
+print s[5:]
+print s[3:3] == ""
+print s[1.0]`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if out != "ï\né\ncafé\nnaïve\n café\ntrue\na\n" {
+		t.Errorf("Expected indexes to count characters, got %q", out)
+	}
+}
+
+func TestListIndexing(t *testing.T) {
+	source := `list xs = [10, "two", [3, 4]]
+print xs[0] + 1
+print xs[2][1]
+print xs[1:]
+print xs[:0]`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if out != "11\n4\n[\"two\", [3, 4]]\n[]\n" {
+		t.Errorf("Unexpected output %q", out)
+	}
+}
+
+func TestCompositeValuesPrintDeterministically(t *testing.T) {
+	source := `print [1, [2, [3, []]], "four"]
+print settings
+print [settings]
+print type(settings)`
+
+	settings := types.NewMap(map[string]types.Value{
+		"zoom":    types.NewNumber(1.5),
+		"name":    types.NewText("main"),
+		"margins": types.ListValue{Elements: []types.Value{types.NewInteger(1), types.NewInteger(2)}},
+		"colors":  types.NewMap(map[string]types.Value{"fg": types.NewText("black"), "bg": types.NewText("white")}),
+		"a b":     types.NewBoolean(true),
+	})
+	expected := `[1, [2, [3, []]], "four"]
+{"a b": true, "colors": {"bg": "white", "fg": "black"}, "margins": [1, 2], "name": "main", "zoom": 1.5}
+[{"a b": true, "colors": {"bg": "white", "fg": "black"}, "margins": [1, 2], "name": "main", "zoom": 1.5}]
+map
+`
+
+	// Go visits map entries in a different order each time, so a few runs
+	// would catch output that followed it
+	for run := 0; run < 5; run++ {
+		var out bytes.Buffer
+		interp := interpreter.NewInterpreter()
+		interp.SetOutput(&out)
+		interp.SetGlobal("settings", settings)
+		if err := interp.Interpret(parseProgram(t, source)); err != nil {
+			t.Fatalf("Interpret failed: %v", err)
+		}
+		if out.String() != expected {
+			t.Fatalf("Expected %q, got %q", expected, out.String())
+		}
+	}
+}
+
+func TestIndexOutOfRange(t *testing.T) {
+	tests := map[string]string{
+		"print \"abc\"[-1]":    "index -1 is out of range for text of length 3",
+		"print \"abc\"[3]":     "index 3 is out of range for text of length 3",
+		"print \"é\"[1]":       "index 1 is out of range for text of length 1",
+		"print [1, 2][2]":      "index 2 is out of range for list of length 2",
+		"print \"abc\"[2:1]":   "slice 2:1 is out of range for text of length 3",
+		"print \"abc\"[-1:]":   "slice -1:3 is out of range for text of length 3",
+		"print \"abc\"[:4]":    "slice 0:4 is out of range for text of length 3",
+		"print \"abc\"[0.5]":   "index must be a whole number, got 0.5",
+		"print \"abc\"[\"a\"]": "index must be a whole number, got text",
+		"print 123[0]":         "cannot index integer, expected text or a list",
+	}
+
+	for source, expected := range tests {
+		_, err := runProgram(t, source)
+		if err == nil || !strings.HasSuffix(err.Error(), expected) {
+			t.Errorf("%q: expected an error ending in %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestEquality(t *testing.T) {
+	source := `function nothing()
+end
+function inc(integer n)
+    return n + 1
+end
+let f = inc
+text? missing = nil
+print nothing() == nothing()
+print missing == nil
+print nil != nil
+print 1 == "1"
+print 1 != "1"
+print "1" == 1.0
+print (1 < 2) == 1
+print f == inc
+print f == nothing
+print inc == function(integer n) return n + 1 end`
+	output, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := "true\ntrue\nfalse\nfalse\ntrue\nfalse\nfalse\ntrue\nfalse\nfalse\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q", expected, output)
+	}
+}
+
+func TestListEquality(t *testing.T) {
+	source := `print [1, "a", [true, nil]] == [1, "a", [true, nil]]
+print [1, 2] == [1.0, 2.0000000001]
+print [1, 2] == [2, 1]
+print [1, 2] == [1, 2, 3]
+print [[1], [2]] != [[1], [3]]
+print [] == []
+print [1] == 1
+list xs = [1, 2]
+print xs == xs[:]`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if expected := "true\ntrue\nfalse\nfalse\ntrue\ntrue\nfalse\ntrue\n"; out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+}
+
+func TestMapEquality(t *testing.T) {
+	source := `print a == same
+print a == different
+print a == fewer
+print a == renamed
+print [a] == [same]`
+
+	entries := func(n types.Value, key string) types.Value {
+		return types.NewMap(map[string]types.Value{
+			key: n,
+			"b": types.ListValue{Elements: []types.Value{types.NewText("x")}},
+		})
+	}
+	var out bytes.Buffer
+	interp := interpreter.NewInterpreter()
+	interp.SetOutput(&out)
+	interp.SetGlobal("a", entries(types.NewInteger(1), "a"))
+	interp.SetGlobal("same", entries(types.NewNumber(1), "a"))
+	interp.SetGlobal("different", entries(types.NewInteger(2), "a"))
+	interp.SetGlobal("fewer", types.NewMap(map[string]types.Value{"a": types.NewInteger(1)}))
+	interp.SetGlobal("renamed", entries(types.NewInteger(1), "c"))
+	if err := interp.Interpret(parseProgram(t, source)); err != nil {
+		t.Fatalf("Interpret failed: %v", err)
+	}
+	if expected := "true\nfalse\nfalse\nfalse\ntrue\n"; out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestSelfReferentialMapEquality(t *testing.T) {
+	left := map[string]types.Value{"n": types.NewInteger(1)}
+	left["self"] = types.NewMap(left)
+	right := map[string]types.Value{"n": types.NewInteger(1)}
+	right["self"] = types.NewMap(right)
+	other := map[string]types.Value{"n": types.NewInteger(2)}
+	other["self"] = types.NewMap(other)
+
+	var out bytes.Buffer
+	interp := interpreter.NewInterpreter()
+	interp.SetOutput(&out)
+	interp.SetGlobal("left", types.NewMap(left))
+	interp.SetGlobal("right", types.NewMap(right))
+	interp.SetGlobal("other", types.NewMap(other))
+	if err := interp.Interpret(parseProgram(t, "print left == right\nprint left == other")); err != nil {
+		t.Fatalf("Interpret failed: %v", err)
+	}
+	if expected := "true\nfalse\n"; out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestMixedComparisonIsAnError(t *testing.T) {
+	for _, source := range []string{`print "10" < 9`, `print 1 >= "1"`, `print (1 < 2) < (2 < 3)`} {
+		_, err := runProgram(t, source)
+		if err == nil || !strings.Contains(err.Error(), "cannot compare") {
+			t.Errorf("For %q expected a comparison error, got %v", source, err)
+		}
+	}
+}
+
+func TestForEachOverList(t *testing.T) {
+	source := `list xs = [10, "two", 3.5]
+for item in xs
+    print item
+end
+integer total = 0
+for n in [1, 2, 3]
+    total = total + n
+end
+print total`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if out != "10\ntwo\n3.5\n6\n" {
+		t.Errorf("Expected each element in order, got %q", out)
+	}
+}
+
+func TestForEachVariableIsScopedToLoop(t *testing.T) {
+	_, err := runProgram(t, "for item in [1, 2, 3]\nend\nprint item")
+	if err == nil || !strings.Contains(err.Error(), "undefined variable: item") {
+		t.Errorf("Expected the loop variable to go out of scope, got %v", err)
+	}
+}
+
+func TestForEachRequiresList(t *testing.T) {
+	_, err := runProgram(t, "for c in \"abc\"\n    print c\nend")
+	if err == nil || !strings.Contains(err.Error(), "cannot iterate over text, expected a list") {
+		t.Errorf("Expected an iteration error, got %v", err)
+	}
+}
+
+func TestChars(t *testing.T) {
+	source := `let c = 'a'
+print c
+print c == 'a'
+print c != 'b'
+print c < 'b'
+print 'é' > 'z'
+print c + 'b' + "cd" + 'e'
+print [c, '\'', '日']
+print c == "a"
+print toText(c) == "a"`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	expected := "a\ntrue\ntrue\ntrue\ntrue\nabcde\n['a', '\\'', '日']\nfalse\ntrue\n"
+	if out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+
+	_, err = runProgram(t, "let c = 'a'\nprint c + 1")
+	if err == nil || err.Error() != "runtime error at line 2, column 9: cannot add char and integer" {
+		t.Errorf("Expected adding a number to a char to fail, got %v", err)
+	}
+}
+
+func TestTextRepetition(t *testing.T) {
+	source := `text line = "-" * 5
+print line
+print 3 * "ab"
+print "ab" * 2.0 + "|" + "ab" * 0 + "|"
+integer n = 2
+print ("x" + "y") * n * 2`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if expected := "-----\nababab\nabab||\nxyxyxyxy\n"; out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+}
+
+func TestTextRepetitionErrors(t *testing.T) {
+	tests := map[string]string{
+		"print \"ab\" * -1":           "runtime error at line 1, column 12: cannot repeat text a negative number of times: -1",
+		"print -2 * \"ab\"":           "runtime error at line 1, column 10: cannot repeat text a negative number of times: -2",
+		"print \"ab\" * 1.5":          "runtime error at line 1, column 12: text can only be repeated a whole number of times, got 1.5",
+		"print \"ab\" * \"c\"":        "runtime error at line 1, column 12: cannot multiply text and text",
+		"print \"ab\" * 999999999999": "runtime error at line 1, column 12: repeated text would be longer than 1073741824 bytes",
+	}
+
+	for source, expected := range tests {
+		_, err := runProgram(t, source)
+		if err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestLoopStep(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{"loop i from 1 to 10 step 2\n    print i\nend", "1\n3\n5\n7\n9\n"},
+		{"loop i from 5 to 1 step -2\n    print i\nend", "5\n3\n1\n"},
+		{"loop i from 3 to 1\n    print i\nend", "3\n2\n1\n"},
+		{"loop i from 1 to 3\n    print i\nend", "1\n2\n3\n"},
+		{"loop i from 1 to 5 step -1\n    print i\nend", ""},
+		{"loop i from 0.0 to 1.0\n    print i\nend", "0\n1\n"},
+		{"loop i from 0 to 1 step 0.25\n    print i\nend", "0\n0.25\n0.5\n0.75\n1\n"},
+		{"loop i from -1 to -2 step -0.5\n    print i\nend", "-1\n-1.5\n-2\n"},
+		{"loop i from -3 to -1\n    print i\nend", "-3\n-2\n-1\n"},
+		{"loop i from 0.5 to 2\n    print i\nend", "0.5\n1.5\n"},
+	}
+
+	for _, c := range cases {
+		out, err := runProgram(t, c.source)
+		if err != nil {
+			t.Errorf("%q failed: %v", c.source, err)
+			continue
+		}
+		if out != c.expected {
+			t.Errorf("%q: expected %q, got %q", c.source, c.expected, out)
+		}
+	}
+}
+
+func TestLoopOverIntegersCountsInIntegers(t *testing.T) {
+	source := `function half(integer n)
+    return n // 2
+end
+loop i from 1 to 3
+    print "iteration " + i + " of type " + type(i) + " halves to " + half(i)
+end
+integer top = 4
+loop i from top to 0 step -2
+    print [1, 2, 3, 4, 5][i] + i / 2
+end
+loop i from 1.0 to 2
+    print type(i)
+end
+loop i from 1 to 2 step 0.5
+    print i
+end`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	expected := "iteration 1 of type integer halves to 0\n" +
+		"iteration 2 of type integer halves to 1\n" +
+		"iteration 3 of type integer halves to 1\n" +
+		"7\n4\n1\n" +
+		"number\nnumber\n" +
+		"1\n1.5\n2\n"
+	if out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+	for _, line := range strings.Split(out, "\n")[:3] {
+		if strings.Contains(line, ".") {
+			t.Errorf("Expected integer loop values without a decimal point, got %q", line)
+		}
+	}
+}
+
+func TestIntegerLoopsStayExactAtTheirLimits(t *testing.T) {
+	cases := map[string]string{
+		"loop i from 9007199254740993 to 9007199254740993\n    print i\nend":              "9007199254740993\n",
+		"loop i from 9007199254740993 to 9007199254740997 step 2\n    print i\nend":       "9007199254740993\n9007199254740995\n9007199254740997\n",
+		"loop i from 9223372036854775806 to 9223372036854775807\n    print i\nend":        "9223372036854775806\n9223372036854775807\n",
+		"loop i from 9223372036854775800 to 9223372036854775807 step 5\n    print i\nend": "9223372036854775800\n9223372036854775805\n",
+		"loop i from -9223372036854775807 to -9223372036854775808\n    print i\nend":      "-9223372036854775807\n-9223372036854775808\n",
+	}
+	for source, expected := range cases {
+		for backend, run := range map[string]func(*testing.T, string) (string, error){"interpreter": runProgram, "vm": runVM} {
+			out, err := run(t, source)
+			if err != nil || out != expected {
+				t.Errorf("%s: %q: expected %q, got %q, %v", backend, source, expected, out, err)
+			}
+		}
+	}
+}
+
+func TestFractionalLoopStepsDoNotDrift(t *testing.T) {
+	cases := []struct {
+		from, to, step string
+		passes         int
+	}{
+		// Adding 0.1 three times gives 0.30000000000000004, past the bound
+		{"0", "0.3", "0.1", 4},
+		{"0", "1", "0.1", 11},
+		{"1", "0", "-0.1", 11},
+		{"0", "0.7", "0.07", 11},
+		{"0", "0.99", "0.1", 10},
+		{"-1", "1", "0.2", 11},
+		{"0", "1", "-0.1", 0},
+	}
+
+	for _, c := range cases {
+		source := "integer passes = 0\nloop i from " + c.from + " to " + c.to + " step " + c.step +
+			"\n    passes++\nend\nprint passes"
+		out, err := runProgram(t, source)
+		if err != nil {
+			t.Errorf("%q failed: %v", source, err)
+			continue
+		}
+		if expected := fmt.Sprintf("%d\n", c.passes); out != expected {
+			t.Errorf("%s to %s step %s: expected %d passes, got %s", c.from, c.to, c.step, c.passes, out)
+		}
+	}
+
+	if got := interpreter.LoopPasses(0, 0.3, 0.1); got != 4 {
+		t.Errorf("Expected LoopPasses to count 4 passes, got %g", got)
+	}
+	if got := interpreter.LoopPasses(0, 1, 0); got != 0 {
+		t.Errorf("Expected a zero step to give no passes, got %g", got)
+	}
+}
+
+func TestLoopZeroStepIsAnError(t *testing.T) {
+	_, err := runProgram(t, "loop i from 1 to 10 step 0\n    print i\nend")
+	if err == nil || !strings.Contains(err.Error(), "loop step cannot be zero") {
+		t.Errorf("Expected a zero step error, got %v", err)
+	}
+}
+
+func TestNullableVariableHoldsNil(t *testing.T) {
+	source := `number? x = nil
+print x
+x = 5
+print x + 1
+text? name = "Ada"
+print name`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if out != "nil\n6\nAda\n" {
+		t.Errorf("Unexpected output: %q", out)
+	}
+}
+
+func TestNilComparison(t *testing.T) {
+	source := `number? x = nil
+print x == nil
+print x != nil
+print nil == 0
+print 0 != nil
+print "" == nil`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if out != "true\nfalse\nfalse\ntrue\nfalse\n" {
+		t.Errorf("Unexpected output: %q", out)
+	}
+}
+
+func TestNilRejectedByNonNullableType(t *testing.T) {
+	_, err := runProgram(t, `number x = nil`)
+	if err == nil || !strings.Contains(err.Error(), "cannot assign nil to variable of type number") {
+		t.Errorf("Expected a type mismatch, got %v", err)
+	}
+
+	_, err = runProgram(t, "function show(number n)\n    print n\nend\nshow(nil)")
+	if err == nil || !strings.Contains(err.Error(), "parameter n expects number, got nil") {
+		t.Errorf("Expected a parameter type mismatch, got %v", err)
+	}
+}
+
+func TestAssignmentChecksDeclaredType(t *testing.T) {
+	cases := map[string]string{
+		"number x = 1\nx = nil":                               "runtime error at line 2, column 1: type mismatch: cannot assign nil to variable of type number",
+		"number x = 1\nx = \"s\"":                             "runtime error at line 2, column 1: type mismatch: cannot assign text to variable of type number",
+		"integer n = 1\nn = 1.5":                              "runtime error at line 2, column 1: type mismatch: cannot assign number to variable of type integer",
+		"function f(text s)\n    s = 1\nend\nf(\"a\")":        "runtime error at line 2, column 5: type mismatch: cannot assign integer to variable of type text",
+		"text s = \"a\"\nlet f = function() s = nil end\nf()": "runtime error at line 2, column 20: type mismatch: cannot assign nil to variable of type text",
+	}
+	for source, expected := range cases {
+		for backend, run := range map[string]func(*testing.T, string) (string, error){"interpreter": runProgram, "vm": runVM} {
+			_, err := run(t, source)
+			if err == nil || err.Error() != expected {
+				t.Errorf("%s: %q: expected %q, got %v", backend, source, expected, err)
+			}
+		}
+	}
+
+	// A number variable widens an integer assigned to it, a nullable one
+	// takes nil, and a let variable takes values of its inferred type
+	source := "number x = 1\nx = 3\nprint x / 2\nnumber? y = 1\ny = nil\nprint y\nlet z = 1\nz = 2\nprint z"
+	for backend, run := range map[string]func(*testing.T, string) (string, error){"interpreter": runProgram, "vm": runVM} {
+		out, err := run(t, source)
+		if err != nil || out != "1.5\nnil\n2\n" {
+			t.Errorf("%s: unexpected result %q, %v", backend, out, err)
+		}
+	}
+}
+
+func TestMultipleDeclarationsOnOneLine(t *testing.T) {
+	source := `number a = 1, b = 2, c = a + b
+print a
+print b
+print c
+text? x = nil, y = "y"
+print y`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if out != "1\n2\n3\ny\n" {
+		t.Errorf("Expected all three variables in scope, got %q", out)
+	}
+}
+
+func TestMultipleDeclarationsCheckEachInitializer(t *testing.T) {
+	_, err := runProgram(t, `integer a = 1, b = 2.5`)
+	if err == nil || !strings.Contains(err.Error(), "cannot assign number to variable of type integer") {
+		t.Errorf("Expected the second initializer to be rejected, got %v", err)
+	}
+}
+
+func TestLetInfersType(t *testing.T) {
+	source := `let count = 42
+let ratio = 0.5
+let greeting = "hi"
+let ready = 1 < 2
+let items = [1, 2]
+integer copy = count
+number half = ratio
+text shout = greeting + "!"
+boolean flag = ready
+list more = items
+print copy
+print half
+print shout
+print flag
+print more
+let a = 1, b = "two"
+print b`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if out != "42\n0.5\nhi!\ntrue\n[1, 2]\ntwo\n" {
+		t.Errorf("Unexpected output: %q", out)
+	}
+}
+
+func TestLetKeepsInferredTypeStrict(t *testing.T) {
+	_, err := runProgram(t, "let ratio = 2.5\ninteger n = ratio")
+	if err == nil || !strings.Contains(err.Error(), "cannot assign number to variable of type integer") {
+		t.Errorf("Expected the inferred number to be rejected as an integer, got %v", err)
+	}
+
+	_, err = runProgram(t, "let nothing = nil")
+	if err == nil || !strings.Contains(err.Error(), "cannot infer the type of nothing from nil") {
+		t.Errorf("Expected nil to be rejected, got %v", err)
+	}
+
+	for backend, run := range map[string]func(*testing.T, string) (string, error){"interpreter": runProgram, "vm": runVM} {
+		_, err = run(t, "let x = 5\nx = \"hi\"\nprint x")
+		if err == nil || err.Error() != "runtime error at line 2, column 1: type mismatch: cannot assign text to variable of type integer" {
+			t.Errorf("%s: expected text to be rejected by the inferred integer, got %v", backend, err)
+		}
+	}
+}
+
+func TestIncrementAndDecrement(t *testing.T) {
+	source := `integer count = 0
+loop i from 1 to 5
+    count++
+end
+print count
+number level = 1.5
+level--
+print level
+print - -count`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if out != "5\n0.5\n5\n" {
+		t.Errorf("Unexpected output: %q", out)
+	}
+}
+
+func TestIncrementRequiresNumericVariable(t *testing.T) {
+	_, err := runProgram(t, `missing++`)
+	if err == nil || !strings.Contains(err.Error(), "undefined variable: missing") {
+		t.Errorf("Expected an undefined variable error, got %v", err)
+	}
+
+	_, err = runProgram(t, "text name = \"a\"\nname++")
+	if err == nil || !strings.Contains(err.Error(), "cannot apply ++ to text variable name") {
+		t.Errorf("Expected a type error, got %v", err)
+	}
+}
+
+func TestUndefinedVariableSuggestsCloseName(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{"integer counter = 0\ncountr = 1", "undefined variable: countr; did you mean 'counter'?"},
+		{"text total = \"t\"\nprint totl", "undefined variable: totl; did you mean 'total'?"},
+		{"integer width = 1\nfunction f()\n    print widht\nend\nf()", "undefined variable: widht; did you mean 'width'?"},
+		{"integer count = 0\nloop i from 1 to 2\n    cuont++\nend", "undefined variable: cuont; did you mean 'count'?"},
+	}
+
+	for _, c := range cases {
+		_, err := runProgram(t, c.source)
+		if err == nil || !strings.HasSuffix(err.Error(), c.expected) {
+			t.Errorf("%q: expected %q, got %v", c.source, c.expected, err)
+		}
+	}
+}
+
+func TestUndefinedVariableWithoutCloseName(t *testing.T) {
+	_, err := runProgram(t, "integer counter = 0\nprint velocity")
+	if err == nil || !strings.HasSuffix(err.Error(), "undefined variable: velocity") {
+		t.Errorf("Expected no suggestion, got %v", err)
+	}
+}
+
+func TestClosureCapturesCounter(t *testing.T) {
+	source := `function makeCounter()
+    integer count = 0
+    function increment()
+        count++
+        return count
+    end
+    return increment
+end
+
+let first = makeCounter()
+let second = makeCounter()
+print first()
+print first()
+print second()
+print first()`
+
+	output, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output != "1\n2\n1\n3\n" {
+		t.Errorf("Expected each counter to keep its own count, got %q", output)
+	}
+}
+
+func TestMultipleReturnValues(t *testing.T) {
+	source := `function divmod(integer a, integer b)
+    return (a - a % b) / b, a % b
+end
+let q, r = divmod(10, 3)
+print q
+print r
+number low, high = [1, 2.5]
+print low + high
+print divmod(7, 2)
+function swap(text a, text b)
+    return b, a
+end
+let first, second = swap("x", "y")
+print first + second`
+
+	output, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := "3\n1\n3.5\n[3, 1]\nyx\n"
+	if output != expected {
+		t.Errorf("Expected %q, got %q", expected, output)
+	}
+}
+
+func TestDestructuringArityMismatch(t *testing.T) {
+	cases := map[string]string{
+		"function pair()\n    return 1, 2\nend\nlet a, b, c = pair()": "runtime error at line 4, column 1: cannot unpack 2 values into 3 variables",
+		"let a, b = [1, 2, 3]":                                "runtime error at line 1, column 1: cannot unpack 3 values into 2 variables",
+		"function one()\n    return 1\nend\nlet a, b = one()": "runtime error at line 4, column 1: cannot unpack integer into 2 variables",
+		"integer a, b = [1, \"two\"]":                         "runtime error at line 1, column 1: type mismatch: cannot assign text to variable of type integer",
+	}
+
+	for source, expected := range cases {
+		_, err := runProgram(t, source)
+		if err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestReturnEndsFunction(t *testing.T) {
+	source := `function firstOver(list values, integer limit)
+    for v in values
+        if v > limit then
+            return v
+        end
+    end
+    return
+end
+
+function square(number x)
+    return x * x
+end
+
+print firstOver([1, 5, 9], 3)
+print firstOver([1, 2], 3)
+print square(4)`
+
+	output, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output != "5\nvoid\n16\n" {
+		t.Errorf("Unexpected output %q", output)
+	}
+}
+
+func TestFunctionsAreValues(t *testing.T) {
+	source := `function twice(function f, integer x)
+    return f(f(x))
+end
+
+function addThree(integer n)
+    return n + 3
+end
+
+let g = addThree
+print twice(g, 1)
+print twice(addThree, 10)
+print g`
+
+	output, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output != "7\n16\n<function addThree>\n" {
+		t.Errorf("Unexpected output %q", output)
+	}
+}
+
+func TestCallingNonFunctionIsAnError(t *testing.T) {
+	_, err := runProgram(t, "integer n = 1\nn(2)")
+	if err == nil || !strings.HasSuffix(err.Error(), "cannot call n: integer is not a function") {
+		t.Errorf("Expected a call error, got %v", err)
+	}
+}
+
+func TestCallFunctionStoredInVariable(t *testing.T) {
+	source := `function add(integer a, integer b)
+    return a + b
+end
+
+let f = add
+print f(2, 3)`
+
+	output, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output != "5\n" {
+		t.Errorf("Expected 5, got %q", output)
+	}
+
+	// Calling through a variable checks arguments just like calling by name
+	_, err = runProgram(t, source+"\nprint f(2)")
+	if err == nil || !strings.HasSuffix(err.Error(), "function add expects 2 arguments, got 1") {
+		t.Errorf("Expected an arity error, got %v", err)
+	}
+	_, err = runProgram(t, source+"\nprint f(2, \"3\")")
+	if err == nil || !strings.HasSuffix(err.Error(), "parameter b expects integer, got text") {
+		t.Errorf("Expected a type error, got %v", err)
+	}
+}
+
+func TestCallResultOfCall(t *testing.T) {
+	source := `function makeAdder(integer n)
+    function adder(integer x)
+        return x + n
+    end
+    return adder
+end
+
+print makeAdder(1)(2)
+print (makeAdder(10))(5)`
+
+	output, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output != "3\n15\n" {
+		t.Errorf("Unexpected output %q", output)
+	}
+
+	_, err = runProgram(t, "print [1, 2](3)")
+	if err == nil || !strings.HasSuffix(err.Error(), "cannot call a value of type list") {
+		t.Errorf("Expected a call error, got %v", err)
+	}
+}
+
+func TestDefaultParameterValues(t *testing.T) {
+	source := `function greet(text name, text greeting = "Hello")
+    print greeting + ", " + name
+end
+
+function area(number width, number height = width)
+    return width * height
+end
+
+greet("Sam")
+greet("Sam", "Hi")
+print area(3)
+print area(3, 2)`
+
+	output, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output != "Hello, Sam\nHi, Sam\n9\n6\n" {
+		t.Errorf("Unexpected output %q", output)
+	}
+}
+
+func TestDefaultParameterErrors(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{"function f(integer a, integer b = 1)\nend\nf()", "function f expects 1 to 2 arguments, got 0"},
+		{"function f(integer a, integer b = 1)\nend\nf(1, 2, 3)", "function f expects 1 to 2 arguments, got 3"},
+		{"function f(integer a = \"one\")\nend\nf()", "type mismatch in function f: parameter a expects integer, got text"},
+	}
+
+	for _, c := range cases {
+		_, err := runProgram(t, c.source)
+		if err == nil || !strings.HasSuffix(err.Error(), c.expected) {
+			t.Errorf("%q: expected %q, got %v", c.source, c.expected, err)
+		}
+	}
+}
+
+func TestFunctionLiterals(t *testing.T) {
+	source := `let square = function(number x) return x * x end
+print square(3)
+
+function apply(function f, integer x)
+    return f(x)
+end
+integer offset = 10
+print apply(function(integer n)
+    return n + offset
+end, 5)
+
+print function(text s) return s + "!" end("hi")
+print square`
+
+	output, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output != "9\n15\nhi!\n<function anonymous>\n" {
+		t.Errorf("Unexpected output %q", output)
+	}
+
+	_, err = runProgram(t, "let f = function(integer n) return n end\nf()")
+	if err == nil || !strings.HasSuffix(err.Error(), "lambda expects 1 arguments, got 0") {
+		t.Errorf("Expected an arity error, got %v", err)
+	}
+}
+
+func TestWriteOmitsNewline(t *testing.T) {
+	source := `write "a"
+write 1
+write [2, 3]
+print "!"
+loop i from 1 to 3
+    write i
+    write ","
+end
+print ""
+write "end"`
+
+	output, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output != "a1[2, 3]!\n1,2,3,\nend" {
+		t.Errorf("Unexpected output %q", output)
+	}
+}
+
+func TestFormatBuiltin(t *testing.T) {
+	source := `integer a = 2
+number b = 0.5
+print format("{} + {} = {}", a, b, a + b)
+print format("{{{}}} and {{}}", [1, "x"])
+print format("no placeholders")`
+
+	output, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output != "2 + 0.5 = 2.5\n{[1, \"x\"]} and {}\nno placeholders\n" {
+		t.Errorf("Unexpected output %q", output)
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{`print format("{} and {}", 1)`, "format: template has 2 placeholders but 1 values were given"},
+		{`print format("{}", 1, 2)`, "format: template has 1 placeholders but 2 values were given"},
+		{`print format("{x}", 1)`, "format: '{' must start a {} placeholder or be doubled as {{"},
+		{`print format("x}", 1)`, "format: '}' must end a {} placeholder or be doubled as }}"},
+		{`print format("{}}", 1)`, "format: '}' must end a {} placeholder or be doubled as }}"},
+		{`print format(1)`, "format: template must be text, got integer"},
+		{`print format()`, "function format expects at least 1 arguments, got 0"},
+	}
+
+	for _, c := range cases {
+		_, err := runProgram(t, c.source)
+		if err == nil || !strings.HasSuffix(err.Error(), c.expected) {
+			t.Errorf("%q: expected %q, got %v", c.source, c.expected, err)
+		}
+	}
+}
+
+func TestAssertPasses(t *testing.T) {
+	source := `integer x = 3
+assert x > 1
+assert x == 3, "x should be three"
+print "done"`
+
+	output, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output != "done\n" {
+		t.Errorf("Unexpected output %q", output)
+	}
+}
+
+func TestAssertFailureReportsLine(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{"integer x = 3\nprint x\nassert x*2 < 5\nprint \"unreachable\"", "assertion failed: x * 2 < 5"},
+		{"integer x = 3\nprint x\nassert x < 0, \"x is \" + x\nprint \"unreachable\"", "assertion failed: x is 3"},
+		{"integer x = 3\nprint x\nassert x\nprint \"unreachable\"", "condition must be boolean, got integer"},
+	}
+
+	for _, c := range cases {
+		output, err := runProgram(t, c.source)
+		if output != "3\n" {
+			t.Errorf("%q: unexpected output %q", c.source, output)
+		}
+		runtimeErr, ok := err.(*interpreter.RuntimeError)
+		if !ok {
+			t.Errorf("%q: expected a runtime error, got %v", c.source, err)
+			continue
+		}
+		if runtimeErr.Line != 3 || runtimeErr.Message != c.expected {
+			t.Errorf("%q: expected %q on line 3, got %v", c.source, c.expected, err)
+		}
+	}
+}
+
+func TestTryCatchesDivisionByZero(t *testing.T) {
+	source := `integer zero = 0
+try
+    print "before"
+    print 10 / zero
+    print "skipped"
+catch e
+    print "caught: " + e
+end
+print "after"`
+
+	output, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output != "before\ncaught: division by zero\nafter\n" {
+		t.Errorf("Unexpected output %q", output)
+	}
+}
+
+func TestTryUnwindsFunctionsAndPassesReturns(t *testing.T) {
+	source := `function fail(integer n)
+    loop i from 1 to 3
+        if i == n then
+            print toNumber("x" + i)
+        end
+    end
+end
+
+function safe(integer n)
+    try
+        fail(n)
+        return "fine"
+    catch e
+        return "failed: " + e
+    end
+end
+
+print safe(2)
+print safe(5)
+try
+    try
+        fail(1)
+    catch inner
+        print 1 / 0
+    end
+catch outer
+    print "outer: " + outer
+end`
+
+	output, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := "failed: toNumber: cannot convert \"x2\" to a number\nfine\nouter: division by zero\n"
+	if output != expected {
+		t.Errorf("Unexpected output %q", output)
+	}
+
+	_, err = runProgram(t, "try\n    print 1 / 0\ncatch e\nend\nprint e")
+	if err == nil || !strings.HasSuffix(err.Error(), "undefined variable: e") {
+		t.Errorf("Expected the error variable to end with the catch body, got %v", err)
+	}
+}
+
+func TestRaisePropagatesUncaught(t *testing.T) {
+	source := `function check(integer age)
+    if age < 0 then
+        raise "age cannot be negative, got " + age
+    end
+    return age
+end
+print check(3)
+print check(-1)
+print "unreachable"`
+
+	output, err := runProgram(t, source)
+	if output != "3\n" {
+		t.Errorf("Unexpected output %q", output)
+	}
+	runtimeErr, ok := err.(*interpreter.RuntimeError)
+	if !ok || runtimeErr.Line != 3 || runtimeErr.Message != "age cannot be negative, got -1" {
+		t.Errorf("Expected the raised message on line 3, got %v", err)
+	}
+
+	_, err = runProgram(t, "raise 42")
+	if err == nil || !strings.HasSuffix(err.Error(), "can only raise text, got integer") {
+		t.Errorf("Expected a type error, got %v", err)
+	}
+}
+
+func TestRaiseIsCaught(t *testing.T) {
+	source := `function withdraw(number balance, number amount)
+    if amount > balance then
+        raise "insufficient funds"
+    end
+    return balance - amount
+end
+try
+    print withdraw(10, 4)
+    print withdraw(10, 40)
+catch problem
+    print "refused: " + problem
+end`
+
+	output, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output != "6\nrefused: insufficient funds\n" {
+		t.Errorf("Unexpected output %q", output)
+	}
+}
+
+func TestMalformedTreeIsAnErrorNotAPanic(t *testing.T) {
+	programs := map[string]*ast.Program{
+		"literal without a type": {Statements: []ast.Statement{
+			&ast.PrintStatement{Value: &ast.Literal{Value: 1}},
+		}},
+		"nil literal": {Statements: []ast.Statement{
+			&ast.PrintStatement{Value: (*ast.Literal)(nil)},
+		}},
+	}
+
+	for name, program := range programs {
+		err := interpreter.NewInterpreter().Interpret(program)
+		if err == nil || !strings.HasPrefix(err.Error(), "internal interpreter error: ") {
+			t.Errorf("%s: expected an internal interpreter error, got %v", name, err)
+		}
+	}
+
+	// A literal holding the wrong Go value is caught without panicking, and
+	// keeps its own message
+	program := &ast.Program{Statements: []ast.Statement{
+		&ast.PrintStatement{Value: &ast.Literal{Value: 1.5, Type: types.IntType{}}},
+	}}
+	err := interpreter.NewInterpreter().Interpret(program)
+	if err == nil || strings.Contains(err.Error(), "internal interpreter error") {
+		t.Errorf("expected an ordinary error, got %v", err)
+	}
+}
+
+func TestEnums(t *testing.T) {
+	source := `enum Color red green
+    blue
+end
+let c = Color.green
+print c
+print c == Color.green
+print c == Color.red
+print Color.blue != Color.blue
+print type(c)
+print toNumber(Color.red) + toNumber(Color.blue)
+switch c
+case Color.red
+    print "stop"
+case Color.green
+    print "go"
+end
+enum Shape circle square end
+print Color.red == Shape.circle`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := "Color.green\ntrue\nfalse\nfalse\nColor\n2\ngo\nfalse\n"
+	if out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+}
+
+func TestEnumRedeclaration(t *testing.T) {
+	_, err := runProgram(t, "enum Color red end\nenum Color red end")
+	expected := "runtime error at line 2, column 1: variable Color.red is already declared in this scope"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected %q, got %v", expected, err)
+	}
+}
+
+func TestRepeatRunsBodyBeforeCheckingCondition(t *testing.T) {
+	source := `integer runs = 0
+repeat
+    runs++
+until true
+print runs
+integer n = 1
+repeat
+    integer square = n * n
+    n++
+until square >= 16
+print n`
+
+	out, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "1\n5\n"; out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+}
+
+func TestRepeatConditionMustBeBoolean(t *testing.T) {
+	_, err := runProgram(t, "repeat\n    print 1\nuntil 1 + 1")
+	expected := "runtime error at line 3, column 9: condition must be boolean, got integer"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected %q, got %v", expected, err)
+	}
+}
+
+// runIncluding runs source on the interpreter and on the VM with include
+// statements resolving against testdata/include, failing unless both give
+// the same output and error
+func runIncluding(t *testing.T, source string) (string, error) {
+	t.Helper()
+	program := parseProgram(t, source)
+
+	var out bytes.Buffer
+	interp := interpreter.NewInterpreter()
+	interp.SetOutput(&out)
+	interp.SetBaseDir("testdata/include")
+	err := interp.Interpret(program)
+
+	bytecode, compileErr := vm.Compile(program)
+	if compileErr != nil {
+		t.Fatalf("Compile failed: %v", compileErr)
+	}
+	var vmOut bytes.Buffer
+	machine := vm.New(bytecode)
+	machine.SetOutput(&vmOut)
+	machine.SetBaseDir("testdata/include")
+	vmErr := machine.Run()
+	if vmOut.String() != out.String() || fmt.Sprint(vmErr) != fmt.Sprint(err) {
+		t.Errorf("%q: the interpreter gave %q and %v, the VM %q and %v", source, out.String(), err, vmOut.String(), vmErr)
+	}
+	return out.String(), err
+}
+
+func TestIncludeDeclaresIntoTheIncludingScope(t *testing.T) {
+	source := `include "utils.sl"
+print double(21)
+print greeting + " world"`
+
+	out, err := runIncluding(t, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "loading utils\n42\nhello world\n"; out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+}
+
+func TestIncludeRunsEachFileOnce(t *testing.T) {
+	// shapes.sl includes utils.sl again, by a different path, and its own
+	// includes resolve against lib
+	source := `include "utils.sl"
+include "lib/shapes.sl"
+include "./utils.sl"
+print area(double(2))`
+
+	out, err := runIncluding(t, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "loading utils\n16\n"; out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+}
+
+func TestIncludePathEscapes(t *testing.T) {
+	out, err := runIncluding(t, `include "lib/sq\u0075are.sl"
+print square(3)`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if out != "9\n" {
+		t.Errorf("Expected the escaped path to name lib/square.sl, got %q", out)
+	}
+}
+
+func TestIncludeCycle(t *testing.T) {
+	out, err := runIncluding(t, `include "ping.sl"`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "pong\nping\n"; out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+}
+
+func TestIncludeErrors(t *testing.T) {
+	for source, expected := range map[string]string{
+		`include "missing.sl"`:                         "runtime error at line 1, column 1: cannot include missing.sl: no such file or directory",
+		`include "broken.sl"`:                          "runtime error at line 1, column 1: in broken.sl: runtime error at line 2, column 9: division by zero",
+		"text greeting = \"hi\"\ninclude \"utils.sl\"": "runtime error at line 2, column 1: in utils.sl: runtime error at line 7, column 1: variable greeting is already declared in this scope",
+	} {
+		_, err := runIncluding(t, source)
+		if err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestImportKeepsModulesApart(t *testing.T) {
+	source := `import "math.sl" as m
+import "math.sl" as again
+import "math.sl" as m
+function add(text a, text b)
+    return a + " and " + b
+end
+print m.add(1, 2)
+print again.twice(4)
+print m.calls
+print add("a", "b")
+print type(m)
+let sum = m.add
+print sum(2, 2) + m.calls`
+
+	out, err := runIncluding(t, source)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "loading math\n3\n8\n2\na and b\nmodule\n7\n"; out != expected {
+		t.Errorf("Expected %q, got %q", expected, out)
+	}
+}
+
+func TestImportPathEscapes(t *testing.T) {
+	out, err := runIncluding(t, `import "lib/sq\u0075are.sl" as shapes
+print shapes.square(4)`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if out != "16\n" {
+		t.Errorf("Expected the escaped path to name lib/square.sl, got %q", out)
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	for source, expected := range map[string]string{
+		`import "missing.sl" as m`:                                     "runtime error at line 1, column 1: cannot import missing.sl: no such file or directory",
+		"import \"math.sl\" as m\nm.subtract(1, 2)":                    "runtime error at line 2, column 1: undefined function: m.subtract",
+		"import \"math.sl\" as m\nprint m.total":                       "runtime error at line 2, column 7: undefined variable: m.total",
+		"text secret = \"s\"\nimport \"peek.sl\" as p\nprint p.peek()": "runtime error at line 2, column 12: undefined variable: secret",
+		"integer m = 1\nimport \"math.sl\" as m":                       "runtime error at line 2, column 1: variable m is already declared in this scope",
+	} {
+		_, err := runIncluding(t, source)
+		if err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestProfileCountsStatements(t *testing.T) {
+	source := `integer total = 0
+loop i from 1 to 4
+    total = total + i
+    if i % 2 == 0 then
+        print i
+    end
+end
+print total`
+	program := parseProgram(t, source)
+
+	var out bytes.Buffer
+	interp := interpreter.NewInterpreter()
+	interp.SetOutput(&out)
+	if interp.Profile() != nil {
+		t.Fatalf("expected no profile before profiling is enabled")
+	}
+	interp.EnableProfiling()
+	if err := interp.Interpret(program); err != nil {
+		t.Fatalf("Interpret failed: %v", err)
+	}
+
+	profile := interp.Profile()
+	expected := map[string]int{
+		"VariableDeclaration": 1,
+		"LoopStatement":       1,
+		"Assignment":          4,
+		"IfStatement":         4,
+		"PrintStatement":      3,
+		"BinaryExpression":    12,
+	}
+	for kind, count := range expected {
+		if profile.Counts[kind] != count {
+			t.Errorf("expected %s to run %d times, got %d", kind, count, profile.Counts[kind])
+		}
+		if _, timed := profile.Times[kind]; !timed {
+			t.Errorf("expected a time for %s", kind)
+		}
+	}
+
+	// Running again starts a new profile
+	if err := interp.Interpret(parseProgram(t, "print total")); err != nil {
+		t.Fatalf("Interpret failed: %v", err)
+	}
+	if got := interp.Profile().Counts; len(got) != 2 || got["PrintStatement"] != 1 || got["Identifier"] != 1 {
+		t.Errorf("expected the second run to count only its own nodes, got %v", got)
+	}
+
+	var summary bytes.Buffer
+	interp.Profile().WriteSummary(&summary)
+	lines := strings.Split(strings.TrimSpace(summary.String()), "\n")
+	if !strings.HasPrefix(lines[0], "NODE") || !strings.HasPrefix(lines[len(lines)-1], "TOTAL") {
+		t.Errorf("expected a table with a header and a total, got %q", summary.String())
+	}
+}
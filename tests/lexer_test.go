@@ -0,0 +1,537 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"simplelang/internal/diagnostic"
+	"simplelang/internal/lexer"
+	"simplelang/internal/parser"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestTextEscapeSequences(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{`"line1\nline2"`, "line1\nline2"},
+		{`"a\tb"`, "a\tb"},
+		{`"a\rb"`, "a\rb"},
+		{`"back\\slash"`, `back\slash`},
+		{`"say \"hi\""`, `say "hi"`},
+		{`"\u0041"`, "A"},
+		{`"caf\u00E9 \u{1F600}\u{41}"`, "café 😀A"},
+		{`"\u00411"`, "A1"},
+	}
+
+	for _, c := range cases {
+		tokens, err := lexer.NewLexer(c.source).Tokenize()
+		if err != nil {
+			t.Fatalf("Lexer failed on %s: %v", c.source, err)
+		}
+
+		if tokens[0].Type != lexer.TokenText {
+			t.Fatalf("Expected TokenText for %s, got %v", c.source, tokens[0].Type)
+		}
+		if tokens[0].Literal != c.expected {
+			t.Errorf("Expected literal %q for %s, got %q", c.expected, c.source, tokens[0].Literal)
+		}
+		if raw := c.source[1 : len(c.source)-1]; tokens[0].Value != raw {
+			t.Errorf("Expected raw value %q, got %q", raw, tokens[0].Value)
+		}
+	}
+}
+
+func TestCharLiterals(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected rune
+	}{
+		{`'a'`, 'a'},
+		{`'é'`, 'é'},
+		{`'\n'`, '\n'},
+		{`'\''`, '\''},
+		{`'"'`, '"'},
+		{`'\u{1F600}'`, '😀'},
+	}
+
+	for _, c := range cases {
+		tokens, err := lexer.NewLexer(c.source).Tokenize()
+		if err != nil {
+			t.Fatalf("Lexer failed on %s: %v", c.source, err)
+		}
+		if tokens[0].Type != lexer.TokenChar || tokens[0].Literal != c.expected {
+			t.Errorf("Expected char %q for %s, got %v with literal %v", c.expected, c.source, tokens[0], tokens[0].Literal)
+		}
+		if raw := c.source[1 : len(c.source)-1]; tokens[0].Value != raw {
+			t.Errorf("Expected raw value %q, got %q", raw, tokens[0].Value)
+		}
+	}
+}
+
+func TestMalformedCharLiterals(t *testing.T) {
+	cases := map[string]string{
+		"print 'ab'":  "lexical error at line 1, column 7: character literal must hold exactly one character",
+		"print ''":    "lexical error at line 1, column 7: empty character literal",
+		"print 'a":    "lexical error at line 1, column 7: unterminated character literal",
+		"print 'a\n'": "lexical error at line 1, column 7: unterminated character literal",
+		"print '\\q'": "lexical error at line 1, column 8: unknown escape sequence: \\q",
+	}
+
+	for source, expected := range cases {
+		_, err := lexer.NewLexer(source).Tokenize()
+		if err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestUnknownEscapeSequence(t *testing.T) {
+	_, err := lexer.NewLexer(`"bad\q escape"`).Tokenize()
+	if err == nil {
+		t.Fatal("Expected an error for an unknown escape sequence")
+	}
+
+	if !strings.Contains(err.Error(), `unknown escape sequence: \q`) {
+		t.Errorf("Unexpected error message: %v", err)
+	}
+	if !strings.Contains(err.Error(), "column 5") {
+		t.Errorf("Expected error to point at the backslash, got: %v", err)
+	}
+}
+
+func TestMalformedUnicodeEscapes(t *testing.T) {
+	cases := map[string]string{
+		`print "\u{110000}"`:  `lexical error at line 1, column 8: invalid Unicode escape: \u{110000} is not a valid code point`,
+		`print "\uD800"`:      `lexical error at line 1, column 8: invalid Unicode escape: \uD800 is not a valid code point`,
+		`print "\u{1234567}"`: `lexical error at line 1, column 8: invalid Unicode escape: \u{1234567} is not a valid code point`,
+		`print "\u00g1"`:      `lexical error at line 1, column 8: invalid Unicode escape: expected four hexadecimal digits or braces after \u`,
+		`print "\u{}"`:        `lexical error at line 1, column 8: invalid Unicode escape: expected hexadecimal digits and '}' after \u{`,
+		`print "\u{41"`:       `lexical error at line 1, column 8: invalid Unicode escape: expected hexadecimal digits and '}' after \u{`,
+		`print '\uDFFF'`:      `lexical error at line 1, column 8: invalid Unicode escape: \uDFFF is not a valid code point`,
+	}
+
+	for source, expected := range cases {
+		_, err := lexer.NewLexer(source).Tokenize()
+		if err == nil || err.Error() != expected {
+			t.Errorf("%s: expected %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestLexicalErrorIsADiagnostic(t *testing.T) {
+	_, err := lexer.NewLexer("print \"a\\u{110000}\"").Tokenize()
+	var d *diagnostic.Diagnostic
+	if !errors.As(err, &d) {
+		t.Fatalf("Expected a diagnostic, got %v", err)
+	}
+	if d.Phase != diagnostic.Lexical || d.Severity != diagnostic.Error || d.Line != 1 || d.Column != 9 || d.Length != 10 {
+		t.Errorf("Expected a lexical error spanning the escape at column 9, got %+v", *d)
+	}
+}
+
+func TestTokenTypeString(t *testing.T) {
+	cases := []struct {
+		tokenType lexer.TokenType
+		expected  string
+	}{
+		{lexer.TokenEOF, "EOF"},
+		{lexer.TokenNumber, "Number"},
+		{lexer.TokenIdentifier, "Identifier"},
+		{lexer.TokenNumberKeyword, "NumberKeyword"},
+		{lexer.TokenPrint, "Print"},
+		{lexer.TokenGreaterEqual, "GreaterEqual"},
+		{lexer.TokenShiftRight, "ShiftRight"},
+		{lexer.TokenColon, "Colon"},
+		{lexer.TokenType(999), "TokenType(999)"},
+	}
+
+	for _, c := range cases {
+		if got := c.tokenType.String(); got != c.expected {
+			t.Errorf("Expected %q, got %q", c.expected, got)
+		}
+	}
+}
+
+func TestMalformedNumbers(t *testing.T) {
+	cases := []struct {
+		source  string
+		message string
+		column  string
+	}{
+		{"3.14.15", "invalid number literal: multiple decimal points", "column 5"},
+		{"5.", "invalid number literal: expected a digit after the decimal point", "column 2"},
+		{"x = 9223372036854775809", "invalid number literal: 9223372036854775809 is too large for an integer", "column 5"},
+		{"1_000_000_000_000_000_000_000", "invalid number literal: 1000000000000000000000 is too large for an integer", "column 1"},
+	}
+
+	for _, c := range cases {
+		_, err := lexer.NewLexer(c.source).Tokenize()
+		if err == nil {
+			t.Errorf("%q: expected a lexical error", c.source)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.message) || !strings.Contains(err.Error(), c.column) {
+			t.Errorf("%q: expected %q at %s, got: %v", c.source, c.message, c.column, err)
+		}
+	}
+}
+
+func TestDecimalNumberLexesAsNumber(t *testing.T) {
+	tokens, err := lexer.NewLexer("3.14").Tokenize()
+	if err != nil {
+		t.Fatalf("Lexer failed: %v", err)
+	}
+	if tokens[0].Type != lexer.TokenNumber || tokens[0].Value != "3.14" {
+		t.Errorf("Expected Number 3.14, got %v %q", tokens[0].Type, tokens[0].Value)
+	}
+}
+
+func TestDigitSeparators(t *testing.T) {
+	cases := []struct {
+		source    string
+		tokenType lexer.TokenType
+		expected  string
+	}{
+		{"1_000_000", lexer.TokenInteger, "1000000"},
+		{"3.141_592", lexer.TokenNumber, "3.141592"},
+		{"12_345.6_7", lexer.TokenNumber, "12345.67"},
+	}
+
+	for _, c := range cases {
+		tokens, err := lexer.NewLexer(c.source).Tokenize()
+		if err != nil {
+			t.Errorf("%q: lexer failed: %v", c.source, err)
+			continue
+		}
+		if tokens[0].Type != c.tokenType || tokens[0].Value != c.expected || tokens[0].Literal != c.expected {
+			t.Errorf("%q: expected %v %q, got %v %q (literal %v)", c.source, c.tokenType, c.expected, tokens[0].Type, tokens[0].Value, tokens[0].Literal)
+		}
+	}
+}
+
+func TestMisplacedDigitSeparators(t *testing.T) {
+	for _, source := range []string{"_5", "5_", "1__0", "3_.14", "3._14"} {
+		if _, err := lexer.NewLexer(source).Tokenize(); err == nil {
+			t.Errorf("%q: expected a lexical error", source)
+		}
+	}
+
+	_, err := lexer.NewLexer("1__0").Tokenize()
+	if err == nil || !strings.Contains(err.Error(), "'_' must separate digits") {
+		t.Errorf("Expected a separator error for 1__0, got %v", err)
+	}
+}
+
+func TestRadixIntegers(t *testing.T) {
+	cases := map[string]string{
+		"0xFF":        "255",
+		"0Xff":        "255",
+		"0b1010":      "10",
+		"0B1":         "1",
+		"0xdead_beef": "3735928559",
+		"0b1111_0000": "240",
+	}
+
+	for source, expected := range cases {
+		tokens, err := lexer.NewLexer(source).Tokenize()
+		if err != nil {
+			t.Errorf("%q: lexer failed: %v", source, err)
+			continue
+		}
+		if tokens[0].Type != lexer.TokenInteger || tokens[0].Value != expected || tokens[0].Literal != expected {
+			t.Errorf("%q: expected Integer %q, got %v %q (literal %v)", source, expected, tokens[0].Type, tokens[0].Value, tokens[0].Literal)
+		}
+	}
+}
+
+func TestMalformedRadixIntegers(t *testing.T) {
+	cases := []struct {
+		source  string
+		message string
+		column  string
+	}{
+		{"0xG1", "invalid number literal: 'G' is not a hexadecimal digit", "column 3"},
+		{"0b102", "invalid number literal: '2' is not a binary digit", "column 5"},
+		{"x = 0xFFz", "invalid number literal: 'z' is not a hexadecimal digit", "column 9"},
+		{"0x", "invalid number literal: expected a hexadecimal digit after 0x", "column 3"},
+		{"0b_1", "invalid number literal: '_' must separate digits", "column 3"},
+		{"0x1_0000_0000_0000_0000", "invalid number literal: 0x10000000000000000 is too large for an integer", "column 1"},
+	}
+
+	for _, c := range cases {
+		_, err := lexer.NewLexer(c.source).Tokenize()
+		if err == nil {
+			t.Errorf("%q: expected a lexical error", c.source)
+			continue
+		}
+		if !strings.Contains(err.Error(), c.message) || !strings.Contains(err.Error(), c.column) {
+			t.Errorf("%q: expected %q at %s, got: %v", c.source, c.message, c.column, err)
+		}
+	}
+}
+
+func TestNotIsAKeyword(t *testing.T) {
+	tokens, err := lexer.NewLexer("not !").Tokenize()
+	if err != nil {
+		t.Fatalf("Lexer failed: %v", err)
+	}
+	if tokens[0].Type != lexer.TokenNot || tokens[1].Type != lexer.TokenNot {
+		t.Errorf("Expected both spellings to lex as Not, got %v and %v", tokens[0].Type, tokens[1].Type)
+	}
+
+	tokens, err = lexer.NewLexer("boolean not = 1 < 2").Tokenize()
+	if err != nil {
+		t.Fatalf("Lexer failed: %v", err)
+	}
+	if _, err := parser.NewParser(tokens).Parse(); err == nil {
+		t.Error("Expected 'not' to be rejected as a variable name")
+	}
+}
+
+func TestBooleanLiterals(t *testing.T) {
+	tokens, err := lexer.NewLexer("true false truth").Tokenize()
+	if err != nil {
+		t.Fatalf("Lexer failed: %v", err)
+	}
+
+	for i, expected := range []bool{true, false} {
+		if tokens[i].Type != lexer.TokenBoolean {
+			t.Errorf("Token %d: expected Boolean, got %v", i, tokens[i].Type)
+		}
+		if tokens[i].Literal != expected {
+			t.Errorf("Token %d: expected literal %v, got %#v", i, expected, tokens[i].Literal)
+		}
+	}
+	if tokens[2].Type != lexer.TokenIdentifier {
+		t.Errorf("Expected truth to lex as an identifier, got %v", tokens[2].Type)
+	}
+}
+
+func TestBooleanLiteralValues(t *testing.T) {
+	out, err := runProgram(t, "boolean flag = true\nprint flag\nprint not false\nprint true == (1 < 2)\nprint type(false)")
+	if err != nil {
+		t.Fatalf("Interpreter failed: %v", err)
+	}
+	if out != "true\ntrue\ntrue\nboolean\n" {
+		t.Errorf("Unexpected output %q", out)
+	}
+}
+
+func TestIncrementTokens(t *testing.T) {
+	tokens, err := lexer.NewLexer("i++ i-- - -x").Tokenize()
+	if err != nil {
+		t.Fatalf("Lexer failed: %v", err)
+	}
+
+	expected := []lexer.TokenType{
+		lexer.TokenIdentifier, lexer.TokenIncrement,
+		lexer.TokenIdentifier, lexer.TokenDecrement,
+		lexer.TokenMinus, lexer.TokenMinus, lexer.TokenIdentifier,
+		lexer.TokenEOF,
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("Expected %d tokens, got %d", len(expected), len(tokens))
+	}
+	for i, tokenType := range expected {
+		if tokens[i].Type != tokenType {
+			t.Errorf("Token %d: expected %v, got %v", i, tokenType, tokens[i].Type)
+		}
+	}
+}
+
+func TestIntDivideToken(t *testing.T) {
+	tokens, err := lexer.NewLexer("a // b / c").Tokenize()
+	if err != nil {
+		t.Fatalf("Lexer failed: %v", err)
+	}
+
+	expected := []lexer.TokenType{
+		lexer.TokenIdentifier, lexer.TokenIntDivide, lexer.TokenIdentifier,
+		lexer.TokenDivide, lexer.TokenIdentifier, lexer.TokenEOF,
+	}
+	if len(tokens) != len(expected) {
+		t.Fatalf("Expected %d tokens, got %d", len(expected), len(tokens))
+	}
+	for i, tokenType := range expected {
+		if tokens[i].Type != tokenType {
+			t.Errorf("Token %d: expected %v, got %v", i, tokenType, tokens[i].Type)
+		}
+	}
+	if tokens[1].Value != "//" || tokens[1].Column != 3 {
+		t.Errorf("Expected '//' at column 3, got %q at column %d", tokens[1].Value, tokens[1].Column)
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	source := "integer count = 10\n    count++\ntext s = \"two\nlines\" + \"é\" + s"
+	tokens, err := lexer.NewLexer(source).Tokenize()
+	if err != nil {
+		t.Fatalf("Lexer failed: %v", err)
+	}
+
+	cases := []struct {
+		index  int
+		value  string
+		line   int
+		column int
+	}{
+		{0, "integer", 1, 1},
+		{1, "count", 1, 9},
+		{3, "10", 1, 17},
+		{4, "count", 2, 5},
+		{5, "++", 2, 10},
+		{6, "text", 3, 1},
+		{8, "=", 3, 8},
+		{9, "two\nlines", 3, 10},
+		// Tokens after a string spanning two lines, one of them after a
+		// multi-byte character
+		{10, "+", 4, 8},
+		{11, "é", 4, 10},
+		{12, "+", 4, 14},
+		{13, "s", 4, 16},
+		{14, "", 4, 17},
+	}
+
+	for _, c := range cases {
+		token := tokens[c.index]
+		if token.Value != c.value || token.Line != c.line || token.Column != c.column {
+			t.Errorf("Expected %q at line %d, column %d, got %v", c.value, c.line, c.column, token)
+		}
+	}
+}
+
+func TestMultiLineTextKeepsStartLine(t *testing.T) {
+	tokens, err := lexer.NewLexer("print \"first\nsecond\"\nprint 2").Tokenize()
+	if err != nil {
+		t.Fatalf("Lexer failed: %v", err)
+	}
+
+	if text := tokens[1]; text.Type != lexer.TokenText || text.Line != 1 || text.Column != 7 {
+		t.Errorf("Expected the text at line 1, column 7, got %v", text)
+	}
+	if next := tokens[2]; next.Value != "print" || next.Line != 3 || next.Column != 1 {
+		t.Errorf("Expected the second print at line 3, column 1, got %v", next)
+	}
+
+	_, err = lexer.NewLexer("print 1\nprint \"open\nstill open").Tokenize()
+	if err == nil || err.Error() != "lexical error at line 2, column 7: unterminated string" {
+		t.Errorf("Expected the unterminated string to be reported where it starts, got %v", err)
+	}
+}
+
+func TestTripleQuotedText(t *testing.T) {
+	source := "text query = \"\"\"SELECT *\nFROM t WHERE a = \"x\\n\"\"\"\"\nprint query"
+	tokens, err := lexer.NewLexer(source).Tokenize()
+	if err != nil {
+		t.Fatalf("Lexer failed: %v", err)
+	}
+
+	text := tokens[3]
+	expected := "SELECT *\nFROM t WHERE a = \"x\\n\""
+	if text.Type != lexer.TokenText || text.Literal != expected || text.Value != expected {
+		t.Errorf("Expected raw text %q, got %v with literal %q", expected, text, text.Literal)
+	}
+	if text.Line != 1 || text.Column != 14 || text.EndLine != 2 {
+		t.Errorf("Expected the text to span lines 1 to 2 from column 14, got %v ending at line %d", text, text.EndLine)
+	}
+	if next := tokens[4]; next.Value != "print" || next.Line != 3 || next.Column != 1 {
+		t.Errorf("Expected print at line 3, column 1, got %v", next)
+	}
+
+	out, err := runProgram(t, "print \"\"\"first\nsecond\"\"\"")
+	if err != nil || out != "first\nsecond\n" {
+		t.Errorf("Expected the embedded newline to be kept, got %q and %v", out, err)
+	}
+	if out, err := runProgram(t, `print """""" + "|"`); err != nil || out != "|\n" {
+		t.Errorf("Expected empty triple-quoted text, got %q and %v", out, err)
+	}
+
+	_, err = lexer.NewLexer("print 1\nprint \"\"\"open\nstill \"\" open").Tokenize()
+	if err == nil || err.Error() != "lexical error at line 2, column 7: unterminated triple-quoted string" {
+		t.Errorf("Expected the unterminated string to be reported where it starts, got %v", err)
+	}
+}
+
+func TestReaderLexerMatchesStringLexer(t *testing.T) {
+	sources := []string{
+		"",
+		"integer x = 0xFF + 0b1010 // 1_000\nprint x",
+		"text s = \"naïve \\\"café\\\"\\n\"\nprint s[1:3] + \"日本\"",
+		"let wörd = 1.5\nprint wörd",
+		"print \"multi\nline\" + 1",
+		"print \"unterminated",
+		"print \"\"\"raw \\n\nlines\"\"\"\"",
+		"print 1.2.3",
+		"print 1 @ 2",
+	}
+	examples, err := os.ReadFile("../examples/functions.sl")
+	if err != nil {
+		t.Fatalf("Failed to read example: %v", err)
+	}
+	sources = append(sources, string(examples))
+
+	for _, source := range sources {
+		want, wantErr := lexer.NewLexer(source).Tokenize()
+		// Reading a byte at a time makes every lookahead cross a read
+		got, gotErr := lexer.NewLexerReader(iotest.OneByteReader(strings.NewReader(source))).Tokenize()
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: expected tokens %v, got %v", source, want, got)
+		}
+		if fmt.Sprint(gotErr) != fmt.Sprint(wantErr) {
+			t.Errorf("%q: expected error %v, got %v", source, wantErr, gotErr)
+		}
+	}
+}
+
+func TestReaderLexerReportsReadErrors(t *testing.T) {
+	failure := errors.New("disk on fire")
+	_, err := lexer.NewLexerReader(iotest.TimeoutReader(iotest.OneByteReader(strings.NewReader("print 1")))).Tokenize()
+	if err == nil || !errors.Is(err, iotest.ErrTimeout) {
+		t.Errorf("Expected the read error, got %v", err)
+	}
+
+	_, err = lexer.NewLexerReader(iotest.ErrReader(failure)).Tokenize()
+	if !errors.Is(err, failure) || err.Error() != "error reading source: disk on fire" {
+		t.Errorf("Expected the read error, got %v", err)
+	}
+}
+
+func TestTokenSpans(t *testing.T) {
+	source := "if counter <= 10 then\n    print \"a\nbé\" + counter_2\nend"
+	tokens, err := lexer.NewLexer(source).Tokenize()
+	if err != nil {
+		t.Fatalf("Lexer failed: %v", err)
+	}
+
+	cases := []struct {
+		index     int
+		value     string
+		line      int
+		column    int
+		endLine   int
+		endColumn int
+	}{
+		{0, "if", 1, 1, 1, 3},
+		{1, "counter", 1, 4, 1, 11},
+		{2, "<=", 1, 12, 1, 14},
+		{3, "10", 1, 15, 1, 17},
+		// Columns count characters, so a multi-byte one is one column wide
+		{6, "a\nbé", 2, 11, 3, 4},
+		{7, "+", 3, 5, 3, 6},
+		{8, "counter_2", 3, 7, 3, 16},
+		{10, "", 4, 4, 4, 4},
+	}
+
+	for _, c := range cases {
+		token := tokens[c.index]
+		if token.Value != c.value || token.Line != c.line || token.Column != c.column || token.EndLine != c.endLine || token.EndColumn != c.endColumn {
+			t.Errorf("Expected %q from %d:%d to %d:%d, got %q from %d:%d to %d:%d", c.value, c.line, c.column, c.endLine, c.endColumn,
+				token.Value, token.Line, token.Column, token.EndLine, token.EndColumn)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package tests
+
+import (
+	"simplelang/internal/ast"
+	"simplelang/internal/optimizer"
+	"simplelang/internal/types"
+	"testing"
+)
+
+// foldExpression parses source as a print value and folds it
+func foldExpression(t *testing.T, source string) ast.Expression {
+	t.Helper()
+
+	program := optimizer.Fold(parseProgram(t, "print "+source))
+	return program.Statements[0].(*ast.PrintStatement).Value
+}
+
+func TestFoldConstantExpressions(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"2 + 3 * 4", "14"},
+		{"(2 + 3) * 4", "20"},
+		{"2 ^ 3 ^ 2", "512"},
+		{"7 % 3", "1"},
+		{"2.5 * 2", "5.0"},
+		{"1 / 4", "0.25"},
+		{"-(1 + 2)", "-3"},
+		{`"a" + "b"`, "ab"},
+		{"1 < 2", "true"},
+		{"not (1 < 2)", "false"},
+		{"1 < 2 and 3 > 4", "false"},
+		{"x + 2 * 3", "(+ x 6)"},
+		{"2 * 3 + x", "(+ 6 x)"},
+		{"x * (1 + 1) - f(2 + 2)", "(- (* x 2) (f 4))"},
+		{`"héllo"[1]`, "é"},
+		{`"héllo"[1 + 1:]`, "llo"},
+		{"s[1 + 1]", "([] s 2)"},
+	}
+
+	for _, tt := range tests {
+		if got := shape(foldExpression(t, tt.source)); got != tt.want {
+			t.Errorf("%q: expected %s, got %s", tt.source, tt.want, got)
+		}
+	}
+}
+
+func TestFoldKeepsLiteralTypes(t *testing.T) {
+	tests := []struct {
+		source string
+		want   types.Type
+	}{
+		{"1 + 2", types.IntType{}},
+		{"1 + 2.0", types.NumberType{}},
+		{"6 / 3", types.NumberType{}},
+		{`"n" + 1`, types.TextType{}},
+		{"1 == 1", types.BooleanType{}},
+	}
+
+	for _, tt := range tests {
+		literal, ok := foldExpression(t, tt.source).(*ast.Literal)
+		if !ok {
+			t.Errorf("%q: expected a literal", tt.source)
+			continue
+		}
+		if literal.Type != tt.want {
+			t.Errorf("%q: expected type %s, got %s", tt.source, tt.want, literal.Type)
+		}
+	}
+}
+
+func TestFoldLeavesFailingExpressions(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{"1 / 0", "(/ 1 0)"},
+		{"5 % 0", "(% 5 0)"},
+		{"1 / (1 - 1)", "(/ 1 0)"},
+		{"1 - 2 / 0", "(- 1 (/ 2 0))"},
+		{`"a" - 1`, "(- a 1)"},
+		{"10.0 ^ 400", "(^ 10.0 400)"},
+		{`"ab"[2]`, "([] ab 2)"},
+	}
+
+	for _, tt := range tests {
+		if got := shape(foldExpression(t, tt.source)); got != tt.want {
+			t.Errorf("%q: expected %s, got %s", tt.source, tt.want, got)
+		}
+	}
+
+	if _, err := runProgram(t, "print 1 / 0"); err == nil {
+		t.Error("expected division by zero to still fail at runtime")
+	}
+}
+
+func TestFoldReachesEveryStatement(t *testing.T) {
+	source := `integer a = 1 + 1
+loop i from 0 to 2 * 2 step 1 + 1
+    print i * (3 - 1)
+end
+if 1 < 2 then
+    a = a + 2 * 2
+end
+for x in [1 + 1, 2 * 3]
+    print x
+end
+function f(integer n)
+    print n + 10 * 10
+end
+`
+	want := `integer a = 2
+loop i from 0 to 4 step 2
+    print i * 2
+end
+if true then
+    a = a + 4
+end
+for x in [2, 6]
+    print x
+end
+
+function f(integer n)
+    print n + 100
+end
+`
+
+	program := optimizer.Fold(parseProgram(t, source))
+	if got := ast.NewFormatter().Format(program); got != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func TestFoldedProgramBehavesTheSame(t *testing.T) {
+	source := `number r = 2.5 * 2
+integer n = -(2 + 1)
+print r
+print n
+print (0 - 2) ^ 2
+print "total: " + (1 + 2)
+`
+	plain, err := runProgram(t, source)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	folded := ast.NewFormatter().Format(optimizer.Fold(parseProgram(t, source)))
+	got, err := runProgram(t, folded)
+	if err != nil {
+		t.Fatalf("unexpected error running folded source:\n%s\n%v", folded, err)
+	}
+	if got != plain {
+		t.Errorf("expected %q, got %q", plain, got)
+	}
+}
@@ -0,0 +1,735 @@
+package tests
+
+import (
+	"fmt"
+	"simplelang/internal/ast"
+	"simplelang/internal/diagnostic"
+	"simplelang/internal/lexer"
+	"simplelang/internal/parser"
+	"strings"
+	"testing"
+)
+
+// parseExpression parses source as the value of a print statement
+func parseExpression(t *testing.T, source string) ast.Expression {
+	t.Helper()
+
+	program := parseProgram(t, "print "+source)
+	if len(program.Statements) != 1 {
+		t.Fatalf("%q: expected a single statement, got %d", source, len(program.Statements))
+	}
+	return program.Statements[0].(*ast.PrintStatement).Value
+}
+
+// shape renders an expression fully parenthesized in prefix form, so that
+// 2 + 3 * 4 becomes (+ 2 (* 3 4))
+func shape(expr ast.Expression) string {
+	switch e := expr.(type) {
+	case *ast.BinaryExpression:
+		return fmt.Sprintf("(%s %s %s)", e.Operator, shape(e.Left), shape(e.Right))
+	case *ast.UnaryExpression:
+		return fmt.Sprintf("(%s %s)", e.Operator, shape(e.Operand))
+	case *ast.Literal:
+		return fmt.Sprint(e.Value)
+	case *ast.Identifier:
+		return e.Name
+	case *ast.FunctionCall:
+		args := make([]string, len(e.Arguments))
+		for i, arg := range e.Arguments {
+			args[i] = shape(arg)
+		}
+		name := e.Name
+		if e.Callee != nil {
+			name = shape(e.Callee)
+		}
+		return fmt.Sprintf("(%s %s)", name, strings.Join(args, " "))
+	case *ast.IndexExpression:
+		return fmt.Sprintf("([] %s %s)", shape(e.Target), shape(e.Index))
+	case *ast.SliceExpression:
+		bounds := []string{"_", "_"}
+		if e.Start != nil {
+			bounds[0] = shape(e.Start)
+		}
+		if e.End != nil {
+			bounds[1] = shape(e.End)
+		}
+		return fmt.Sprintf("([:] %s %s %s)", shape(e.Target), bounds[0], bounds[1])
+	default:
+		return fmt.Sprintf("%T", expr)
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	cases := []struct {
+		name     string
+		source   string
+		expected string
+	}{
+		{"multiplication over addition", "2 + 3 * 4", "(+ 2 (* 3 4))"},
+		{"division and modulo over subtraction", "a - b / c % d", "(- a (% (/ b c) d))"},
+		{"integer division beside multiplication", "a + b // c * d", "(+ a (* (// b c) d))"},
+		{"parentheses override", "(2 + 3) * 4", "(* (+ 2 3) 4)"},
+		{"addition over comparison", "a + 1 < b * 2", "(< (+ a 1) (* b 2))"},
+		{"comparison over equality", "1 < 2 == true", "(== (< 1 2) true)"},
+		{"mixed comparisons", "a < b != c >= d", "(!= (< a b) (>= c d))"},
+		{"equality over and", "a == b and c != d", "(and (== a b) (!= c d))"},
+		{"and over or", "a and b or c", "(or (and a b) c)"},
+		{"or on the right of and", "a or b and c", "(or a (and b c))"},
+		{"unary minus over multiplication", "-a * b", "(* (- a) b)"},
+		{"not over equality", "not a == b", "(== (not a) b)"},
+		{"power over unary minus", "-2 ^ 2", "(- (^ 2 2))"},
+		{"power over multiplication", "2 * 3 ^ 2", "(* 2 (^ 3 2))"},
+		{"negative exponent", "2 ^ -1", "(^ 2 (- 1))"},
+		{"call arguments", "f(1 + 2, 3) * 2", "(* (f (+ 1 2) 3) 2)"},
+		{"call of a call result", "f(1)(2) + 1", "(+ ((f 1) 2) 1)"},
+		{"call over power", "f(2)(3) ^ 2", "(^ ((f 2) 3) 2)"},
+		{"call of a parenthesized expression", "(g)(x)", "(g x)"},
+		{"addition over shift", "1 + a << b - 1", "(<< (+ 1 a) (- b 1))"},
+		{"shift over and", "a & b << 1", "(& a (<< b 1))"},
+		{"and over xor", "a xor b & c", "(xor a (& b c))"},
+		{"xor over or", "a | b xor c", "(| a (xor b c))"},
+		{"or over comparison", "a | b < c", "(< (| a b) c)"},
+		{"mask before equality", "flags & 4 == 4", "(== (& flags 4) 4)"},
+		{"index over addition", "s[i + 1] + t[0]", "(+ ([] s (+ i 1)) ([] t 0))"},
+		{"index of a call result", "f(1)[2][3]", "([] ([] (f 1) 2) 3)"},
+		{"call of an index", "fs[0](1)", "(([] fs 0) 1)"},
+		{"index over power", "xs[0] ^ 2", "(^ ([] xs 0) 2)"},
+		{"slice", "s[1:n - 1]", "([:] s 1 (- n 1))"},
+		{"method call", "s.upper().length() + 1", "(+ (length (upper s)) 1)"},
+		{"method arguments", "xs.join(sep, 1).length()", "(length (join xs sep 1))"},
+		{"method of an index", "-xs[0].length() ^ 2", "(- (^ (length ([] xs 0)) 2))"},
+		{"slice without bounds", "s[:2] + s[2:] + s[:]", "(+ (+ ([:] s _ 2) ([:] s 2 _)) ([:] s _ _))"},
+	}
+
+	for _, c := range cases {
+		got := shape(parseExpression(t, c.source))
+		if got != c.expected {
+			t.Errorf("%s: %q parsed as %s, expected %s", c.name, c.source, got, c.expected)
+		}
+	}
+}
+
+func TestAssociativity(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{"a - b - c", "(- (- a b) c)"},
+		{"a / b / c", "(/ (/ a b) c)"},
+		{"a % b * c", "(* (% a b) c)"},
+		{"a == b == c", "(== (== a b) c)"},
+		{"a < b < c", "(< (< a b) c)"},
+		{"a and b and c", "(and (and a b) c)"},
+		{"a or b or c", "(or (or a b) c)"},
+		{"a << b >> c", "(>> (<< a b) c)"},
+		{"a & b & c", "(& (& a b) c)"},
+		{"2 ^ 3 ^ 2", "(^ 2 (^ 3 2))"},
+		{"- - a", "(- (- a))"},
+	}
+
+	for _, c := range cases {
+		got := shape(parseExpression(t, c.source))
+		if got != c.expected {
+			t.Errorf("%q parsed as %s, expected %s", c.source, got, c.expected)
+		}
+	}
+}
+
+func TestPowerEvaluation(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{`print 2 ^ 10`, "1024\n"},
+		{`print 2 ^ 3 ^ 2`, "512\n"},
+		{`print -2 ^ 2`, "-4\n"},
+		{`print (-2) ^ 2`, "4\n"},
+		{`print 2 ^ -1`, "0.5\n"},
+		{`print 9 ^ 0.5`, "3\n"},
+		{`print 5 ^ 0`, "1\n"},
+		{"integer n = 3 ^ 4\nprint n", "81\n"},
+	}
+
+	for _, c := range cases {
+		out, err := runProgram(t, c.source)
+		if err != nil {
+			t.Errorf("%q failed: %v", c.source, err)
+			continue
+		}
+		if out != c.expected {
+			t.Errorf("%q: expected %q, got %q", c.source, c.expected, out)
+		}
+	}
+}
+
+func TestBitwiseEvaluation(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{`print 12 & 10`, "8\n"},
+		{`print 12 | 10`, "14\n"},
+		{`print 12 xor 10`, "6\n"},
+		{`print 1 << 4`, "16\n"},
+		{`print 256 >> 4`, "16\n"},
+		{`print -8 >> 1`, "-4\n"},
+		{`print 1 << 64`, "0\n"},
+		{`print 6.0 | 1`, "7\n"},
+		{"integer mask = 0xFF & 0b1010\nprint mask", "10\n"},
+	}
+
+	for _, c := range cases {
+		out, err := runProgram(t, c.source)
+		if err != nil {
+			t.Errorf("%q failed: %v", c.source, err)
+			continue
+		}
+		if out != c.expected {
+			t.Errorf("%q: expected %q, got %q", c.source, c.expected, out)
+		}
+	}
+}
+
+func TestBitwiseErrors(t *testing.T) {
+	cases := map[string]string{
+		`print 1.5 & 1`:      "'&' needs whole numbers, got 1.5",
+		`print 1 | 2.25`:     "'|' needs whole numbers, got 2.25",
+		`print "a" xor 1`:    "cannot apply 'xor' to text",
+		`print 1 << -1`:      "cannot shift by a negative amount: -1",
+		`print (1 < 2) >> 1`: "cannot apply '>>' to boolean",
+	}
+
+	for source, message := range cases {
+		_, err := runProgram(t, source)
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("%q: expected %q, got %v", source, message, err)
+		}
+	}
+}
+
+func TestFormatterKeepsPrecedence(t *testing.T) {
+	for _, source := range []string{"(-2) ^ 2", "-2 ^ 2", "(2 ^ 3) ^ 2", "2 ^ 3 ^ 2", "2 ^ -1", "a - (b - c)", "(a or b) and c", "(a | b) & c", "(a << 1) + 2", "a xor (b xor c)", "(a + b)[1:]", "-s[0]", "f(x)[0]"} {
+		formatted := ast.NewFormatter().Format(parseProgram(t, "print "+source))
+		expected := "print " + source + "\n"
+		if formatted != expected {
+			t.Errorf("%q formatted as %q", source, formatted)
+		}
+	}
+}
+
+func TestParseExpressionRejectsLeftoverTokens(t *testing.T) {
+	cases := map[string]string{
+		"1 + 2 3":   "parse error at line 1, column 7: unexpected token after expression: 3",
+		"a = 1":     "parse error at line 1, column 3: unexpected token after expression: =",
+		"print 1":   "parse error at line 1, column 1: unexpected token: print",
+		"f(1)\nend": "parse error at line 2, column 1: unexpected token after expression: end",
+	}
+
+	for source, expected := range cases {
+		tokens, err := lexer.NewLexer(source).Tokenize()
+		if err != nil {
+			t.Fatalf("Lexer failed: %v", err)
+		}
+		if _, err := parser.ParseExpression(tokens); err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestDestructuringDeclaration(t *testing.T) {
+	program := parseProgram(t, "function f()\n    return 1, 2 + 3\nend\nnumber a, b = f()")
+
+	ret := program.Statements[0].(*ast.FunctionDeclaration).Body[0].(*ast.ReturnStatement)
+	values, ok := ret.Value.(*ast.ListLiteral)
+	if !ret.Multiple || !ok || len(values.Elements) != 2 {
+		t.Errorf("Expected a return of two values, got %#v", ret)
+	}
+
+	decl, ok := program.Statements[1].(*ast.DestructuringDeclaration)
+	if !ok {
+		t.Fatalf("Expected a destructuring declaration, got %T", program.Statements[1])
+	}
+	if strings.Join(decl.Names, ",") != "a,b" || decl.Type.String() != "number" || shape(decl.Value) != "(f )" {
+		t.Errorf("Unexpected declaration %#v", decl)
+	}
+}
+
+func TestDestructuringDeclarationErrors(t *testing.T) {
+	cases := map[string]string{
+		"let a, a = [1, 2]": "parse error at line 1, column 8: variable a is declared twice",
+		"let a, 1 = [1, 2]": "parse error at line 1, column 8: expected identifier after ',', got 1",
+		"integer a, b":      "parse error at line 1, column 13: expected '=' after variable names, got end of input",
+	}
+
+	for source, expected := range cases {
+		tokens, err := lexer.NewLexer(source).Tokenize()
+		if err != nil {
+			t.Fatalf("Lexer failed: %v", err)
+		}
+		if _, err := parser.NewParser(tokens).Parse(); err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestMultipleDeclarationTrailingComma(t *testing.T) {
+	tokens, err := lexer.NewLexer("number a = 1, b = 2,").Tokenize()
+	if err != nil {
+		t.Fatalf("Lexer failed: %v", err)
+	}
+	_, err = parser.NewParser(tokens).Parse()
+	expected := "parse error at line 1, column 21: expected identifier after ',', got end of input"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected %q, got %v", expected, err)
+	}
+}
+
+func TestParseReportsEveryError(t *testing.T) {
+	source := `number a =
+print "still parsed"
+if a < then
+    print a
+end
+loop i from 1 to 3
+    print i
+end
+print )
+text b = "fine"`
+
+	tokens, err := lexer.NewLexer(source).Tokenize()
+	if err != nil {
+		t.Fatalf("Lexer failed: %v", err)
+	}
+	program, err := parser.NewParser(tokens).Parse()
+
+	errs, ok := err.(parser.ErrorList)
+	if !ok {
+		t.Fatalf("Expected an ErrorList, got %v", err)
+	}
+
+	expected := []struct {
+		line    int
+		message string
+	}{
+		{2, "unexpected token: print"},
+		{3, "unexpected token: then"},
+		{9, "unexpected token: )"},
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got %d:\n%v", len(expected), len(errs), err)
+	}
+	for i, e := range expected {
+		if errs[i].Line != e.line || errs[i].Message != e.message {
+			t.Errorf("Error %d: expected %q at line %d, got %q at line %d", i, e.message, e.line, errs[i].Message, errs[i].Line)
+		}
+	}
+
+	// The statements around the errors are still parsed
+	var kinds []string
+	for _, stmt := range program.Statements {
+		kinds = append(kinds, fmt.Sprintf("%T", stmt))
+	}
+	if got := strings.Join(kinds, " "); got != "*ast.PrintStatement *ast.LoopStatement *ast.VariableDeclaration" {
+		t.Errorf("Unexpected partial program: %s", got)
+	}
+}
+
+func TestParseErrorText(t *testing.T) {
+	tokens, err := lexer.NewLexer("print (1").Tokenize()
+	if err != nil {
+		t.Fatalf("Lexer failed: %v", err)
+	}
+	_, err = parser.NewParser(tokens).Parse()
+	if err == nil || !strings.HasPrefix(err.Error(), "parse error at line 1, column ") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestReturnOutsideFunction(t *testing.T) {
+	tokens, err := lexer.NewLexer("print 1\nreturn 2").Tokenize()
+	if err != nil {
+		t.Fatalf("Lexer failed: %v", err)
+	}
+
+	_, err = parser.NewParser(tokens).Parse()
+	if err == nil || err.Error() != "parse error at line 2, column 1: 'return' outside of a function" {
+		t.Errorf("Expected a return error, got %v", err)
+	}
+}
+
+func TestIncludeOnlyAtTopLevel(t *testing.T) {
+	program := parseProgram(t, "include \"utils.sl\"")
+	if include, ok := program.Statements[0].(*ast.IncludeStatement); !ok || include.Path != "utils.sl" {
+		t.Errorf("Expected an include of utils.sl, got %#v", program.Statements[0])
+	}
+
+	for source, expected := range map[string]string{
+		"if true then\n    include \"utils.sl\"\nend": "parse error at line 2, column 5: 'include' is only allowed at the top level of a program",
+		"include utils": "parse error at line 1, column 9: expected file name text after 'include', got utils",
+	} {
+		tokens, err := lexer.NewLexer(source).Tokenize()
+		if err != nil {
+			t.Fatalf("Lexer failed: %v", err)
+		}
+		_, err = parser.NewParser(tokens).Parse()
+		if err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestImportedModuleCalls(t *testing.T) {
+	program := parseProgram(t, "import \"math.sl\" as m\nprint m.add(1, 2)\nprint s.upper()")
+	if imported, ok := program.Statements[0].(*ast.ImportStatement); !ok || imported.Path != "math.sl" || imported.Alias != "m" {
+		t.Errorf("Expected an import of math.sl as m, got %#v", program.Statements[0])
+	}
+	call := program.Statements[1].(*ast.PrintStatement).Value.(*ast.FunctionCall)
+	if call.Name != "m.add" || call.Method || len(call.Arguments) != 2 {
+		t.Errorf("Expected a call to m.add with 2 arguments, got %s, method %v, %d arguments", call.Name, call.Method, len(call.Arguments))
+	}
+	if method := program.Statements[2].(*ast.PrintStatement).Value.(*ast.FunctionCall); !method.Method {
+		t.Errorf("Expected s.upper() to stay a method call")
+	}
+
+	for source, expected := range map[string]string{
+		"function f()\n    import \"math.sl\" as m\nend": "parse error at line 2, column 5: 'import' is only allowed at the top level of a program",
+		"import \"math.sl\"":                             "parse error at line 1, column 17: expected 'as' and a module name after the imported file, got end of input",
+		"import \"math.sl\" as loop":                     "parse error at line 1, column 21: 'loop' is a reserved keyword and cannot be used as a name",
+		"integer as = 1":                                 "parse error at line 1, column 9: 'as' is a reserved keyword and cannot be used as a name",
+	} {
+		tokens, err := lexer.NewLexer(source).Tokenize()
+		if err != nil {
+			t.Fatalf("Lexer failed: %v", err)
+		}
+		_, err = parser.NewParser(tokens).Parse()
+		if err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestReturnValueMustStartOnSameLine(t *testing.T) {
+	program := parseProgram(t, "function f()\n    return\n    print 1\nend")
+	body := program.Statements[0].(*ast.FunctionDeclaration).Body
+	if len(body) != 2 {
+		t.Fatalf("Expected return and print, got %d statements", len(body))
+	}
+	if ret := body[0].(*ast.ReturnStatement); ret.Value != nil {
+		t.Errorf("Expected a bare return, got value %s", shape(ret.Value))
+	}
+}
+
+func TestStatementsMustBeSeparated(t *testing.T) {
+	cases := map[string]string{
+		"print 1 2":             "parse error at line 1, column 9: unexpected 2 after the end of a statement; put the next statement on a new line or separate them with ';'",
+		"integer a = 1 print a": "parse error at line 1, column 15: unexpected print after the end of a statement; put the next statement on a new line or separate them with ';'",
+		"print 1 print 2":       "parse error at line 1, column 9: unexpected print after the end of a statement; put the next statement on a new line or separate them with ';'",
+	}
+
+	for source, expected := range cases {
+		tokens, err := lexer.NewLexer(source).Tokenize()
+		if err != nil {
+			t.Fatalf("Lexer failed: %v", err)
+		}
+		if _, err := parser.NewParser(tokens).Parse(); err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestStatementBoundaries(t *testing.T) {
+	tests := map[string]string{
+		"print 1; print 2;":                                  "1\n2\n",
+		"if 1 < 2 then print 1 else print 2 end":             "1\n",
+		"integer a = 1 +\n    2\nprint a\n    * 3":           "9\n",
+		"function f(integer n) return n end; print f(1 + 1)": "2\n",
+	}
+
+	for source, expected := range tests {
+		out, err := runProgram(t, source)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", source, err)
+		} else if out != expected {
+			t.Errorf("%q: expected output %q, got %q", source, expected, out)
+		}
+	}
+}
+
+func TestLineStartingWithMinusOrParenIsANewStatement(t *testing.T) {
+	cases := map[string]string{
+		"integer a = 1\nprint a\n-1":  "parse error at line 3, column 1: unexpected token: -",
+		"integer a = 1\nprint a\n(2)": "parse error at line 3, column 1: unexpected token: (",
+	}
+
+	for source, expected := range cases {
+		tokens, err := lexer.NewLexer(source).Tokenize()
+		if err != nil {
+			t.Fatalf("Lexer failed: %v", err)
+		}
+		if _, err := parser.NewParser(tokens).Parse(); err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestMethodCallErrors(t *testing.T) {
+	cases := map[string]string{
+		"print s.1":             "parse error at line 1, column 9: expected method name after '.', got 1",
+		"print s.upper().lower": "parse error at line 1, column 22: expected '(' after method name lower, got end of input",
+		"print .upper()":        "parse error at line 1, column 7: unexpected token: .",
+	}
+
+	for source, expected := range cases {
+		tokens, err := lexer.NewLexer(source).Tokenize()
+		if err != nil {
+			t.Fatalf("Lexer failed: %v", err)
+		}
+		if _, err := parser.NewParser(tokens).Parse(); err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestIndexErrors(t *testing.T) {
+	cases := map[string]string{
+		"print s[1 2]": "parse error at line 1, column 11: expected ']' or ':' after index, got 2",
+		"print s[1:2":  "parse error at line 1, column 12: expected ']' after slice, got end of input",
+		"print s[]":    "parse error at line 1, column 9: unexpected token: ]",
+	}
+
+	for source, expected := range cases {
+		tokens, err := lexer.NewLexer(source).Tokenize()
+		if err != nil {
+			t.Fatalf("Lexer failed: %v", err)
+		}
+		if _, err := parser.NewParser(tokens).Parse(); err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestUnterminatedBlocks(t *testing.T) {
+	cases := map[string]string{
+		"integer x = 1\nif x > 0 then\n    print x\n":                    "parse error at line 4, column 1: unexpected end of file; expected 'end' to close the if started at line 2",
+		"if x > 0 then\n    print x\nelse\n    print 0":                  "parse error at line 4, column 12: unexpected end of file; expected 'end' to close the if started at line 1",
+		"loop i from 1 to 3\n    print i":                                "parse error at line 2, column 12: unexpected end of file; expected 'end' to close the loop started at line 1",
+		"function f()\n    loop i from 1 to 3\n        print i\n    end": "parse error at line 4, column 8: unexpected end of file; expected 'end' to close the function started at line 1",
+		"let f = function(integer n)\n    return n":                      "parse error at line 2, column 13: unexpected end of file; expected 'end' to close the function started at line 1",
+		"for x in [1]\n    print x":                                      "parse error at line 2, column 12: unexpected end of file; expected 'end' to close the for started at line 1",
+		"try\n    print 1":                                               "parse error at line 2, column 12: unexpected end of file; expected 'catch' to follow the try started at line 1",
+		"begin\n    print 1":                                             "parse error at line 2, column 12: unexpected end of file; expected 'end' to close the begin started at line 1",
+	}
+
+	for source, expected := range cases {
+		tokens, err := lexer.NewLexer(source).Tokenize()
+		if err != nil {
+			t.Fatalf("Lexer failed: %v", err)
+		}
+		if _, err := parser.NewParser(tokens).Parse(); err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestRequiredParameterAfterDefault(t *testing.T) {
+	tokens, err := lexer.NewLexer("function f(integer a = 1, integer b)\nend").Tokenize()
+	if err != nil {
+		t.Fatalf("Lexer failed: %v", err)
+	}
+
+	_, err = parser.NewParser(tokens).Parse()
+	if err == nil || !strings.HasSuffix(err.Error(), "parameter b needs a default value because the parameters before it have one") {
+		t.Errorf("Expected a parameter order error, got %v", err)
+	}
+}
+
+func TestFunctionLiteralIsAnExpression(t *testing.T) {
+	program := parseProgram(t, "let f = function(number x) return x end\nfunction(text s) print s end(\"hi\")\nfunction g()\nend")
+	if len(program.Statements) != 3 {
+		t.Fatalf("Expected 3 statements, got %d", len(program.Statements))
+	}
+
+	decl, ok := program.Statements[0].(*ast.VariableDeclaration)
+	if !ok {
+		t.Fatalf("Expected a variable declaration, got %T", program.Statements[0])
+	}
+	if _, ok := decl.Value.(*ast.FunctionLiteral); !ok {
+		t.Errorf("Expected a function literal, got %T", decl.Value)
+	}
+
+	stmt, ok := program.Statements[1].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("Expected an expression statement, got %T", program.Statements[1])
+	}
+	if call, ok := stmt.Expression.(*ast.FunctionCall); !ok || call.Callee == nil {
+		t.Errorf("Expected a call of a function literal, got %T", stmt.Expression)
+	}
+
+	if _, ok := program.Statements[2].(*ast.FunctionDeclaration); !ok {
+		t.Errorf("Expected a function declaration, got %T", program.Statements[2])
+	}
+}
+
+func TestEnumDeclaration(t *testing.T) {
+	program := parseProgram(t, "enum Color red green\n    blue\nend\nprint Color.red")
+	enum, ok := program.Statements[0].(*ast.EnumDeclaration)
+	if !ok {
+		t.Fatalf("Expected an enum declaration, got %T", program.Statements[0])
+	}
+	if enum.Name != "Color" || strings.Join(enum.Members, " ") != "red green blue" {
+		t.Errorf("Unexpected enum %s with members %v", enum.Name, enum.Members)
+	}
+	stmt := program.Statements[1].(*ast.PrintStatement)
+	if ident, ok := stmt.Value.(*ast.Identifier); !ok || ident.Name != "Color.red" {
+		t.Errorf("Expected the member Color.red, got %#v", stmt.Value)
+	}
+}
+
+func TestEnumDeclarationErrors(t *testing.T) {
+	cases := map[string]string{
+		"enum 1 end":         "parse error at line 1, column 6: expected enum name after 'enum', got 1",
+		"enum Color end":     "parse error at line 1, column 12: enum Color has no members",
+		"enum Color a a end": "parse error at line 1, column 14: duplicate member a in enum Color",
+		"enum Color a 1 end": "parse error at line 1, column 14: expected member name in enum Color, got 1",
+		"enum Color a":       "parse error at line 1, column 13: unexpected end of file; expected 'end' to close the enum started at line 1",
+	}
+
+	for source, expected := range cases {
+		tokens, err := lexer.NewLexer(source).Tokenize()
+		if err != nil {
+			t.Fatalf("Lexer failed: %v", err)
+		}
+		if _, err := parser.NewParser(tokens).Parse(); err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestKeywordsCannotBeNames(t *testing.T) {
+	cases := map[string]string{
+		"integer loop = 1":                 "parse error at line 1, column 9: 'loop' is a reserved keyword and cannot be used as a name",
+		"let if = 1":                       "parse error at line 1, column 5: 'if' is a reserved keyword and cannot be used as a name",
+		"text a, end = \"x\", \"y\"":       "parse error at line 1, column 9: 'end' is a reserved keyword and cannot be used as a name",
+		"let a, true = [1, 2]":             "parse error at line 1, column 8: 'true' is a reserved keyword and cannot be used as a name",
+		"function print()\nend":            "parse error at line 1, column 10: 'print' is a reserved keyword and cannot be used as a name",
+		"function f(integer step)\nend":    "parse error at line 1, column 20: 'step' is a reserved keyword and cannot be used as a name",
+		"let f = function(text in) end":    "parse error at line 1, column 23: 'in' is a reserved keyword and cannot be used as a name",
+		"loop to from 1 to 2\nend":         "parse error at line 1, column 6: 'to' is a reserved keyword and cannot be used as a name",
+		"for nil in [1]\nend":              "parse error at line 1, column 5: 'nil' is a reserved keyword and cannot be used as a name",
+		"try\n    print 1\ncatch and\nend": "parse error at line 3, column 7: 'and' is a reserved keyword and cannot be used as a name",
+		"enum Color red case end":          "parse error at line 1, column 16: 'case' is a reserved keyword and cannot be used as a name",
+		"integer 1 = 1":                    "parse error at line 1, column 9: expected identifier after type, got 1",
+	}
+
+	for source, expected := range cases {
+		tokens, err := lexer.NewLexer(source).Tokenize()
+		if err != nil {
+			t.Fatalf("Lexer failed: %v", err)
+		}
+		if _, err := parser.NewParser(tokens).Parse(); err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+
+	// Parsing carries on after a rejected name instead of taking it for the
+	// keyword, which would swallow the rest of the program into its block
+	tokens, err := lexer.NewLexer("integer loop = 1\nlet if = 2\nprint 3").Tokenize()
+	if err != nil {
+		t.Fatalf("Lexer failed: %v", err)
+	}
+	program, err := parser.NewParser(tokens).Parse()
+	if errs, ok := err.(parser.ErrorList); !ok || len(errs) != 2 {
+		t.Errorf("Expected two errors, got %v", err)
+	}
+	if len(program.Statements) != 1 {
+		t.Errorf("Expected the print statement to be parsed, got %d statements", len(program.Statements))
+	}
+}
+
+func TestParsingPastTheLastToken(t *testing.T) {
+	// A host may hand the parser tokens without the EOF token the lexer ends
+	// with; looking past them still finds an end of file at a real position
+	cases := map[string]string{
+		"print 1 +":        "parse error at line 1, column 10: unexpected token: end of input",
+		"print (1\n  + 2":  "parse error at line 2, column 6: expected ')', got end of input",
+		"enum Color red":   "parse error at line 1, column 15: unexpected end of file; expected 'end' to close the enum started at line 1",
+		"print 1 +   \n\n": "parse error at line 1, column 10: unexpected token: end of input",
+	}
+
+	for source, expected := range cases {
+		tokens, err := lexer.NewLexer(source).Tokenize()
+		if err != nil {
+			t.Fatalf("Lexer failed: %v", err)
+		}
+		withoutEOF := tokens[:len(tokens)-1]
+		if _, err := parser.NewParser(withoutEOF).Parse(); err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+
+	_, err := parser.ParseExpression(nil)
+	if expected := "parse error at line 1, column 1: unexpected token: end of input"; err == nil || err.Error() != expected {
+		t.Errorf("Expected %q, got %v", expected, err)
+	}
+}
+
+func TestRepeatStatementErrors(t *testing.T) {
+	cases := map[string]string{
+		"repeat\n    print 1":        "parse error at line 2, column 12: unexpected end of file; expected 'until' to close the repeat started at line 1",
+		"repeat\n    print 1\nend":   "parse error at line 3, column 1: expected 'until' after repeat body, got end",
+		"repeat\n    print 1\nuntil": "parse error at line 3, column 6: unexpected token: end of input",
+	}
+
+	for source, expected := range cases {
+		tokens, err := lexer.NewLexer(source).Tokenize()
+		if err != nil {
+			t.Fatalf("Lexer failed: %v", err)
+		}
+		if _, err := parser.NewParser(tokens).Parse(); err == nil || err.Error() != expected {
+			t.Errorf("%q: expected %q, got %v", source, expected, err)
+		}
+	}
+
+	// Recovery skips a broken body through its 'until' and its condition
+	tokens, err := lexer.NewLexer("repeat\n    print )\nuntil x > 1\nprint 2").Tokenize()
+	if err != nil {
+		t.Fatalf("Lexer failed: %v", err)
+	}
+	program, err := parser.NewParser(tokens).Parse()
+	if errs, ok := err.(parser.ErrorList); !ok || len(errs) != 1 {
+		t.Errorf("Expected one error, got %v", err)
+	}
+	if len(program.Statements) != 1 {
+		t.Errorf("Expected the print after the loop to be parsed, got %d statements", len(program.Statements))
+	}
+}
+
+func TestParseErrorIsADiagnostic(t *testing.T) {
+	tokens, err := lexer.NewLexer("integer x = 1\nif x > 0 print x end").Tokenize()
+	if err != nil {
+		t.Fatalf("Lexer failed: %v", err)
+	}
+	_, err = parser.NewParser(tokens).Parse()
+	errs, ok := err.(parser.ErrorList)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("Expected one parse error, got %v", err)
+	}
+
+	expected := diagnostic.Diagnostic{
+		Severity: diagnostic.Error,
+		Phase:    diagnostic.Parse,
+		Message:  "expected 'then' after condition, got print",
+		Line:     2,
+		Column:   10,
+		Length:   5,
+	}
+	if *errs[0] != expected {
+		t.Errorf("Expected %+v, got %+v", expected, *errs[0])
+	}
+	if got := errs[0].Error(); got != "parse error at line 2, column 10: expected 'then' after condition, got print" {
+		t.Errorf("Unexpected error text: %q", got)
+	}
+	if got := errs[0].Format("main.sl"); got != "main.sl:2:10: error: expected 'then' after condition, got print" {
+		t.Errorf("Unexpected formatted diagnostic: %q", got)
+	}
+}
@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"bytes"
+	"simplelang/internal/repl"
+	"strings"
+	"testing"
+)
+
+func TestREPLSession(t *testing.T) {
+	input := strings.Join([]string{
+		`number x = 10`,
+		`x * 2`,
+		`function double(number n)`,
+		`    print n * 2`,
+		`end`,
+		`double(x + 1)`,
+		`print "done"`,
+		`enum Level low`,
+		`    high`,
+		`end`,
+		`Level.high`,
+		`missing`,
+		`x`,
+	}, "\n")
+
+	var out bytes.Buffer
+	if err := repl.Run(strings.NewReader(input), &out); err != nil {
+		t.Fatalf("REPL failed: %v", err)
+	}
+
+	expected := []string{
+		"SimpleLang REPL - press Ctrl-D to exit",
+		">>> >>> 20",
+		">>> ... ... >>> 22",
+		">>> done",
+		">>> ... ... >>> Level.high",
+		">>> Error: runtime error at line 1, column 1: undefined variable: missing",
+		">>> 10",
+		">>> ",
+		"",
+	}
+	if got := out.String(); got != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected session output.\nExpected:\n%s\nGot:\n%s", strings.Join(expected, "\n"), got)
+	}
+}
+
+func TestREPLCallsModulesImportedEarlier(t *testing.T) {
+	input := strings.Join([]string{
+		`import "testdata/include/math.sl" as m`,
+		`m.add(1, 2)`,
+		`m.calls`,
+	}, "\n")
+
+	var out bytes.Buffer
+	if err := repl.Run(strings.NewReader(input), &out); err != nil {
+		t.Fatalf("REPL failed: %v", err)
+	}
+	if expected := ">>> loading math\n>>> 3\n>>> 1\n>>> \n"; !strings.HasSuffix(out.String(), expected) {
+		t.Errorf("Expected the session to end with %q, got %q", expected, out.String())
+	}
+}
+
+func TestREPLEchoesAnyExpression(t *testing.T) {
+	input := strings.Join([]string{
+		`integer n = 4`,
+		`1 + 2`,
+		`"a"`,
+		`(n)`,
+		`-n`,
+		`[1, "two"]`,
+		`not true`,
+		`1 +`,
+	}, "\n")
+
+	var out bytes.Buffer
+	if err := repl.Run(strings.NewReader(input), &out); err != nil {
+		t.Fatalf("REPL failed: %v", err)
+	}
+
+	expected := []string{
+		"SimpleLang REPL - press Ctrl-D to exit",
+		">>> >>> 3",
+		">>> a",
+		">>> 4",
+		">>> -4",
+		`>>> [1, "two"]`,
+		">>> false",
+		">>> Error: parse error at line 2, column 1: unexpected token: end of input",
+		">>> ",
+		"",
+	}
+	if got := out.String(); got != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected session output.\nExpected:\n%s\nGot:\n%s", strings.Join(expected, "\n"), got)
+	}
+}
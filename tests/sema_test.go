@@ -0,0 +1,301 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"simplelang/internal/sema"
+	"simplelang/internal/types"
+	"strings"
+	"testing"
+)
+
+// checkProgram parses source and returns the messages of every semantic
+// error found in it
+func checkProgram(t *testing.T, source string) []string {
+	t.Helper()
+
+	var messages []string
+	for _, err := range sema.Check(parseProgram(t, source)) {
+		messages = append(messages, err.Message)
+	}
+	return messages
+}
+
+func TestSemaReportsEveryError(t *testing.T) {
+	source := `print "start"
+number total = "ten"
+function greet(text name)
+    print "Hello " + name
+end
+greet("a", "b")
+print missing`
+
+	messages := checkProgram(t, source)
+	expected := []string{
+		"type mismatch: cannot assign text to variable of type number",
+		"function greet expects 1 arguments, got 2",
+		"undefined variable: missing",
+	}
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected:\n%s\nGot:\n%s", strings.Join(expected, "\n"), strings.Join(messages, "\n"))
+	}
+}
+
+func TestSemaErrorPosition(t *testing.T) {
+	errs := sema.Check(parseProgram(t, "integer a = 1\nprint a + b"))
+	if len(errs) != 1 {
+		t.Fatalf("Expected one error, got %v", errs)
+	}
+	if errs[0].Line != 2 || !strings.HasPrefix(errs[0].Error(), "semantic error at line 2, column ") {
+		t.Errorf("Unexpected error: %v", errs[0])
+	}
+}
+
+func TestSemaChecks(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{"undefinedFunction()", "undefined function: undefinedFunction"},
+		{"function f(integer n)\nend\nf(1.5)", "type mismatch in function f: parameter n expects integer, got number"},
+		{"if 1 then\n    print 1\nend", "condition must be boolean, got integer"},
+		{"loop i from 1 to \"ten\"\nend", "loop bounds must be numbers, got text"},
+		{"loop i from 1 to 3\n    integer n = i\nend", ""},
+		{"loop i from 1 to 3 step 0.5\n    integer n = i\nend", "type mismatch: cannot assign number to variable of type integer"},
+		{"loop i from 1.0 to 3\n    integer n = i\nend", "type mismatch: cannot assign number to variable of type integer"},
+		{"for x in 5\nend", "cannot iterate over integer, expected a list"},
+		{"if 1 < 2 then\n    integer x = 1\nend\nprint x", "undefined variable: x"},
+		{"if 1 > 2 then\nelse\n    integer x = 1\nend\nprint x", "undefined variable: x"},
+		{"switch 1\ncase 1\n    number s = 2\nend\nprint s", "undefined variable: s"},
+		{"switch 1\ncase 2\ndefault\n    number s = 2\nend\nprint s", "undefined variable: s"},
+		{"try\n    number s = 2\ncatch e\nend\nprint s", "undefined variable: s"},
+		{"try\ncatch e\n    number s = 2\nend\nprint s", "undefined variable: s"},
+		{"begin\n    number s = 2\nend\nprint s", "undefined variable: s"},
+		{"if true then\n    eval(\"number z = 3\")\nend\nprint z", "undefined variable: z"},
+		{"print z\neval(\"number z = 3\")", "undefined variable: z"},
+		{"number s = 1\nbegin\n    text s = \"a\"\n    print s + \"!\"\nend\nprint s - 1", ""},
+		{`print 1 - "a"`, "cannot apply '-' to integer and text"},
+		{`print "a" < "b"`, ""},
+		{"print 9223372036854775808", "integer literal 9223372036854775808 is too large for an integer"},
+		{"print -(1 + 9223372036854775808)", "integer literal 9223372036854775808 is too large for an integer"},
+		{"integer smallest = -9223372036854775808", ""},
+		{`print "a" < 1`, "cannot compare text and integer"},
+		{`print 'a' < 'b'`, ""},
+		{"number x = 3 + 2\nprint x < 5.5", ""},
+		{`integer n = 3 + 2.5`, "type mismatch: cannot assign number to variable of type integer"},
+		{`print 'a' < "b"`, "cannot compare char and text"},
+		{`print 'a' + 1`, "cannot apply '+' to char and integer"},
+		{`text s = 'a' + "b" + 'c'`, ""},
+		{`text s = 'a'`, "type mismatch: cannot assign char to variable of type text"},
+		{`print 1 < 2 < 3`, "cannot compare boolean and integer"},
+		{`print -"a"`, "cannot negate non-number value"},
+		{"integer n = 1.0 & 3 | 1 << 2", ""},
+		{"function f()\n    return 1, 2\nend\nlet a, b = f()\nprint a + b", ""},
+		{"let a, b = 1", "cannot unpack integer into 2 variables"},
+		{"let a, b = [1]", "cannot unpack 1 values into 2 variables"},
+		{"text a, b = [\"x\", \"y\"]\nprint a - b", "cannot apply '-' to text and text"},
+		{`print "a" xor 1`, "cannot apply 'xor' to text and integer"},
+		{`print 1 and 1 < 2`, "left operand of 'and' must be boolean, got integer"},
+		{"text s = \"a\"\ns++", "cannot apply ++ to text variable s"},
+		{"print 12[0]", "cannot index integer, expected text or a list"},
+		{"print \"abc\"[\"b\"]", "index must be a whole number, got text"},
+		{"print [1][0:\"b\"]", "index must be a whole number, got text"},
+		{"print \"abc\"[0] - 1", "cannot apply '-' to text and integer"},
+		{"print [1, 2][0] - 1", ""},
+		{"let nothing = nil", "cannot infer the type of nothing from nil; declare its type instead"},
+		{"function f()\n    print local\nend\ninteger local = 1\nf()", ""},
+		{"function f()\n    integer local = 1\nend\nprint local", "undefined variable: local"},
+		{"loop i from 1 to 3\n    number inner = i\nend\nprint inner", "undefined variable: inner"},
+		{"toText(1, 2)", "function toText expects 1 arguments, got 2"},
+		{"try\n    print 1\ncatch e\n    print e - 1\nend", "cannot apply '-' to text and integer"},
+		{"try\n    print 1\ncatch e\nend\nprint e", "undefined variable: e"},
+		{"raise 1 + 1", "can only raise text, got integer"},
+		{"assert 1 + 1", "condition must be boolean, got integer"},
+		{"print format()", "function format expects at least 1 arguments, got 0"},
+		{"integer n = 1\nn(2)", "cannot call n: integer is not a function"},
+		{"print (1 + 2)(3)", "cannot call a value of type integer"},
+		{"function f(integer a, integer b = 2)\nend\nf()", "function f expects 1 to 2 arguments, got 0"},
+		{"function f(integer a = \"one\")\nend\nf()", "type mismatch in function f: parameter a expects integer, got text"},
+		{"let f = function() print missing end", "undefined variable: missing"},
+		{"let f = function(integer n) return n end\nprint f + 1", "cannot apply '+' to function and integer"},
+		{"function f(text s, number n = s)\nend\nf(\"a\")", "type mismatch in function f: parameter n expects number, got text"},
+		{"print \"a\".shout()", "unknown method shout: a method must name a built-in function"},
+		{"function shout(text s)\nend\nprint \"a\".shout()", "unknown method shout: a method must name a built-in function"},
+		{"print \"a\".upper(1)", "function upper expects 1 arguments, got 2"},
+		{"print missing.upper()", "undefined variable: missing"},
+		{"enum Color red end\nprint Color.blue", "undefined variable: Color.blue"},
+		{"enum Color red blue end\nprint Color.red < Color.blue", "cannot compare Color and Color"},
+		{"enum Color red end\nprint Color.red == Color.red", ""},
+		{"repeat\n    print 1\nuntil 1", "condition must be boolean, got integer"},
+		{"integer n = \"ab\" * 2", "type mismatch: cannot assign text to variable of type integer"},
+		{"text s = 2.0 * \"ab\"\ntext t = \"ab\" * 2", ""},
+		{"print \"ab\" * \"c\"", "cannot apply '*' to text and text"},
+		{"print padLeft(\"a\")", "function padLeft expects 2 to 3 arguments, got 1"},
+		{"print trim(\"a\") + trim(\"a\", \"b\")", ""},
+		{"repeat\n    let done = true\nuntil done", ""},
+		{"repeat\n    let done = true\nuntil done\nprint done", "undefined variable: done"},
+		{"number x = 1\nx = nil", "type mismatch: cannot assign nil to variable of type number"},
+		{"number x = 1\nx = \"s\"", "type mismatch: cannot assign text to variable of type number"},
+		{"function f(integer n)\n    n = 1.5\nend", "type mismatch: cannot assign number to variable of type integer"},
+		{"number? x = 1\nx = nil\nx = 2", ""},
+		{"let x = 1\nx = nil", "type mismatch: cannot assign nil to variable of type integer"},
+		{"let x = 5\nx = \"hi\"", "type mismatch: cannot assign text to variable of type integer"},
+		{"number? m = 5\ntext t = m", "type mismatch: cannot assign number? to variable of type text"},
+		{"text? s = nil\nprint s - 1", "cannot apply '-' to text? and integer"},
+	}
+
+	for _, c := range cases {
+		got := strings.Join(checkProgram(t, c.source), "\n")
+		if got != c.expected {
+			t.Errorf("%q: expected %q, got %q", c.source, c.expected, got)
+		}
+	}
+}
+
+func TestSemaAvoidsFalsePositives(t *testing.T) {
+	sources := []string{
+		// Functions may be called before they are declared
+		"show()\nfunction show()\n    print \"hi\"\nend",
+		// Values of unknown type are not second-guessed
+		"for item in [1, \"a\"]\n    print item * 2\nend",
+		"number? maybe = nil\nprint maybe == nil",
+		// A nullable value may be used as its inner type, as it holds one
+		// once it has been checked against nil
+		"function f(number n)\n    return n\nend\nnumber? m = 5\nif m != nil then\n    print m + 1\n    if m > 2 then\n        number n = m\n        print f(m) - n\n    end\nend",
+		"text? s = \"ab\"\nif s != nil then\n    print s[0] + \"!\"\nend",
+		"print toNumber(\"1\") + 1",
+		// Code run by eval may declare names the checker cannot see
+		"print eval(\"number z = 3\")\nprint z\nz = 4",
+		"text code = \"function twice(integer n) return n * 2 end\"\ncode.eval()\nprint twice(2)",
+		"eval(\"number z = 3\")\nfunction f()\n    return z\nend",
+		// Closures see the variables of the function that declared them, and
+		// functions can be passed around by name
+		"function outer()\n    integer count = 0\n    function inner()\n        count++\n        return count\n    end\n    return inner\nend\nlet f = outer()\nprint f()",
+		"function apply(function f)\n    return f(1)\nend\nfunction id(integer n)\n    return n\nend\nprint apply(id)",
+		"text? reason = nil\nif reason == nil then\n    reason = \"unknown\"\nend\nraise reason",
+	}
+
+	for _, source := range sources {
+		if messages := checkProgram(t, source); len(messages) > 0 {
+			t.Errorf("%q: unexpected errors %v", source, messages)
+		}
+	}
+}
+
+func TestSemaReportsRedeclarations(t *testing.T) {
+	cases := []struct {
+		source   string
+		expected string
+	}{
+		{"number x = 1\nnumber x = 2", "variable x is already declared in this scope"},
+		{"integer a = 1, b = 2, a = 3", "variable a is already declared in this scope"},
+		{"let a = 1\nlet a, b = [2, 3]", "variable a is already declared in this scope"},
+		{"if true then\n    let y = 1\n    let y = 2\nend", "variable y is already declared in this scope"},
+		{"function f(integer n)\n    integer n = 2\nend", "variable n is already declared in this scope"},
+		{"loop i from 1 to 2\n    integer i = 3\nend", "variable i is already declared in this scope"},
+		{"integer i = 0\nloop i from 1 to 2\nend", "loop variable i is already declared in this scope"},
+		{"enum Color red end\nenum Color red end", "variable Color.red is already declared in this scope"},
+		// Shadowing in a nested scope is allowed
+		{"number x = 1\nif true then\n    number x = 2\nend", ""},
+		{"number x = 1\nfunction f()\n    number x = 2\nend", ""},
+		{"loop i from 1 to 2\n    integer n = i\nend\nloop i from 1 to 2\n    integer n = i\nend", ""},
+		// Only one case of a switch runs, so each may declare the same name
+		{"switch 1\ncase 1\n    let x = 1\ncase 2\n    let x = 2\nend", ""},
+	}
+
+	for _, c := range cases {
+		got := strings.Join(checkProgram(t, c.source), "\n")
+		if got != c.expected {
+			t.Errorf("%q: expected %q, got %q", c.source, c.expected, got)
+		}
+	}
+}
+
+func TestSemaPredeclaredGlobals(t *testing.T) {
+	checker := sema.NewChecker()
+	checker.Declare("rate", types.NumberType{})
+
+	errs := checker.Check(parseProgram(t, "print rate * 2\nprint rate - \"a\"\nprint other"))
+	var messages []string
+	for _, err := range errs {
+		messages = append(messages, err.Message)
+	}
+	expected := "cannot apply '-' to number and text\nundefined variable: other"
+	if got := strings.Join(messages, "\n"); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestSemaFollowsIncludes(t *testing.T) {
+	checker := sema.NewChecker()
+	checker.SetBaseDir("testdata/include")
+
+	source := `include "lib/shapes.sl"
+include "missing.sl"
+include "lib/mistyped.sl"
+print area(2) + double(1) + half(4)
+print double("two")`
+	var messages []string
+	for _, err := range checker.Check(parseProgram(t, source)) {
+		messages = append(messages, err.Error())
+	}
+	expected := []string{
+		"semantic error at line 2, column 1: cannot include missing.sl: no such file or directory",
+		"semantic error at line 5, column 1: in lib/mistyped.sl: type mismatch: cannot assign text to variable of type integer",
+		"semantic error at line 5, column 14: type mismatch in function double: parameter n expects integer, got text",
+	}
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected:\n%s\nGot:\n%s", strings.Join(expected, "\n"), strings.Join(messages, "\n"))
+	}
+}
+
+func TestSemaChecksModuleMembers(t *testing.T) {
+	checker := sema.NewChecker()
+	checker.SetBaseDir("testdata/include")
+
+	source := `text secret = "s"
+import "math.sl" as m
+import "peek.sl" as p
+function add(text a)
+    return a
+end
+print m.add(1, 2) + m.twice(3) + m.calls + add("x").length()
+print m.add(1)
+print m.add("a", 2)
+print m.subtract(1)
+print m.total
+print p.peek()
+print m.upper("a")`
+	var messages []string
+	for _, err := range checker.Check(parseProgram(t, source)) {
+		messages = append(messages, err.Error())
+	}
+	expected := []string{
+		"semantic error at line 8, column 7: function m.add expects 2 arguments, got 1",
+		"semantic error at line 9, column 13: type mismatch in function m.add: parameter a expects integer, got text",
+		"semantic error at line 10, column 7: undefined function: m.subtract",
+		"semantic error at line 11, column 7: undefined variable: m.total",
+		"semantic error at line 13, column 7: undefined function: m.upper",
+		"semantic error at line 2, column 12: in peek.sl: undefined variable: secret",
+	}
+	if strings.Join(messages, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected:\n%s\nGot:\n%s", strings.Join(expected, "\n"), strings.Join(messages, "\n"))
+	}
+}
+
+func TestExamplesPassSema(t *testing.T) {
+	paths, err := filepath.Glob("../examples/*.sl")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("Failed to find examples: %v", err)
+	}
+
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		if messages := checkProgram(t, string(source)); len(messages) > 0 {
+			t.Errorf("%s: unexpected errors %v", path, messages)
+		}
+	}
+}
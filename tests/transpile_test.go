@@ -0,0 +1,398 @@
+package tests
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"simplelang/internal/transpile"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files with the current output")
+
+// transpileGo translates source to Go, failing the test if it cannot
+func transpileGo(t *testing.T, source string) string {
+	t.Helper()
+
+	generated, err := transpile.Go(parseProgram(t, source))
+	if err != nil {
+		t.Fatalf("Transpile failed: %v", err)
+	}
+	return generated
+}
+
+// assertGolden compares generated code with the golden file of that name in
+// testdata, rewriting the file first when the tests run with -update
+func assertGolden(t *testing.T, name, got string) {
+	t.Helper()
+
+	golden := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+			t.Fatalf("Failed to update %s: %v", golden, err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", golden, err)
+	}
+	if got != string(want) {
+		t.Errorf("Generated code differs from %s.\nExpected:\n%s\nGot:\n%s", golden, want, got)
+	}
+}
+
+// readExample returns the source of the named program in examples
+func readExample(t *testing.T, name string) string {
+	t.Helper()
+
+	source, err := os.ReadFile(filepath.Join("..", "examples", name+".sl"))
+	if err != nil {
+		t.Fatalf("Failed to read example %s: %v", name, err)
+	}
+	return string(source)
+}
+
+func TestGoTranspilerGolden(t *testing.T) {
+	assertGolden(t, "arithmetic.go.golden", transpileGo(t, readExample(t, "arithmetic")))
+}
+
+func TestGoTranspilerOutputRunsLikeInterpreter(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds Go programs")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+
+	sources := map[string]string{
+		"recursion": `function fact(integer n)
+    if n <= 1 then
+        return 1
+    end
+    return n * fact(n - 1)
+end
+function half(number x)
+    if x > 10 then
+        return 5
+    end
+    return x / 2
+end
+print fact(10)
+print half(3) + half(20)`,
+		"scopes": `integer k = 2
+switch k
+case 2
+    text label = "two"
+    print label
+default
+    print "other"
+end
+loop i from k to 0 step -0.5
+    integer unused = 1
+    write i
+    write " "
+end
+loop i from 0 to 0.3 step 0.1
+    write i
+    write " "
+end
+number top = 0.7
+loop i from 0 to top step 0.07
+    write i
+    write " "
+end
+loop i from -2 to k step 2
+    print i
+end
+print ""
+let type = "keyword"
+print type`,
+		"operators": `print not (1 == 2) or 1 != 3 and 1.5 == 1.5
+print -(-3) + 7 % 3 + 7.5 % 2
+print 2 ^ 10 + 2 ^ 0.5
+print "text " + 1 / 4 + toText(2)
+print "apple" < "banana" and "b" >= "b"
+integer flags = 0b0110
+print flags & 3 | 1 << 4 xor 8 >> 1
+print -8 >> 1
+number x = -7.5
+print 7 // 2 + -7 // 2 * 10 + x // 2 * 100 + 7 // 2.5 * 1000
+integer width = 3
+print "ab" * width + 2 * ("-" + "=") + "x" * 0`,
+		"repeat": `integer n = 1
+repeat
+    integer square = n * n
+    write square
+    write " "
+    n++
+until square > 10
+repeat
+    print "once"
+until true`,
+		// Go would work these out exactly when it compiles
+		"constants": `print 0.1 + 0.2
+print 1 / 3 * 3 - 1
+print -(0.1) - 0.2
+print 7.5 // 2 + 7 // 2 + 7 % 4
+print 1 << 62 << 1`,
+	}
+	for _, name := range []string{"arithmetic", "control_flow", "functions", "if_else", "loops"} {
+		sources[name] = readExample(t, name)
+	}
+
+	for name, source := range sources {
+		want, err := runProgram(t, source)
+		if err != nil {
+			t.Fatalf("%s: interpreter failed: %v", name, err)
+		}
+
+		dir := t.TempDir()
+		path := filepath.Join(dir, "main.go")
+		if err := os.WriteFile(path, []byte(transpileGo(t, source)), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+		got, err := exec.Command(goTool, "run", path).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s: go run failed: %v\n%s", name, err, got)
+		}
+		if string(got) != want {
+			t.Errorf("%s: expected output %q, got %q", name, want, got)
+		}
+	}
+}
+
+func TestGoTranspilerDividesByZeroAtRuntime(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds Go programs")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+
+	for _, source := range []string{"print 1 // 0", "integer n = 7\nprint n % 0", "print 1.5 / 0"} {
+		dir := t.TempDir()
+		path := filepath.Join(dir, "main.go")
+		if err := os.WriteFile(path, []byte(transpileGo(t, source)), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+		if out, err := exec.Command(goTool, "vet", path).CombinedOutput(); err != nil {
+			t.Errorf("%q: generated Go does not compile: %v\n%s", source, err, out)
+		}
+	}
+}
+
+func TestGoTranspilerUnsupported(t *testing.T) {
+	tests := map[string]string{
+		"list xs = [1, 2]": "transpile error at line 1, column 11: unsupported in Go: list literals",
+		"print 'a'":        "transpile error at line 1, column 7: unsupported in Go: char literals",
+		"print 1\ntry\n    print 2\ncatch e\nend":     "transpile error at line 2, column 1: unsupported in Go: try statements",
+		"enum Color red end":                          "transpile error at line 1, column 1: unsupported in Go: enums",
+		"include \"utils.sl\"":                        "transpile error at line 1, column 1: unsupported in Go: include statements",
+		"import \"math.sl\" as m":                     "transpile error at line 1, column 1: unsupported in Go: import statements",
+		"integer n = 1\nn = 1.5":                      "transpile error at line 2, column 1: unsupported in Go: storing number in integer variable n",
+		"function f(integer a = 1)\nend":              "transpile error at line 1, column 1: unsupported in Go: default value for parameter a",
+		"let f = function() return 1 end":             "transpile error at line 1, column 9: unsupported in Go: function literals",
+		"print toNumber(\"1\")":                       "transpile error at line 1, column 7: unsupported in Go: calling toNumber",
+		"function f(integer n)\n    return f(n)\nend": "transpile error at line 1, column 1: unsupported in Go: cannot infer what recursive function f returns",
+		"function f(integer n)\n    if n > 1 then\n        return \"big\"\n    end\n    return n\nend": "transpile error at line 1, column 1: unsupported in Go: function f returns both text and integer",
+		"integer n = 2\nprint n ^ n": "transpile error at line 2, column 9: unsupported in Go: raising an integer to an integer power that is not a non-negative constant",
+		"print 4.0 & 1":              "transpile error at line 1, column 11: unsupported in Go: '&' on number and integer",
+		"print \"abc\"[1:]":          "transpile error at line 1, column 12: unsupported in Go: slicing",
+		"print \"ab\" * 2.0":         "transpile error at line 1, column 12: unsupported in Go: repeating text a number of times that is not an integer",
+	}
+
+	for source, expected := range tests {
+		_, err := transpile.Go(parseProgram(t, source))
+		if err == nil || err.Error() != expected {
+			t.Errorf("For %q expected error %q, got %v", source, expected, err)
+		}
+	}
+}
+
+// transpileJS translates source to JavaScript, failing the test if it cannot
+func transpileJS(t *testing.T, source string) string {
+	t.Helper()
+
+	generated, err := transpile.JS(parseProgram(t, source))
+	if err != nil {
+		t.Fatalf("Transpile failed: %v", err)
+	}
+	return generated
+}
+
+func TestJSTranspilerGolden(t *testing.T) {
+	assertGolden(t, "control_flow.js.golden", transpileJS(t, readExample(t, "control_flow")))
+}
+
+func TestJSTranspilerOutputRunsLikeInterpreter(t *testing.T) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node not found")
+	}
+
+	sources := map[string]string{
+		"closures": `function makeCounter()
+    integer count = 0
+    function increment()
+        count++
+        return count
+    end
+    return increment
+end
+let counter = makeCounter()
+print counter()
+print counter()`,
+		"function values": `let square = function(number x) return x * x end
+function compose(function f, function g)
+    return function(number x) return f(g(x)) end
+end
+print compose(square, function(number x) return x + 1 end)(2)
+print function() return "now" end()
+function area(number w, number h = w)
+    return w * h
+end
+print area(3) + area(3, 2)`,
+		"operators": `print -2 ^ 2 + 2 ^ -1 + 2 ^ 3 ^ 2
+print 7 / 2 + 7 % 3 + 7.5 % 2
+print 7 // 2 + -7 // 2 * 10 + -7.5 // 2 * 100
+print 0.1 + 0.2 == 0.3
+print "apple" < "banana" and "b" >= "b"
+let this = "a \"quoted\" word"
+print this + 1
+integer width = 3
+print "ab" * width + 2 * ("-" + "=") + "x" * 0`,
+		"repeat": `integer n = 1
+repeat
+    let square = n * n
+    print square
+    n++
+until square > 10
+repeat
+    print "once"
+until true`,
+		"fractional loops": `loop i from 0 to 0.3 step 0.1
+    print i
+end
+number top = 0.7
+loop i from 0 to top step 0.07
+    i = i * 2
+    print i
+end
+loop i from -2 to top step 2
+    print i
+end`,
+	}
+	for _, name := range []string{"arithmetic", "control_flow", "functions", "if_else", "loops"} {
+		sources[name] = readExample(t, name)
+	}
+
+	for name, source := range sources {
+		want, err := runProgram(t, source)
+		if err != nil {
+			t.Fatalf("%s: interpreter failed: %v", name, err)
+		}
+
+		path := filepath.Join(t.TempDir(), "main.js")
+		if err := os.WriteFile(path, []byte(transpileJS(t, source)), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+		got, err := exec.Command(node, path).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s: node failed: %v\n%s", name, err, got)
+		}
+		if string(got) != want {
+			t.Errorf("%s: expected output %q, got %q", name, want, got)
+		}
+	}
+}
+
+func TestJSTranspilerUnsupported(t *testing.T) {
+	tests := map[string]string{
+		"list xs = [1, 2]": "transpile error at line 1, column 11: unsupported in JavaScript: list literals",
+		"print 'a'":        "transpile error at line 1, column 7: unsupported in JavaScript: char literals",
+		"print 1\ntry\n    print 2\ncatch e\nend": "transpile error at line 2, column 1: unsupported in JavaScript: try statements",
+		"enum Color red end":                      "transpile error at line 1, column 1: unsupported in JavaScript: enums",
+		"include \"utils.sl\"":                    "transpile error at line 1, column 1: unsupported in JavaScript: include statements",
+		"import \"math.sl\" as m":                 "transpile error at line 1, column 1: unsupported in JavaScript: import statements",
+		"write 1":                                 "transpile error at line 1, column 1: unsupported in JavaScript: write, since there is no portable way to print without a newline",
+		"print toNumber(\"1\")":                   "transpile error at line 1, column 7: unsupported in JavaScript: calling toNumber",
+		"text? t = nil":                           "transpile error at line 1, column 11: unsupported in JavaScript: nil literals",
+		"print \"a\" + (1 > 2)":                   "transpile error at line 1, column 11: unsupported in JavaScript: '+' on text and boolean",
+		"print 1 << 2":                            "transpile error at line 1, column 9: unsupported in JavaScript: operator '<<'",
+		"print \"ab\"[0]":                         "transpile error at line 1, column 11: unsupported in JavaScript: indexing",
+		"print \"ab\" * 2.0":                      "transpile error at line 1, column 12: unsupported in JavaScript: repeating text a number of times that is not an integer",
+		"function f(list a, list b)\n    print a == b\nend": "transpile error at line 2, column 13: unsupported in JavaScript: '==' on list and list",
+	}
+
+	for source, expected := range tests {
+		_, err := transpile.JS(parseProgram(t, source))
+		if err == nil || err.Error() != expected {
+			t.Errorf("For %q expected error %q, got %v", source, expected, err)
+		}
+	}
+}
+
+func TestGoTranspilerLineComments(t *testing.T) {
+	source := `integer total = 0
+loop i from 1 to 3
+    total = total + 2
+end
+print total`
+
+	transpiler := transpile.NewGoTranspiler()
+	transpiler.SetLineComments(true)
+	generated, err := transpiler.Transpile(parseProgram(t, source))
+	if err != nil {
+		t.Fatalf("Transpile failed: %v", err)
+	}
+
+	// gofmt aligns the comments, so match each statement's line by its code
+	expected := map[string]string{
+		"total = 0":          "// line 1",
+		"total = total + 2":  "// line 3",
+		"fmt.Println(total)": "// line 5",
+	}
+	for _, line := range strings.Split(generated, "\n") {
+		for statement, comment := range expected {
+			if strings.HasPrefix(strings.TrimSpace(line), statement+" ") {
+				if !strings.HasSuffix(line, comment) {
+					t.Errorf("Expected %q to end in %q", line, comment)
+				}
+				delete(expected, statement)
+			}
+		}
+	}
+	for statement := range expected {
+		t.Errorf("Expected a line for %q in:\n%s", statement, generated)
+	}
+	if strings.Contains(transpileGo(t, source), "// line") {
+		t.Errorf("Expected no line comments unless they are enabled")
+	}
+}
+
+func TestJSTranspilerSourceMap(t *testing.T) {
+	source := `print "start"
+loop i from 1 to 2
+    print i
+end`
+
+	transpiler := transpile.NewJSTranspiler()
+	if _, err := transpiler.Transpile(parseProgram(t, source)); err != nil {
+		t.Fatalf("Transpile failed: %v", err)
+	}
+	encoded, err := transpiler.SourceMap("loop.sl")
+	if err != nil {
+		t.Fatalf("SourceMap failed: %v", err)
+	}
+
+	// The two header lines map to nothing; "print i" on generated line 5
+	// starts at column 2 and comes from line 3, column 5
+	expected := `{"version":3,"sources":["loop.sl"],"names":[],"mappings":";;AAAA;AACA;EACI;"}`
+	if string(encoded) != expected {
+		t.Errorf("Expected source map %s, got %s", expected, encoded)
+	}
+}
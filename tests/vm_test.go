@@ -0,0 +1,358 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"simplelang/internal/interpreter"
+	"simplelang/internal/vm"
+	"strings"
+	"testing"
+)
+
+// runVM compiles source to bytecode and runs it, returning what it printed
+func runVM(t *testing.T, source string) (string, error) {
+	t.Helper()
+
+	bytecode, err := vm.Compile(parseProgram(t, source))
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	var out bytes.Buffer
+	machine := vm.New(bytecode)
+	machine.SetOutput(&out)
+	err = machine.Run()
+	return out.String(), err
+}
+
+// assertSameAsInterpreter runs source on both backends and fails if their
+// output or errors differ
+func assertSameAsInterpreter(t *testing.T, name, source string) {
+	t.Helper()
+
+	want, wantErr := runProgram(t, source)
+	got, gotErr := runVM(t, source)
+	if got != want {
+		t.Errorf("%s: expected output %q, got %q", name, want, got)
+	}
+	if fmt.Sprint(gotErr) != fmt.Sprint(wantErr) {
+		t.Errorf("%s: expected error %v, got %v", name, wantErr, gotErr)
+	}
+}
+
+func TestVMMatchesInterpreterOnExamples(t *testing.T) {
+	paths, err := filepath.Glob("../examples/*.sl")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("Failed to find examples: %v", err)
+	}
+
+	for _, path := range paths {
+		source, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		assertSameAsInterpreter(t, path, string(source))
+	}
+}
+
+func TestVMMatchesInterpreter(t *testing.T) {
+	tests := map[string]string{
+		"arithmetic": `integer a = 7
+number b = a / 2
+print a % 3 + b * 2 ^ 3
+print -a
+print "a is " + a`,
+		"assignment": `integer n = 1
+n = n + 1
+n++
+n--
+n++
+print n`,
+		"if": `integer n = 5
+if n > 3 and n < 10 then
+    print "middle"
+else
+    print "outside"
+end
+if not (n == 5) or n != 5 then
+    print "never"
+end`,
+		"loops": `loop i from 1 to 3
+    loop j from i to 1
+        print i * 10 + j
+    end
+end
+loop k from 0 to 1 step 0.25
+    print k
+end
+loop k from 10 to 0 step -5
+    print k
+end
+loop k from 0 to 0.3 step 0.1
+    print k
+end
+number top = -1
+loop k from 0.5 to top step -0.5
+    print k
+end`,
+		"loop scope": `loop i from 1 to 2
+    integer inside = 1
+end
+print inside`,
+		"for": `for x in [1, "two", 3.5, [4]]
+    print x
+end`,
+		"switch": `loop i from 1 to 4
+    switch i
+    case 1
+        print "one"
+    case 2
+        print "two"
+    default
+        print "many"
+    end
+end`,
+		"functions": `function fib(integer n)
+    if n < 2 then
+        print n
+    end
+end
+function twice(text s)
+    print s + s
+end
+fib(1)
+twice("ab")
+print toText(12) + toNumber("1.5")`,
+		"recursion": `function down(number n)
+    print n
+    if n > 0 then
+        down(n - 1)
+    end
+end
+down(3)`,
+		"lexical scope": `integer x = 1
+function show()
+    print x
+end
+function caller()
+    integer x = 2
+    show()
+end
+caller()`,
+		"closures": `function makeCounter()
+    integer count = 0
+    function increment()
+        count++
+        return count
+    end
+    return increment
+end
+let a = makeCounter()
+let b = makeCounter()
+print a()
+print a()
+print b()`,
+		"return": `function find(list values, integer wanted)
+    loop i from 1 to 3
+        for v in values
+            if v == wanted then
+                return "found " + v + " on pass " + i
+            end
+        end
+    end
+    return
+end
+print find([1, 2], 2)
+print find([1, 2], 5)`,
+		"function values": `function twice(function f, integer x)
+    return f(f(x))
+end
+function inc(integer n)
+    return n + 1
+end
+let g = inc
+print twice(g, 1)
+print g`,
+		"call results": `function makeAdder(integer n)
+    function adder(integer x)
+        return x + n
+    end
+    return adder
+end
+let add2 = makeAdder(2)
+print add2(1)
+print makeAdder(1)(2)
+print add2("x")`,
+		"call a non-function value": `print [1](2)`,
+		"top-level functions": `function outer(integer n)
+    function middle(integer m)
+        if m > 0 then
+            return middle(m - 1)
+        end
+        return map([n], function(integer k) return helper(k) end)
+    end
+    return middle(n)
+end
+print outer(2)
+function helper(integer k)
+    return "helped " + k
+end
+function shadows()
+    function helper(integer k)
+        return "inner " + k
+    end
+    return helper(1)
+end
+print shadows() + helper(2)
+if true then
+    function local()
+    end
+end
+local()`,
+		"default parameters": `function greet(text name, text greeting = "Hello", integer times = 1)
+    loop i from 1 to times
+        print greeting + ", " + name
+    end
+end
+function area(number w, number h = w)
+    return w * h
+end
+greet("Sam")
+greet("Sam", "Hi", 2)
+print area(3)
+print area(3, 2)`,
+		"function literals": `let square = function(number x) return x * x end
+function compose(function f, function g)
+    return function(number x) return f(g(x)) end
+end
+let inc = function(number x) return x + 1 end
+print compose(square, inc)(2)
+print function() return "now" end()
+print square`,
+		"bad function literal call": "let f = function(integer n) return n end\nf(\"x\")",
+		"write":                     "write \"a\"\nwrite 1 + 1\nprint \"\"\nwrite [1]",
+		"format":                    "print format(\"{} is {{{}}}\", \"x\", 1.5)\nprint format(\"{}\")",
+		"type":                      "function f()\nend\nprint type(1) + type(1.5) + type(\"a\") + type(1 < 2) + type([1]) + type(nil) + type(f) + type(f())",
+		"text comparisons":          "print \"apple\" < \"banana\"\nprint \"b\" >= \"b\"\nprint \"a\" > 1",
+		"equality":                  "function f()\nend\nlet g = f\nprint f() == f()\nprint nil == nil\nprint 1 == \"1\"\nprint g == f\nprint [1] != [1]",
+		"bitwise":                   "print 12 & 10 | 1 << 4 xor 3\nprint -8 >> 1\nprint 6.0 & 3\nprint 1.5 & 1",
+		"multiple returns":          "function divmod(integer a, integer b)\n    return (a - a % b) / b, a % b\nend\nlet q, r = divmod(10, 3)\nprint q + r\nprint divmod(7, 2)\ninteger x, y = [1, \"two\"]",
+		"redeclaration":             "integer x = 1\nloop i from 1 to 2\n    for x in [i]\n        let y = x\n        print y\n    end\nend\ninteger x = 2",
+		"if scope":                  "integer x = 1\nif x > 0 then\n    integer x = 2\n    print x\nelse\n    integer y = 3\nend\nprint x\nprint y",
+		"loop variable collision":   "list xs = [1]\nfor xs in xs\nend",
+		"indexing":                  "text s = \"naïve\"\nlist xs = [s, [1, 2]]\nprint s[2] + s[1:3] + s[:1] + s[3:] + s[:]\nprint xs[1][0:1]\nprint xs[0][4]\nprint s[5]",
+		"list builtins":             "list xs = concat([\"a\"], [\"b\", \"c\"])\nprint join(xs, \"+\")\nprint join(concat(xs, [1]), \"\")",
+		"higher-order builtins":     "integer seen = 0\nfunction odd(integer n)\n    seen++\n    return n % 2 == 1\nend\nlist xs = filter([1, 2, 3], odd)\nprint map(xs, function(integer n) return n * seen end)\nprint reduce(xs, function(text s, integer n) return s + n end, \"\")\ntry\n    print map([0], function(integer n) return 1 / n end)\ncatch e\n    print e\nend\nprint filter([1], function(integer n) return n end)",
+		"list equality":             "list xs = [1, [2.0, \"a\"]]\nprint xs == [1.0, [2, \"a\"]]\nprint xs != [1, [2]]\nswitch xs\ncase [1]\n    print \"short\"\ncase [1, [2, \"a\"]]\n    print \"match\"\nend",
+		"dumpEnv":                   "integer x = 1\nif x > 0 then\n    text s = \"in\"\n    for y in [[x]]\n        dumpEnv()\n    end\nend\nprint map([2], function(integer n) dumpEnv(); return n end)",
+		"integer division":          "print 7 // 2 + -7 // 2 * 10\nprint -7.5 // 2\nprint 1 // 0",
+		"methods":                   "function upper(text s)\n    return s\nend\ntext s = \"ab\"\nprint s.upper() + upper(s) + s\n    .lower()\n    .length()\nprint [s].length()\nprint s.upper(s)",
+		"enums":                     "enum Color red green\n    blue\nend\nlet c = Color.green\nprint c\nprint c == Color.green\nprint c != Color.red\nprint [Color.red, c]\nprint toNumber(Color.blue) + type(c)\nenum Color red\nend",
+		"repeat":                    "integer n = 0\nrepeat\n    n++\n    integer twice = n * 2\n    print twice\nuntil twice >= 6 or n > 10\nrepeat\n    print \"once\"\nuntil 1 < 2\nrepeat\n    print n\nuntil n",
+		"mixed arithmetic":          "print 3 + 2.5\nprint 5 < 5.5\nprint type(3 + 2.5) + type(3 + 2) + type(2 * 1.5)\nprint 2 == 2.0\nprint 7 % 2.5",
+		"eval":                      "eval(\"integer x = 40\\nprint x\")\nprint eval(\"x + 2\")\nfunction f()\n    return eval(\"1 / 0\")\nend\nprint eval(\"1 + 2\") + eval(\"\\\"a\\\"\")\nprint f()",
+		"eval declarations":         "eval(\"function twice(integer n)\\n    return n * 2\\nend\")\nprint twice(twice(3))\nprint map([1, 2], twice)",
+		"integer loops":             "loop i from 1 to 3\n    print type(i) + i / 2\nend\ninteger n = 2\nloop i from n to 0 step -2\n    print i\nend\nloop i from 1 to 2 step 0.5\n    print type(i)\nend",
+		"chars":                     "let c = 'a'\nprint c + 'b' + \"c\"\nprint c == 'a'\nprint c < 'b'\nprint [c, 'é'] + c\nprint c + 1",
+		"text repetition":           "text s = \"ab\"\nprint s * 3 + 2 * \"-\" + \"x\" * 0 + s * 1.0\nprint \"ab\" * -1",
+		"text search":               "text s = \"naïve\"\nprint contains(s, \"ï\")\nprint indexOf(s, \"ve\") + replace(s, \"ï\", \"i\")\nprint indexOf(s, \"x\")\nprint contains(s, 1)",
+		"split":                     "let parts = split(\"a,b\", \",\")\nprint parts[1] + length(parts)\nprint splitLines(\"x\\ny\\n\")\nprint split(\"\", \"\")\nprint split(1, \",\")",
+		"trim and pad":              "print \"[\" + trim(\" a \") + padLeft(\"b\", 3) + padRight(\"c\", 2, \".\") + \"]\"\nprint padLeft(\"a\", -1)",
+		"bad slice":                 "print [1, 2][\"a\":]",
+		"unpack arity":              "let a, b, c = [1, 2]",
+		"assert":                    "integer x = 2\nassert x == 2\nassert x > 1, \"unused \" + 1 / 0\nassert not (x == 2)",
+		"assert message":            "assert 1 > 2, \"one is \" + 1",
+		"assert condition":          "assert 1",
+		"try": `function fail(integer n)
+    loop i from 1 to 3
+        for x in [1, 2]
+            if i == n then
+                print toNumber("x" + i)
+            end
+        end
+    end
+end
+function safe(integer n)
+    try
+        fail(n)
+        return "fine"
+    catch e
+        return "failed: " + e
+    end
+end
+print safe(2)
+print safe(5)
+try
+    try
+        fail(1)
+    catch inner
+        print 1 / 0
+    end
+catch outer
+    print "outer: " + outer
+end
+function deep(integer n)
+    return deep(n + 1)
+end
+try
+    deep(0)
+catch e
+    print e
+end
+print safe(1)`,
+		"uncaught in catch":  "try\n    print 1 / 0\ncatch e\n    print e\n    print missing\nend",
+		"raise":              "function f(integer n)\n    if n > 1 then\n        raise \"too big: \" + n\n    end\n    return n\nend\ntry\n    print f(1)\n    print f(2)\ncatch e\n    print e\nend\nprint f(3)",
+		"raise non-text":     "raise [1]",
+		"bad default":        "function f(integer a = 1 / 0)\nend\nf(5)\nf()",
+		"bad default type":   "function f(integer a = \"one\")\nend\nf()",
+		"default arity":      "function f(integer a, integer b = 1)\nend\nf()",
+		"not a function":     "integer n = 1\nn(2)",
+		"division by zero":   `print 1 + 2 / (1 - 1)`,
+		"undefined variable": "integer count = 1\nprint cont",
+		"type mismatch":      `integer n = 1.5`,
+		"bad condition":      "if 1 then\n    print 1\nend",
+		"bad loop bounds":    "loop i from \"a\" to 3\n    print i\nend",
+		"zero step":          "loop i from 1 to 3 step 0\n    print i\nend",
+		"bad iterable":       "for x in 3\n    print x\nend",
+		"bad logical":        `print 1 and 2 > 1`,
+		"bad shorthand":      "text s = \"a\"\ns++",
+		"undefined function": `print missing(1 / 0)`,
+		"arity":              "function f(integer a)\n    print a\nend\nf(1, 2)",
+		"parameter type":     "function f(integer a)\n    print a\nend\nf(\"x\")",
+		"builtin error":      `print toNumber("abc")`,
+		"error in function":  "function f()\n    print 1 / 0\nend\nprint \"before\"\nf()",
+	}
+
+	for name, source := range tests {
+		assertSameAsInterpreter(t, name, source)
+	}
+}
+
+func TestVMRecursionLimit(t *testing.T) {
+	source := `function forever(integer n)
+    forever(n + 1)
+end
+forever(0)`
+	program := parseProgram(t, source)
+
+	interp := interpreter.NewInterpreter()
+	interp.SetMaxDepth(50)
+	want := interp.Interpret(program)
+
+	bytecode, err := vm.Compile(program)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	machine := vm.New(bytecode)
+	machine.SetMaxDepth(50)
+	got := machine.Run()
+
+	if got == nil || !strings.Contains(got.Error(), "maximum recursion depth exceeded (50)") {
+		t.Fatalf("expected a recursion error, got %v", got)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("expected error %v, got %v", want, got)
+	}
+}